@@ -0,0 +1,395 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	blobstore_configuration "github.com/buildbarn/bb-storage/pkg/blobstore/configuration"
+	"github.com/buildbarn/bb-storage/pkg/clock"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/proto/configuration/bb_admin"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const usage = `Usage: bb_admin [-instance name] [-digest_function name] bb_admin.jsonnet command [arguments]
+
+Digests are provided in the form ${hash}-${size_bytes}. Unless a digest
+function (e.g., blake3) is provided, it is inferred from the length of
+the hash.
+
+Commands:
+  get digest                  Write an object in the CAS to stdout.
+  put [file]                  Store a file (or stdin) in the CAS and
+                              print its digest. SHA-256 is used if no
+                              digest function is provided.
+  find-missing digest...      Print the digests of objects absent in
+                              the CAS.
+  cat-action-result digest    Print the ActionResult stored in the AC
+                              for an Action as JSON.
+  decode type digest          Print an Action, Command, Directory or
+                              Tree stored in the CAS as JSON.
+  dump-tree digest            Print a Directory stored in the CAS and
+                              all of its children as a Tree in JSON.
+  fsck                        Validate the contents of all local and
+                              circular storage backends, removing
+                              corrupted objects. These backends may not
+                              be in use by other processes.
+  migrate                     Copy all objects stored in the local and
+                              circular storage backends of the
+                              migration source into the storage.
+                              Corrupted objects are not copied. The
+                              migration source may not be in use by
+                              other processes.
+`
+
+// storage holds the BlobAccess objects on which commands operate.
+type storage struct {
+	contentAddressableStorage blobstore.BlobAccess
+	actionCache               blobstore.BlobAccess
+	instance                  string
+	digestFunction            remoteexecution.DigestFunction_Value
+	maximumMessageSizeBytes   int
+}
+
+// parseDigest converts a digest in the form ${hash}-${size_bytes}, as
+// used by Bazel, to a Digest.
+func (s *storage) parseDigest(value string) (digest.Digest, error) {
+	separator := strings.LastIndexByte(value, '-')
+	if separator < 0 {
+		return digest.BadDigest, status.Errorf(codes.InvalidArgument, "Digest %#v is not of the form ${hash}-${size_bytes}", value)
+	}
+	sizeBytes, err := strconv.ParseInt(value[separator+1:], 10, 64)
+	if err != nil {
+		return digest.BadDigest, status.Errorf(codes.InvalidArgument, "Digest %#v has an invalid size", value)
+	}
+	return digest.NewDigest(s.instance, s.digestFunction, value[:separator], sizeBytes)
+}
+
+func (s *storage) getMessage(ctx context.Context, value string, message proto.Message) error {
+	blobDigest, err := s.parseDigest(value)
+	if err != nil {
+		return err
+	}
+	data, err := s.contentAddressableStorage.Get(ctx, blobDigest).ToByteSlice(s.maximumMessageSizeBytes)
+	if err != nil {
+		return util.StatusWrapf(err, "Failed to obtain object %s", blobDigest)
+	}
+	if err := proto.Unmarshal(data, message); err != nil {
+		return util.StatusWrapfWithCode(err, codes.InvalidArgument, "Failed to unmarshal object %s", blobDigest)
+	}
+	return nil
+}
+
+func printJSON(message proto.Message) error {
+	marshaler := jsonpb.Marshaler{Indent: "  "}
+	if err := marshaler.Marshal(os.Stdout, message); err != nil {
+		return util.StatusWrapWithCode(err, codes.Internal, "Failed to marshal message")
+	}
+	fmt.Println()
+	return nil
+}
+
+func (s *storage) get(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return status.Error(codes.InvalidArgument, "Expected a single digest")
+	}
+	blobDigest, err := s.parseDigest(args[0])
+	if err != nil {
+		return err
+	}
+	return s.contentAddressableStorage.Get(ctx, blobDigest).IntoWriter(os.Stdout)
+}
+
+func (s *storage) put(ctx context.Context, args []string) error {
+	var r io.Reader
+	switch len(args) {
+	case 0:
+		r = os.Stdin
+	case 1:
+		f, err := os.Open(args[0])
+		if err != nil {
+			return util.StatusWrapf(err, "Failed to open %#v", args[0])
+		}
+		defer f.Close()
+		r = f
+	default:
+		return status.Error(codes.InvalidArgument, "Expected at most one file")
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return util.StatusWrap(err, "Failed to read data")
+	}
+	digestFunction := s.digestFunction
+	if digestFunction == remoteexecution.DigestFunction_UNKNOWN {
+		digestFunction = remoteexecution.DigestFunction_SHA256
+	}
+	generator, err := digest.NewGeneratorForDigestFunction(s.instance, digestFunction)
+	if err != nil {
+		return err
+	}
+	generator.Write(data)
+	blobDigest := generator.Sum()
+	if err := s.contentAddressableStorage.Put(ctx, blobDigest, buffer.NewValidatedBufferFromByteSlice(data)); err != nil {
+		return util.StatusWrapf(err, "Failed to store object %s", blobDigest)
+	}
+	fmt.Printf("%s-%d\n", blobDigest.GetHashString(), blobDigest.GetSizeBytes())
+	return nil
+}
+
+func (s *storage) findMissing(ctx context.Context, args []string) error {
+	digests := digest.NewSetBuilder()
+	for _, arg := range args {
+		blobDigest, err := s.parseDigest(arg)
+		if err != nil {
+			return err
+		}
+		digests.Add(blobDigest)
+	}
+	missing, err := s.contentAddressableStorage.FindMissing(ctx, digests.Build())
+	if err != nil {
+		return err
+	}
+	for _, blobDigest := range missing.Items() {
+		fmt.Printf("%s-%d\n", blobDigest.GetHashString(), blobDigest.GetSizeBytes())
+	}
+	return nil
+}
+
+func (s *storage) catActionResult(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return status.Error(codes.InvalidArgument, "Expected a single digest")
+	}
+	actionDigest, err := s.parseDigest(args[0])
+	if err != nil {
+		return err
+	}
+	actionResult, err := s.actionCache.Get(ctx, actionDigest).ToActionResult(s.maximumMessageSizeBytes)
+	if err != nil {
+		return util.StatusWrapf(err, "Failed to obtain action result for %s", actionDigest)
+	}
+	return printJSON(actionResult)
+}
+
+func (s *storage) decode(ctx context.Context, args []string) error {
+	if len(args) != 2 {
+		return status.Error(codes.InvalidArgument, "Expected a message type and a digest")
+	}
+	var message proto.Message
+	switch args[0] {
+	case "action":
+		message = &remoteexecution.Action{}
+	case "command":
+		message = &remoteexecution.Command{}
+	case "directory":
+		message = &remoteexecution.Directory{}
+	case "tree":
+		message = &remoteexecution.Tree{}
+	default:
+		return status.Errorf(codes.InvalidArgument, "Unknown message type %#v", args[0])
+	}
+	if err := s.getMessage(ctx, args[1], message); err != nil {
+		return err
+	}
+	return printJSON(message)
+}
+
+func (s *storage) dumpTree(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return status.Error(codes.InvalidArgument, "Expected a single digest")
+	}
+	var tree remoteexecution.Tree
+	tree.Root = &remoteexecution.Directory{}
+	if err := s.getMessage(ctx, args[0], tree.Root); err != nil {
+		return err
+	}
+
+	// Traverse the directory hierarchy, only loading every unique
+	// child directory once.
+	seen := map[string]struct{}{}
+	pending := []*remoteexecution.Directory{tree.Root}
+	for len(pending) > 0 {
+		directory := pending[0]
+		pending = pending[1:]
+		for _, child := range directory.Directories {
+			childDigest := fmt.Sprintf("%s-%d", child.Digest.GetHash(), child.Digest.GetSizeBytes())
+			if _, ok := seen[childDigest]; ok {
+				continue
+			}
+			seen[childDigest] = struct{}{}
+			var childDirectory remoteexecution.Directory
+			if err := s.getMessage(ctx, childDigest, &childDirectory); err != nil {
+				return util.StatusWrapf(err, "Failed to obtain directory %#v", child.Name)
+			}
+			tree.Children = append(tree.Children, &childDirectory)
+			pending = append(pending, &childDirectory)
+		}
+	}
+	return printJSON(&tree)
+}
+
+// fsck validates the contents of all local and circular storage
+// backends declared in the configuration file. As these backends are
+// opened directly, this command must not be run while bb_storage is
+// using them.
+func fsck(ctx context.Context, configuration *bb_admin.ApplicationConfiguration, args []string) error {
+	if len(args) != 0 {
+		return status.Error(codes.InvalidArgument, "Unexpected arguments")
+	}
+	backends, err := blobstore_configuration.CreateScrubbableBackendsFromConfig(
+		configuration.Blobstore,
+		int(configuration.MaximumMessageSizeBytes))
+	if err != nil {
+		return util.StatusWrap(err, "Failed to create blob access")
+	}
+
+	invalidObjects := int64(0)
+	for _, backend := range backends {
+		results, err := blobstore.NewScrubber(backend.BlobAccess, clock.SystemClock, backend.Name, 0).ScrubOnce(ctx)
+		if err != nil {
+			return util.StatusWrapf(err, "Failed to validate backend %#v", backend.Name)
+		}
+		fmt.Printf(
+			"%s: %d valid objects, %d invalid objects, %d bytes\n",
+			backend.Name,
+			results.ValidObjects,
+			results.InvalidObjects,
+			results.SizeBytes)
+		invalidObjects += results.InvalidObjects
+	}
+	if invalidObjects > 0 {
+		return status.Errorf(codes.DataLoss, "Found %d invalid objects", invalidObjects)
+	}
+	return nil
+}
+
+// migrate copies all objects stored in the local and circular storage
+// backends of the migration source into the Content Addressable
+// Storage and Action Cache. This can, for example, be used to switch
+// from circular to local storage without starting with a cold cache.
+func migrate(ctx context.Context, configuration *bb_admin.ApplicationConfiguration, contentAddressableStorage blobstore.BlobAccess, actionCache blobstore.BlobAccess, args []string) error {
+	if len(args) != 0 {
+		return status.Error(codes.InvalidArgument, "Unexpected arguments")
+	}
+	if configuration.MigrationSource == nil {
+		return status.Error(codes.InvalidArgument, "No migration source specified")
+	}
+	backends, err := blobstore_configuration.CreateScrubbableBackendsFromConfig(
+		configuration.MigrationSource,
+		int(configuration.MaximumMessageSizeBytes))
+	if err != nil {
+		return util.StatusWrap(err, "Failed to create migration source blob access")
+	}
+
+	for _, backend := range backends {
+		var destination blobstore.BlobAccess
+		switch backend.StorageType {
+		case blobstore.CASStorageType:
+			destination = contentAddressableStorage
+		case blobstore.ACStorageType:
+			destination = actionCache
+		default:
+			return status.Errorf(codes.InvalidArgument, "Backend %#v does not contain uncompressed objects", backend.Name)
+		}
+		results, err := blobstore.Migrate(ctx, backend.BlobAccess, destination)
+		if err != nil {
+			return util.StatusWrapf(err, "Failed to migrate backend %#v", backend.Name)
+		}
+		fmt.Printf(
+			"%s: %d copied objects, %d existing objects, %d invalid objects, %d bytes copied\n",
+			backend.Name,
+			results.CopiedObjects,
+			results.ExistingObjects,
+			results.InvalidObjects,
+			results.SizeBytes)
+	}
+	return nil
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+	}
+	instance := flag.String("instance", "", "Instance name of objects to access")
+	digestFunctionName := flag.String("digest_function", "", "Digest function of objects to access")
+	flag.Parse()
+	if flag.NArg() < 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	digestFunction := remoteexecution.DigestFunction_UNKNOWN
+	if *digestFunctionName != "" {
+		value, ok := remoteexecution.DigestFunction_Value_value[strings.ToUpper(*digestFunctionName)]
+		if !ok {
+			log.Fatalf("Unknown digest function %#v", *digestFunctionName)
+		}
+		digestFunction = remoteexecution.DigestFunction_Value(value)
+	}
+
+	var configuration bb_admin.ApplicationConfiguration
+	if err := util.UnmarshalConfigurationFromFile(flag.Arg(0), &configuration); err != nil {
+		log.Fatalf("Failed to read configuration from %s: %s", flag.Arg(0), err)
+	}
+
+	// Consistency checking needs direct access to the storage
+	// backends, as opposed to the decorated BlobAccess objects.
+	if flag.Arg(1) == "fsck" {
+		if err := fsck(context.Background(), &configuration, flag.Args()[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	contentAddressableStorage, actionCache, err := blobstore_configuration.CreateBlobAccessObjectsFromConfig(
+		configuration.Blobstore,
+		int(configuration.MaximumMessageSizeBytes),
+		nil)
+	if err != nil {
+		log.Fatal("Failed to create blob access: ", err)
+	}
+	if flag.Arg(1) == "migrate" {
+		if err := migrate(context.Background(), &configuration, contentAddressableStorage, actionCache, flag.Args()[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	s := &storage{
+		contentAddressableStorage: contentAddressableStorage,
+		actionCache:               actionCache,
+		instance:                  *instance,
+		digestFunction:            digestFunction,
+		maximumMessageSizeBytes:   int(configuration.MaximumMessageSizeBytes),
+	}
+
+	commands := map[string]func(context.Context, []string) error{
+		"get":               s.get,
+		"put":               s.put,
+		"find-missing":      s.findMissing,
+		"cat-action-result": s.catActionResult,
+		"decode":            s.decode,
+		"dump-tree":         s.dumpTree,
+	}
+	command, ok := commands[flag.Arg(1)]
+	if !ok {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err := command(context.Background(), flag.Args()[2:]); err != nil {
+		log.Fatal(err)
+	}
+}
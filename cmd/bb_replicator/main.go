@@ -1,17 +1,21 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
 	"os"
 
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
 	blobstore_configuration "github.com/buildbarn/bb-storage/pkg/blobstore/configuration"
 	"github.com/buildbarn/bb-storage/pkg/blobstore/mirrored"
+	"github.com/buildbarn/bb-storage/pkg/clock"
 	"github.com/buildbarn/bb-storage/pkg/digest"
 	bb_grpc "github.com/buildbarn/bb-storage/pkg/grpc"
 	"github.com/buildbarn/bb-storage/pkg/proto/configuration/bb_replicator"
 	replicator_pb "github.com/buildbarn/bb-storage/pkg/proto/replicator"
 	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/golang/protobuf/ptypes"
 	"github.com/gorilla/mux"
 
 	"google.golang.org/grpc"
@@ -47,6 +51,43 @@ func main() {
 		log.Fatal("Failed to create replicator: ", err)
 	}
 
+	if antiEntropy := configuration.AntiEntropy; antiEntropy != nil {
+		enumerableSource, ok := source.(blobstore.EnumerableBlobAccess)
+		if !ok {
+			log.Fatal("Anti-entropy requires a source that is capable of enumerating its contents")
+		}
+		enumerableSink, ok := sink.(blobstore.EnumerableBlobAccess)
+		if !ok {
+			log.Fatal("Anti-entropy requires a sink that is capable of enumerating its contents")
+		}
+		if antiEntropy.BatchSize <= 0 {
+			log.Fatal("Anti-entropy batch size must be positive")
+		}
+		interval, err := ptypes.Duration(antiEntropy.Interval)
+		if err != nil {
+			log.Fatal("Failed to parse anti-entropy interval: ", err)
+		}
+		var reverseReplicator mirrored.BlobReplicator
+		if antiEntropy.ReverseReplicator != nil {
+			reverseReplicator, err = blobstore_configuration.CreateBlobReplicatorFromConfig(
+				antiEntropy.ReverseReplicator,
+				sink,
+				source,
+				digest.KeyWithoutInstance)
+			if err != nil {
+				log.Fatal("Failed to create reverse replicator: ", err)
+			}
+		}
+		go mirrored.NewAntiEntropy(
+			enumerableSource,
+			enumerableSink,
+			replicator,
+			reverseReplicator,
+			clock.SystemClock,
+			"cas",
+			int(antiEntropy.BatchSize)).Run(context.Background(), interval)
+	}
+
 	go func() {
 		log.Fatal(
 			"gRPC server failure: ",
@@ -57,6 +98,20 @@ func main() {
 				}))
 	}()
 
+	if queue, ok := replicator.(mirrored.ReplicationQueue); ok && len(configuration.AdminGrpcServers) > 0 {
+		// Permit administrators to inspect and cancel pending
+		// replications through separate gRPC servers.
+		go func() {
+			log.Fatal(
+				"Administrative gRPC server failure: ",
+				bb_grpc.NewGRPCServersFromConfigurationAndServe(
+					configuration.AdminGrpcServers,
+					func(s *grpc.Server) {
+						replicator_pb.RegisterReplicationQueueServer(s, mirrored.NewReplicationQueueServer(queue))
+					}))
+		}()
+	}
+
 	// Web server for metrics and profiling.
 	router := mux.NewRouter()
 	util.RegisterAdministrativeHTTPEndpoints(router)
@@ -1,20 +1,38 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
 	"github.com/buildbarn/bb-storage/pkg/ac"
+	"github.com/buildbarn/bb-storage/pkg/auth"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
 	"github.com/buildbarn/bb-storage/pkg/blobstore/completenesschecking"
 	blobstore_configuration "github.com/buildbarn/bb-storage/pkg/blobstore/configuration"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/expiring"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/mirrored"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/signing"
 	"github.com/buildbarn/bb-storage/pkg/builder"
 	"github.com/buildbarn/bb-storage/pkg/cas"
+	"github.com/buildbarn/bb-storage/pkg/clock"
+	"github.com/buildbarn/bb-storage/pkg/eviction"
+	"github.com/buildbarn/bb-storage/pkg/filesystem"
 	bb_grpc "github.com/buildbarn/bb-storage/pkg/grpc"
+	bb_http "github.com/buildbarn/bb-storage/pkg/http"
 	"github.com/buildbarn/bb-storage/pkg/opencensus"
 	"github.com/buildbarn/bb-storage/pkg/proto/configuration/bb_storage"
+	grpc_pb "github.com/buildbarn/bb-storage/pkg/proto/configuration/grpc"
 	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
 	"github.com/gorilla/mux"
 
 	"google.golang.org/genproto/googleapis/bytestream"
@@ -23,25 +41,219 @@ import (
 	"google.golang.org/grpc/status"
 )
 
-func main() {
-	if len(os.Args) != 2 {
-		log.Fatal("Usage: bb_storage bb_storage.jsonnet")
+// reloadableComponents contains all parts of bb_storage that are
+// recreated when the configuration is reloaded.
+type reloadableComponents struct {
+	contentAddressableStorage           blobstore.BlobAccess
+	actionCache                         blobstore.BlobAccess
+	schedulers                          map[string]builder.BuildQueue
+	allowActionCacheUpdatesForInstances map[string]bool
+	authorizer                          auth.Authorizer
+	grpcAuthenticators                  []bb_grpc.Authenticator
+	httpAuthenticator                   bb_grpc.Authenticator
+}
+
+// reusableResources contains objects that are preserved across
+// configuration reloads, such as stateful storage backends and
+// connections to schedulers. Objects that are no longer referenced
+// after a reload are released by commit().
+type reusableResources struct {
+	backends           *blobstore_configuration.ReusableBackends
+	schedulerClients   map[string]*reusableSchedulerClient
+	failoverSchedulers map[string]*reusableFailoverScheduler
+}
+
+type reusableSchedulerClient struct {
+	client  *grpc.ClientConn
+	active  bool
+	pending bool
+}
+
+type reusableFailoverScheduler struct {
+	scheduler          *builder.FailoverBuildQueue
+	clientKeys         []string
+	cancelHealthChecks context.CancelFunc
+	active             bool
+	pending            bool
+}
+
+func newReusableResources() *reusableResources {
+	return &reusableResources{
+		backends:           blobstore_configuration.NewReusableBackends(),
+		schedulerClients:   map[string]*reusableSchedulerClient{},
+		failoverSchedulers: map[string]*reusableFailoverScheduler{},
 	}
-	var configuration bb_storage.ApplicationConfiguration
-	if err := util.UnmarshalConfigurationFromFile(os.Args[1], &configuration); err != nil {
-		log.Fatalf("Failed to read configuration from %s: %s", os.Args[1], err)
+}
+
+// getSchedulerClient returns a gRPC client for a scheduler. Clients
+// are reused if their configuration is unchanged.
+func (r *reusableResources) getSchedulerClient(endpoint *grpc_pb.GRPCClientConfiguration) (*grpc.ClientConn, error) {
+	key := proto.MarshalTextString(endpoint)
+	if scheduler, ok := r.schedulerClients[key]; ok {
+		scheduler.pending = true
+		return scheduler.client, nil
 	}
+	client, err := bb_grpc.NewGRPCClientFromConfiguration(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	r.schedulerClients[key] = &reusableSchedulerClient{
+		client:  client,
+		pending: true,
+	}
+	return client, nil
+}
 
-	if configuration.Jaeger != nil {
-		opencensus.Initialize(configuration.Jaeger)
+// getFailoverScheduler returns a BuildQueue that forwards requests to
+// one of multiple replicas of a scheduler. These are reused if their
+// configuration is unchanged, so that health checks are only started
+// once. Health checks are stopped by commit() and abort() once the
+// scheduler is no longer referenced.
+func (r *reusableResources) getFailoverScheduler(instance string, configuration *bb_storage.SchedulerReplicasConfiguration) (*builder.FailoverBuildQueue, error) {
+	key := instance + "\x00" + proto.MarshalTextString(configuration)
+	if scheduler, ok := r.failoverSchedulers[key]; ok {
+		// Also retain the connections to the replicas.
+		scheduler.pending = true
+		for _, clientKey := range scheduler.clientKeys {
+			r.schedulerClients[clientKey].pending = true
+		}
+		return scheduler.scheduler, nil
+	}
+	if len(configuration.Endpoints) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "No scheduler replicas specified")
+	}
+	var retryDelay time.Duration
+	if configuration.RetryDelay != nil {
+		var err error
+		retryDelay, err = ptypes.Duration(configuration.RetryDelay)
+		if err != nil {
+			return nil, util.StatusWrapWithCode(err, codes.InvalidArgument, "Invalid retry delay")
+		}
+	}
+	var healthCheckInterval time.Duration
+	if configuration.HealthCheckInterval != nil {
+		var err error
+		healthCheckInterval, err = ptypes.Duration(configuration.HealthCheckInterval)
+		if err != nil {
+			return nil, util.StatusWrapWithCode(err, codes.InvalidArgument, "Invalid health check interval")
+		}
+	}
+	unhealthyRetryInterval := time.Minute
+	if configuration.UnhealthyRetryInterval != nil {
+		var err error
+		unhealthyRetryInterval, err = ptypes.Duration(configuration.UnhealthyRetryInterval)
+		if err != nil {
+			return nil, util.StatusWrapWithCode(err, codes.InvalidArgument, "Invalid unhealthy retry interval")
+		}
+	}
+	maximumRetries := int(configuration.MaximumRetries)
+	if maximumRetries == 0 {
+		maximumRetries = len(configuration.Endpoints) - 1
+	}
+
+	replicas := make([]builder.BuildQueue, 0, len(configuration.Endpoints))
+	clientKeys := make([]string, 0, len(configuration.Endpoints))
+	for _, endpoint := range configuration.Endpoints {
+		client, err := r.getSchedulerClient(endpoint)
+		if err != nil {
+			return nil, util.StatusWrap(err, "Failed to create scheduler RPC client")
+		}
+		replicas = append(replicas, builder.NewForwardingBuildQueue(client))
+		clientKeys = append(clientKeys, proto.MarshalTextString(endpoint))
+	}
+	scheduler := builder.NewFailoverBuildQueue(replicas, clock.SystemClock, retryDelay, maximumRetries, unhealthyRetryInterval)
+	healthCheckCtx, cancelHealthChecks := context.WithCancel(context.Background())
+	if healthCheckInterval > 0 {
+		go scheduler.RunHealthChecks(healthCheckCtx, instance, healthCheckInterval)
+	}
+	r.failoverSchedulers[key] = &reusableFailoverScheduler{
+		scheduler:          scheduler,
+		clientKeys:         clientKeys,
+		cancelHealthChecks: cancelHealthChecks,
+		pending:            true,
+	}
+	return scheduler, nil
+}
+
+// commit must be called after a configuration created by
+// newReloadableComponents() has been applied. Resources that are not
+// referenced by the new configuration are released.
+func (r *reusableResources) commit() {
+	r.backends.Commit()
+	for key, scheduler := range r.failoverSchedulers {
+		scheduler.active = scheduler.pending
+		scheduler.pending = false
+		if !scheduler.active {
+			scheduler.cancelHealthChecks()
+			delete(r.failoverSchedulers, key)
+		}
 	}
+	for key, scheduler := range r.schedulerClients {
+		scheduler.active = scheduler.pending
+		scheduler.pending = false
+		if !scheduler.active {
+			scheduler.client.Close()
+			delete(r.schedulerClients, key)
+		}
+	}
+}
 
+// abort must be called if newReloadableComponents() failed. Resources
+// that were created in the process are released.
+func (r *reusableResources) abort() {
+	r.backends.Abort()
+	for key, scheduler := range r.failoverSchedulers {
+		scheduler.pending = false
+		if !scheduler.active {
+			scheduler.cancelHealthChecks()
+			delete(r.failoverSchedulers, key)
+		}
+	}
+	for key, scheduler := range r.schedulerClients {
+		scheduler.pending = false
+		if !scheduler.active {
+			scheduler.client.Close()
+			delete(r.schedulerClients, key)
+		}
+	}
+}
+
+func newReloadableComponents(configuration *bb_storage.ApplicationConfiguration, reusable *reusableResources) (*reloadableComponents, error) {
 	// Storage access.
-	contentAddressableStorageBlobAccess, actionCache, err := blobstore_configuration.CreateBlobAccessObjectsFromConfig(
+	contentAddressableStorage, actionCache, err := blobstore_configuration.CreateBlobAccessObjectsFromConfig(
 		configuration.Blobstore,
-		int(configuration.MaximumMessageSizeBytes))
+		int(configuration.MaximumMessageSizeBytes),
+		reusable.backends)
 	if err != nil {
-		log.Fatal("Failed to create blob access: ", err)
+		return nil, util.StatusWrap(err, "Failed to create blob access")
+	}
+
+	// Sign ActionResults as they are written by trusted clients, so
+	// that entries written by other clients or by other processes
+	// can be ignored.
+	if signingConfiguration := configuration.ActionResultSigning; signingConfiguration != nil {
+		actionCache, err = signing.NewSigningBlobAccessFromConfiguration(
+			actionCache,
+			signingConfiguration,
+			clock.SystemClock,
+			int(configuration.MaximumMessageSizeBytes))
+		if err != nil {
+			return nil, util.StatusWrap(err, "Failed to create Action Cache signing")
+		}
+	}
+
+	// Hide ActionResults that are older than permitted. This needs
+	// to be applied on top of signing, so that the write times that
+	// are appended to ActionResults are covered by signatures.
+	if expiryConfiguration := configuration.ActionResultExpiry; expiryConfiguration != nil {
+		actionCache, err = expiring.NewExpiringBlobAccessFromConfiguration(
+			actionCache,
+			expiryConfiguration,
+			clock.SystemClock,
+			int(configuration.MaximumMessageSizeBytes))
+		if err != nil {
+			return nil, util.StatusWrap(err, "Failed to create Action Cache expiry")
+		}
 	}
 
 	// If this instance of bb-storage has access to all data (as in,
@@ -53,13 +265,20 @@ func main() {
 		actionCache = completenesschecking.NewCompletenessCheckingBlobAccess(
 			actionCache,
 			cas.NewBlobAccessContentAddressableStorage(
-				contentAddressableStorageBlobAccess,
+				contentAddressableStorage,
 				int(configuration.MaximumMessageSizeBytes)),
-			contentAddressableStorageBlobAccess,
+			contentAddressableStorage,
 			100,
 			int(configuration.MaximumMessageSizeBytes))
 	}
 
+	// Bazel's HTTP caching protocol identifies actions by hash
+	// only. Ignore the size of action digests for all clients, so
+	// that entries are shared between HTTP and gRPC clients.
+	if configuration.BazelHttpCache != nil {
+		actionCache = blobstore.NewSizeAgnosticBlobAccess(actionCache)
+	}
+
 	// Ensure that instance names for which we don't have a
 	// scheduler, but allow AC updates, at least have a no-op
 	// scheduler. This ensures that GetCapabilities() works for
@@ -70,21 +289,83 @@ func main() {
 		schedulers[instance] = nonExecutableScheduler
 	}
 
+	authorizer, err := auth.NewAuthorizerFromConfiguration(configuration.Authorization)
+	if err != nil {
+		return nil, util.StatusWrap(err, "Failed to create authorizer")
+	}
+
 	// Register schedulers for instances capable of compiling.
+	// Connections to schedulers are reused if their configuration
+	// is unchanged.
+	executableInstances := map[string]bool{}
 	for name, endpoint := range configuration.Schedulers {
-		scheduler, err := bb_grpc.NewGRPCClientFromConfiguration(endpoint)
+		scheduler, err := reusable.getSchedulerClient(endpoint)
 		if err != nil {
-			log.Fatal("Failed to create scheduler RPC client: ", err)
+			return nil, util.StatusWrap(err, "Failed to create scheduler RPC client")
 		}
 		schedulers[name] = builder.NewForwardingBuildQueue(scheduler)
+		executableInstances[name] = true
 	}
-	buildQueue := builder.NewDemultiplexingBuildQueue(func(instance string) (builder.BuildQueue, error) {
-		scheduler, ok := schedulers[instance]
-		if !ok {
-			return nil, status.Errorf(codes.InvalidArgument, "Unknown instance name")
+
+	// Register instances whose actions are distributed across
+	// multiple schedulers, based on their platform properties.
+	for name, routing := range configuration.PlatformRoutedSchedulers {
+		if executableInstances[name] {
+			return nil, status.Errorf(codes.InvalidArgument, "Instance %#v has multiple scheduler configurations", name)
 		}
-		return scheduler, nil
-	})
+		if len(routing.Rules) == 0 {
+			return nil, status.Errorf(codes.InvalidArgument, "Instance %#v has no platform routing rules", name)
+		}
+		rules := make([]builder.PlatformRoutingRule, 0, len(routing.Rules))
+		seenNames := map[string]bool{}
+		for _, rule := range routing.Rules {
+			if strings.ContainsRune(rule.Name, '|') || seenNames[rule.Name] {
+				return nil, status.Errorf(codes.InvalidArgument, "Platform routing rule name %#v of instance %#v is not unique or contains a pipe character", rule.Name, name)
+			}
+			seenNames[rule.Name] = true
+
+			scheduler, err := reusable.getSchedulerClient(rule.Endpoint)
+			if err != nil {
+				return nil, util.StatusWrapf(err, "Failed to create scheduler RPC client for platform routing rule %#v of instance %#v", rule.Name, name)
+			}
+			rules = append(rules, builder.PlatformRoutingRule{
+				Name:               rule.Name,
+				PlatformProperties: rule.PlatformProperties,
+				BuildQueue:         builder.NewForwardingBuildQueue(scheduler),
+			})
+		}
+		schedulers[name] = builder.NewPlatformRoutingBuildQueue(
+			cas.NewBlobAccessContentAddressableStorage(
+				contentAddressableStorage,
+				int(configuration.MaximumMessageSizeBytes)),
+			authorizer,
+			rules)
+		executableInstances[name] = true
+	}
+
+	// Register instances that are backed by multiple replicas of a
+	// scheduler, between which requests are failed over.
+	for name, replicas := range configuration.ReplicatedSchedulers {
+		if executableInstances[name] {
+			return nil, status.Errorf(codes.InvalidArgument, "Instance %#v has multiple scheduler configurations", name)
+		}
+		scheduler, err := reusable.getFailoverScheduler(name, replicas)
+		if err != nil {
+			return nil, util.StatusWrapf(err, "Failed to create replicated scheduler for instance %#v", name)
+		}
+		schedulers[name] = scheduler
+		executableInstances[name] = true
+	}
+
+	// Operation names returned by the demultiplexing build queue
+	// are prefixed with the instance name, followed by a pipe
+	// character. Reject instance names that would make these
+	// operation names ambiguous.
+	for name := range schedulers {
+		if err := builder.CheckInstanceName(name); err != nil {
+			return nil, util.StatusWrapf(err, "Invalid instance name %#v", name)
+		}
+	}
 
 	// Wrap all schedulers for which the Action Cache is writable to
 	// announce this through GetCapabilities().
@@ -94,22 +375,254 @@ func main() {
 		allowActionCacheUpdatesForInstances[instance] = true
 	}
 
+	// Announce that the ByteStream service supports compressed
+	// transfers for all instances.
+	for instance, scheduler := range schedulers {
+		schedulers[instance] = builder.NewCompressionAnnouncingBuildQueue(scheduler, cas.SupportedCompressors)
+	}
+
+	// Let schedulers return results that are already present in the
+	// Action Cache directly, thereby reducing the load on them.
+	if configuration.ServeCachedResultsFromExecute {
+		for name := range executableInstances {
+			schedulers[name] = builder.NewCachedResultBuildQueue(schedulers[name], actionCache, authorizer, int(configuration.MaximumMessageSizeBytes))
+		}
+	}
+
+	grpcAuthenticators := make([]bb_grpc.Authenticator, 0, len(configuration.GrpcServers))
+	for _, grpcServer := range configuration.GrpcServers {
+		authenticator, err := bb_grpc.NewAuthenticatorFromConfiguration(grpcServer.AuthenticationPolicy)
+		if err != nil {
+			return nil, util.StatusWrap(err, "Failed to create gRPC server authenticator")
+		}
+		grpcAuthenticators = append(grpcAuthenticators, authenticator)
+	}
+
+	var httpAuthenticator bb_grpc.Authenticator
+	if httpCache := configuration.BazelHttpCache; httpCache != nil {
+		httpAuthenticator, err = bb_grpc.NewAuthenticatorFromConfiguration(httpCache.AuthenticationPolicy)
+		if err != nil {
+			return nil, util.StatusWrap(err, "Failed to create HTTP cache authenticator")
+		}
+	}
+
+	return &reloadableComponents{
+		contentAddressableStorage:           contentAddressableStorage,
+		actionCache:                         actionCache,
+		schedulers:                          schedulers,
+		allowActionCacheUpdatesForInstances: allowActionCacheUpdatesForInstances,
+		authorizer:                          authorizer,
+		grpcAuthenticators:                  grpcAuthenticators,
+		httpAuthenticator:                   httpAuthenticator,
+	}, nil
+}
+
+// checkNonReloadableConfiguration returns an error if two versions of
+// the configuration differ in ways that can only be applied by
+// restarting bb_storage, such as changes to listen addresses.
+func checkNonReloadableConfiguration(oldConfiguration *bb_storage.ApplicationConfiguration, newConfiguration *bb_storage.ApplicationConfiguration) error {
+	clearReloadableFields := func(configuration *bb_storage.ApplicationConfiguration) *bb_storage.ApplicationConfiguration {
+		configuration = proto.Clone(configuration).(*bb_storage.ApplicationConfiguration)
+		configuration.Blobstore = nil
+		configuration.Schedulers = nil
+		configuration.PlatformRoutedSchedulers = nil
+		configuration.ReplicatedSchedulers = nil
+		configuration.AllowAcUpdatesForInstances = nil
+		configuration.VerifyActionResultCompleteness = false
+		configuration.Authorization = nil
+		configuration.ActionResultSigning = nil
+		configuration.ActionResultExpiry = nil
+		configuration.ServeCachedResultsFromExecute = false
+		for _, grpcServer := range configuration.GrpcServers {
+			grpcServer.AuthenticationPolicy = nil
+		}
+		if httpCache := configuration.BazelHttpCache; httpCache != nil {
+			httpCache.AuthenticationPolicy = nil
+		}
+		return configuration
+	}
+	if !proto.Equal(clearReloadableFields(oldConfiguration), clearReloadableFields(newConfiguration)) {
+		return status.Error(codes.InvalidArgument, "Configuration contains changes that can only be applied by restarting")
+	}
+	return nil
+}
+
+func main() {
+	if len(os.Args) != 2 {
+		log.Fatal("Usage: bb_storage bb_storage.jsonnet")
+	}
+	var configuration bb_storage.ApplicationConfiguration
+	if err := util.UnmarshalConfigurationFromFile(os.Args[1], &configuration); err != nil {
+		log.Fatalf("Failed to read configuration from %s: %s", os.Args[1], err)
+	}
+
+	if configuration.Jaeger != nil {
+		opencensus.Initialize(configuration.Jaeger)
+	}
+
+	reusable := newReusableResources()
+	components, err := newReloadableComponents(&configuration, reusable)
+	if err != nil {
+		log.Fatal(err)
+	}
+	reusable.commit()
+
+	// Components that may be replaced when the configuration is
+	// reloaded are accessed through indirections.
+	contentAddressableStorageBlobAccess := blobstore.NewSwappableBlobAccess(components.contentAddressableStorage)
+	actionCache := blobstore.NewSwappableBlobAccess(components.actionCache)
+	authorizer := auth.NewSwappableAuthorizer(components.authorizer)
+	grpcAuthenticators := make([]bb_grpc.SwappableAuthenticator, 0, len(components.grpcAuthenticators))
+	grpcServerAuthenticators := make([]bb_grpc.Authenticator, 0, len(components.grpcAuthenticators))
+	for _, authenticator := range components.grpcAuthenticators {
+		swappableAuthenticator := bb_grpc.NewSwappableAuthenticator(authenticator)
+		grpcAuthenticators = append(grpcAuthenticators, swappableAuthenticator)
+		grpcServerAuthenticators = append(grpcServerAuthenticators, swappableAuthenticator)
+	}
+	var httpAuthenticator bb_grpc.SwappableAuthenticator
+	if components.httpAuthenticator != nil {
+		httpAuthenticator = bb_grpc.NewSwappableAuthenticator(components.httpAuthenticator)
+	}
+
+	var schedulersLock sync.RWMutex
+	schedulers := components.schedulers
+	allowActionCacheUpdatesForInstances := components.allowActionCacheUpdatesForInstances
+	buildQueue := builder.NewDemultiplexingBuildQueue(func(instance string) (builder.BuildQueue, error) {
+		schedulersLock.RLock()
+		scheduler, ok := schedulers[instance]
+		schedulersLock.RUnlock()
+		if !ok {
+			return nil, status.Errorf(codes.InvalidArgument, "Unknown instance name")
+		}
+		return scheduler, nil
+	})
+	allowActionCacheUpdatesForInstance := func(instance string) bool {
+		schedulersLock.RLock()
+		defer schedulersLock.RUnlock()
+		return allowActionCacheUpdatesForInstances[instance]
+	}
+
+	// Reload the configuration upon receipt of SIGHUP. All
+	// components are created before any of them are swapped, so that
+	// a faulty configuration leaves the existing one in place.
+	reloadSignals := make(chan os.Signal, 1)
+	signal.Notify(reloadSignals, syscall.SIGHUP)
+	go func() {
+		for range reloadSignals {
+			var newConfiguration bb_storage.ApplicationConfiguration
+			if err := util.UnmarshalConfigurationFromFile(os.Args[1], &newConfiguration); err != nil {
+				log.Printf("Failed to reload configuration from %s: %s", os.Args[1], err)
+				continue
+			}
+			if err := checkNonReloadableConfiguration(&configuration, &newConfiguration); err != nil {
+				log.Printf("Failed to reload configuration from %s: %s", os.Args[1], err)
+				continue
+			}
+			components, err := newReloadableComponents(&newConfiguration, reusable)
+			if err != nil {
+				reusable.abort()
+				log.Printf("Failed to reload configuration from %s: %s", os.Args[1], err)
+				continue
+			}
+
+			contentAddressableStorageBlobAccess.Swap(components.contentAddressableStorage)
+			actionCache.Swap(components.actionCache)
+			authorizer.Swap(components.authorizer)
+			for i, authenticator := range components.grpcAuthenticators {
+				grpcAuthenticators[i].Swap(authenticator)
+			}
+			if httpAuthenticator != nil {
+				httpAuthenticator.Swap(components.httpAuthenticator)
+			}
+			schedulersLock.Lock()
+			schedulers = components.schedulers
+			allowActionCacheUpdatesForInstances = components.allowActionCacheUpdatesForInstances
+			schedulersLock.Unlock()
+			reusable.commit()
+			log.Printf("Reloaded configuration from %s", os.Args[1])
+		}
+	}()
+
+	// Directory in which partial ByteStream uploads are stored,
+	// allowing them to be resumed.
+	var uploadStagingDirectory filesystem.Directory
+	if path := configuration.UploadStagingDirectoryPath; path != "" {
+		uploadStagingDirectory, err = filesystem.NewLocalDirectory(path)
+		if err != nil {
+			log.Fatal("Failed to open upload staging directory: ", err)
+		}
+		if err := uploadStagingDirectory.RemoveAllChildren(); err != nil {
+			log.Fatal("Failed to clean upload staging directory: ", err)
+		}
+	}
+	uploadStagingIdleTimeout := time.Hour
+	if configuration.UploadStagingIdleTimeout != nil {
+		uploadStagingIdleTimeout, err = ptypes.Duration(configuration.UploadStagingIdleTimeout)
+		if err != nil {
+			log.Fatal("Failed to parse upload staging idle timeout: ", err)
+		}
+	}
+	maximumUploadStagingSizeBytes := int64(16 << 30)
+	if configuration.MaximumUploadStagingSizeBytes != 0 {
+		maximumUploadStagingSizeBytes = configuration.MaximumUploadStagingSizeBytes
+	}
+
 	go func() {
 		log.Fatal(
 			"gRPC server failure: ",
-			bb_grpc.NewGRPCServersFromConfigurationAndServe(
+			bb_grpc.NewGRPCServersFromConfigurationWithAuthenticatorsAndServe(
 				configuration.GrpcServers,
+				grpcServerAuthenticators,
 				func(s *grpc.Server) {
-					remoteexecution.RegisterActionCacheServer(s, ac.NewActionCacheServer(actionCache, allowActionCacheUpdatesForInstances, int(configuration.MaximumMessageSizeBytes)))
-					remoteexecution.RegisterContentAddressableStorageServer(s, cas.NewContentAddressableStorageServer(contentAddressableStorageBlobAccess, configuration.MaximumMessageSizeBytes))
-					bytestream.RegisterByteStreamServer(s, cas.NewByteStreamServer(contentAddressableStorageBlobAccess, 1<<16))
+					remoteexecution.RegisterActionCacheServer(s, ac.NewActionCacheServer(actionCache, allowActionCacheUpdatesForInstance, int(configuration.MaximumMessageSizeBytes), authorizer))
+					remoteexecution.RegisterContentAddressableStorageServer(s, cas.NewContentAddressableStorageServer(contentAddressableStorageBlobAccess, configuration.MaximumMessageSizeBytes, authorizer))
+					bytestream.RegisterByteStreamServer(s, cas.NewByteStreamServer(contentAddressableStorageBlobAccess, 1<<16, uploadStagingDirectory, uploadStagingIdleTimeout, maximumUploadStagingSizeBytes, clock.SystemClock, authorizer))
 					remoteexecution.RegisterCapabilitiesServer(s, buildQueue)
 					remoteexecution.RegisterExecutionServer(s, buildQueue)
 				}))
 	}()
 
+	// Optional frontend for Bazel's HTTP caching protocol, for
+	// clients that cannot use gRPC.
+	if httpCache := configuration.BazelHttpCache; httpCache != nil {
+		tlsConfig, err := util.NewTLSConfigFromServerConfiguration(httpCache.Tls)
+		if err != nil {
+			log.Fatal("Failed to create HTTP cache TLS configuration: ", err)
+		}
+		evictionSet, err := eviction.NewSetFromConfiguration(httpCache.SizeCacheReplacementPolicy)
+		if err != nil {
+			log.Fatal("Failed to create HTTP cache size cache replacement policy: ", err)
+		}
+		handler := bb_http.NewAuthenticatingHandler(
+			bb_http.NewBazelCacheHandler(
+				contentAddressableStorageBlobAccess,
+				actionCache,
+				httpCache.InstanceName,
+				allowActionCacheUpdatesForInstance,
+				int(configuration.MaximumMessageSizeBytes),
+				int(httpCache.SizeCacheSize),
+				eviction.NewMetricsSet(evictionSet, "BazelCacheHandler"),
+				authorizer),
+			httpAuthenticator)
+		for _, listenAddress := range httpCache.ListenAddresses {
+			server := &http.Server{
+				Addr:      listenAddress,
+				Handler:   handler,
+				TLSConfig: tlsConfig,
+			}
+			go func() {
+				if tlsConfig != nil {
+					log.Fatal("HTTP cache server failure: ", server.ListenAndServeTLS("", ""))
+				} else {
+					log.Fatal("HTTP cache server failure: ", server.ListenAndServe())
+				}
+			}()
+		}
+	}
+
 	// Web server for metrics and profiling.
 	router := mux.NewRouter()
 	util.RegisterAdministrativeHTTPEndpoints(router)
+	router.HandleFunc("/-/mirrored", mirrored.HandleDegradedState)
 	log.Fatal(http.ListenAndServe(configuration.HttpListenAddress, router))
 }
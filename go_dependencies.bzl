@@ -14,6 +14,8 @@ def bb_storage_go_dependencies():
         importpath = "github.com/bazelbuild/remote-apis",
         patches = [
             "@com_github_buildbarn_bb_storage//:patches/com_github_bazelbuild_remote_apis/auxiliary_metadata.diff",
+            "@com_github_buildbarn_bb_storage//:patches/com_github_bazelbuild_remote_apis/compressors.diff",
+            "@com_github_buildbarn_bb_storage//:patches/com_github_bazelbuild_remote_apis/digest_functions.diff",
             "@com_github_buildbarn_bb_storage//:patches/com_github_bazelbuild_remote_apis/golang.diff",
         ],
         sha256 = "79204ed1fa385c03b5235f65b25ced6ac51cf4b00e45e1157beca6a28bdb8043",
@@ -57,6 +59,20 @@ def bb_storage_go_dependencies():
         urls = ["https://github.com/go-redis/redis/archive/v6.15.1.tar.gz"],
     )
 
+    go_repository(
+        name = "com_github_klauspost_compress",
+        importpath = "github.com/klauspost/compress",
+        sum = "h1:OP96hzwJVBIHYU52pVTI6CczrxPvrGfgqF9N5eTO0Q8=",
+        version = "v1.10.3",
+    )
+
+    go_repository(
+        name = "com_github_zeebo_blake3",
+        importpath = "github.com/zeebo/blake3",
+        sum = "h1:sP3n5SxSbzU8x4Svc4ZcQv7SmQOqCkiKBeAZWP+hePo=",
+        version = "v0.1.0",
+    )
+
     go_repository(
         name = "com_github_grpc_ecosystem_go_grpc_prometheus",
         importpath = "github.com/grpc-ecosystem/go-grpc-prometheus",
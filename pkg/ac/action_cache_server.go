@@ -4,6 +4,7 @@ import (
 	"context"
 
 	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/pkg/auth"
 	"github.com/buildbarn/bb-storage/pkg/blobstore"
 	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
 	"github.com/buildbarn/bb-storage/pkg/digest"
@@ -13,37 +14,48 @@ import (
 )
 
 type actionCacheServer struct {
-	blobAccess               blobstore.BlobAccess
-	allowUpdatesForInstances map[string]bool
-	maximumMessageSizeBytes  int
+	blobAccess              blobstore.BlobAccess
+	allowUpdatesForInstance func(instance string) bool
+	maximumMessageSizeBytes int
+	authorizer              auth.Authorizer
 }
 
 // NewActionCacheServer creates a GRPC service for serving the contents
-// of a Bazel Action Cache (AC) to Bazel.
-func NewActionCacheServer(blobAccess blobstore.BlobAccess, allowUpdatesForInstances map[string]bool, maximumMessageSizeBytes int) remoteexecution.ActionCacheServer {
+// of a Bazel Action Cache (AC) to Bazel. Every request is checked
+// against an Authorizer before the backend is accessed. Updates are
+// only permitted for instances for which allowUpdatesForInstance
+// returns true.
+func NewActionCacheServer(blobAccess blobstore.BlobAccess, allowUpdatesForInstance func(instance string) bool, maximumMessageSizeBytes int, authorizer auth.Authorizer) remoteexecution.ActionCacheServer {
 	return &actionCacheServer{
-		blobAccess:               blobAccess,
-		allowUpdatesForInstances: allowUpdatesForInstances,
-		maximumMessageSizeBytes:  maximumMessageSizeBytes,
+		blobAccess:              blobAccess,
+		allowUpdatesForInstance: allowUpdatesForInstance,
+		maximumMessageSizeBytes: maximumMessageSizeBytes,
+		authorizer:              authorizer,
 	}
 }
 
 func (s *actionCacheServer) GetActionResult(ctx context.Context, in *remoteexecution.GetActionResultRequest) (*remoteexecution.ActionResult, error) {
-	digest, err := digest.NewDigestFromPartialDigest(in.InstanceName, in.ActionDigest)
+	digest, err := digest.NewDigestFromPartialDigest(in.InstanceName, in.DigestFunction, in.ActionDigest)
 	if err != nil {
 		return nil, err
 	}
+	if err := s.authorizer.Authorize(ctx, digest.GetInstance(), auth.OperationActionCacheRead); err != nil {
+		return nil, err
+	}
 	return s.blobAccess.Get(ctx, digest).ToActionResult(s.maximumMessageSizeBytes)
 }
 
 func (s *actionCacheServer) UpdateActionResult(ctx context.Context, in *remoteexecution.UpdateActionResultRequest) (*remoteexecution.ActionResult, error) {
-	digest, err := digest.NewDigestFromPartialDigest(in.InstanceName, in.ActionDigest)
+	digest, err := digest.NewDigestFromPartialDigest(in.InstanceName, in.DigestFunction, in.ActionDigest)
 	if err != nil {
 		return nil, err
 	}
-	if instance := digest.GetInstance(); !s.allowUpdatesForInstances[instance] {
+	if instance := digest.GetInstance(); !s.allowUpdatesForInstance(instance) {
 		return nil, status.Errorf(codes.Unimplemented, "This service can only be used to get action results for instance %#v", instance)
 	}
+	if err := s.authorizer.Authorize(ctx, digest.GetInstance(), auth.OperationActionCacheWrite); err != nil {
+		return nil, err
+	}
 	return in.ActionResult, s.blobAccess.Put(
 		ctx,
 		digest,
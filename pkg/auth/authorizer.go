@@ -0,0 +1,24 @@
+package auth
+
+import (
+	"context"
+)
+
+// Authorizer decides whether a client may perform an operation against
+// storage associated with an instance name. It is called after the
+// client has been authenticated, meaning that its identity can be
+// obtained through GetIdentityFromContext().
+type Authorizer interface {
+	Authorize(ctx context.Context, instance string, operation Operation) error
+}
+
+type allowAuthorizer struct{}
+
+func (a allowAuthorizer) Authorize(ctx context.Context, instance string, operation Operation) error {
+	return nil
+}
+
+// AllowAuthorizer is an implementation of Authorizer that permits all
+// operations. It can be used in case access control is solely
+// performed through authentication.
+var AllowAuthorizer Authorizer = allowAuthorizer{}
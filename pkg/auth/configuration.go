@@ -0,0 +1,40 @@
+package auth
+
+import (
+	pb "github.com/buildbarn/bb-storage/pkg/proto/configuration/auth"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// NewAuthorizerFromConfiguration creates an Authorizer based on rules
+// specified in a configuration file. When no configuration is
+// provided, all operations are permitted.
+func NewAuthorizerFromConfiguration(configuration *pb.AuthorizationConfiguration) (Authorizer, error) {
+	if configuration == nil {
+		return AllowAuthorizer, nil
+	}
+	rules := make([]Rule, 0, len(configuration.Rules))
+	for _, ruleConfiguration := range configuration.Rules {
+		rule := Rule{
+			InstanceNames: ruleConfiguration.InstanceNames,
+			Identities:    ruleConfiguration.Identities,
+		}
+		for _, operation := range ruleConfiguration.Operations {
+			switch operation {
+			case pb.Operation_ACTION_CACHE_READ:
+				rule.Operations = append(rule.Operations, OperationActionCacheRead)
+			case pb.Operation_ACTION_CACHE_WRITE:
+				rule.Operations = append(rule.Operations, OperationActionCacheWrite)
+			case pb.Operation_CONTENT_ADDRESSABLE_STORAGE_READ:
+				rule.Operations = append(rule.Operations, OperationContentAddressableStorageRead)
+			case pb.Operation_CONTENT_ADDRESSABLE_STORAGE_WRITE:
+				rule.Operations = append(rule.Operations, OperationContentAddressableStorageWrite)
+			default:
+				return nil, status.Errorf(codes.InvalidArgument, "Unknown operation %s", operation)
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return NewRuleBasedAuthorizer(rules), nil
+}
@@ -0,0 +1,22 @@
+package auth
+
+import (
+	"context"
+)
+
+type identityKey struct{}
+
+// NewContextWithIdentity creates a Context that stores the identity of
+// the client that issued a request, as established by an
+// Authenticator. This identity is used by Authorizers.
+func NewContextWithIdentity(ctx context.Context, identity string) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+// GetIdentityFromContext returns the identity of the client that
+// issued a request. The empty string is returned for clients for which
+// no identity has been established.
+func GetIdentityFromContext(ctx context.Context) string {
+	identity, _ := ctx.Value(identityKey{}).(string)
+	return identity
+}
@@ -0,0 +1,36 @@
+package auth
+
+// Operation is a kind of access to storage that may be granted or
+// denied by an Authorizer.
+type Operation int
+
+const (
+	// OperationActionCacheRead corresponds to obtaining entries
+	// from the Action Cache.
+	OperationActionCacheRead Operation = iota
+	// OperationActionCacheWrite corresponds to storing entries in
+	// the Action Cache.
+	OperationActionCacheWrite
+	// OperationContentAddressableStorageRead corresponds to
+	// obtaining objects from the Content Addressable Storage, or
+	// querying their existence.
+	OperationContentAddressableStorageRead
+	// OperationContentAddressableStorageWrite corresponds to
+	// storing objects in the Content Addressable Storage.
+	OperationContentAddressableStorageWrite
+)
+
+func (o Operation) String() string {
+	switch o {
+	case OperationActionCacheRead:
+		return "ActionCacheRead"
+	case OperationActionCacheWrite:
+		return "ActionCacheWrite"
+	case OperationContentAddressableStorageRead:
+		return "ContentAddressableStorageRead"
+	case OperationContentAddressableStorageWrite:
+		return "ContentAddressableStorageWrite"
+	default:
+		return "Unknown"
+	}
+}
@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"context"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// AnyIdentity may be used in Rule.Identities to match all clients,
+// including ones for which no identity has been established.
+const AnyIdentity = "*"
+
+// Rule grants a set of identities access to perform a set of
+// operations on a set of instance names.
+type Rule struct {
+	// Instance names to which the rule applies. The rule applies
+	// to all instance names if left empty.
+	InstanceNames []string
+	// Identities to which the rule applies.
+	Identities []string
+	// Operations that the rule permits.
+	Operations []Operation
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Rule) matches(identity string, instance string, operation Operation) bool {
+	if len(r.InstanceNames) > 0 && !containsString(r.InstanceNames, instance) {
+		return false
+	}
+	if !containsString(r.Identities, AnyIdentity) && !containsString(r.Identities, identity) {
+		return false
+	}
+	for _, o := range r.Operations {
+		if o == operation {
+			return true
+		}
+	}
+	return false
+}
+
+type ruleBasedAuthorizer struct {
+	rules []Rule
+}
+
+// NewRuleBasedAuthorizer creates an Authorizer that permits an
+// operation if at least one of the provided rules grants it. All other
+// operations are denied.
+func NewRuleBasedAuthorizer(rules []Rule) Authorizer {
+	return &ruleBasedAuthorizer{
+		rules: rules,
+	}
+}
+
+func (a *ruleBasedAuthorizer) Authorize(ctx context.Context, instance string, operation Operation) error {
+	identity := GetIdentityFromContext(ctx)
+	for i := range a.rules {
+		if a.rules[i].matches(identity, instance, operation) {
+			return nil
+		}
+	}
+	return status.Errorf(codes.PermissionDenied, "Identity %#v is not permitted to perform operation %s on instance %#v", identity, operation, instance)
+}
@@ -0,0 +1,56 @@
+package auth_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/pkg/auth"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRuleBasedAuthorizer(t *testing.T) {
+	// Everyone may read, but only CI may write to the Action Cache
+	// of the "main" instance.
+	authorizer := auth.NewRuleBasedAuthorizer([]auth.Rule{
+		{
+			Identities: []string{auth.AnyIdentity},
+			Operations: []auth.Operation{
+				auth.OperationActionCacheRead,
+				auth.OperationContentAddressableStorageRead,
+				auth.OperationContentAddressableStorageWrite,
+			},
+		},
+		{
+			InstanceNames: []string{"main"},
+			Identities:    []string{"ci"},
+			Operations:    []auth.Operation{auth.OperationActionCacheWrite},
+		},
+	})
+	ciContext := auth.NewContextWithIdentity(context.Background(), "ci")
+	developerContext := auth.NewContextWithIdentity(context.Background(), "developer")
+
+	t.Run("AnonymousRead", func(t *testing.T) {
+		require.NoError(t, authorizer.Authorize(context.Background(), "main", auth.OperationActionCacheRead))
+	})
+
+	t.Run("DeveloperWrite", func(t *testing.T) {
+		require.Equal(
+			t,
+			status.Error(codes.PermissionDenied, "Identity \"developer\" is not permitted to perform operation ActionCacheWrite on instance \"main\""),
+			authorizer.Authorize(developerContext, "main", auth.OperationActionCacheWrite))
+	})
+
+	t.Run("CIWrite", func(t *testing.T) {
+		require.NoError(t, authorizer.Authorize(ciContext, "main", auth.OperationActionCacheWrite))
+	})
+
+	t.Run("CIWriteOtherInstance", func(t *testing.T) {
+		require.Equal(
+			t,
+			status.Error(codes.PermissionDenied, "Identity \"ci\" is not permitted to perform operation ActionCacheWrite on instance \"other\""),
+			authorizer.Authorize(ciContext, "other", auth.OperationActionCacheWrite))
+	})
+}
@@ -0,0 +1,41 @@
+package auth
+
+import (
+	"context"
+	"sync"
+)
+
+// SwappableAuthorizer is an Authorizer that forwards all calls to an
+// Authorizer that may be replaced at runtime. It is used to apply
+// changes to authorization rules without restarting.
+type SwappableAuthorizer interface {
+	Authorizer
+
+	Swap(authorizer Authorizer)
+}
+
+type swappableAuthorizer struct {
+	lock       sync.RWMutex
+	authorizer Authorizer
+}
+
+// NewSwappableAuthorizer creates a SwappableAuthorizer that initially
+// forwards all calls to the provided Authorizer.
+func NewSwappableAuthorizer(authorizer Authorizer) SwappableAuthorizer {
+	return &swappableAuthorizer{
+		authorizer: authorizer,
+	}
+}
+
+func (a *swappableAuthorizer) Swap(authorizer Authorizer) {
+	a.lock.Lock()
+	a.authorizer = authorizer
+	a.lock.Unlock()
+}
+
+func (a *swappableAuthorizer) Authorize(ctx context.Context, instance string, operation Operation) error {
+	a.lock.RLock()
+	authorizer := a.authorizer
+	a.lock.RUnlock()
+	return authorizer.Authorize(ctx, instance, operation)
+}
@@ -13,11 +13,15 @@ func (f acStorageType) GetDigestKey(blobDigest digest.Digest) string {
 	return blobDigest.GetKey(digest.KeyWithInstance)
 }
 
+func (f acStorageType) ParseDigestKey(key string) (digest.Digest, error) {
+	return digest.NewDigestFromKey(key, digest.KeyWithInstance)
+}
+
 func (f acStorageType) NewBufferFromByteSlice(digest digest.Digest, data []byte, repairStrategy buffer.RepairStrategy) buffer.Buffer {
 	return buffer.NewACBufferFromByteSlice(data, repairStrategy)
 }
 
-func (f acStorageType) NewBufferFromReader(digest digest.Digest, r io.ReadCloser, repairStrategy buffer.RepairStrategy) buffer.Buffer {
+func (f acStorageType) NewBufferFromReader(digest digest.Digest, r io.ReadCloser, sizeBytes int64, repairStrategy buffer.RepairStrategy) buffer.Buffer {
 	return buffer.NewACBufferFromReader(r, repairStrategy)
 }
 
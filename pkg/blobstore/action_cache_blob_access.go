@@ -30,8 +30,9 @@ func NewActionCacheBlobAccess(client *grpc.ClientConn, maximumMessageSizeBytes i
 
 func (ba *actionCacheBlobAccess) Get(ctx context.Context, digest digest.Digest) buffer.Buffer {
 	actionResult, err := ba.actionCacheClient.GetActionResult(ctx, &remoteexecution.GetActionResultRequest{
-		InstanceName: digest.GetInstance(),
-		ActionDigest: digest.GetPartialDigest(),
+		InstanceName:   digest.GetInstance(),
+		ActionDigest:   digest.GetPartialDigest(),
+		DigestFunction: digest.GetDigestFunction(),
 	})
 	if err != nil {
 		return buffer.NewBufferFromError(err)
@@ -45,9 +46,10 @@ func (ba *actionCacheBlobAccess) Put(ctx context.Context, digest digest.Digest,
 		return err
 	}
 	_, err = ba.actionCacheClient.UpdateActionResult(ctx, &remoteexecution.UpdateActionResultRequest{
-		InstanceName: digest.GetInstance(),
-		ActionDigest: digest.GetPartialDigest(),
-		ActionResult: actionResult,
+		InstanceName:   digest.GetInstance(),
+		ActionDigest:   digest.GetPartialDigest(),
+		ActionResult:   actionResult,
+		DigestFunction: digest.GetDigestFunction(),
 	})
 	return err
 }
@@ -56,7 +56,7 @@ func (b *casChunkReaderBuffer) ToChunkReader(off int64, maximumChunkSizeBytes in
 }
 
 func (b *casChunkReaderBuffer) ToReader() io.ReadCloser {
-	return newChunkReaderBackedReader(b.toValidatedChunkReader())
+	return NewChunkReaderBackedReader(b.toValidatedChunkReader())
 }
 
 func (b *casChunkReaderBuffer) CloneCopy(maximumSizeBytes int) (Buffer, Buffer) {
@@ -83,5 +83,5 @@ func (b *casChunkReaderBuffer) toUnvalidatedChunkReader(off int64, maximumChunkS
 }
 
 func (b *casChunkReaderBuffer) toUnvalidatedReader(off int64) io.ReadCloser {
-	return newChunkReaderBackedReader(newOffsetChunkReader(b.r, off))
+	return NewChunkReaderBackedReader(newOffsetChunkReader(b.r, off))
 }
@@ -107,7 +107,7 @@ func (b *casClonedBuffer) ToChunkReader(off int64, maximumChunkSizeBytes int) Ch
 }
 
 func (b *casClonedBuffer) ToReader() io.ReadCloser {
-	return newChunkReaderBackedReader(b.toChunkReader(true, defaultChunkSizeBytes))
+	return NewChunkReaderBackedReader(b.toChunkReader(true, defaultChunkSizeBytes))
 }
 
 func (b *casClonedBuffer) CloneCopy(maximumSizeBytes int) (Buffer, Buffer) {
@@ -141,5 +141,5 @@ func (b *casClonedBuffer) toUnvalidatedChunkReader(off int64, maximumChunkSizeBy
 }
 
 func (b *casClonedBuffer) toUnvalidatedReader(off int64) io.ReadCloser {
-	return newChunkReaderBackedReader(b.toUnvalidatedChunkReader(off, defaultChunkSizeBytes))
+	return NewChunkReaderBackedReader(b.toUnvalidatedChunkReader(off, defaultChunkSizeBytes))
 }
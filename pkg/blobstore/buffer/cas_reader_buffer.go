@@ -95,7 +95,7 @@ func (b *casReaderBuffer) ToChunkReader(off int64, maximumChunkSizeBytes int) Ch
 		r.Close()
 		return newErrorChunkReader(err)
 	}
-	return newReaderBackedChunkReader(r, maximumChunkSizeBytes)
+	return NewReaderBackedChunkReader(r, maximumChunkSizeBytes)
 }
 
 func (b *casReaderBuffer) ToReader() io.ReadCloser {
@@ -126,7 +126,7 @@ func (b *casReaderBuffer) toUnvalidatedChunkReader(off int64, maximumChunkSizeBy
 		b.r.Close()
 		return newErrorChunkReader(err)
 	}
-	return newReaderBackedChunkReader(b.r, maximumChunkSizeBytes)
+	return NewReaderBackedChunkReader(b.r, maximumChunkSizeBytes)
 }
 
 func (b *casReaderBuffer) toUnvalidatedReader(off int64) io.ReadCloser {
@@ -9,7 +9,10 @@ type chunkReaderBackedReader struct {
 	lastChunk []byte
 }
 
-func newChunkReaderBackedReader(r ChunkReader) io.ReadCloser {
+// NewChunkReaderBackedReader creates a ReadCloser based on an existing
+// ChunkReader. Chunks returned by the ChunkReader are copied into the
+// output arrays provided to Read().
+func NewChunkReaderBackedReader(r ChunkReader) io.ReadCloser {
 	return &chunkReaderBackedReader{
 		r: r,
 	}
@@ -3,6 +3,7 @@ package buffer_test
 import (
 	"testing"
 
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
 	"github.com/buildbarn/bb-storage/internal/mock"
 	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
 	"github.com/buildbarn/bb-storage/pkg/digest"
@@ -34,7 +35,7 @@ func TestNewCASBufferFromByteSliceSuccess(t *testing.T) {
 		// SHA-512:
 		"b1d33bb21db304209f584b55e1a86db38c7c44c466c680c38805db07a92d43260d0e82ffd0a48c337d40372a4ac5b9be1ff24beef2c990e6ea3f2079d067b0e0": []byte("Ridiculously long checksums"),
 	} {
-		digest := digest.MustNewDigest("fedora29", hash, int64(len(body)))
+		digest := digest.MustNewDigest("fedora29", remoteexecution.DigestFunction_UNKNOWN, hash, int64(len(body)))
 		repairFunc := mock.NewMockRepairFunc(ctrl)
 
 		data, err := buffer.NewCASBufferFromByteSlice(
@@ -50,7 +51,7 @@ func TestNewCASBufferFromByteSliceSizeMismatch(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 
-	digest := digest.MustNewDigest("ubuntu1804", "8b1a9953c4611296a827abf8c47804d7", 6)
+	digest := digest.MustNewDigest("ubuntu1804", remoteexecution.DigestFunction_MD5, "8b1a9953c4611296a827abf8c47804d7", 6)
 	repairFunc := mock.NewMockRepairFunc(ctrl)
 	repairFunc.EXPECT().Call()
 
@@ -65,7 +66,7 @@ func TestNewCASBufferFromByteSliceHashMismatch(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 
-	digest := digest.MustNewDigest("ubuntu1804", "d41d8cd98f00b204e9800998ecf8427e", 5)
+	digest := digest.MustNewDigest("ubuntu1804", remoteexecution.DigestFunction_MD5, "d41d8cd98f00b204e9800998ecf8427e", 5)
 	repairFunc := mock.NewMockRepairFunc(ctrl)
 	repairFunc.EXPECT().Call()
 
@@ -5,6 +5,7 @@ import (
 	"io"
 	"testing"
 
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
 	"github.com/buildbarn/bb-storage/internal/mock"
 	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
 	"github.com/buildbarn/bb-storage/pkg/digest"
@@ -20,7 +21,7 @@ func TestNewCASBufferFromChunkReaderGetSizeBytes(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 
-	helloDigest := digest.MustNewDigest("foo", "8b1a9953c4611296a827abf8c47804d7", 5)
+	helloDigest := digest.MustNewDigest("foo", remoteexecution.DigestFunction_MD5, "8b1a9953c4611296a827abf8c47804d7", 5)
 	chunkReader := mock.NewMockChunkReader(ctrl)
 	chunkReader.EXPECT().Close()
 
@@ -35,7 +36,7 @@ func TestNewCASBufferFromChunkReaderIntoWriter(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 
-	helloDigest := digest.MustNewDigest("foo", "8b1a9953c4611296a827abf8c47804d7", 5)
+	helloDigest := digest.MustNewDigest("foo", remoteexecution.DigestFunction_MD5, "8b1a9953c4611296a827abf8c47804d7", 5)
 
 	t.Run("Success", func(t *testing.T) {
 		chunkReader := mock.NewMockChunkReader(ctrl)
@@ -87,7 +88,7 @@ func TestNewCASBufferFromChunkReaderReadAt(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 
-	helloDigest := digest.MustNewDigest("foo", "8b1a9953c4611296a827abf8c47804d7", 5)
+	helloDigest := digest.MustNewDigest("foo", remoteexecution.DigestFunction_MD5, "8b1a9953c4611296a827abf8c47804d7", 5)
 
 	t.Run("Success", func(t *testing.T) {
 		chunkReader := mock.NewMockChunkReader(ctrl)
@@ -290,7 +291,7 @@ func TestNewCASBufferFromChunkReaderToActionResult(t *testing.T) {
 		chunkReader.EXPECT().Close()
 		repairFunc := mock.NewMockRepairFunc(ctrl)
 
-		helloDigest := digest.MustNewDigest("foo", "8b1a9953c4611296a827abf8c47804d7", 5)
+		helloDigest := digest.MustNewDigest("foo", remoteexecution.DigestFunction_MD5, "8b1a9953c4611296a827abf8c47804d7", 5)
 		_, err := buffer.NewCASBufferFromChunkReader(
 			helloDigest,
 			chunkReader,
@@ -326,7 +327,7 @@ func TestNewCASBufferFromChunkReaderToByteSlice(t *testing.T) {
 		chunkReader.EXPECT().Close()
 		repairFunc := mock.NewMockRepairFunc(ctrl)
 
-		helloDigest := digest.MustNewDigest("foo", "8b1a9953c4611296a827abf8c47804d7", 5)
+		helloDigest := digest.MustNewDigest("foo", remoteexecution.DigestFunction_MD5, "8b1a9953c4611296a827abf8c47804d7", 5)
 		data, err := buffer.NewCASBufferFromChunkReader(
 			helloDigest,
 			chunkReader,
@@ -344,7 +345,7 @@ func TestNewCASBufferFromChunkReaderToByteSlice(t *testing.T) {
 		chunkReader.EXPECT().Close()
 		repairFunc := mock.NewMockRepairFunc(ctrl)
 
-		emptyDigest := digest.MustNewDigest("empty", "d41d8cd98f00b204e9800998ecf8427e", 0)
+		emptyDigest := digest.MustNewDigest("empty", remoteexecution.DigestFunction_MD5, "d41d8cd98f00b204e9800998ecf8427e", 0)
 		data, err := buffer.NewCASBufferFromChunkReader(
 			emptyDigest,
 			chunkReader,
@@ -358,7 +359,7 @@ func TestNewCASBufferFromChunkReaderToChunkReader(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 
-	helloDigest := digest.MustNewDigest("foo", "3e25960a79dbc69b674cd4ec67a72c62", 11)
+	helloDigest := digest.MustNewDigest("foo", remoteexecution.DigestFunction_MD5, "3e25960a79dbc69b674cd4ec67a72c62", 11)
 
 	t.Run("Success", func(t *testing.T) {
 		chunkReader := mock.NewMockChunkReader(ctrl)
@@ -493,7 +494,7 @@ func TestNewCASBufferFromChunkReaderToReader(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 
-	helloDigest := digest.MustNewDigest("foo", "3e25960a79dbc69b674cd4ec67a72c62", 11)
+	helloDigest := digest.MustNewDigest("foo", remoteexecution.DigestFunction_MD5, "3e25960a79dbc69b674cd4ec67a72c62", 11)
 
 	t.Run("Success", func(t *testing.T) {
 		chunkReader := mock.NewMockChunkReader(ctrl)
@@ -565,7 +566,7 @@ func TestNewCASBufferFromChunkReaderCloneCopy(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 
-	helloDigest := digest.MustNewDigest("foo", "8b1a9953c4611296a827abf8c47804d7", 5)
+	helloDigest := digest.MustNewDigest("foo", remoteexecution.DigestFunction_MD5, "8b1a9953c4611296a827abf8c47804d7", 5)
 
 	t.Run("Success", func(t *testing.T) {
 		chunkReader := mock.NewMockChunkReader(ctrl)
@@ -647,7 +648,7 @@ func TestNewCASBufferFromChunkReaderCloneStream(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 
-	helloDigest := digest.MustNewDigest("foo", "8b1a9953c4611296a827abf8c47804d7", 5)
+	helloDigest := digest.MustNewDigest("foo", remoteexecution.DigestFunction_MD5, "8b1a9953c4611296a827abf8c47804d7", 5)
 
 	t.Run("Success", func(t *testing.T) {
 		chunkReader := mock.NewMockChunkReader(ctrl)
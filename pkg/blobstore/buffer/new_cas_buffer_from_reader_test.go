@@ -6,6 +6,7 @@ import (
 	"io/ioutil"
 	"testing"
 
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
 	"github.com/buildbarn/bb-storage/internal/mock"
 	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
 	"github.com/buildbarn/bb-storage/pkg/digest"
@@ -21,7 +22,7 @@ func TestNewCASBufferFromReaderGetSizeBytes(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 
-	helloDigest := digest.MustNewDigest("foo", "8b1a9953c4611296a827abf8c47804d7", 5)
+	helloDigest := digest.MustNewDigest("foo", remoteexecution.DigestFunction_MD5, "8b1a9953c4611296a827abf8c47804d7", 5)
 	reader := mock.NewMockReadCloser(ctrl)
 	reader.EXPECT().Close()
 
@@ -36,7 +37,7 @@ func TestNewCASBufferFromReaderIntoWriter(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 
-	helloDigest := digest.MustNewDigest("foo", "8b1a9953c4611296a827abf8c47804d7", 5)
+	helloDigest := digest.MustNewDigest("foo", remoteexecution.DigestFunction_MD5, "8b1a9953c4611296a827abf8c47804d7", 5)
 
 	t.Run("Success", func(t *testing.T) {
 		reader := ioutil.NopCloser(bytes.NewBufferString("Hello"))
@@ -85,7 +86,7 @@ func TestNewCASBufferFromReaderReadAt(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 
-	helloDigest := digest.MustNewDigest("foo", "8b1a9953c4611296a827abf8c47804d7", 5)
+	helloDigest := digest.MustNewDigest("foo", remoteexecution.DigestFunction_MD5, "8b1a9953c4611296a827abf8c47804d7", 5)
 
 	t.Run("Success", func(t *testing.T) {
 		reader := ioutil.NopCloser(bytes.NewBufferString("Hello"))
@@ -256,7 +257,7 @@ func TestNewCASBufferFromReaderToActionResult(t *testing.T) {
 		reader := ioutil.NopCloser(bytes.NewBufferString("Hello"))
 		repairFunc := mock.NewMockRepairFunc(ctrl)
 
-		helloDigest := digest.MustNewDigest("foo", "8b1a9953c4611296a827abf8c47804d7", 5)
+		helloDigest := digest.MustNewDigest("foo", remoteexecution.DigestFunction_MD5, "8b1a9953c4611296a827abf8c47804d7", 5)
 		_, err := buffer.NewCASBufferFromReader(
 			helloDigest,
 			reader,
@@ -288,7 +289,7 @@ func TestNewCASBufferFromReaderToByteSlice(t *testing.T) {
 		reader := ioutil.NopCloser(bytes.NewBufferString("Hello"))
 		repairFunc := mock.NewMockRepairFunc(ctrl)
 
-		helloDigest := digest.MustNewDigest("foo", "8b1a9953c4611296a827abf8c47804d7", 5)
+		helloDigest := digest.MustNewDigest("foo", remoteexecution.DigestFunction_MD5, "8b1a9953c4611296a827abf8c47804d7", 5)
 		data, err := buffer.NewCASBufferFromReader(
 			helloDigest,
 			reader,
@@ -301,7 +302,7 @@ func TestNewCASBufferFromReaderToByteSlice(t *testing.T) {
 		reader := ioutil.NopCloser(bytes.NewBuffer(nil))
 		repairFunc := mock.NewMockRepairFunc(ctrl)
 
-		emptyDigest := digest.MustNewDigest("empty", "d41d8cd98f00b204e9800998ecf8427e", 0)
+		emptyDigest := digest.MustNewDigest("empty", remoteexecution.DigestFunction_MD5, "d41d8cd98f00b204e9800998ecf8427e", 0)
 		data, err := buffer.NewCASBufferFromReader(
 			emptyDigest,
 			reader,
@@ -317,6 +318,7 @@ func TestNewCASBufferFromReaderToChunkReader(t *testing.T) {
 
 	helloDigest := digest.MustNewDigest(
 		"foo",
+		remoteexecution.DigestFunction_MD5,
 		"3e25960a79dbc69b674cd4ec67a72c62",
 		11)
 
@@ -435,7 +437,7 @@ func TestNewCASBufferFromReaderToReader(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 
-	helloDigest := digest.MustNewDigest("foo", "3e25960a79dbc69b674cd4ec67a72c62", 11)
+	helloDigest := digest.MustNewDigest("foo", remoteexecution.DigestFunction_MD5, "3e25960a79dbc69b674cd4ec67a72c62", 11)
 
 	t.Run("Success", func(t *testing.T) {
 		reader := ioutil.NopCloser(bytes.NewBufferString("Hello world"))
@@ -496,6 +498,7 @@ func TestNewCASBufferFromReaderCloneCopy(t *testing.T) {
 
 	helloDigest := digest.MustNewDigest(
 		"foo",
+		remoteexecution.DigestFunction_MD5,
 		"8b1a9953c4611296a827abf8c47804d7",
 		5)
 
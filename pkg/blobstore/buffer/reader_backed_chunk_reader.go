@@ -9,10 +9,10 @@ type readerBackedChunkReader struct {
 	maximumChunkSizeBytes int
 }
 
-// newReaderBackedChunkReader creates a ChunkReader based on an existing
+// NewReaderBackedChunkReader creates a ChunkReader based on an existing
 // ReadCloser. It attempts to read data from the ReadCloser, turning it
 // into chunks of the maximum permitted size.
-func newReaderBackedChunkReader(r io.ReadCloser, maximumChunkSizeBytes int) ChunkReader {
+func NewReaderBackedChunkReader(r io.ReadCloser, maximumChunkSizeBytes int) ChunkReader {
 	return &readerBackedChunkReader{
 		r:                     r,
 		maximumChunkSizeBytes: maximumChunkSizeBytes,
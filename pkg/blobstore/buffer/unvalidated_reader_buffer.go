@@ -0,0 +1,167 @@
+package buffer
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type unvalidatedReaderBuffer struct {
+	r              io.ReadCloser
+	sizeBytes      int64
+	repairStrategy RepairStrategy
+}
+
+// NewUnvalidatedBufferFromReader creates a buffer for an object whose
+// contents don't correspond with its digest, such as an object that is
+// stored in compressed form. Its contents are streamed from a
+// ReadCloser without being validated.
+//
+// The repair strategy is retained, so that it may be invoked by
+// NewCASBufferFromUnvalidatedBuffer() in case the contents turn out to
+// be invalid after decoding.
+func NewUnvalidatedBufferFromReader(r io.ReadCloser, sizeBytes int64, repairStrategy RepairStrategy) Buffer {
+	return &unvalidatedReaderBuffer{
+		r:              r,
+		sizeBytes:      sizeBytes,
+		repairStrategy: repairStrategy,
+	}
+}
+
+// NewCASBufferFromUnvalidatedBuffer creates a buffer for an object
+// stored in the Content Addressable Storage, whose contents are
+// obtained by decoding the contents of another buffer, e.g. by
+// decompressing them. The decoded contents are validated against the
+// digest.
+//
+// If the other buffer was created using
+// NewUnvalidatedBufferFromReader(), data consistency issues are
+// reported through its repair strategy, so that the storage backend
+// may repair the object.
+func NewCASBufferFromUnvalidatedBuffer(digest digest.Digest, b Buffer, decoder func(r io.ReadCloser) (io.ReadCloser, error)) Buffer {
+	repairStrategy := Irreparable
+	if bUnvalidated, ok := b.(*unvalidatedReaderBuffer); ok {
+		repairStrategy = bUnvalidated.repairStrategy
+	}
+	r, err := decoder(b.ToReader())
+	if err != nil {
+		return NewBufferFromError(err)
+	}
+	return NewCASBufferFromReader(digest, r, repairStrategy)
+}
+
+// newBufferFromByteSlice creates a copy of the buffer whose contents
+// have been loaded into memory, retaining the repair strategy.
+func (b *unvalidatedReaderBuffer) newBufferFromByteSlice(data []byte) Buffer {
+	return NewUnvalidatedBufferFromReader(ioutil.NopCloser(bytes.NewReader(data)), int64(len(data)), b.repairStrategy)
+}
+
+func (b *unvalidatedReaderBuffer) GetSizeBytes() (int64, error) {
+	return b.sizeBytes, nil
+}
+
+func (b *unvalidatedReaderBuffer) IntoWriter(w io.Writer) error {
+	defer b.r.Close()
+
+	_, err := io.Copy(w, b.r)
+	return err
+}
+
+func (b *unvalidatedReaderBuffer) ReadAt(p []byte, off int64) (int, error) {
+	defer b.r.Close()
+
+	if err := validateReaderOffset(b.sizeBytes, off); err != nil {
+		return 0, err
+	}
+	if err := discardFromReader(b.r, off); err != nil {
+		return 0, err
+	}
+	n, err := io.ReadFull(b.r, p)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return n, io.EOF
+	} else if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (b *unvalidatedReaderBuffer) ToActionResult(maximumSizeBytes int) (*remoteexecution.ActionResult, error) {
+	return toActionResultViaByteSlice(b, maximumSizeBytes)
+}
+
+func (b *unvalidatedReaderBuffer) ToByteSlice(maximumSizeBytes int) ([]byte, error) {
+	defer b.r.Close()
+
+	if b.sizeBytes > int64(maximumSizeBytes) {
+		return nil, status.Errorf(codes.InvalidArgument, "Buffer is %d bytes in size, while a maximum of %d bytes is permitted", b.sizeBytes, maximumSizeBytes)
+	}
+	return ioutil.ReadAll(b.r)
+}
+
+func (b *unvalidatedReaderBuffer) ToChunkReader(off int64, maximumChunkSizeBytes int) ChunkReader {
+	if err := validateReaderOffset(b.sizeBytes, off); err != nil {
+		b.r.Close()
+		return newErrorChunkReader(err)
+	}
+	return b.toUnvalidatedChunkReader(off, maximumChunkSizeBytes)
+}
+
+func (b *unvalidatedReaderBuffer) ToReader() io.ReadCloser {
+	return b.r
+}
+
+func (b *unvalidatedReaderBuffer) CloneCopy(maximumSizeBytes int) (Buffer, Buffer) {
+	if b.sizeBytes > int64(maximumSizeBytes) {
+		b.r.Close()
+		return NewBufferFromError(status.Errorf(codes.InvalidArgument, "Buffer is %d bytes in size, while a maximum of %d bytes is permitted", b.sizeBytes, maximumSizeBytes)).CloneCopy(maximumSizeBytes)
+	}
+	return b.CloneStream()
+}
+
+func (b *unvalidatedReaderBuffer) CloneStream() (Buffer, Buffer) {
+	// Multiplexing the stream would require the contents to be
+	// validated. Load the object into memory instead.
+	data, err := b.ToByteSlice(int(b.sizeBytes))
+	if err != nil {
+		return NewBufferFromError(err).CloneStream()
+	}
+	return b.newBufferFromByteSlice(data), b.newBufferFromByteSlice(data)
+}
+
+func (b *unvalidatedReaderBuffer) Discard() {
+	b.r.Close()
+}
+
+func (b *unvalidatedReaderBuffer) applyErrorHandler(errorHandler ErrorHandler) (Buffer, bool) {
+	// As the contents of the buffer cannot be validated, there is
+	// no way to detect errors after data has been returned. Load
+	// the object into memory, so that I/O errors can be handled.
+	data, err := b.ToByteSlice(int(b.sizeBytes))
+	if err != nil {
+		return NewBufferFromError(err), true
+	}
+	errorHandler.Done()
+	return b.newBufferFromByteSlice(data), false
+}
+
+func (b *unvalidatedReaderBuffer) toUnvalidatedChunkReader(off int64, maximumChunkSizeBytes int) ChunkReader {
+	if err := discardFromReader(b.r, off); err != nil {
+		b.r.Close()
+		return newErrorChunkReader(err)
+	}
+	return NewReaderBackedChunkReader(b.r, maximumChunkSizeBytes)
+}
+
+func (b *unvalidatedReaderBuffer) toUnvalidatedReader(off int64) io.ReadCloser {
+	if err := discardFromReader(b.r, off); err != nil {
+		b.r.Close()
+		return newErrorReader(err)
+	}
+	return b.r
+}
@@ -6,6 +6,7 @@ import (
 	"io/ioutil"
 	"testing"
 
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
 	"github.com/buildbarn/bb-storage/internal/mock"
 	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
 	"github.com/buildbarn/bb-storage/pkg/digest"
@@ -77,7 +78,7 @@ func TestWithErrorHandlerOnSimpleCASBuffers(t *testing.T) {
 	// ErrorHandler evaluation. It is sufficient to only test
 	// ToByteSlice().
 
-	digest := digest.MustNewDigest("instance", "3e25960a79dbc69b674cd4ec67a72c62", 11)
+	digest := digest.MustNewDigest("instance", remoteexecution.DigestFunction_MD5, "3e25960a79dbc69b674cd4ec67a72c62", 11)
 
 	t.Run("ImmediateSuccess", func(t *testing.T) {
 		errorHandler := mock.NewMockErrorHandler(ctrl)
@@ -124,7 +125,7 @@ func TestWithErrorHandlerOnCASBuffersIntoWriter(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 
-	digest := digest.MustNewDigest("instance", "3e25960a79dbc69b674cd4ec67a72c62", 11)
+	digest := digest.MustNewDigest("instance", remoteexecution.DigestFunction_MD5, "3e25960a79dbc69b674cd4ec67a72c62", 11)
 
 	t.Run("RetriesFailure", func(t *testing.T) {
 		reader1 := mock.NewMockChunkReader(ctrl)
@@ -310,7 +311,7 @@ func TestWithErrorHandlerOnCASBuffersToChunkReader(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 
-	digest := digest.MustNewDigest("instance", "3e25960a79dbc69b674cd4ec67a72c62", 11)
+	digest := digest.MustNewDigest("instance", remoteexecution.DigestFunction_MD5, "3e25960a79dbc69b674cd4ec67a72c62", 11)
 
 	t.Run("RetriesFailure", func(t *testing.T) {
 		reader1 := mock.NewMockChunkReader(ctrl)
@@ -407,7 +408,7 @@ func TestWithErrorHandlerOnCASBuffersToReader(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 
-	digest := digest.MustNewDigest("instance", "3e25960a79dbc69b674cd4ec67a72c62", 11)
+	digest := digest.MustNewDigest("instance", remoteexecution.DigestFunction_MD5, "3e25960a79dbc69b674cd4ec67a72c62", 11)
 
 	t.Run("RetriesFailure", func(t *testing.T) {
 		reader1 := mock.NewMockChunkReader(ctrl)
@@ -487,7 +488,7 @@ func TestWithErrorHandlerOnCASBuffersCloneCopy(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 
-	digest := digest.MustNewDigest("instance", "3e25960a79dbc69b674cd4ec67a72c62", 11)
+	digest := digest.MustNewDigest("instance", remoteexecution.DigestFunction_MD5, "3e25960a79dbc69b674cd4ec67a72c62", 11)
 	reader1 := mock.NewMockReadCloser(ctrl)
 	reader1.EXPECT().Read(gomock.Any()).Return(0, status.Error(codes.Internal, "Connection closed"))
 	reader1.EXPECT().Close().Return(nil)
@@ -513,7 +514,7 @@ func TestWithErrorHandlerOnCASBuffersCloneStream(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 
-	digest := digest.MustNewDigest("instance", "3e25960a79dbc69b674cd4ec67a72c62", 11)
+	digest := digest.MustNewDigest("instance", remoteexecution.DigestFunction_MD5, "3e25960a79dbc69b674cd4ec67a72c62", 11)
 	reader1 := mock.NewMockReadCloser(ctrl)
 	reader1.EXPECT().Read(gomock.Any()).Return(0, status.Error(codes.Internal, "Connection closed"))
 	reader1.EXPECT().Close().Return(nil)
@@ -13,11 +13,15 @@ func (f casStorageType) GetDigestKey(blobDigest digest.Digest) string {
 	return blobDigest.GetKey(digest.KeyWithoutInstance)
 }
 
+func (f casStorageType) ParseDigestKey(key string) (digest.Digest, error) {
+	return digest.NewDigestFromKey(key, digest.KeyWithoutInstance)
+}
+
 func (f casStorageType) NewBufferFromByteSlice(digest digest.Digest, data []byte, repairStrategy buffer.RepairStrategy) buffer.Buffer {
 	return buffer.NewCASBufferFromByteSlice(digest, data, repairStrategy)
 }
 
-func (f casStorageType) NewBufferFromReader(digest digest.Digest, r io.ReadCloser, repairStrategy buffer.RepairStrategy) buffer.Buffer {
+func (f casStorageType) NewBufferFromReader(digest digest.Digest, r io.ReadCloser, sizeBytes int64, repairStrategy buffer.RepairStrategy) buffer.Buffer {
 	return buffer.NewCASBufferFromReader(digest, r, repairStrategy)
 }
 
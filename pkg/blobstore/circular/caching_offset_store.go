@@ -66,3 +66,7 @@ func (os *cachingOffsetStore) Put(digest digest.Digest, offset uint64, length in
 	}
 	return nil
 }
+
+func (os *cachingOffsetStore) Walk(cursors Cursors, position string, walkFunc OffsetStoreWalkFunc) error {
+	return os.backend.Walk(cursors, position, walkFunc)
+}
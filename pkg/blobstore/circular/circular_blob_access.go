@@ -10,6 +10,7 @@ import (
 	"github.com/buildbarn/bb-storage/pkg/blobstore"
 	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
 	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
 
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -17,11 +18,21 @@ import (
 	"go.opencensus.io/trace"
 )
 
+// OffsetStoreWalkFunc is the callback type that is invoked by
+// OffsetStore.Walk() for every entry stored in the offset store. The
+// position may be provided to a subsequent call to Walk() to resume
+// walking after this entry.
+type OffsetStoreWalkFunc func(digest digest.Digest, offset uint64, length int64, position string) error
+
 // OffsetStore maps a digest to an offset within the data file. This is
-// where the blob's contents may be found.
+// where the blob's contents may be found. Walk() invokes a callback for
+// every entry that refers to data contained within the cursors,
+// starting at the provided position. An empty position corresponds to
+// the start of the offset store.
 type OffsetStore interface {
 	Get(digest digest.Digest, cursors Cursors) (uint64, int64, bool, error)
 	Put(digest digest.Digest, offset uint64, length int64, cursors Cursors) error
+	Walk(cursors Cursors, position string, walkFunc OffsetStoreWalkFunc) error
 }
 
 // DataStore is where the data corresponding with a blob is stored. Data
@@ -55,7 +66,11 @@ type circularBlobAccess struct {
 // NewCircularBlobAccess creates a new circular storage backend. Instead
 // of writing data to storage directly, all three storage files are
 // injected through separate interfaces.
-func NewCircularBlobAccess(offsetStore OffsetStore, dataStore DataStore, stateStore StateStore, storageType blobstore.StorageType) blobstore.BlobAccess {
+//
+// The contents of the data file may be validated by calling Scrub().
+// Like for Get(), detecting a corrupted blob causes all data up to and
+// including the blob to be invalidated.
+func NewCircularBlobAccess(offsetStore OffsetStore, dataStore DataStore, stateStore StateStore, storageType blobstore.StorageType) blobstore.ScrubbableBlobAccess {
 	return &circularBlobAccess{
 		offsetStore: offsetStore,
 		dataStore:   dataStore,
@@ -81,18 +96,44 @@ func (ba *circularBlobAccess) Get(ctx context.Context, digest digest.Digest) buf
 	if err != nil {
 		return buffer.NewBufferFromError(err)
 	} else if ok {
-		return ba.storageType.NewBufferFromReader(
-			digest,
-			ioutil.NopCloser(ba.dataStore.Get(offset, length)),
-			buffer.Reparable(digest, func() error {
-				ba.lock.Lock()
-				defer ba.lock.Unlock()
-				return ba.stateStore.Invalidate(offset, length)
-			}))
+		return ba.newBuffer(digest, offset, length, ba.newRepairStrategy(digest, offset, length))
 	}
 	return buffer.NewBufferFromError(status.Errorf(codes.NotFound, "Blob not found"))
 }
 
+func (ba *circularBlobAccess) GetReadOnly(ctx context.Context, digest digest.Digest) buffer.Buffer {
+	ba.lock.Lock()
+	cursors := ba.stateStore.GetCursors()
+	offset, length, ok, err := ba.offsetStore.Get(digest, cursors)
+	ba.lock.Unlock()
+	if err != nil {
+		return buffer.NewBufferFromError(err)
+	} else if ok {
+		return ba.newBuffer(digest, offset, length, buffer.Irreparable)
+	}
+	return buffer.NewBufferFromError(status.Errorf(codes.NotFound, "Blob not found"))
+}
+
+// newBuffer creates a buffer for a blob stored in the data store.
+func (ba *circularBlobAccess) newBuffer(digest digest.Digest, offset uint64, length int64, repairStrategy buffer.RepairStrategy) buffer.Buffer {
+	return ba.storageType.NewBufferFromReader(
+		digest,
+		ioutil.NopCloser(ba.dataStore.Get(offset, length)),
+		length,
+		repairStrategy)
+}
+
+// newRepairStrategy creates a repair strategy for a blob stored in the
+// data store. When the blob is observed to be corrupted, all data up to
+// and including the blob is invalidated.
+func (ba *circularBlobAccess) newRepairStrategy(digest digest.Digest, offset uint64, length int64) buffer.RepairStrategy {
+	return buffer.Reparable(digest, func() error {
+		ba.lock.Lock()
+		defer ba.lock.Unlock()
+		return ba.stateStore.Invalidate(offset, length)
+	})
+}
+
 func (ba *circularBlobAccess) Put(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
 	sizeBytes, err := b.GetSizeBytes()
 	if err != nil {
@@ -152,3 +193,48 @@ func (ba *circularBlobAccess) FindMissing(ctx context.Context, digests digest.Se
 	}
 	return missingDigests.Build(), nil
 }
+
+func (ba *circularBlobAccess) Scrub(ctx context.Context, scrubFunc blobstore.ScrubFunc) error {
+	ba.lock.Lock()
+	defer ba.lock.Unlock()
+
+	return ba.offsetStore.Walk(ba.stateStore.GetCursors(), "", func(blobDigest digest.Digest, offset uint64, length int64, position string) error {
+		// Data may have been overwritten or invalidated while
+		// the lock was released.
+		if cursors := ba.stateStore.GetCursors(); !cursors.Contains(offset, length) {
+			return nil
+		}
+		b := ba.newBuffer(blobDigest, offset, length, ba.newRepairStrategy(blobDigest, offset, length))
+
+		// Validate the blob while unlocked, so that concurrent
+		// requests continue to be serviced.
+		ba.lock.Unlock()
+		err := scrubFunc(blobDigest, b)
+		ba.lock.Lock()
+		return err
+	})
+}
+
+func (ba *circularBlobAccess) Enumerate(ctx context.Context, cursor string, enumerateFunc blobstore.EnumerateFunc) error {
+	ba.lock.Lock()
+	defer ba.lock.Unlock()
+
+	// Positions in the offset store are used as cursors.
+	return ba.offsetStore.Walk(ba.stateStore.GetCursors(), cursor, func(blobDigest digest.Digest, offset uint64, length int64, position string) error {
+		if err := util.StatusFromContext(ctx); err != nil {
+			return err
+		}
+		// Data may have been overwritten or invalidated while
+		// the lock was released.
+		if cursors := ba.stateStore.GetCursors(); !cursors.Contains(offset, length) {
+			return nil
+		}
+
+		// Invoke the callback while unlocked, so that concurrent
+		// requests continue to be serviced.
+		ba.lock.Unlock()
+		err := enumerateFunc(blobDigest, position)
+		ba.lock.Lock()
+		return err
+	})
+}
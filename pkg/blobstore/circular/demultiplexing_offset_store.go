@@ -1,16 +1,18 @@
 package circular
 
 import (
+	"sort"
+	"strings"
+
 	"github.com/buildbarn/bb-storage/pkg/digest"
 	"github.com/buildbarn/bb-storage/pkg/util"
-)
 
-// OffsetStoreGetter is the callback type used by the demultiplexing
-// offset store and is invoked for every operation.
-type OffsetStoreGetter func(instanceName string) (OffsetStore, error)
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
 
 type demultiplexingOffsetStore struct {
-	offsetStoreGetter OffsetStoreGetter
+	offsetStores map[string]OffsetStore
 }
 
 // NewDemultiplexingOffsetStore creates an OffsetStore that
@@ -18,26 +20,72 @@ type demultiplexingOffsetStore struct {
 // provided digest. This may be used for Action Cache purposes, where a
 // single storage server may be used to store cached actions for
 // multiple instance names.
-func NewDemultiplexingOffsetStore(offsetStoreGetter OffsetStoreGetter) OffsetStore {
+func NewDemultiplexingOffsetStore(offsetStores map[string]OffsetStore) OffsetStore {
 	return &demultiplexingOffsetStore{
-		offsetStoreGetter: offsetStoreGetter,
+		offsetStores: offsetStores,
 	}
 }
 
-func (os *demultiplexingOffsetStore) Get(digest digest.Digest, cursors Cursors) (uint64, int64, bool, error) {
+func (os *demultiplexingOffsetStore) getOffsetStore(digest digest.Digest) (OffsetStore, error) {
 	instance := digest.GetInstance()
-	backend, err := os.offsetStoreGetter(instance)
+	if backend, ok := os.offsetStores[instance]; ok {
+		return backend, nil
+	}
+	return nil, status.Errorf(codes.InvalidArgument, "Failed to obtain offset store for instance %#v: Unknown instance name", instance)
+}
+
+func (os *demultiplexingOffsetStore) Get(digest digest.Digest, cursors Cursors) (uint64, int64, bool, error) {
+	backend, err := os.getOffsetStore(digest)
 	if err != nil {
-		return 0, 0, false, util.StatusWrapf(err, "Failed to obtain offset store for instance %#v", instance)
+		return 0, 0, false, err
 	}
 	return backend.Get(digest, cursors)
 }
 
 func (os *demultiplexingOffsetStore) Put(digest digest.Digest, offset uint64, length int64, cursors Cursors) error {
-	instance := digest.GetInstance()
-	backend, err := os.offsetStoreGetter(instance)
+	backend, err := os.getOffsetStore(digest)
 	if err != nil {
-		return util.StatusWrapf(err, "Failed to obtain offset store for instance %#v", instance)
+		return err
 	}
 	return backend.Put(digest, offset, length, cursors)
 }
+
+func (os *demultiplexingOffsetStore) Walk(cursors Cursors, position string, walkFunc OffsetStoreWalkFunc) error {
+	instances := make([]string, 0, len(os.offsetStores))
+	for instance := range os.offsetStores {
+		instances = append(instances, instance)
+	}
+	sort.Strings(instances)
+
+	// Positions are prefixed with the instance name, so that
+	// walking can be resumed at the right offset store.
+	startInstance, storePosition := "", ""
+	if position != "" {
+		i := strings.LastIndexByte(position, '/')
+		if i < 0 {
+			return status.Errorf(codes.InvalidArgument, "Invalid position %#v", position)
+		}
+		startInstance, storePosition = position[:i], position[i+1:]
+	}
+
+	// Entries stored in the underlying offset stores don't contain
+	// an instance name. Add it to the digests.
+	for _, instance := range instances {
+		if instance < startInstance {
+			continue
+		}
+		if instance != startInstance {
+			storePosition = ""
+		}
+		if err := os.offsetStores[instance].Walk(cursors, storePosition, func(blobDigest digest.Digest, offset uint64, length int64, position string) error {
+			instanceDigest, err := digest.NewDigest(instance, blobDigest.GetDigestFunction(), blobDigest.GetHashString(), blobDigest.GetSizeBytes())
+			if err != nil {
+				return err
+			}
+			return walkFunc(instanceDigest, offset, length, instance+"/"+position)
+		}); err != nil {
+			return util.StatusWrapf(err, "Instance %#v", instance)
+		}
+	}
+	return nil
+}
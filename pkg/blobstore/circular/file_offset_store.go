@@ -2,12 +2,18 @@ package circular
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/binary"
 	"io"
+	"strconv"
 	"sync"
 
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
 	"github.com/buildbarn/bb-storage/pkg/digest"
 	"github.com/prometheus/client_golang/prometheus"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 const (
@@ -50,7 +56,7 @@ var (
 // offsetRecord contains the hash table entries written to disk. They
 // consist of four components:
 //
-// - A simple digest of the blob (hash and size),
+// - A simple digest of the blob (hash, size and digest function),
 // - The attempt (i.e., how many times this entry got pushed to its next
 //   preferential slot in the hash table).
 // - The offset of the blob's data within the data file.
@@ -234,3 +240,48 @@ func (os *fileOffsetStore) Put(digest digest.Digest, offset uint64, length int64
 		}
 	}
 }
+
+func (os *fileOffsetStore) Walk(cursors Cursors, walkPosition string, walkFunc OffsetStoreWalkFunc) error {
+	// Positions correspond to the slot at which walking resumes.
+	startSlot := uint64(0)
+	if walkPosition != "" {
+		var err error
+		if startSlot, err = strconv.ParseUint(walkPosition, 10, 64); err != nil {
+			return status.Errorf(codes.InvalidArgument, "Invalid position %#v", walkPosition)
+		}
+	}
+	recordLen := uint64(len(offsetRecord{}))
+	for slot := startSlot; slot < os.size/recordLen; slot++ {
+		position := int64(slot * recordLen)
+		record, err := os.getRecordAtPosition(position)
+		if err != nil {
+			return err
+		}
+		// Skip unused slots, records that refer to data that is
+		// no longer valid, and records that cannot be reached by
+		// Get(). The latter may be the case if the offset file
+		// contains garbage.
+		offset, length := record.getOffset(), record.getLength()
+		if record == (offsetRecord{}) ||
+			!cursors.Contains(offset, length) ||
+			record.getAttempt() >= maximumIterations-1 ||
+			os.getPositionOfSlot(record.getSlot()) != position ||
+			binary.LittleEndian.Uint32(record[sha256.Size:]) != uint32(length) {
+			continue
+		}
+		// Skip records whose digest cannot be recovered, as
+		// their hashes were truncated when stored.
+		blobDigest, err := digest.NewDigestFromPaddedHashBytes(
+			"",
+			remoteexecution.DigestFunction_Value(binary.LittleEndian.Uint32(record[sha256.Size+4:])),
+			record[:sha256.Size],
+			length)
+		if err != nil {
+			continue
+		}
+		if err := walkFunc(blobDigest, offset, length, strconv.FormatUint(slot+1, 10)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -11,7 +11,11 @@ import (
 // storage backend uses.
 //
 // Digests are encoded by storing the hash, followed by the size. Enough
-// space is left for a SHA-256 sum.
+// space is left for a SHA-256 sum. The size is followed by the digest
+// function, which is only set for digest functions that cannot be
+// inferred from the length of the hash (e.g., BLAKE3) and for digest
+// functions whose hashes are truncated (SHA-384 and SHA-512). The
+// latter cannot be validated by Scrub(), as their hashes are lost.
 type simpleDigest [sha256.Size + 8]byte
 
 // NewSimpleDigest converts a Digest to a simpleDigest.
@@ -19,5 +23,6 @@ func newSimpleDigest(digest digest.Digest) simpleDigest {
 	var sd simpleDigest
 	copy(sd[:], digest.GetHashBytes())
 	binary.LittleEndian.PutUint32(sd[sha256.Size:], uint32(digest.GetSizeBytes()))
+	binary.LittleEndian.PutUint32(sd[sha256.Size+4:], uint32(digest.GetPaddedHashDigestFunction()))
 	return sd
 }
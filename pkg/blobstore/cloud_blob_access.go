@@ -3,10 +3,13 @@ package blobstore
 import (
 	"context"
 	"io"
+	"strings"
 
 	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
 	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
 
+	"github.com/aws/aws-sdk-go/service/s3"
 	"gocloud.dev/blob"
 	"gocloud.dev/gcerrors"
 
@@ -42,6 +45,7 @@ func (ba *cloudBlobAccess) Get(ctx context.Context, digest digest.Digest) buffer
 	return ba.storageType.NewBufferFromReader(
 		digest,
 		result,
+		result.Size(),
 		buffer.Reparable(digest, func() error {
 			return ba.bucket.Delete(ctx, key)
 		}))
@@ -81,6 +85,52 @@ func (ba *cloudBlobAccess) FindMissing(ctx context.Context, digests digest.Set)
 	return missing.Build(), nil
 }
 
+func (ba *cloudBlobAccess) Enumerate(ctx context.Context, cursor string, enumerateFunc EnumerateFunc) error {
+	// Objects are listed in lexicographical order. Use the key of
+	// the last object that was reported as the cursor. Let the
+	// driver start listing after the cursor if it supports it, so
+	// that objects that have already been visited aren't listed
+	// again. Drivers that don't support this (e.g., Azure) list
+	// the bucket from the start, causing the objects to be skipped
+	// below.
+	iterator := ba.bucket.List(&blob.ListOptions{
+		Prefix: ba.keyPrefix,
+		BeforeList: func(asFunc func(interface{}) bool) error {
+			if cursor == "" {
+				return nil
+			}
+			startAfter := ba.keyPrefix + cursor
+			var listObjectsV2Input *s3.ListObjectsV2Input
+			var listObjectsInput *s3.ListObjectsInput
+			if asFunc(&listObjectsV2Input) {
+				listObjectsV2Input.StartAfter = &startAfter
+			} else if asFunc(&listObjectsInput) {
+				listObjectsInput.Marker = &startAfter
+			}
+			return nil
+		},
+	})
+	for {
+		object, err := iterator.Next(ctx)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return util.StatusWrapWithCode(err, codes.Unavailable, "Failed to list objects")
+		}
+		key := strings.TrimPrefix(object.Key, ba.keyPrefix)
+		if object.IsDir || (cursor != "" && key <= cursor) {
+			continue
+		}
+		// Skip objects that don't correspond to objects of
+		// this storage type, as the bucket may be shared.
+		if blobDigest, err := ba.storageType.ParseDigestKey(key); err == nil {
+			if err := enumerateFunc(blobDigest, key); err != nil {
+				return err
+			}
+		}
+	}
+}
+
 func (ba *cloudBlobAccess) getKey(digest digest.Digest) string {
 	return ba.keyPrefix + ba.storageType.GetDigestKey(digest)
 }
@@ -0,0 +1,58 @@
+package blobstore_test
+
+import (
+	"context"
+	"testing"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"gocloud.dev/blob/memblob"
+)
+
+func TestCloudBlobAccessEnumerate(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+	defer ctrl.Finish()
+
+	bucket := memblob.OpenBucket(nil)
+	defer bucket.Close()
+	for _, key := range []string{
+		"cas/8b1a9953c4611296a827abf8c47804d7-5-example",
+		"cas/8b1a9953c4611296a827abf8c47804d7-5",
+		"cas/5d41402abc4b2a76b9719d911017c592-5",
+		"other/8b1a9953c4611296a827abf8c47804d7-6",
+	} {
+		require.NoError(t, bucket.WriteAll(ctx, key, []byte("Hello"), nil))
+	}
+	blobAccess := blobstore.NewCloudBlobAccess(bucket, "cas/", blobstore.CASStorageType).(blobstore.EnumerableBlobAccess)
+
+	enumerateFunc := mock.NewMockEnumerateFunc(ctrl)
+
+	t.Run("FromStart", func(t *testing.T) {
+		// Only objects stored under the key prefix that
+		// correspond to objects in the Content Addressable
+		// Storage should be returned.
+		enumerateFunc.EXPECT().Call(
+			digest.MustNewDigest("", remoteexecution.DigestFunction_MD5, "5d41402abc4b2a76b9719d911017c592", 5),
+			"5d41402abc4b2a76b9719d911017c592-5")
+		enumerateFunc.EXPECT().Call(
+			digest.MustNewDigest("", remoteexecution.DigestFunction_MD5, "8b1a9953c4611296a827abf8c47804d7", 5),
+			"8b1a9953c4611296a827abf8c47804d7-5")
+
+		require.NoError(t, blobAccess.Enumerate(ctx, "", enumerateFunc.Call))
+	})
+
+	t.Run("Resume", func(t *testing.T) {
+		// Enumeration should resume after the object to which
+		// the cursor refers.
+		enumerateFunc.EXPECT().Call(
+			digest.MustNewDigest("", remoteexecution.DigestFunction_MD5, "8b1a9953c4611296a827abf8c47804d7", 5),
+			"8b1a9953c4611296a827abf8c47804d7-5")
+
+		require.NoError(t, blobAccess.Enumerate(ctx, "5d41402abc4b2a76b9719d911017c592-5", enumerateFunc.Call))
+	})
+}
@@ -30,7 +30,7 @@ func TestCompletenessCheckingBlobAccess(t *testing.T) {
 		5,
 		1000)
 
-	actionDigest := digest.MustNewDigest("hello", "d41d8cd98f00b204e9800998ecf8427e", 123)
+	actionDigest := digest.MustNewDigest("hello", remoteexecution.DigestFunction_MD5, "d41d8cd98f00b204e9800998ecf8427e", 123)
 
 	t.Run("ActionCacheFailure", func(t *testing.T) {
 		// Errors on the backing action cache should be passed
@@ -58,7 +58,7 @@ func TestCompletenessCheckingBlobAccess(t *testing.T) {
 				buffer.Reparable(actionDigest, repairFunc.Call)))
 
 		_, err := completenessCheckingBlobAccess.Get(ctx, actionDigest).ToActionResult(1000)
-		require.Equal(t, err, status.Error(codes.NotFound, "Action result contained malformed digest: Unknown digest hash length: 24 characters"))
+		require.Equal(t, err, status.Error(codes.NotFound, "Action result contained malformed digest: Hash has length 24, while 32 characters were expected for digest function MD5"))
 	})
 
 	t.Run("MissingInput", func(t *testing.T) {
@@ -84,12 +84,12 @@ func TestCompletenessCheckingBlobAccess(t *testing.T) {
 		contentAddressableStorageBlobAccess.EXPECT().FindMissing(
 			ctx,
 			digest.NewSetBuilder().
-				Add(digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5)).
-				Add(digest.MustNewDigest("hello", "6fc422233a40a75a1f028e11c3cd1140", 7)).
+				Add(digest.MustNewDigest("hello", remoteexecution.DigestFunction_MD5, "8b1a9953c4611296a827abf8c47804d7", 5)).
+				Add(digest.MustNewDigest("hello", remoteexecution.DigestFunction_MD5, "6fc422233a40a75a1f028e11c3cd1140", 7)).
 				Build(),
 		).Return(
 			digest.NewSetBuilder().
-				Add(digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5)).
+				Add(digest.MustNewDigest("hello", remoteexecution.DigestFunction_MD5, "8b1a9953c4611296a827abf8c47804d7", 5)).
 				Build(),
 			nil)
 
@@ -112,7 +112,7 @@ func TestCompletenessCheckingBlobAccess(t *testing.T) {
 		contentAddressableStorageBlobAccess.EXPECT().FindMissing(
 			ctx,
 			digest.NewSetBuilder().
-				Add(digest.MustNewDigest("hello", "6fc422233a40a75a1f028e11c3cd1140", 7)).
+				Add(digest.MustNewDigest("hello", remoteexecution.DigestFunction_MD5, "6fc422233a40a75a1f028e11c3cd1140", 7)).
 				Build(),
 		).Return(digest.EmptySet, status.Error(codes.Internal, "Hard disk has a case of the Mondays"))
 
@@ -139,7 +139,7 @@ func TestCompletenessCheckingBlobAccess(t *testing.T) {
 				buffer.Reparable(actionDigest, repairFunc.Call)))
 		contentAddressableStorage.EXPECT().GetTree(
 			ctx,
-			digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5),
+			digest.MustNewDigest("hello", remoteexecution.DigestFunction_MD5, "8b1a9953c4611296a827abf8c47804d7", 5),
 		).Return(nil, status.Error(codes.Internal, "Hard disk has a case of the Mondays"))
 
 		_, err := completenessCheckingBlobAccess.Get(ctx, actionDigest).ToActionResult(1000)
@@ -195,7 +195,7 @@ func TestCompletenessCheckingBlobAccess(t *testing.T) {
 				buffer.Reparable(actionDigest, repairFunc.Call)))
 		contentAddressableStorage.EXPECT().GetTree(
 			ctx,
-			digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5),
+			digest.MustNewDigest("hello", remoteexecution.DigestFunction_MD5, "8b1a9953c4611296a827abf8c47804d7", 5),
 		).Return(&remoteexecution.Tree{
 			Root: &remoteexecution.Directory{
 				// Directory digests should not be part of
@@ -235,18 +235,18 @@ func TestCompletenessCheckingBlobAccess(t *testing.T) {
 		contentAddressableStorageBlobAccess.EXPECT().FindMissing(
 			ctx,
 			digest.NewSetBuilder().
-				Add(digest.MustNewDigest("hello", "38837949e2518a6e8a912ffb29942788", 10)).
-				Add(digest.MustNewDigest("hello", "ebbbb099e9d2f7892d97ab3640ae8283", 9)).
-				Add(digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5)).
-				Add(digest.MustNewDigest("hello", "136de6de72514772b9302d4776e5c3d2", 4)).
-				Add(digest.MustNewDigest("hello", "41d7247285b686496aa91b56b4c48395", 11)).
+				Add(digest.MustNewDigest("hello", remoteexecution.DigestFunction_MD5, "38837949e2518a6e8a912ffb29942788", 10)).
+				Add(digest.MustNewDigest("hello", remoteexecution.DigestFunction_MD5, "ebbbb099e9d2f7892d97ab3640ae8283", 9)).
+				Add(digest.MustNewDigest("hello", remoteexecution.DigestFunction_MD5, "8b1a9953c4611296a827abf8c47804d7", 5)).
+				Add(digest.MustNewDigest("hello", remoteexecution.DigestFunction_MD5, "136de6de72514772b9302d4776e5c3d2", 4)).
+				Add(digest.MustNewDigest("hello", remoteexecution.DigestFunction_MD5, "41d7247285b686496aa91b56b4c48395", 11)).
 				Build(),
 		).Return(digest.EmptySet, nil)
 		contentAddressableStorageBlobAccess.EXPECT().FindMissing(
 			ctx,
 			digest.NewSetBuilder().
-				Add(digest.MustNewDigest("hello", "eda14e187a768b38eda999457c9cca1e", 6)).
-				Add(digest.MustNewDigest("hello", "6c396013ff0ebff6a2a96cdc20a4ba4c", 5)).
+				Add(digest.MustNewDigest("hello", remoteexecution.DigestFunction_MD5, "eda14e187a768b38eda999457c9cca1e", 6)).
+				Add(digest.MustNewDigest("hello", remoteexecution.DigestFunction_MD5, "6c396013ff0ebff6a2a96cdc20a4ba4c", 5)).
 				Build(),
 		).Return(digest.EmptySet, nil)
 
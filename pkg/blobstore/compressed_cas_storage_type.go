@@ -0,0 +1,49 @@
+package blobstore
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+)
+
+type compressedCASStorageType struct{}
+
+func (f compressedCASStorageType) GetDigestKey(blobDigest digest.Digest) string {
+	return blobDigest.GetKey(digest.KeyWithoutInstance)
+}
+
+func (f compressedCASStorageType) ParseDigestKey(key string) (digest.Digest, error) {
+	return digest.NewDigestFromKey(key, digest.KeyWithoutInstance)
+}
+
+func (f compressedCASStorageType) NewBufferFromByteSlice(digest digest.Digest, data []byte, repairStrategy buffer.RepairStrategy) buffer.Buffer {
+	return buffer.NewUnvalidatedBufferFromReader(ioutil.NopCloser(bytes.NewReader(data)), int64(len(data)), repairStrategy)
+}
+
+func (f compressedCASStorageType) NewBufferFromReader(digest digest.Digest, r io.ReadCloser, sizeBytes int64, repairStrategy buffer.RepairStrategy) buffer.Buffer {
+	if sizeBytes < 0 {
+		// The size of the compressed object is not known up
+		// front. Load it into memory, so that
+		// buffer.Buffer.GetSizeBytes() can still be used to copy
+		// it into other backends.
+		defer r.Close()
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return buffer.NewBufferFromError(err)
+		}
+		return f.NewBufferFromByteSlice(digest, data, repairStrategy)
+	}
+	return buffer.NewUnvalidatedBufferFromReader(r, sizeBytes, repairStrategy)
+}
+
+// CompressedCASStorageType is capable of creating identifiers and
+// buffers for objects stored in the Content Addressable Storage (CAS)
+// by CompressingBlobAccess. As the contents of these objects don't
+// correspond with their digests, buffers created by this storage type
+// are not validated. Validation is performed by CompressingBlobAccess
+// after decompression, invoking the repair strategy provided to this
+// storage type in case of data consistency issues.
+var CompressedCASStorageType StorageType = compressedCASStorageType{}
@@ -0,0 +1,231 @@
+package blobstore
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"io"
+	"io/ioutil"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/klauspost/compress/zstd"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// CompressionAlgorithm is an enumeration of the compression algorithms
+// that may be used by CompressingBlobAccess. The value of the
+// algorithm is stored in the first byte of every object, so that
+// objects can be decompressed even if the configured algorithm
+// changes.
+type CompressionAlgorithm byte
+
+const (
+	// compressionAlgorithmNone is stored in the header of objects
+	// that are stored without any compression applied, either
+	// because they are small or because they turned out to be
+	// incompressible.
+	compressionAlgorithmNone CompressionAlgorithm = 0
+	// CompressionAlgorithmDeflate compresses objects using raw
+	// DEFLATE (RFC 1951).
+	CompressionAlgorithmDeflate CompressionAlgorithm = 1
+	// CompressionAlgorithmZstd compresses objects using Zstandard
+	// (RFC 8478).
+	CompressionAlgorithmZstd CompressionAlgorithm = 2
+)
+
+type compressingBlobAccess struct {
+	blobAccess       BlobAccess
+	algorithm        CompressionAlgorithm
+	minimumSizeBytes int64
+	maximumSizeBytes int64
+}
+
+// NewCompressingBlobAccess creates a decorator for BlobAccess that
+// compresses objects stored in the Content Addressable Storage. Every
+// object is prefixed with a single byte header, indicating whether
+// and how it is compressed. Objects that are smaller than a given
+// size, or that don't become smaller when compressed, are stored
+// without compression.
+//
+// Objects returned by Get() are decompressed and validated against
+// their digest, meaning callers observe ordinary CAS buffers. The
+// backend should be created using CompressedCASStorageType, as the
+// objects it stores don't correspond with their digests.
+//
+// As compression requires objects to be held in memory in their
+// entirety to determine whether they are compressible, objects larger
+// than a given size are streamed into the backend without compression.
+func NewCompressingBlobAccess(blobAccess BlobAccess, algorithm CompressionAlgorithm, minimumSizeBytes int64, maximumSizeBytes int64) BlobAccess {
+	return &compressingBlobAccess{
+		blobAccess:       blobAccess,
+		algorithm:        algorithm,
+		minimumSizeBytes: minimumSizeBytes,
+		maximumSizeBytes: maximumSizeBytes,
+	}
+}
+
+// zstdReadCloser is a wrapper around zstd.Decoder that also closes
+// the underlying reader upon completion.
+type zstdReadCloser struct {
+	*zstd.Decoder
+	r io.ReadCloser
+}
+
+func (r zstdReadCloser) Close() error {
+	r.Decoder.Close()
+	return r.r.Close()
+}
+
+// flateReadCloser is a wrapper around a DEFLATE decompressor that
+// also closes the underlying reader upon completion.
+type flateReadCloser struct {
+	io.ReadCloser
+	r io.ReadCloser
+}
+
+func (r flateReadCloser) Close() error {
+	r.ReadCloser.Close()
+	return r.r.Close()
+}
+
+// NewDecompressingReader creates a reader that decompresses data
+// obtained from another reader, using a given compression algorithm.
+// Closing the returned reader also closes the underlying reader.
+func NewDecompressingReader(algorithm CompressionAlgorithm, r io.ReadCloser) (io.ReadCloser, error) {
+	switch algorithm {
+	case CompressionAlgorithmDeflate:
+		return flateReadCloser{ReadCloser: flate.NewReader(r), r: r}, nil
+	case CompressionAlgorithmZstd:
+		decoder, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, util.StatusWrapWithCode(err, codes.Internal, "Failed to create Zstandard decoder")
+		}
+		return zstdReadCloser{Decoder: decoder, r: r}, nil
+	default:
+		return nil, status.Errorf(codes.Internal, "Unknown compression algorithm %d", algorithm)
+	}
+}
+
+// NewCompressingWriter creates a writer that compresses data before
+// writing it into another writer, using a given compression algorithm.
+// The returned writer must be closed to flush any buffered data. The
+// underlying writer is not closed.
+func NewCompressingWriter(algorithm CompressionAlgorithm, w io.Writer) (io.WriteCloser, error) {
+	switch algorithm {
+	case CompressionAlgorithmDeflate:
+		compressor, err := flate.NewWriter(w, flate.DefaultCompression)
+		if err != nil {
+			return nil, util.StatusWrapWithCode(err, codes.Internal, "Failed to create DEFLATE encoder")
+		}
+		return compressor, nil
+	case CompressionAlgorithmZstd:
+		encoder, err := zstd.NewWriter(w)
+		if err != nil {
+			return nil, util.StatusWrapWithCode(err, codes.Internal, "Failed to create Zstandard encoder")
+		}
+		return encoder, nil
+	default:
+		return nil, status.Errorf(codes.Internal, "Unknown compression algorithm %d", algorithm)
+	}
+}
+
+func (ba *compressingBlobAccess) Get(ctx context.Context, digest digest.Digest) buffer.Buffer {
+	return buffer.NewCASBufferFromUnvalidatedBuffer(digest, ba.blobAccess.Get(ctx, digest), decompressObject)
+}
+
+// decompressObject strips the header from an object stored by
+// CompressingBlobAccess, decompressing its contents if needed.
+func decompressObject(r io.ReadCloser) (io.ReadCloser, error) {
+	var header [1]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		r.Close()
+		if err == io.EOF {
+			return nil, status.Error(codes.Internal, "Compressed object does not contain a header")
+		}
+		return nil, err
+	}
+
+	if algorithm := CompressionAlgorithm(header[0]); algorithm != compressionAlgorithmNone {
+		decompressed, err := NewDecompressingReader(algorithm, r)
+		if err != nil {
+			r.Close()
+			return nil, util.StatusWrap(err, "Compressed object has an invalid header")
+		}
+		return decompressed, nil
+	}
+	return r, nil
+}
+
+func (ba *compressingBlobAccess) compress(data []byte) ([]byte, error) {
+	compressed := bytes.NewBuffer([]byte{byte(ba.algorithm)})
+	w, err := NewCompressingWriter(ba.algorithm, compressed)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		w.Close()
+		return nil, util.StatusWrapWithCode(err, codes.Internal, "Failed to compress object")
+	}
+	if err := w.Close(); err != nil {
+		return nil, util.StatusWrapWithCode(err, codes.Internal, "Failed to compress object")
+	}
+	return compressed.Bytes(), nil
+}
+
+// headerReadCloser is a reader that returns the header of an object
+// stored without compression, followed by the object's contents.
+type headerReadCloser struct {
+	io.Reader
+	r io.ReadCloser
+}
+
+func (r headerReadCloser) Close() error {
+	return r.r.Close()
+}
+
+// putUncompressed streams an object into the backend without
+// compressing it, prefixed with a header.
+func (ba *compressingBlobAccess) putUncompressed(ctx context.Context, digest digest.Digest, r io.ReadCloser) error {
+	return ba.blobAccess.Put(
+		ctx,
+		digest,
+		buffer.NewUnvalidatedBufferFromReader(
+			headerReadCloser{
+				Reader: io.MultiReader(bytes.NewReader([]byte{byte(compressionAlgorithmNone)}), r),
+				r:      r,
+			},
+			1+digest.GetSizeBytes(),
+			buffer.UserProvided))
+}
+
+func (ba *compressingBlobAccess) Put(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+	sizeBytes := digest.GetSizeBytes()
+	if sizeBytes < ba.minimumSizeBytes || sizeBytes > ba.maximumSizeBytes {
+		// Object is too small to benefit from compression, or
+		// too large to be held in memory. Store it as is.
+		return ba.putUncompressed(ctx, digest, b.ToReader())
+	}
+
+	data, err := b.ToByteSlice(int(sizeBytes))
+	if err != nil {
+		return err
+	}
+	compressed, err := ba.compress(data)
+	if err != nil {
+		return err
+	}
+	if len(compressed) < len(data) {
+		return ba.blobAccess.Put(ctx, digest, buffer.NewValidatedBufferFromByteSlice(compressed))
+	}
+
+	// Object is incompressible. Store it as is.
+	return ba.putUncompressed(ctx, digest, ioutil.NopCloser(bytes.NewReader(data)))
+}
+
+func (ba *compressingBlobAccess) FindMissing(ctx context.Context, digests digest.Set) (digest.Set, error) {
+	return ba.blobAccess.FindMissing(ctx, digests)
+}
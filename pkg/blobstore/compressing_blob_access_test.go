@@ -0,0 +1,181 @@
+package blobstore_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestCompressingBlobAccess(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+	defer ctrl.Finish()
+
+	newDigest := func(data []byte) digest.Digest {
+		generator := digest.MustNewDigest("default", remoteexecution.DigestFunction_SHA256, "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", 0).NewGenerator()
+		generator.Write(data)
+		return generator.Sum()
+	}
+	compressibleData := bytes.Repeat([]byte("Hello world "), 100)
+	compressibleDigest := newDigest(compressibleData)
+	incompressibleData := make([]byte, 1000)
+	rand.New(rand.NewSource(1)).Read(incompressibleData)
+	incompressibleDigest := newDigest(incompressibleData)
+	smallDigest := newDigest([]byte("Hello"))
+
+	for name, algorithm := range map[string]blobstore.CompressionAlgorithm{
+		"Deflate": blobstore.CompressionAlgorithmDeflate,
+		"Zstd":    blobstore.CompressionAlgorithmZstd,
+	} {
+		t.Run(name, func(t *testing.T) {
+			baseBlobAccess := mock.NewMockBlobAccess(ctrl)
+			blobAccess := blobstore.NewCompressingBlobAccess(baseBlobAccess, algorithm, 10, 10000)
+
+			// Store an object in the backend, returning
+			// the data that was written into it.
+			put := func(blobDigest digest.Digest, data []byte) []byte {
+				var stored []byte
+				baseBlobAccess.EXPECT().Put(ctx, blobDigest, gomock.Any()).DoAndReturn(
+					func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+						var err error
+						stored, err = b.ToByteSlice(10000)
+						require.NoError(t, err)
+						return nil
+					})
+				require.NoError(t, blobAccess.Put(ctx, blobDigest, buffer.NewValidatedBufferFromByteSlice(data)))
+				return stored
+			}
+			get := func(blobDigest digest.Digest, stored []byte) ([]byte, error) {
+				baseBlobAccess.EXPECT().Get(ctx, blobDigest).Return(buffer.NewValidatedBufferFromByteSlice(stored))
+				return blobAccess.Get(ctx, blobDigest).ToByteSlice(10000)
+			}
+
+			t.Run("Compressible", func(t *testing.T) {
+				stored := put(compressibleDigest, compressibleData)
+				require.Equal(t, byte(algorithm), stored[0])
+				require.Less(t, len(stored), len(compressibleData))
+
+				data, err := get(compressibleDigest, stored)
+				require.NoError(t, err)
+				require.Equal(t, compressibleData, data)
+			})
+
+			t.Run("Incompressible", func(t *testing.T) {
+				// Objects that don't shrink should be
+				// stored as is.
+				stored := put(incompressibleDigest, incompressibleData)
+				require.Equal(t, append([]byte{0}, incompressibleData...), stored)
+
+				data, err := get(incompressibleDigest, stored)
+				require.NoError(t, err)
+				require.Equal(t, incompressibleData, data)
+			})
+
+			t.Run("Small", func(t *testing.T) {
+				// Objects below the minimum size should
+				// be stored as is.
+				stored := put(smallDigest, []byte("Hello"))
+				require.Equal(t, []byte("\x00Hello"), stored)
+
+				data, err := get(smallDigest, stored)
+				require.NoError(t, err)
+				require.Equal(t, []byte("Hello"), data)
+			})
+		})
+	}
+
+	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
+	blobAccess := blobstore.NewCompressingBlobAccess(baseBlobAccess, blobstore.CompressionAlgorithmZstd, 10, 10000)
+
+	t.Run("GetNotFound", func(t *testing.T) {
+		baseBlobAccess.EXPECT().Get(ctx, compressibleDigest).Return(buffer.NewBufferFromError(status.Error(codes.NotFound, "Object not found")))
+
+		_, err := blobAccess.Get(ctx, compressibleDigest).ToByteSlice(10000)
+		require.Equal(t, status.Error(codes.NotFound, "Object not found"), err)
+	})
+
+	t.Run("GetUnknownAlgorithm", func(t *testing.T) {
+		baseBlobAccess.EXPECT().Get(ctx, compressibleDigest).Return(buffer.NewValidatedBufferFromByteSlice([]byte("\xffHello")))
+
+		_, err := blobAccess.Get(ctx, compressibleDigest).ToByteSlice(10000)
+		require.Equal(t, status.Error(codes.Internal, "Compressed object has an invalid header: Unknown compression algorithm 255"), err)
+	})
+
+	t.Run("GetCorrupted", func(t *testing.T) {
+		// Decompressed data should be validated against the
+		// digest of the object.
+		baseBlobAccess.EXPECT().Get(ctx, smallDigest).Return(buffer.NewValidatedBufferFromByteSlice([]byte("\x00Hellp")))
+
+		_, err := blobAccess.Get(ctx, smallDigest).ToByteSlice(10000)
+		require.Equal(t, codes.Internal, status.Code(err))
+	})
+
+	t.Run("GetCorruptedRepair", func(t *testing.T) {
+		// Corruption of decompressed data should be reported
+		// through the repair strategy provided by the backend,
+		// so that it may repair the compressed object.
+		repaired := false
+		baseBlobAccess.EXPECT().Get(ctx, smallDigest).Return(blobstore.CompressedCASStorageType.NewBufferFromReader(
+			smallDigest,
+			ioutil.NopCloser(bytes.NewBufferString("\x00Hellp")),
+			6,
+			buffer.Reparable(smallDigest, func() error {
+				repaired = true
+				return nil
+			})))
+
+		_, err := blobAccess.Get(ctx, smallDigest).ToByteSlice(10000)
+		require.Equal(t, codes.Internal, status.Code(err))
+		require.True(t, repaired)
+	})
+
+	t.Run("PutInvalid", func(t *testing.T) {
+		// Data provided to Put() should be validated before
+		// being compressed.
+		err := blobAccess.Put(ctx, compressibleDigest, buffer.NewCASBufferFromByteSlice(compressibleDigest, incompressibleData, buffer.UserProvided))
+		require.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("PutInvalidUncompressed", func(t *testing.T) {
+		// Data that is streamed into the backend should also be
+		// validated, causing the backend to fail.
+		baseBlobAccess.EXPECT().Put(ctx, smallDigest, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+				_, err := b.ToByteSlice(10000)
+				return err
+			})
+
+		err := blobAccess.Put(ctx, smallDigest, buffer.NewCASBufferFromByteSlice(smallDigest, []byte("Hellp"), buffer.UserProvided))
+		require.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("PutLarge", func(t *testing.T) {
+		// Objects above the maximum size should be streamed
+		// into the backend without being compressed.
+		largeBlobAccess := blobstore.NewCompressingBlobAccess(baseBlobAccess, blobstore.CompressionAlgorithmZstd, 10, 100)
+		baseBlobAccess.EXPECT().Put(ctx, compressibleDigest, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+				sizeBytes, err := b.GetSizeBytes()
+				require.NoError(t, err)
+				require.Equal(t, int64(1+len(compressibleData)), sizeBytes)
+				stored, err := b.ToByteSlice(10000)
+				require.NoError(t, err)
+				require.Equal(t, append([]byte{0}, compressibleData...), stored)
+				return nil
+			})
+
+		require.NoError(t, largeBlobAccess.Put(ctx, compressibleDigest, buffer.NewValidatedBufferFromByteSlice(compressibleData)))
+	})
+}
@@ -20,6 +20,7 @@ import (
 	"github.com/buildbarn/bb-storage/pkg/digest"
 	"github.com/buildbarn/bb-storage/pkg/filesystem"
 	bb_grpc "github.com/buildbarn/bb-storage/pkg/grpc"
+	local_pb "github.com/buildbarn/bb-storage/pkg/proto/blobstore/local"
 	pb "github.com/buildbarn/bb-storage/pkg/proto/configuration/blobstore"
 	"github.com/buildbarn/bb-storage/pkg/util"
 	"github.com/go-redis/redis"
@@ -51,14 +52,75 @@ type blobAccessCreationOptions struct {
 	storageTypeName         string
 	keyFormat               digest.KeyFormat
 	maximumMessageSizeBytes int
+	reusableBackends        *ReusableBackends
+	scrubbableBackends      *[]ScrubbableBackend
+	closeFuncs              *[]func() error
+	dependencies            *[]*reusableBackend
+}
+
+// addCloseFunc registers a function that releases resources held by a
+// backend, so that they may be released when the backend is no longer
+// referenced after reloading the configuration.
+func (o *blobAccessCreationOptions) addCloseFunc(closeFunc func() error) {
+	if o.closeFuncs != nil {
+		*o.closeFuncs = append(*o.closeFuncs, closeFunc)
+	}
+}
+
+// addDependency records that a reusable backend is used by the
+// backend that is being created, so that it is retained for as long as
+// the backend that is being created is reused.
+func (o *blobAccessCreationOptions) addDependency(backend *reusableBackend) {
+	if o.dependencies != nil {
+		*o.dependencies = append(*o.dependencies, backend)
+	}
+}
+
+// ScrubbableBackend is a storage backend declared in a configuration
+// file that is capable of validating its own contents.
+type ScrubbableBackend struct {
+	Name        string
+	StorageType blobstore.StorageType
+	BlobAccess  blobstore.ScrubbableBlobAccess
 }
 
 // CreateBlobAccessObjectsFromConfig creates a pair of BlobAccess
 // objects for the Content Addressable Storage and Action cache based on
 // a configuration file.
-func CreateBlobAccessObjectsFromConfig(configuration *pb.BlobstoreConfiguration, maximumMessageSizeBytes int) (blobstore.BlobAccess, blobstore.BlobAccess, error) {
+//
+// If a set of ReusableBackends is provided, stateful backends created
+// by earlier calls are reused if their configuration is unchanged.
+// This permits reloading the configuration at runtime. The caller
+// must call ReusableBackends.Commit() or ReusableBackends.Abort()
+// afterwards, depending on whether the configuration was applied.
+func CreateBlobAccessObjectsFromConfig(configuration *pb.BlobstoreConfiguration, maximumMessageSizeBytes int, reusableBackends *ReusableBackends) (blobstore.BlobAccess, blobstore.BlobAccess, error) {
+	return createBlobAccessObjects(configuration, maximumMessageSizeBytes, reusableBackends, nil)
+}
+
+// CreateScrubbableBackendsFromConfig creates the storage backends
+// declared in a configuration file, returning the ones that are capable
+// of validating their own contents (i.e., the local and circular
+// storage backends). Background scrubbing is not started for these
+// backends, regardless of their configuration. This function can be
+// used by tools that perform offline consistency checking.
+func CreateScrubbableBackendsFromConfig(configuration *pb.BlobstoreConfiguration, maximumMessageSizeBytes int) ([]ScrubbableBackend, error) {
+	scrubbableBackends := []ScrubbableBackend{}
+	if _, _, err := createBlobAccessObjects(configuration, maximumMessageSizeBytes, nil, &scrubbableBackends); err != nil {
+		return nil, err
+	}
+	return scrubbableBackends, nil
+}
+
+func createBlobAccessObjects(configuration *pb.BlobstoreConfiguration, maximumMessageSizeBytes int, reusableBackends *ReusableBackends, scrubbableBackends *[]ScrubbableBackend) (blobstore.BlobAccess, blobstore.BlobAccess, error) {
 	// Create two stores based on definitions in configuration.
-	contentAddressableStorage, err := CreateCASBlobAccessObjectFromConfig(configuration.ContentAddressableStorage, maximumMessageSizeBytes)
+	contentAddressableStorage, err := createBlobAccess(configuration.ContentAddressableStorage, &blobAccessCreationOptions{
+		storageType:             blobstore.CASStorageType,
+		storageTypeName:         "cas",
+		keyFormat:               digest.KeyWithoutInstance,
+		maximumMessageSizeBytes: maximumMessageSizeBytes,
+		reusableBackends:        reusableBackends,
+		scrubbableBackends:      scrubbableBackends,
+	})
 	if err != nil {
 		return nil, nil, err
 	}
@@ -67,6 +129,8 @@ func CreateBlobAccessObjectsFromConfig(configuration *pb.BlobstoreConfiguration,
 		storageTypeName:         "ac",
 		keyFormat:               digest.KeyWithInstance,
 		maximumMessageSizeBytes: maximumMessageSizeBytes,
+		reusableBackends:        reusableBackends,
+		scrubbableBackends:      scrubbableBackends,
 	})
 	if err != nil {
 		return nil, nil, err
@@ -87,20 +151,33 @@ func CreateCASBlobAccessObjectFromConfig(configuration *pb.BlobAccessConfigurati
 }
 
 func createBlobAccess(configuration *pb.BlobAccessConfiguration, options *blobAccessCreationOptions) (blobstore.BlobAccess, error) {
-	var implementation blobstore.BlobAccess
-	var backendType string
 	if configuration == nil {
 		return nil, errors.New("Storage configuration not specified")
 	}
+	if options.reusableBackends == nil || !isReusableBackend(configuration) {
+		return createBlobAccessUncached(configuration, options)
+	}
+	return options.reusableBackends.getOrCreate(configuration, options, func(options *blobAccessCreationOptions) (blobstore.BlobAccess, error) {
+		return createBlobAccessUncached(configuration, options)
+	})
+}
+
+func createBlobAccessUncached(configuration *pb.BlobAccessConfiguration, options *blobAccessCreationOptions) (blobstore.BlobAccess, error) {
+	var implementation blobstore.BlobAccess
+	var backendType string
+	var scrubbableImplementation blobstore.ScrubbableBlobAccess
+	var scrubbing *pb.ScrubbingConfiguration
 	switch backend := configuration.Backend.(type) {
 	case *pb.BlobAccessConfiguration_Circular:
 		backendType = "circular"
 
 		var err error
-		implementation, err = createCircularBlobAccess(backend.Circular, options)
+		scrubbableImplementation, err = createCircularBlobAccess(backend.Circular, options)
 		if err != nil {
 			return nil, err
 		}
+		implementation = scrubbableImplementation
+		scrubbing = backend.Circular.Scrubbing
 	case *pb.BlobAccessConfiguration_Cloud:
 		backendType = "cloud"
 		switch backendConfig := backend.Cloud.Config.(type) {
@@ -110,6 +187,7 @@ func createBlobAccess(configuration *pb.BlobAccessConfiguration, options *blobAc
 			if err != nil {
 				return nil, err
 			}
+			options.addCloseFunc(bucket.Close)
 			implementation = blobstore.NewCloudBlobAccess(bucket, backend.Cloud.KeyPrefix, options.storageType)
 		case *pb.CloudBlobAccessConfiguration_Azure:
 			backendType = "azure"
@@ -123,6 +201,7 @@ func createBlobAccess(configuration *pb.BlobAccessConfiguration, options *blobAc
 			if err != nil {
 				return nil, err
 			}
+			options.addCloseFunc(bucket.Close)
 			implementation = blobstore.NewCloudBlobAccess(bucket, backend.Cloud.KeyPrefix, options.storageType)
 		case *pb.CloudBlobAccessConfiguration_Gcs:
 			backendType = "gcs"
@@ -145,6 +224,7 @@ func createBlobAccess(configuration *pb.BlobAccessConfiguration, options *blobAc
 			if err != nil {
 				return nil, err
 			}
+			options.addCloseFunc(bucket.Close)
 			implementation = blobstore.NewCloudBlobAccess(bucket, backend.Cloud.KeyPrefix, options.storageType)
 		case *pb.CloudBlobAccessConfiguration_S3:
 			backendType = "s3"
@@ -165,6 +245,7 @@ func createBlobAccess(configuration *pb.BlobAccessConfiguration, options *blobAc
 			if err != nil {
 				return nil, err
 			}
+			options.addCloseFunc(bucket.Close)
 			implementation = blobstore.NewCloudBlobAccess(bucket, backend.Cloud.KeyPrefix, options.storageType)
 		default:
 			return nil, errors.New("Cloud configuration did not contain a backend")
@@ -178,11 +259,14 @@ func createBlobAccess(configuration *pb.BlobAccessConfiguration, options *blobAc
 		if err != nil {
 			return nil, err
 		}
+		options.addCloseFunc(client.Close)
 		switch options.storageType {
 		case blobstore.ACStorageType:
 			implementation = blobstore.NewActionCacheBlobAccess(client, options.maximumMessageSizeBytes)
 		case blobstore.CASStorageType:
 			implementation = blobstore.NewContentAddressableStorageBlobAccess(client, uuid.NewRandom, 65536)
+		default:
+			return nil, status.Error(codes.InvalidArgument, "gRPC backends cannot store compressed objects")
 		}
 	case *pb.BlobAccessConfiguration_ReadCaching:
 		backendType = "read_caching"
@@ -267,35 +351,39 @@ func createBlobAccess(configuration *pb.BlobAccessConfiguration, options *blobAc
 				maxRetries = int(mode.Clustered.MaximumRetries)
 			}
 
+			client := redis.NewClusterClient(
+				&redis.ClusterOptions{
+					Addrs:           mode.Clustered.Endpoints,
+					TLSConfig:       tlsConfig,
+					ReadOnly:        true,
+					MaxRetries:      maxRetries,
+					MinRetryBackoff: minRetryDur,
+					MaxRetryBackoff: maxRetryDur,
+					DialTimeout:     dialTimeout,
+					ReadTimeout:     readTimeout,
+					WriteTimeout:    writeTimeout,
+				})
+			options.addCloseFunc(client.Close)
 			implementation = blobstore.NewRedisBlobAccess(
-				redis.NewClusterClient(
-					&redis.ClusterOptions{
-						Addrs:           mode.Clustered.Endpoints,
-						TLSConfig:       tlsConfig,
-						ReadOnly:        true,
-						MaxRetries:      maxRetries,
-						MinRetryBackoff: minRetryDur,
-						MaxRetryBackoff: maxRetryDur,
-						DialTimeout:     dialTimeout,
-						ReadTimeout:     readTimeout,
-						WriteTimeout:    writeTimeout,
-					}),
+				client,
 				options.storageType,
 				keyTTL,
 				backend.Redis.ReplicationCount,
 				replicationTimeout)
 		case *pb.RedisBlobAccessConfiguration_Single:
+			client := redis.NewClient(
+				&redis.Options{
+					Addr:         mode.Single.Endpoint,
+					Password:     mode.Single.Password,
+					DB:           int(mode.Single.Db),
+					TLSConfig:    tlsConfig,
+					DialTimeout:  dialTimeout,
+					ReadTimeout:  readTimeout,
+					WriteTimeout: writeTimeout,
+				})
+			options.addCloseFunc(client.Close)
 			implementation = blobstore.NewRedisBlobAccess(
-				redis.NewClient(
-					&redis.Options{
-						Addr:         mode.Single.Endpoint,
-						Password:     mode.Single.Password,
-						DB:           int(mode.Single.Db),
-						TLSConfig:    tlsConfig,
-						DialTimeout:  dialTimeout,
-						ReadTimeout:  readTimeout,
-						WriteTimeout: writeTimeout,
-					}),
+				client,
 				options.storageType,
 				keyTTL,
 				backend.Redis.ReplicationCount,
@@ -308,36 +396,30 @@ func createBlobAccess(configuration *pb.BlobAccessConfiguration, options *blobAc
 		implementation = blobstore.NewRemoteBlobAccess(backend.Remote.Address, options.storageTypeName, options.storageType)
 	case *pb.BlobAccessConfiguration_Sharding:
 		backendType = "sharding"
-		backends := make([]blobstore.BlobAccess, 0, len(backend.Sharding.Shards))
-		weights := make([]uint32, 0, len(backend.Sharding.Shards))
-		hasUndrainedBackend := false
-		for _, shard := range backend.Sharding.Shards {
-			if shard.Backend == nil {
-				// Drained backend.
-				backends = append(backends, nil)
-			} else {
-				// Undrained backend.
-				backend, err := createBlobAccess(shard.Backend, options)
-				if err != nil {
-					return nil, err
-				}
-				backends = append(backends, backend)
-				hasUndrainedBackend = true
+		current, err := createShardingBlobAccess(backend.Sharding, backend.Sharding.Shards, backend.Sharding.HashInitialization, options)
+		if err != nil {
+			return nil, err
+		}
+		implementation = current
+		if rebalancing := backend.Sharding.Rebalancing; rebalancing != nil {
+			previous, err := createShardingBlobAccess(backend.Sharding, rebalancing.PreviousShards, rebalancing.PreviousHashInitialization, options)
+			if err != nil {
+				return nil, util.StatusWrap(err, "Previous shards")
 			}
-
-			if shard.Weight == 0 {
-				return nil, status.Errorf(codes.InvalidArgument, "Shards must have positive weights")
+			replicator, err := CreateBlobReplicatorFromConfig(rebalancing.Replicator, previous, current, options.keyFormat)
+			if err != nil {
+				return nil, err
 			}
-			weights = append(weights, shard.Weight)
-		}
-		if !hasUndrainedBackend {
-			return nil, status.Errorf(codes.InvalidArgument, "Cannot create sharding blob access without any undrained backends")
+			maximumQueuedMigrations := 100000
+			if rebalancing.MaximumQueuedMigrations != 0 {
+				maximumQueuedMigrations = int(rebalancing.MaximumQueuedMigrations)
+			}
+			migrationConcurrency := 10
+			if rebalancing.MigrationConcurrency != 0 {
+				migrationConcurrency = int(rebalancing.MigrationConcurrency)
+			}
+			implementation = sharding.NewRebalancingBlobAccess(current, previous, replicator, maximumQueuedMigrations, migrationConcurrency)
 		}
-		implementation = sharding.NewShardingBlobAccess(
-			backends,
-			sharding.NewWeightedShardPermuter(weights),
-			options.storageType,
-			backend.Sharding.HashInitialization)
 	case *pb.BlobAccessConfiguration_SizeDistinguishing:
 		backendType = "size_distinguishing"
 		small, err := createBlobAccess(backend.SizeDistinguishing.Small, options)
@@ -367,22 +449,93 @@ func createBlobAccess(configuration *pb.BlobAccessConfiguration, options *blobAc
 		if err != nil {
 			return nil, err
 		}
-		implementation = mirrored.NewMirroredBlobAccess(backendA, backendB, replicatorAToB, replicatorBToA)
+		if degradedMode := backend.Mirrored.DegradedMode; degradedMode == nil {
+			implementation = mirrored.NewMirroredBlobAccess(backendA, backendB, replicatorAToB, replicatorBToA)
+		} else {
+			if degradedMode.Name == "" {
+				return nil, status.Error(codes.InvalidArgument, "Mirrored backends with degraded mode enabled must have a name")
+			}
+			retryInterval, err := ptypes.Duration(degradedMode.RetryInterval)
+			if err != nil {
+				return nil, util.StatusWrap(err, "Failed to parse retry interval")
+			}
+			degradable := mirrored.NewDegradableMirroredBlobAccess(
+				degradedMode.Name,
+				backendA,
+				backendB,
+				replicatorAToB,
+				replicatorBToA,
+				clock.SystemClock,
+				mirrored.DegradedModeConfiguration{
+					FailureThreshold:   int(degradedMode.FailureThreshold),
+					RetryInterval:      retryInterval,
+					MaximumJournalSize: int(degradedMode.MaximumJournalSize),
+				})
+			options.addCloseFunc(func() error {
+				mirrored.RemoveDegradableMirroredBlobAccess(degradable)
+				return nil
+			})
+			implementation = degradable
+		}
 	case *pb.BlobAccessConfiguration_Local:
+		// When persistency is enabled, load the persistent
+		// state prior to creating the digest-location maps, as
+		// it contains the hash initialization that needs to be
+		// used.
+		var persistentStateDirectory filesystem.Directory
+		var persistentStateStore local.PersistentStateStore
+		var persistentState *local_pb.PersistentState
+		digestLocationMapHashInitialization := rand.Uint64()
+		clearDigestLocationMaps := false
+		if persistent := backend.Local.Persistent; persistent != nil {
+			if _, ok := backend.Local.DataBackend.(*pb.LocalBlobAccessConfiguration_BlockDevice_); !ok {
+				return nil, status.Error(codes.InvalidArgument, "Persistency can only be enabled when data is stored on a block device")
+			}
+			var err error
+			persistentStateDirectory, err = filesystem.NewLocalDirectory(persistent.StateDirectoryPath)
+			if err != nil {
+				return nil, util.StatusWrapf(err, "Failed to open persistent state directory %#v", persistent.StateDirectoryPath)
+			}
+			persistentStateStore = local.NewDirectoryBackedPersistentStateStore(persistentStateDirectory)
+			persistentState, err = persistentStateStore.ReadPersistentState()
+			if status.Code(err) == codes.NotFound {
+				// No state has been persisted yet. Any
+				// existing digest-location maps cannot
+				// be trusted, as they may refer to
+				// blocks that are about to be reused.
+				persistentState = &local_pb.PersistentState{
+					DigestLocationMapHashInitialization: digestLocationMapHashInitialization,
+				}
+				clearDigestLocationMaps = true
+			} else if err != nil {
+				return nil, util.StatusWrapf(err, "Failed to read persistent state from directory %#v", persistent.StateDirectoryPath)
+			} else {
+				digestLocationMapHashInitialization = persistentState.DigestLocationMapHashInitialization
+			}
+		}
+
 		var digestLocationMap local.DigestLocationMap
 		switch options.storageType {
-		case blobstore.CASStorageType:
+		case blobstore.CASStorageType, blobstore.CompressedCASStorageType:
 			// Let the CAS use a single store for all
 			// objects, regardless of the instance name that
 			// was used to store them. There is no need to
 			// distinguish, due to objects being content
 			// addressed.
-			digestLocationMap = createDigestLocationMap(backend.Local)
+			var err error
+			digestLocationMap, err = createDigestLocationMap(backend.Local, digestLocationMapHashInitialization, persistentStateDirectory, "digest_location_map", clearDigestLocationMaps)
+			if err != nil {
+				return nil, err
+			}
 		case blobstore.ACStorageType:
 			// Let the AC use a single store per instance name.
 			maps := map[string]local.DigestLocationMap{}
 			for _, instance := range backend.Local.Instances {
-				maps[instance] = createDigestLocationMap(backend.Local)
+				var err error
+				maps[instance], err = createDigestLocationMap(backend.Local, digestLocationMapHashInitialization, persistentStateDirectory, "digest_location_map."+instance, clearDigestLocationMaps)
+				if err != nil {
+					return nil, err
+				}
 			}
 			digestLocationMap = local.NewPerInstanceDigestLocationMap(maps)
 		}
@@ -425,9 +578,11 @@ func createBlobAccess(configuration *pb.BlobAccessConfiguration, options *blobAc
 		}
 
 		var err error
-		implementation, err = local.NewLocalBlobAccess(
+		scrubbableImplementation, err = local.NewLocalBlobAccess(
 			digestLocationMap,
 			blockAllocator,
+			persistentStateStore,
+			persistentState,
 			options.storageTypeName,
 			sectorSizeBytes,
 			blockSectorCount,
@@ -437,6 +592,8 @@ func createBlobAccess(configuration *pb.BlobAccessConfiguration, options *blobAc
 		if err != nil {
 			return nil, err
 		}
+		implementation = scrubbableImplementation
+		scrubbing = backend.Local.Scrubbing
 	case *pb.BlobAccessConfiguration_ExistenceCaching:
 		backendType = "existence_caching"
 		base, err := createBlobAccess(backend.ExistenceCaching.Backend, options)
@@ -448,22 +605,190 @@ func createBlobAccess(configuration *pb.BlobAccessConfiguration, options *blobAc
 			return nil, err
 		}
 		implementation = blobstore.NewExistenceCachingBlobAccess(base, existenceCache)
+	case *pb.BlobAccessConfiguration_Compressing:
+		backendType = "compressing"
+		if options.storageType != blobstore.CASStorageType {
+			return nil, status.Error(codes.InvalidArgument, "Compression can only be applied to the Content Addressable Storage")
+		}
+		compressedOptions := *options
+		compressedOptions.storageType = blobstore.CompressedCASStorageType
+		base, err := createBlobAccess(backend.Compressing.Backend, &compressedOptions)
+		if err != nil {
+			return nil, err
+		}
+		var algorithm blobstore.CompressionAlgorithm
+		switch backend.Compressing.Algorithm {
+		case pb.CompressingBlobAccessConfiguration_DEFLATE:
+			algorithm = blobstore.CompressionAlgorithmDeflate
+		case pb.CompressingBlobAccessConfiguration_ZSTD:
+			algorithm = blobstore.CompressionAlgorithmZstd
+		default:
+			return nil, status.Errorf(codes.InvalidArgument, "Unknown compression algorithm %d", backend.Compressing.Algorithm)
+		}
+		if backend.Compressing.MaximumSizeBytes <= 0 || backend.Compressing.MaximumSizeBytes < backend.Compressing.MinimumSizeBytes {
+			return nil, status.Errorf(codes.InvalidArgument, "Maximum size of %d bytes must be positive and at least the minimum size of %d bytes", backend.Compressing.MaximumSizeBytes, backend.Compressing.MinimumSizeBytes)
+		}
+		implementation = blobstore.NewCompressingBlobAccess(base, algorithm, backend.Compressing.MinimumSizeBytes, backend.Compressing.MaximumSizeBytes)
 	default:
 		return nil, errors.New("Configuration did not contain a backend")
 	}
-	return blobstore.NewMetricsBlobAccess(implementation, clock.SystemClock, fmt.Sprintf("%s_%s", options.storageTypeName, backendType)), nil
+
+	name := fmt.Sprintf("%s_%s", options.storageTypeName, backendType)
+	if scrubbableImplementation != nil {
+		if options.scrubbableBackends != nil {
+			// Scrubbing is performed by the caller.
+			*options.scrubbableBackends = append(*options.scrubbableBackends, ScrubbableBackend{
+				Name:        name,
+				StorageType: options.storageType,
+				BlobAccess:  scrubbableImplementation,
+			})
+		} else if scrubbing != nil {
+			interval, err := ptypes.Duration(scrubbing.Interval)
+			if err != nil {
+				return nil, util.StatusWrap(err, "Failed to parse scrubbing interval")
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			options.addCloseFunc(func() error {
+				cancel()
+				return nil
+			})
+			go blobstore.NewScrubber(scrubbableImplementation, clock.SystemClock, name, scrubbing.MaximumBytesPerSecond).Run(ctx, interval)
+		}
+	}
+	blobAccess := blobstore.NewTracingBlobAccess(
+		blobstore.NewMetricsBlobAccess(implementation, clock.SystemClock, name),
+		options.storageTypeName,
+		backendType)
+	if enumerableImplementation, ok := implementation.(blobstore.EnumerableBlobAccess); ok {
+		return blobstore.NewEnumerableBlobAccess(blobAccess, enumerableImplementation), nil
+	}
+	return blobAccess, nil
 }
 
-func createDigestLocationMap(config *pb.LocalBlobAccessConfiguration) local.DigestLocationMap {
+func createDigestLocationMap(config *pb.LocalBlobAccessConfiguration, hashInitialization uint64, persistentStateDirectory filesystem.Directory, name string, clear bool) (local.DigestLocationMap, error) {
+	var recordArray local.LocationRecordArray
+	if persistentStateDirectory == nil {
+		recordArray = local.NewInMemoryLocationRecordArray(int(config.DigestLocationMapSize))
+	} else {
+		f, err := persistentStateDirectory.OpenReadWrite(name, filesystem.CreateReuse(0644))
+		if err != nil {
+			return nil, util.StatusWrapf(err, "Failed to open digest-location map file %#v", name)
+		}
+		if clear {
+			if err := f.Truncate(0); err != nil {
+				f.Close()
+				return nil, util.StatusWrapf(err, "Failed to clear digest-location map file %#v", name)
+			}
+		}
+		recordArray = local.NewFileBackedLocationRecordArray(f)
+	}
 	return local.NewHashingDigestLocationMap(
-		local.NewInMemoryLocationRecordArray(int(config.DigestLocationMapSize)),
+		recordArray,
 		int(config.DigestLocationMapSize),
-		rand.Uint64(),
+		hashInitialization,
 		config.DigestLocationMapMaximumGetAttempts,
-		int(config.DigestLocationMapMaximumPutAttempts))
+		int(config.DigestLocationMapMaximumPutAttempts)), nil
+}
+
+func createShardingBlobAccess(config *pb.ShardingBlobAccessConfiguration, shards []*pb.ShardingBlobAccessConfiguration_Shard, hashInitialization uint64, options *blobAccessCreationOptions) (blobstore.BlobAccess, error) {
+	backends := make([]blobstore.BlobAccess, 0, len(shards))
+	weights := make([]uint32, 0, len(shards))
+	undrainedBackends := 0
+	for _, shard := range shards {
+		if shard.Backend == nil {
+			// Drained backend.
+			backends = append(backends, nil)
+		} else {
+			// Undrained backend.
+			backend, err := createBlobAccess(shard.Backend, options)
+			if err != nil {
+				return nil, err
+			}
+			backends = append(backends, backend)
+			undrainedBackends++
+		}
+
+		if shard.Weight == 0 {
+			return nil, status.Errorf(codes.InvalidArgument, "Shards must have positive weights")
+		}
+		weights = append(weights, shard.Weight)
+	}
+	if undrainedBackends == 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "Cannot create sharding blob access without any undrained backends")
+	}
+	shardPermuter := sharding.NewWeightedShardPermuter(weights)
+	if config.ReplicationFactor <= 1 {
+		return sharding.NewShardingBlobAccess(
+			backends,
+			shardPermuter,
+			options.storageType,
+			hashInitialization), nil
+	}
+
+	replicationFactor := int(config.ReplicationFactor)
+	if replicationFactor > undrainedBackends {
+		return nil, status.Errorf(codes.InvalidArgument, "Replication factor %d exceeds the number of undrained backends", replicationFactor)
+	}
+	writeQuorum := replicationFactor
+	if config.WriteQuorum != 0 {
+		writeQuorum = int(config.WriteQuorum)
+		if writeQuorum > replicationFactor {
+			return nil, status.Errorf(codes.InvalidArgument, "Write quorum %d exceeds the replication factor %d", writeQuorum, replicationFactor)
+		}
+	}
+	hedgeDelay := 100 * time.Millisecond
+	if config.HedgeDelay != nil {
+		var err error
+		hedgeDelay, err = ptypes.Duration(config.HedgeDelay)
+		if err != nil {
+			return nil, util.StatusWrap(err, "Failed to obtain hedge delay")
+		}
+	}
+	maximumQueuedRepairs := 100000
+	if config.MaximumQueuedRepairs != 0 {
+		maximumQueuedRepairs = int(config.MaximumQueuedRepairs)
+	}
+	repairConcurrency := 10
+	if config.RepairConcurrency != 0 {
+		repairConcurrency = int(config.RepairConcurrency)
+	}
+	newReplicatedShardingBlobAccess := func(replicators []mirrored.BlobReplicator) blobstore.BlobAccess {
+		return sharding.NewReplicatedShardingBlobAccess(
+			backends,
+			replicators,
+			shardPermuter,
+			options.storageType,
+			hashInitialization,
+			replicationFactor,
+			writeQuorum,
+			clock.SystemClock,
+			hedgeDelay,
+			maximumQueuedRepairs,
+			repairConcurrency)
+	}
+	if config.Replicator == nil {
+		return newReplicatedShardingBlobAccess(nil), nil
+	}
+
+	// Replicas are repaired by reading objects from any of the
+	// other replicas.
+	source := newReplicatedShardingBlobAccess(nil)
+	replicators := make([]mirrored.BlobReplicator, 0, len(backends))
+	for _, backend := range backends {
+		if backend == nil {
+			replicators = append(replicators, nil)
+		} else {
+			replicator, err := CreateBlobReplicatorFromConfig(config.Replicator, source, backend, options.keyFormat)
+			if err != nil {
+				return nil, err
+			}
+			replicators = append(replicators, replicator)
+		}
+	}
+	return newReplicatedShardingBlobAccess(replicators), nil
 }
 
-func createCircularBlobAccess(config *pb.CircularBlobAccessConfiguration, options *blobAccessCreationOptions) (blobstore.BlobAccess, error) {
+func createCircularBlobAccess(config *pb.CircularBlobAccessConfiguration, options *blobAccessCreationOptions) (blobstore.ScrubbableBlobAccess, error) {
 	// Open input files.
 	circularDirectory, err := filesystem.NewLocalDirectory(config.Directory)
 	if err != nil {
@@ -481,7 +806,7 @@ func createCircularBlobAccess(config *pb.CircularBlobAccessConfiguration, option
 
 	var offsetStore circular.OffsetStore
 	switch options.storageType {
-	case blobstore.CASStorageType:
+	case blobstore.CASStorageType, blobstore.CompressedCASStorageType:
 		// Open a single offset file for all entries. This is
 		// sufficient for the Content Addressable Storage.
 		offsetFile, err := circularDirectory.OpenReadWrite("offset", filesystem.CreateReuse(0644))
@@ -504,13 +829,7 @@ func createCircularBlobAccess(config *pb.CircularBlobAccessConfiguration, option
 				circular.NewFileOffsetStore(offsetFile, config.OffsetFileSizeBytes),
 				uint(config.OffsetCacheSize))
 		}
-		offsetStore = circular.NewDemultiplexingOffsetStore(func(instance string) (circular.OffsetStore, error) {
-			offsetStore, ok := offsetStores[instance]
-			if !ok {
-				return nil, status.Errorf(codes.InvalidArgument, "Unknown instance name")
-			}
-			return offsetStore, nil
-		})
+		offsetStore = circular.NewDemultiplexingOffsetStore(offsetStores)
 	}
 	stateStore, err := circular.NewFileStateStore(stateFile, config.DataFileSizeBytes)
 	if err != nil {
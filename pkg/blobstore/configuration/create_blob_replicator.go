@@ -3,9 +3,13 @@ package configuration
 import (
 	"github.com/buildbarn/bb-storage/pkg/blobstore"
 	"github.com/buildbarn/bb-storage/pkg/blobstore/mirrored"
+	"github.com/buildbarn/bb-storage/pkg/clock"
 	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/filesystem"
 	bb_grpc "github.com/buildbarn/bb-storage/pkg/grpc"
 	pb "github.com/buildbarn/bb-storage/pkg/proto/configuration/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/golang/protobuf/ptypes"
 
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -36,6 +40,34 @@ func CreateBlobReplicatorFromConfig(configuration *pb.BlobReplicatorConfiguratio
 			return nil, err
 		}
 		return mirrored.NewQueuedBlobReplicator(source, base, existenceCache), nil
+	case *pb.BlobReplicatorConfiguration_PersistentQueued:
+		base, err := CreateBlobReplicatorFromConfig(mode.PersistentQueued.Base, source, sink, keyFormat)
+		if err != nil {
+			return nil, err
+		}
+		if mode.PersistentQueued.Concurrency <= 0 {
+			return nil, status.Error(codes.InvalidArgument, "Concurrency must be positive")
+		}
+		initialRetryDelay, err := ptypes.Duration(mode.PersistentQueued.InitialRetryDelay)
+		if err != nil {
+			return nil, util.StatusWrap(err, "Failed to parse initial retry delay")
+		}
+		maximumRetryDelay, err := ptypes.Duration(mode.PersistentQueued.MaximumRetryDelay)
+		if err != nil {
+			return nil, util.StatusWrap(err, "Failed to parse maximum retry delay")
+		}
+		directory, err := filesystem.NewLocalDirectory(mode.PersistentQueued.Directory)
+		if err != nil {
+			return nil, util.StatusWrapf(err, "Failed to open queue directory %#v", mode.PersistentQueued.Directory)
+		}
+		return mirrored.NewPersistentQueuedBlobReplicator(
+			source,
+			base,
+			clock.SystemClock,
+			directory,
+			int(mode.PersistentQueued.Concurrency),
+			initialRetryDelay,
+			maximumRetryDelay)
 	default:
 		return nil, status.Error(codes.InvalidArgument, "Configuration did not contain a supported replicator")
 	}
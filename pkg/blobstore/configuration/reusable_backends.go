@@ -0,0 +1,201 @@
+package configuration
+
+import (
+	"log"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	pb "github.com/buildbarn/bb-storage/pkg/proto/configuration/blobstore"
+	"github.com/golang/protobuf/proto"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type reusableBackendKey struct {
+	options       blobAccessCreationOptions
+	configuration string
+}
+
+type reusableBackend struct {
+	// Full configuration of the backend. For backends that are
+	// identified by the path at which they store their data, this
+	// is used to detect configuration changes.
+	key        reusableBackendKey
+	blobAccess blobstore.BlobAccess
+
+	// Functions that need to be called to release resources held by
+	// the backend (e.g., network connections, scrubbing goroutines).
+	closeFuncs []func() error
+
+	// Reusable backends that were created as part of this backend.
+	// These remain referenced for as long as this backend is.
+	dependencies []*reusableBackend
+
+	// Whether the backend is referenced by the configuration that
+	// is currently in use, and by the configuration that is being
+	// created.
+	active  bool
+	pending bool
+
+	// Backends that store data in files or on block devices are
+	// never released, as other goroutines may still access their
+	// memory maps. Reopening them would also cause two instances
+	// to manage the same data.
+	pathBound bool
+}
+
+// ReusableBackends keeps track of storage backends that have been
+// created from configuration, so that they may be reused when the
+// configuration is reloaded. This applies to backends that hold state
+// that would be lost if they were recreated (e.g., LocalBlobAccess) or
+// that hold connections to remote services. Backends that only
+// forward requests to other backends (e.g., ShardingBlobAccess) are
+// always recreated, so that changes to their configuration take
+// effect, unless they hold state themselves (e.g., MirroredBlobAccess
+// with degraded mode enabled).
+//
+// Backends that store data in files or on block devices are identified
+// by their path. Changing their configuration requires a restart, as
+// opening the same files twice would corrupt their contents. They are
+// retained for the lifetime of the process. Other backends are reused
+// if their configuration is identical to one that was used before, and
+// are closed once a configuration that no longer references them has
+// been applied.
+//
+// ReusableBackends is not safe for concurrent use.
+type ReusableBackends struct {
+	backends map[reusableBackendKey]*reusableBackend
+}
+
+// NewReusableBackends creates an empty set of ReusableBackends.
+func NewReusableBackends() *ReusableBackends {
+	return &ReusableBackends{
+		backends: map[reusableBackendKey]*reusableBackend{},
+	}
+}
+
+// Commit must be called after a configuration created using
+// CreateBlobAccessObjectsFromConfig() has been applied. Backends that
+// are no longer referenced are closed.
+func (rb *ReusableBackends) Commit() {
+	for key, backend := range rb.backends {
+		backend.active = backend.pending
+		backend.pending = false
+		if !backend.active {
+			rb.release(key, backend)
+		}
+	}
+}
+
+// Abort must be called if creating or applying a configuration using
+// CreateBlobAccessObjectsFromConfig() failed. Backends that were
+// created in the process and are not referenced by the configuration
+// that is currently in use are closed.
+func (rb *ReusableBackends) Abort() {
+	for key, backend := range rb.backends {
+		backend.pending = false
+		if !backend.active {
+			rb.release(key, backend)
+		}
+	}
+}
+
+func (rb *ReusableBackends) release(key reusableBackendKey, backend *reusableBackend) {
+	if backend.pathBound {
+		return
+	}
+	for _, closeFunc := range backend.closeFuncs {
+		if err := closeFunc(); err != nil {
+			log.Printf("Failed to close storage backend: %s", err)
+		}
+	}
+	delete(rb.backends, key)
+}
+
+func (rb *ReusableBackends) getOrCreate(configuration *pb.BlobAccessConfiguration, options *blobAccessCreationOptions, create func(options *blobAccessCreationOptions) (blobstore.BlobAccess, error)) (blobstore.BlobAccess, error) {
+	fullKey := newReusableBackendKey(configuration, options)
+	key := fullKey
+	path, pathBound := getReusableBackendPath(configuration)
+	if pathBound {
+		key = reusableBackendKey{configuration: path}
+	}
+	if backend, ok := rb.backends[key]; ok {
+		if backend.key != fullKey {
+			return nil, status.Errorf(codes.InvalidArgument, "Storage backend at path %#v is already in use with a different configuration, which can only be changed by restarting", path)
+		}
+		backend.markPending()
+		options.addDependency(backend)
+		return backend.blobAccess, nil
+	}
+
+	backend := &reusableBackend{
+		key:       fullKey,
+		pending:   true,
+		pathBound: pathBound,
+	}
+	createOptions := *options
+	createOptions.closeFuncs = &backend.closeFuncs
+	createOptions.dependencies = &backend.dependencies
+	blobAccess, err := create(&createOptions)
+	if err != nil {
+		for _, closeFunc := range backend.closeFuncs {
+			closeFunc()
+		}
+		return nil, err
+	}
+	backend.blobAccess = blobAccess
+	rb.backends[key] = backend
+	options.addDependency(backend)
+	return blobAccess, nil
+}
+
+// markPending marks a backend and all of the backends it uses as being
+// referenced by the configuration that is being created.
+func (backend *reusableBackend) markPending() {
+	backend.pending = true
+	for _, dependency := range backend.dependencies {
+		dependency.markPending()
+	}
+}
+
+func isReusableBackend(configuration *pb.BlobAccessConfiguration) bool {
+	switch backend := configuration.Backend.(type) {
+	case *pb.BlobAccessConfiguration_Circular,
+		*pb.BlobAccessConfiguration_Cloud,
+		*pb.BlobAccessConfiguration_Grpc,
+		*pb.BlobAccessConfiguration_Local,
+		*pb.BlobAccessConfiguration_Redis,
+		*pb.BlobAccessConfiguration_Remote:
+		return true
+	case *pb.BlobAccessConfiguration_Mirrored:
+		return backend.Mirrored.DegradedMode != nil
+	default:
+		return false
+	}
+}
+
+// getReusableBackendPath returns the path at which a backend stores
+// its data, if any.
+func getReusableBackendPath(configuration *pb.BlobAccessConfiguration) (string, bool) {
+	switch backend := configuration.Backend.(type) {
+	case *pb.BlobAccessConfiguration_Circular:
+		return backend.Circular.Directory, true
+	case *pb.BlobAccessConfiguration_Local:
+		if blockDevice, ok := backend.Local.DataBackend.(*pb.LocalBlobAccessConfiguration_BlockDevice_); ok {
+			return blockDevice.BlockDevice.Path, true
+		}
+	}
+	return "", false
+}
+
+func newReusableBackendKey(configuration *pb.BlobAccessConfiguration, options *blobAccessCreationOptions) reusableBackendKey {
+	keyOptions := *options
+	keyOptions.reusableBackends = nil
+	keyOptions.scrubbableBackends = nil
+	keyOptions.closeFuncs = nil
+	keyOptions.dependencies = nil
+	return reusableBackendKey{
+		options:       keyOptions,
+		configuration: proto.MarshalTextString(configuration),
+	}
+}
@@ -2,7 +2,6 @@ package blobstore
 
 import (
 	"context"
-	"fmt"
 	"io"
 
 	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
@@ -54,14 +53,10 @@ func (r *byteStreamChunkReader) Close() {
 }
 
 func (ba *contentAddressableStorageBlobAccess) Get(ctx context.Context, digest digest.Digest) buffer.Buffer {
-	var readRequest bytestream.ReadRequest
-	if instance := digest.GetInstance(); instance == "" {
-		readRequest.ResourceName = fmt.Sprintf("blobs/%s/%d", digest.GetHashString(), digest.GetSizeBytes())
-	} else {
-		readRequest.ResourceName = fmt.Sprintf("%s/blobs/%s/%d", instance, digest.GetHashString(), digest.GetSizeBytes())
-	}
 	ctxWithCancel, cancel := context.WithCancel(ctx)
-	client, err := ba.byteStreamClient.Read(ctxWithCancel, &readRequest)
+	client, err := ba.byteStreamClient.Read(ctxWithCancel, &bytestream.ReadRequest{
+		ResourceName: digest.GetByteStreamReadPath(remoteexecution.Compressor_IDENTITY),
+	})
 	if err != nil {
 		return buffer.NewBufferFromError(err)
 	}
@@ -80,12 +75,7 @@ func (ba *contentAddressableStorageBlobAccess) Put(ctx context.Context, digest d
 		return err
 	}
 
-	var resourceName string
-	if instance := digest.GetInstance(); instance == "" {
-		resourceName = fmt.Sprintf("uploads/%s/blobs/%s/%d", uuid.Must(ba.uuidGenerator()), digest.GetHashString(), digest.GetSizeBytes())
-	} else {
-		resourceName = fmt.Sprintf("%s/uploads/%s/blobs/%s/%d", instance, uuid.Must(ba.uuidGenerator()), digest.GetHashString(), digest.GetSizeBytes())
-	}
+	resourceName := digest.GetByteStreamWritePath(uuid.Must(ba.uuidGenerator()), remoteexecution.Compressor_IDENTITY)
 
 	writeOffset := int64(0)
 	for {
@@ -118,21 +108,21 @@ func (ba *contentAddressableStorageBlobAccess) Put(ctx context.Context, digest d
 }
 
 func (ba *contentAddressableStorageBlobAccess) FindMissing(ctx context.Context, digests digest.Set) (digest.Set, error) {
-	// Partition all digests by instance name, as the
-	// FindMissingBlobs() RPC can only process digests for a single
-	// instance.
-	perInstanceDigests := map[string][]*remoteexecution.Digest{}
-	for _, digest := range digests.Items() {
-		instanceName := digest.GetInstance()
-		perInstanceDigests[instanceName] = append(perInstanceDigests[instanceName], digest.GetPartialDigest())
-	}
-
+	// Partition all digests by instance name and digest function,
+	// as the FindMissingBlobs() RPC can only process digests for a
+	// single instance and digest function.
 	missingDigests := digest.NewSetBuilder()
-	for instanceName, blobDigests := range perInstanceDigests {
-		// Call FindMissingBlobs() for each instance.
+	for _, partition := range digests.PartitionByInstanceAndDigestFunction() {
+		// Call FindMissingBlobs() for each partition.
+		firstDigest, _ := partition.First()
+		instanceName := firstDigest.GetInstance()
+		digestFunction := firstDigest.GetDigestFunction()
 		request := remoteexecution.FindMissingBlobsRequest{
-			InstanceName: instanceName,
-			BlobDigests:  blobDigests,
+			InstanceName:   instanceName,
+			DigestFunction: digestFunction,
+		}
+		for _, blobDigest := range partition.Items() {
+			request.BlobDigests = append(request.BlobDigests, blobDigest.GetPartialDigest())
 		}
 		response, err := ba.contentAddressableStorageClient.FindMissingBlobs(ctx, &request)
 		if err != nil {
@@ -141,7 +131,7 @@ func (ba *contentAddressableStorageBlobAccess) FindMissing(ctx context.Context,
 
 		// Convert results back.
 		for _, partialDigest := range response.MissingBlobDigests {
-			blobDigest, err := digest.NewDigestFromPartialDigest(instanceName, partialDigest)
+			blobDigest, err := digest.NewDigestFromPartialDigest(instanceName, digestFunction, partialDigest)
 			if err != nil {
 				return digest.EmptySet, err
 			}
@@ -0,0 +1,113 @@
+package blobstore
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// EnumerateFunc is the callback type that is invoked by
+// EnumerableBlobAccess.Enumerate() for every object stored in a
+// backend. In addition to the digest of the object, a cursor is
+// provided that may be passed to a subsequent call to Enumerate() to
+// resume enumeration after this object.
+type EnumerateFunc func(digest digest.Digest, cursor string) error
+
+// EnumerableBlobAccess is implemented by storage backends that are
+// capable of listing the digests of the objects they store. This is
+// used to compare the contents of backends, for example to repair
+// inconsistencies between mirrored backends.
+type EnumerableBlobAccess interface {
+	BlobAccess
+
+	// Enumerate invokes a callback for every object stored in the
+	// backend. Unlike Get(), it does not cause objects to be
+	// refreshed. Objects may be added and removed concurrently,
+	// meaning that there is no guarantee that every object is
+	// visited. Objects may also be visited more than once.
+	//
+	// Decorators that store objects in multiple backends (e.g.,
+	// ShardingBlobAccess with replication, MirroredBlobAccess) report
+	// an object once for every backend that stores it, as
+	// deduplicating would require keeping track of all objects
+	// visited. Callers must therefore tolerate duplicates.
+	//
+	// Enumeration starts at the beginning if the cursor is empty.
+	// Otherwise, it resumes at the point where the cursor was
+	// obtained. Cursors are opaque, and are only valid for the
+	// backend that returned them.
+	Enumerate(ctx context.Context, cursor string, enumerateFunc EnumerateFunc) error
+}
+
+type enumerableBlobAccess struct {
+	BlobAccess
+	enumerator EnumerableBlobAccess
+}
+
+// NewEnumerableBlobAccess creates an EnumerableBlobAccess that
+// forwards Get(), Put() and FindMissing() calls to one backend, while
+// forwarding Enumerate() calls to another. This can be used to ensure
+// that decorators that only provide instrumentation (e.g.,
+// MetricsBlobAccess) don't hide the ability of the underlying backend
+// to enumerate its contents.
+func NewEnumerableBlobAccess(blobAccess BlobAccess, enumerator EnumerableBlobAccess) EnumerableBlobAccess {
+	return &enumerableBlobAccess{
+		BlobAccess: blobAccess,
+		enumerator: enumerator,
+	}
+}
+
+func (ba *enumerableBlobAccess) Enumerate(ctx context.Context, cursor string, enumerateFunc EnumerateFunc) error {
+	return ba.enumerator.Enumerate(ctx, cursor, enumerateFunc)
+}
+
+// EnumerateSequentially enumerates the objects stored in a list of
+// backends, one backend at a time. It can be used by decorators that
+// distribute objects across multiple backends (e.g., sharding and
+// mirroring) to implement EnumerableBlobAccess. Nil entries in the list
+// of backends (e.g., drained shards) are skipped.
+//
+// Cursors returned by the backends are prefixed with the index of the
+// backend, so that enumeration can be resumed at the right backend.
+// Objects that are stored in multiple backends are reported multiple
+// times.
+func EnumerateSequentially(ctx context.Context, backends []BlobAccess, cursor string, enumerateFunc EnumerateFunc) error {
+	startIndex, backendCursor := 0, ""
+	if cursor != "" {
+		fields := strings.SplitN(cursor, "/", 2)
+		if len(fields) != 2 {
+			return status.Errorf(codes.InvalidArgument, "Invalid cursor %#v", cursor)
+		}
+		index, err := strconv.Atoi(fields[0])
+		if err != nil || index < 0 || index >= len(backends) {
+			return status.Errorf(codes.InvalidArgument, "Invalid cursor %#v", cursor)
+		}
+		startIndex, backendCursor = index, fields[1]
+	}
+
+	for i := startIndex; i < len(backends); i++ {
+		if backends[i] == nil {
+			continue
+		}
+		enumerableBackend, ok := backends[i].(EnumerableBlobAccess)
+		if !ok {
+			return status.Errorf(codes.Unimplemented, "Backend %d does not support enumeration", i)
+		}
+		if i != startIndex {
+			backendCursor = ""
+		}
+		if err := enumerableBackend.Enumerate(ctx, backendCursor, func(blobDigest digest.Digest, cursor string) error {
+			return enumerateFunc(blobDigest, fmt.Sprintf("%d/%s", i, cursor))
+		}); err != nil {
+			return util.StatusWrapf(err, "Backend %d", i)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package blobstore_test
+
+import (
+	"context"
+	"testing"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestEnumerateSequentially(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+	defer ctrl.Finish()
+
+	backend0 := mock.NewMockEnumerableBlobAccess(ctrl)
+	backend2 := mock.NewMockEnumerableBlobAccess(ctrl)
+	backends := []blobstore.BlobAccess{backend0, nil, backend2}
+	digest1 := digest.MustNewDigest("default", remoteexecution.DigestFunction_MD5, "8b1a9953c4611296a827abf8c47804d7", 5)
+	digest2 := digest.MustNewDigest("default", remoteexecution.DigestFunction_MD5, "5d41402abc4b2a76b9719d911017c592", 5)
+
+	t.Run("FromStart", func(t *testing.T) {
+		// Backends should be enumerated in order, skipping
+		// drained backends. Cursors should be prefixed with the
+		// index of the backend.
+		backend0.EXPECT().Enumerate(ctx, "", gomock.Any()).DoAndReturn(
+			func(ctx context.Context, cursor string, enumerateFunc blobstore.EnumerateFunc) error {
+				return enumerateFunc(digest1, "a")
+			})
+		backend2.EXPECT().Enumerate(ctx, "", gomock.Any()).DoAndReturn(
+			func(ctx context.Context, cursor string, enumerateFunc blobstore.EnumerateFunc) error {
+				return enumerateFunc(digest2, "b/c")
+			})
+		enumerateFunc := mock.NewMockEnumerateFunc(ctrl)
+		enumerateFunc.EXPECT().Call(digest1, "0/a")
+		enumerateFunc.EXPECT().Call(digest2, "2/b/c")
+
+		require.NoError(t, blobstore.EnumerateSequentially(ctx, backends, "", enumerateFunc.Call))
+	})
+
+	t.Run("Resume", func(t *testing.T) {
+		// The cursor of the first backend should be forwarded
+		// as is. Subsequent backends should start at the
+		// beginning.
+		backend0.EXPECT().Enumerate(ctx, "a", gomock.Any())
+		backend2.EXPECT().Enumerate(ctx, "", gomock.Any())
+
+		require.NoError(t, blobstore.EnumerateSequentially(ctx, backends, "0/a", mock.NewMockEnumerateFunc(ctrl).Call))
+	})
+
+	t.Run("InvalidCursor", func(t *testing.T) {
+		require.Equal(
+			t,
+			status.Error(codes.InvalidArgument, "Invalid cursor \"3/a\""),
+			blobstore.EnumerateSequentially(ctx, backends, "3/a", mock.NewMockEnumerateFunc(ctrl).Call))
+	})
+
+	t.Run("BackendFailure", func(t *testing.T) {
+		backend0.EXPECT().Enumerate(ctx, "", gomock.Any()).Return(status.Error(codes.Unavailable, "Server offline"))
+
+		require.Equal(
+			t,
+			status.Error(codes.Unavailable, "Backend 0: Server offline"),
+			blobstore.EnumerateSequentially(ctx, backends, "", mock.NewMockEnumerateFunc(ctrl).Call))
+	})
+
+	t.Run("NotEnumerable", func(t *testing.T) {
+		require.Equal(
+			t,
+			status.Error(codes.Unimplemented, "Backend 0 does not support enumeration"),
+			blobstore.EnumerateSequentially(ctx, []blobstore.BlobAccess{mock.NewMockBlobAccess(ctrl)}, "", mock.NewMockEnumerateFunc(ctrl).Call))
+	})
+}
@@ -0,0 +1,51 @@
+package expiring
+
+import (
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/clock"
+	pb "github.com/buildbarn/bb-storage/pkg/proto/configuration/expiring"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/golang/protobuf/ptypes"
+
+	"google.golang.org/grpc/codes"
+)
+
+func newPolicyFromConfiguration(configuration *pb.ActionResultExpiryPolicy) (Policy, error) {
+	var policy Policy
+	if configuration == nil {
+		return policy, nil
+	}
+	if configuration.MaximumAge != nil {
+		maximumAge, err := ptypes.Duration(configuration.MaximumAge)
+		if err != nil {
+			return Policy{}, util.StatusWrapWithCode(err, codes.InvalidArgument, "Invalid maximum age")
+		}
+		policy.MaximumAge = maximumAge
+	}
+	if configuration.InvalidateBefore != nil {
+		invalidateBefore, err := ptypes.Timestamp(configuration.InvalidateBefore)
+		if err != nil {
+			return Policy{}, util.StatusWrapWithCode(err, codes.InvalidArgument, "Invalid invalidation time")
+		}
+		policy.InvalidateBefore = invalidateBefore
+	}
+	return policy, nil
+}
+
+// NewExpiringBlobAccessFromConfiguration creates an ExpiringBlobAccess
+// based on policies specified in a configuration file.
+func NewExpiringBlobAccessFromConfiguration(actionCache blobstore.BlobAccess, configuration *pb.ActionResultExpiryConfiguration, clock clock.Clock, maximumMessageSizeBytes int) (blobstore.BlobAccess, error) {
+	defaultPolicy, err := newPolicyFromConfiguration(configuration.DefaultPolicy)
+	if err != nil {
+		return nil, util.StatusWrap(err, "Default policy")
+	}
+	policiesPerInstance := make(map[string]Policy, len(configuration.PoliciesPerInstance))
+	for instance, policyConfiguration := range configuration.PoliciesPerInstance {
+		policy, err := newPolicyFromConfiguration(policyConfiguration)
+		if err != nil {
+			return nil, util.StatusWrapf(err, "Policy for instance %#v", instance)
+		}
+		policiesPerInstance[instance] = policy
+	}
+	return NewExpiringBlobAccess(actionCache, defaultPolicy, policiesPerInstance, clock, maximumMessageSizeBytes), nil
+}
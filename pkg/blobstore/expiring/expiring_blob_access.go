@@ -0,0 +1,144 @@
+package expiring
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/clock"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	ac_pb "github.com/buildbarn/bb-storage/pkg/proto/ac"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Policy determines which ActionResult messages stored in the Action
+// Cache are considered to be expired.
+type Policy struct {
+	// Maximum age of ActionResult messages. No maximum age is
+	// enforced if zero.
+	MaximumAge time.Duration
+
+	// ActionResult messages written before this time are treated
+	// as expired. This may be used to invalidate the contents of
+	// the Action Cache in bulk. No entries are invalidated if the
+	// zero value is used.
+	InvalidateBefore time.Time
+}
+
+type expiringBlobAccess struct {
+	blobstore.BlobAccess
+	defaultPolicy           Policy
+	policiesPerInstance     map[string]Policy
+	clock                   clock.Clock
+	maximumMessageSizeBytes int
+}
+
+// NewExpiringBlobAccess creates a wrapper around an Action Cache (AC)
+// that treats ActionResult messages as non-existent if they are older
+// than permitted by a Policy. Policies may be specified per instance
+// name, falling back to a default policy.
+//
+// The age of an ActionResult is derived from the time at which it was
+// written through this wrapper, which is stored in a trailer that is
+// appended to the ActionResult. Timestamps provided by clients (e.g.,
+// the ones in ExecutedActionMetadata) are not used, as clients could
+// otherwise prevent entries from expiring. ActionResult messages
+// without a trailer are treated as expired, unless the policy enforces
+// no limits.
+func NewExpiringBlobAccess(actionCache blobstore.BlobAccess, defaultPolicy Policy, policiesPerInstance map[string]Policy, clock clock.Clock, maximumMessageSizeBytes int) blobstore.BlobAccess {
+	return &expiringBlobAccess{
+		BlobAccess:              actionCache,
+		defaultPolicy:           defaultPolicy,
+		policiesPerInstance:     policiesPerInstance,
+		clock:                   clock,
+		maximumMessageSizeBytes: maximumMessageSizeBytes,
+	}
+}
+
+func (ba *expiringBlobAccess) getPolicy(instance string) Policy {
+	if policy, ok := ba.policiesPerInstance[instance]; ok {
+		return policy
+	}
+	return ba.defaultPolicy
+}
+
+// splitWriteTime separates the trailer containing the write time from
+// a serialized ActionResult. The trailer is only accepted if it is
+// placed at the end of the entry, so that the serialized ActionResult
+// preceding it can be recovered.
+func splitWriteTime(data []byte) ([]byte, time.Time, bool) {
+	var trailer ac_pb.WriteTimeActionResultTrailer
+	if err := proto.Unmarshal(data, &trailer); err != nil || trailer.WriteTime == nil {
+		return data, time.Time{}, false
+	}
+	// Fields of the ActionResult are retained as unknown fields of
+	// the trailer. Omit them when reconstructing it.
+	trailerData, err := proto.Marshal(&ac_pb.WriteTimeActionResultTrailer{
+		WriteTime: trailer.WriteTime,
+	})
+	if err != nil || !bytes.HasSuffix(data, trailerData) {
+		return data, time.Time{}, false
+	}
+	writeTime, err := ptypes.Timestamp(trailer.WriteTime)
+	if err != nil {
+		return data, time.Time{}, false
+	}
+	return data[:len(data)-len(trailerData)], writeTime, true
+}
+
+func (ba *expiringBlobAccess) checkExpiry(policy Policy, writeTime time.Time) error {
+	if !policy.InvalidateBefore.IsZero() && writeTime.Before(policy.InvalidateBefore) {
+		return status.Errorf(codes.NotFound, "Action result was written at %s, which is before the invalidation time %s", writeTime.UTC().Format(time.RFC3339), policy.InvalidateBefore.UTC().Format(time.RFC3339))
+	}
+	if policy.MaximumAge != 0 && ba.clock.Now().Sub(writeTime) > policy.MaximumAge {
+		return status.Errorf(codes.NotFound, "Action result was written at %s, which is more than %s ago", writeTime.UTC().Format(time.RFC3339), policy.MaximumAge)
+	}
+	return nil
+}
+
+func (ba *expiringBlobAccess) Get(ctx context.Context, digest digest.Digest) buffer.Buffer {
+	data, err := ba.BlobAccess.Get(ctx, digest).ToByteSlice(ba.maximumMessageSizeBytes)
+	if err != nil {
+		return buffer.NewBufferFromError(err)
+	}
+	actionResultData, writeTime, ok := splitWriteTime(data)
+	if policy := ba.getPolicy(digest.GetInstance()); policy.MaximumAge != 0 || !policy.InvalidateBefore.IsZero() {
+		if !ok {
+			return buffer.NewBufferFromError(status.Error(codes.NotFound, "Action result does not contain a write time"))
+		}
+		if err := ba.checkExpiry(policy, writeTime); err != nil {
+			return buffer.NewBufferFromError(err)
+		}
+	}
+	return buffer.NewACBufferFromByteSlice(actionResultData, buffer.Irreparable)
+}
+
+func (ba *expiringBlobAccess) Put(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+	actionResultData, err := b.ToByteSlice(ba.maximumMessageSizeBytes)
+	if err != nil {
+		return err
+	}
+	// Record the time at which the ActionResult was written, so
+	// that its age can be determined when read.
+	writeTime, err := ptypes.TimestampProto(ba.clock.Now())
+	if err != nil {
+		return util.StatusWrapWithCode(err, codes.Internal, "Failed to create write timestamp")
+	}
+	trailerData, err := proto.Marshal(&ac_pb.WriteTimeActionResultTrailer{
+		WriteTime: writeTime,
+	})
+	if err != nil {
+		return util.StatusWrap(err, "Failed to marshal write time")
+	}
+	data := make([]byte, 0, len(actionResultData)+len(trailerData))
+	data = append(data, actionResultData...)
+	data = append(data, trailerData...)
+	return ba.BlobAccess.Put(ctx, digest, buffer.NewACBufferFromByteSlice(data, buffer.UserProvided))
+}
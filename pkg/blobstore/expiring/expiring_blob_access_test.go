@@ -0,0 +1,167 @@
+package expiring_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/expiring"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// newStoredData returns the data that ExpiringBlobAccess stores for an
+// ActionResult that is written at a given time.
+func newStoredData(ctx context.Context, t *testing.T, ctrl *gomock.Controller, actionResult *remoteexecution.ActionResult, writeTime time.Time) []byte {
+	baseActionCache := mock.NewMockBlobAccess(ctrl)
+	clock := mock.NewMockClock(ctrl)
+	blobAccess := expiring.NewExpiringBlobAccess(baseActionCache, expiring.Policy{}, nil, clock, 10000)
+	actionDigest := digest.MustNewDigest("default", remoteexecution.DigestFunction_MD5, "8b1a9953c4611296a827abf8c47804d7", 123)
+
+	var storedData []byte
+	baseActionCache.EXPECT().Put(ctx, actionDigest, gomock.Any()).DoAndReturn(
+		func(ctx context.Context, actionDigest digest.Digest, b buffer.Buffer) error {
+			data, err := b.ToByteSlice(10000)
+			require.NoError(t, err)
+			storedData = data
+			return nil
+		})
+	clock.EXPECT().Now().Return(writeTime)
+	require.NoError(t, blobAccess.Put(ctx, actionDigest, buffer.NewACBufferFromActionResult(actionResult, buffer.UserProvided)))
+	return storedData
+}
+
+func TestExpiringBlobAccessGet(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+	defer ctrl.Finish()
+
+	baseActionCache := mock.NewMockBlobAccess(ctrl)
+	clock := mock.NewMockClock(ctrl)
+	blobAccess := expiring.NewExpiringBlobAccess(
+		baseActionCache,
+		expiring.Policy{
+			MaximumAge: 100 * time.Second,
+		},
+		map[string]expiring.Policy{
+			"invalidated": {
+				InvalidateBefore: time.Unix(1100, 0),
+			},
+			"unlimited": {},
+		},
+		clock,
+		10000)
+
+	actionResult := &remoteexecution.ActionResult{
+		ExitCode: 1,
+	}
+	storedData := newStoredData(ctx, t, ctrl, actionResult, time.Unix(1000, 0))
+
+	t.Run("Fresh", func(t *testing.T) {
+		actionDigest := digest.MustNewDigest("default", remoteexecution.DigestFunction_MD5, "8b1a9953c4611296a827abf8c47804d7", 123)
+		baseActionCache.EXPECT().Get(ctx, actionDigest).Return(buffer.NewACBufferFromByteSlice(storedData, buffer.Irreparable))
+		clock.EXPECT().Now().Return(time.Unix(1100, 0))
+
+		// The write time should be removed from the returned
+		// ActionResult.
+		data, err := blobAccess.Get(ctx, actionDigest).ToByteSlice(10000)
+		require.NoError(t, err)
+		expectedData, err := proto.Marshal(actionResult)
+		require.NoError(t, err)
+		require.Equal(t, expectedData, data)
+	})
+
+	t.Run("TooOld", func(t *testing.T) {
+		actionDigest := digest.MustNewDigest("default", remoteexecution.DigestFunction_MD5, "8b1a9953c4611296a827abf8c47804d7", 123)
+		baseActionCache.EXPECT().Get(ctx, actionDigest).Return(buffer.NewACBufferFromByteSlice(storedData, buffer.Irreparable))
+		clock.EXPECT().Now().Return(time.Unix(1101, 0))
+
+		_, err := blobAccess.Get(ctx, actionDigest).ToActionResult(10000)
+		require.Equal(t, status.Error(codes.NotFound, "Action result was written at 1970-01-01T00:16:40Z, which is more than 1m40s ago"), err)
+	})
+
+	t.Run("ClientProvidedTimestamps", func(t *testing.T) {
+		// Timestamps provided by the client should not be used
+		// to determine the age of an ActionResult, as they could
+		// be used to prevent it from expiring.
+		actionDigest := digest.MustNewDigest("default", remoteexecution.DigestFunction_MD5, "8b1a9953c4611296a827abf8c47804d7", 123)
+		futureActionResult := &remoteexecution.ActionResult{
+			ExecutionMetadata: &remoteexecution.ExecutedActionMetadata{
+				OutputUploadCompletedTimestamp: &timestamp.Timestamp{Seconds: 1000000},
+			},
+		}
+		futureData := newStoredData(ctx, t, ctrl, futureActionResult, time.Unix(1000, 0))
+		baseActionCache.EXPECT().Get(ctx, actionDigest).Return(buffer.NewACBufferFromByteSlice(futureData, buffer.Irreparable))
+		clock.EXPECT().Now().Return(time.Unix(1101, 0))
+
+		_, err := blobAccess.Get(ctx, actionDigest).ToActionResult(10000)
+		require.Equal(t, status.Error(codes.NotFound, "Action result was written at 1970-01-01T00:16:40Z, which is more than 1m40s ago"), err)
+	})
+
+	t.Run("NoWriteTime", func(t *testing.T) {
+		actionDigest := digest.MustNewDigest("default", remoteexecution.DigestFunction_MD5, "8b1a9953c4611296a827abf8c47804d7", 123)
+		baseActionCache.EXPECT().Get(ctx, actionDigest).Return(buffer.NewACBufferFromActionResult(&remoteexecution.ActionResult{
+			ExecutionMetadata: &remoteexecution.ExecutedActionMetadata{
+				OutputUploadCompletedTimestamp: &timestamp.Timestamp{Seconds: 1000},
+			},
+		}, buffer.Irreparable))
+
+		_, err := blobAccess.Get(ctx, actionDigest).ToActionResult(10000)
+		require.Equal(t, status.Error(codes.NotFound, "Action result does not contain a write time"), err)
+	})
+
+	t.Run("Invalidated", func(t *testing.T) {
+		// The per-instance policy should be used instead of
+		// the default policy.
+		actionDigest := digest.MustNewDigest("invalidated", remoteexecution.DigestFunction_MD5, "8b1a9953c4611296a827abf8c47804d7", 123)
+		baseActionCache.EXPECT().Get(ctx, actionDigest).Return(buffer.NewACBufferFromByteSlice(storedData, buffer.Irreparable))
+
+		_, err := blobAccess.Get(ctx, actionDigest).ToActionResult(10000)
+		require.Equal(t, status.Error(codes.NotFound, "Action result was written at 1970-01-01T00:16:40Z, which is before the invalidation time 1970-01-01T00:18:20Z"), err)
+	})
+
+	t.Run("Unlimited", func(t *testing.T) {
+		// Without any limits, entries should be returned, even
+		// if they contain no write time.
+		actionDigest := digest.MustNewDigest("unlimited", remoteexecution.DigestFunction_MD5, "8b1a9953c4611296a827abf8c47804d7", 123)
+		baseActionCache.EXPECT().Get(ctx, actionDigest).Return(buffer.NewACBufferFromActionResult(actionResult, buffer.Irreparable))
+
+		returnedActionResult, err := blobAccess.Get(ctx, actionDigest).ToActionResult(10000)
+		require.NoError(t, err)
+		require.True(t, proto.Equal(actionResult, returnedActionResult))
+	})
+}
+
+func TestExpiringBlobAccessPut(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+	defer ctrl.Finish()
+
+	// The write time should be appended to the ActionResult, while
+	// leaving the ActionResult itself unmodified. Timestamps
+	// provided by the client should be retained.
+	actionResult := &remoteexecution.ActionResult{
+		ExitCode: 1,
+		ExecutionMetadata: &remoteexecution.ExecutedActionMetadata{
+			OutputUploadCompletedTimestamp: &timestamp.Timestamp{Seconds: 900},
+		},
+	}
+	storedData := newStoredData(ctx, t, ctrl, actionResult, time.Unix(1000, 0))
+
+	actionResultData, err := proto.Marshal(actionResult)
+	require.NoError(t, err)
+	require.Equal(t, actionResultData, storedData[:len(actionResultData)])
+
+	// Stored entries should remain valid ActionResult messages.
+	storedActionResult, err := buffer.NewACBufferFromByteSlice(storedData, buffer.Irreparable).ToActionResult(10000)
+	require.NoError(t, err)
+	require.Equal(t, int32(1), storedActionResult.ExitCode)
+	require.Equal(t, int64(900), storedActionResult.ExecutionMetadata.OutputUploadCompletedTimestamp.Seconds)
+}
@@ -3,12 +3,15 @@ package local
 import (
 	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
 	"github.com/buildbarn/bb-storage/pkg/digest"
+	pb "github.com/buildbarn/bb-storage/pkg/proto/blobstore/local"
 )
 
 // Block of storage that contains a sequence of blobs. Buffers returned
-// by Get() must remain valid, even if Release() is called.
+// by Get() must remain valid, even if Release() is called. The
+// RepairStrategy provided to Get() is applied to the resulting buffer,
+// for implementations that are capable of detecting data corruption.
 type Block interface {
-	Get(digest digest.Digest, offsetBytes int64, sizeBytes int64) buffer.Buffer
+	Get(digest digest.Digest, offsetBytes int64, sizeBytes int64, repairStrategy buffer.RepairStrategy) buffer.Buffer
 	Put(offsetBytes int64, b buffer.Buffer) error
 	Release()
 }
@@ -16,6 +19,12 @@ type Block interface {
 // BlockAllocator is used by LocalBlobAccess to allocate large blocks of
 // storage (in-memory or on-disk) at a time. These blocks are then
 // filled with blobs that are stored without any padding in between.
+//
+// NewBlock() returns the location of the block on underlying storage.
+// For allocators that are not capable of persisting data, the location
+// is nil. For persistent allocators, this location may be provided to
+// NewBlockAtLocation() after a restart to reacquire the same block.
 type BlockAllocator interface {
-	NewBlock() (Block, error)
+	NewBlock() (Block, *pb.BlockLocation, error)
+	NewBlockAtLocation(location *pb.BlockLocation) (Block, bool)
 }
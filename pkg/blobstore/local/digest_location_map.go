@@ -4,12 +4,26 @@ import (
 	"github.com/buildbarn/bb-storage/pkg/digest"
 )
 
+// DigestLocationMapWalkFunc is the callback type that is invoked by
+// DigestLocationMap.Walk() for every entry stored in the map. The
+// position may be provided to a subsequent call to Walk() to resume
+// walking after this entry.
+type DigestLocationMapWalkFunc func(digest digest.Digest, location Location, position string) error
+
 // DigestLocationMap is equivalent to a map[digest.Digest]Location. It is
 // used by LocalBlobAccess to track where blobs are stored, so that they
 // may be accessed. Implementations are permitted to discard entries
 // for outdated locations during lookups/insertions using the provided
 // validator.
+//
+// Remove() and Walk() are used to perform consistency checking. Remove()
+// only removes an entry if it still refers to the provided location,
+// so that newer copies of the same blob are retained. Walk() invokes a
+// callback for every valid entry in the map, starting at the provided
+// position. An empty position corresponds to the start of the map.
 type DigestLocationMap interface {
 	Get(digest digest.Digest, validator *LocationValidator) (Location, error)
 	Put(digest digest.Digest, validator *LocationValidator, location Location) error
+	Remove(digest digest.Digest, validator *LocationValidator, location Location) error
+	Walk(validator *LocationValidator, position string, walkFunc DigestLocationMapWalkFunc) error
 }
@@ -0,0 +1,98 @@
+package local
+
+import (
+	"io"
+	"os"
+
+	"github.com/buildbarn/bb-storage/pkg/filesystem"
+	pb "github.com/buildbarn/bb-storage/pkg/proto/blobstore/local"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/golang/protobuf/proto"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	persistentStateFileName          = "state"
+	persistentStateTemporaryFileName = "state.tmp"
+)
+
+type directoryBackedPersistentStateStore struct {
+	directory filesystem.Directory
+}
+
+// NewDirectoryBackedPersistentStateStore creates a PersistentStateStore
+// that stores the persistent state of LocalBlobAccess as a file in a
+// directory. Updates are first written to a temporary file, which is
+// synchronized to disk and subsequently renamed on top of the original
+// file. This ensures that the original file remains intact if writing
+// fails or the system crashes.
+func NewDirectoryBackedPersistentStateStore(directory filesystem.Directory) PersistentStateStore {
+	return &directoryBackedPersistentStateStore{
+		directory: directory,
+	}
+}
+
+func (pss *directoryBackedPersistentStateStore) ReadPersistentState() (*pb.PersistentState, error) {
+	f, err := pss.directory.OpenRead(persistentStateFileName)
+	if os.IsNotExist(err) {
+		return nil, status.Error(codes.NotFound, "No persistent state has been written yet")
+	} else if err != nil {
+		return nil, util.StatusWrap(err, "Failed to open persistent state file")
+	}
+	defer f.Close()
+
+	// Read the entire file. As FileReader only provides ReadAt(),
+	// read it in chunks until end-of-file is reached.
+	var data []byte
+	chunk := make([]byte, 4096)
+	for {
+		n, err := f.ReadAt(chunk, int64(len(data)))
+		data = append(data, chunk[:n]...)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, util.StatusWrap(err, "Failed to read persistent state file")
+		}
+	}
+
+	var persistentState pb.PersistentState
+	if err := proto.Unmarshal(data, &persistentState); err != nil {
+		return nil, util.StatusWrapWithCode(err, codes.DataLoss, "Failed to unmarshal persistent state")
+	}
+	return &persistentState, nil
+}
+
+func (pss *directoryBackedPersistentStateStore) WritePersistentState(persistentState *pb.PersistentState) error {
+	data, err := proto.Marshal(persistentState)
+	if err != nil {
+		return util.StatusWrapWithCode(err, codes.InvalidArgument, "Failed to marshal persistent state")
+	}
+
+	if err := pss.directory.Remove(persistentStateTemporaryFileName); err != nil && !os.IsNotExist(err) {
+		return util.StatusWrap(err, "Failed to remove stale temporary persistent state file")
+	}
+	f, err := pss.directory.OpenWrite(persistentStateTemporaryFileName, filesystem.CreateExcl(0666))
+	if err != nil {
+		return util.StatusWrap(err, "Failed to create temporary persistent state file")
+	}
+	if _, err := f.WriteAt(data, 0); err != nil {
+		f.Close()
+		return util.StatusWrap(err, "Failed to write temporary persistent state file")
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return util.StatusWrap(err, "Failed to synchronize temporary persistent state file")
+	}
+	if err := f.Close(); err != nil {
+		return util.StatusWrap(err, "Failed to close temporary persistent state file")
+	}
+	if err := pss.directory.Rename(persistentStateTemporaryFileName, pss.directory, persistentStateFileName); err != nil {
+		return util.StatusWrap(err, "Failed to rename temporary persistent state file")
+	}
+	if err := pss.directory.Sync(); err != nil {
+		return util.StatusWrap(err, "Failed to synchronize persistent state directory")
+	}
+	return nil
+}
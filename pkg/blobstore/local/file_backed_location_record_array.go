@@ -0,0 +1,90 @@
+package local
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"io"
+
+	"github.com/buildbarn/bb-storage/pkg/util"
+)
+
+const (
+	// FileBackedLocationRecordSize is the size of a single serialized
+	// LocationRecord in bytes. In serialized form, a LocationRecord
+	// contains the following fields:
+	//
+	// - Digest          32 bytes
+	// - Attempt          4 bytes, of which the upper 8 bits contain
+	//                    the digest function, if not inferable
+	// - BlockID          8 bytes
+	// - OffsetBytes      8 bytes
+	// - SizeBytes        8 bytes
+	// - Checksum         4 bytes
+	//   Total:          64 bytes
+	FileBackedLocationRecordSize = 32 + 4 + 8 + 8 + 8 + 4
+)
+
+type fileBackedLocationRecordArray struct {
+	f ReadWriterAt
+}
+
+// NewFileBackedLocationRecordArray creates a persistent
+// LocationRecordArray. It works by using a file or block device as an
+// array-like structure, writing serialized LocationRecords next to each
+// other. Writes are not aligned to sector boundaries, meaning that the
+// ReadWriterAt must permit writes at arbitrary offsets.
+//
+// Every record contains a checksum. Records for which the checksum does
+// not match (e.g., due to the file being freshly created, or due to
+// writes being torn when the system crashes) are returned as if they
+// were never written. HashingDigestLocationMap treats such records as
+// being unused.
+func NewFileBackedLocationRecordArray(f ReadWriterAt) LocationRecordArray {
+	return &fileBackedLocationRecordArray{
+		f: f,
+	}
+}
+
+func computeLocationRecordChecksum(record []byte) uint32 {
+	h := fnv.New32a()
+	h.Write(record[:FileBackedLocationRecordSize-4])
+	return h.Sum32()
+}
+
+func (lra *fileBackedLocationRecordArray) Get(index int) (LocationRecord, error) {
+	var record [FileBackedLocationRecordSize]byte
+	if n, err := lra.f.ReadAt(record[:], int64(index)*FileBackedLocationRecordSize); err == io.EOF {
+		// Records past the end of the file have simply never
+		// been written.
+		if n < len(record) {
+			return LocationRecord{}, nil
+		}
+	} else if err != nil {
+		return LocationRecord{}, util.StatusWrapf(err, "Failed to read location record at index %d", index)
+	}
+	if computeLocationRecordChecksum(record[:]) != binary.LittleEndian.Uint32(record[FileBackedLocationRecordSize-4:]) {
+		return LocationRecord{}, nil
+	}
+
+	var locationRecord LocationRecord
+	copy(locationRecord.Key.Digest[:], record[:32])
+	locationRecord.Key.setAttemptAndDigestFunction(binary.LittleEndian.Uint32(record[32:]))
+	locationRecord.Location.BlockID = int(binary.LittleEndian.Uint64(record[36:]))
+	locationRecord.Location.OffsetBytes = int64(binary.LittleEndian.Uint64(record[44:]))
+	locationRecord.Location.SizeBytes = int64(binary.LittleEndian.Uint64(record[52:]))
+	return locationRecord, nil
+}
+
+func (lra *fileBackedLocationRecordArray) Put(index int, locationRecord LocationRecord) error {
+	var record [FileBackedLocationRecordSize]byte
+	copy(record[:], locationRecord.Key.Digest[:])
+	binary.LittleEndian.PutUint32(record[32:], locationRecord.Key.getAttemptAndDigestFunction())
+	binary.LittleEndian.PutUint64(record[36:], uint64(locationRecord.Location.BlockID))
+	binary.LittleEndian.PutUint64(record[44:], uint64(locationRecord.Location.OffsetBytes))
+	binary.LittleEndian.PutUint64(record[52:], uint64(locationRecord.Location.SizeBytes))
+	binary.LittleEndian.PutUint32(record[FileBackedLocationRecordSize-4:], computeLocationRecordChecksum(record[:]))
+	if _, err := lra.f.WriteAt(record[:], int64(index)*FileBackedLocationRecordSize); err != nil {
+		return util.StatusWrapf(err, "Failed to write location record at index %d", index)
+	}
+	return nil
+}
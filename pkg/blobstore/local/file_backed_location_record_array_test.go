@@ -0,0 +1,118 @@
+package local_test
+
+import (
+	"io"
+	"testing"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/local"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileBackedLocationRecordArray(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	f := mock.NewMockFileReadWriter(ctrl)
+	array := local.NewFileBackedLocationRecordArray(f)
+
+	t.Run("EndOfFile", func(t *testing.T) {
+		// Entries past the end of the file should be treated as
+		// being default initialized.
+		f.EXPECT().ReadAt(gomock.Len(local.FileBackedLocationRecordSize), int64(7872)).Return(0, io.EOF)
+		record, err := array.Get(123)
+		require.NoError(t, err)
+		require.Equal(t, local.LocationRecord{}, record)
+	})
+
+	t.Run("ZeroInitialized", func(t *testing.T) {
+		// Zero initialized entries have an invalid checksum.
+		// These should also be treated as being default
+		// initialized.
+		f.EXPECT().ReadAt(gomock.Len(local.FileBackedLocationRecordSize), int64(7872)).Return(local.FileBackedLocationRecordSize, nil)
+		record, err := array.Get(123)
+		require.NoError(t, err)
+		require.Equal(t, local.LocationRecord{}, record)
+	})
+
+	t.Run("RoundTrip", func(t *testing.T) {
+		// Entries that are written should be readable.
+		record := local.LocationRecord{
+			Key: local.NewLocationRecordKey(
+				digest.MustNewDigest(
+					"hello",
+					remoteexecution.DigestFunction_SHA256,
+					"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+					123)),
+			Location: local.Location{
+				BlockID:     483,
+				OffsetBytes: 32984729387,
+				SizeBytes:   58974582,
+			},
+		}
+		record.Key.Attempt = 7
+		var data []byte
+		f.EXPECT().WriteAt(gomock.Len(local.FileBackedLocationRecordSize), int64(7872)).DoAndReturn(
+			func(p []byte, off int64) (int, error) {
+				data = append([]byte{}, p...)
+				return len(p), nil
+			})
+		require.NoError(t, array.Put(123, record))
+
+		f.EXPECT().ReadAt(gomock.Len(local.FileBackedLocationRecordSize), int64(7872)).DoAndReturn(
+			func(p []byte, off int64) (int, error) {
+				return copy(p, data), nil
+			})
+		readRecord, err := array.Get(123)
+		require.NoError(t, err)
+		require.Equal(t, record, readRecord)
+
+		// Entries with a corrupted checksum should be ignored.
+		data[40] ^= 0x01
+		f.EXPECT().ReadAt(gomock.Len(local.FileBackedLocationRecordSize), int64(7872)).DoAndReturn(
+			func(p []byte, off int64) (int, error) {
+				return copy(p, data), nil
+			})
+		readRecord, err = array.Get(123)
+		require.NoError(t, err)
+		require.Equal(t, local.LocationRecord{}, readRecord)
+	})
+
+	t.Run("RoundTripDigestFunction", func(t *testing.T) {
+		// Digest functions that cannot be inferred from the
+		// length of the hash should be preserved as well.
+		record := local.LocationRecord{
+			Key: local.NewLocationRecordKey(
+				digest.MustNewDigest(
+					"hello",
+					remoteexecution.DigestFunction_BLAKE3,
+					"af1349b9f5f9a1a6a0404dea36dcc9499bcb25c9adc112b7cc9a93cae41f3262",
+					123)),
+			Location: local.Location{
+				BlockID:     483,
+				OffsetBytes: 32984729387,
+				SizeBytes:   58974582,
+			},
+		}
+		record.Key.Attempt = 7
+		require.Equal(t, remoteexecution.DigestFunction_BLAKE3, record.Key.DigestFunction)
+		var data []byte
+		f.EXPECT().WriteAt(gomock.Len(local.FileBackedLocationRecordSize), int64(7872)).DoAndReturn(
+			func(p []byte, off int64) (int, error) {
+				data = append([]byte{}, p...)
+				return len(p), nil
+			})
+		require.NoError(t, array.Put(123, record))
+
+		f.EXPECT().ReadAt(gomock.Len(local.FileBackedLocationRecordSize), int64(7872)).DoAndReturn(
+			func(p []byte, off int64) (int, error) {
+				return copy(p, data), nil
+			})
+		readRecord, err := array.Get(123)
+		require.NoError(t, err)
+		require.Equal(t, record, readRecord)
+	})
+}
@@ -1,6 +1,7 @@
 package local
 
 import (
+	"strconv"
 	"sync"
 
 	"github.com/buildbarn/bb-storage/pkg/digest"
@@ -142,7 +143,10 @@ func (dlm *hashingDigestLocationMap) Get(digest digest.Digest, validator *Locati
 	key := NewLocationRecordKey(digest)
 	for {
 		slot := dlm.getSlot(&key)
-		record := dlm.recordArray.Get(slot)
+		record, err := dlm.recordArray.Get(slot)
+		if err != nil {
+			return Location{}, err
+		}
 		if !validator.IsValid(record.Location) {
 			// Record points to a block that no longer
 			// exists. There is no need to continue
@@ -174,10 +178,15 @@ func (dlm *hashingDigestLocationMap) Put(digest digest.Digest, validator *Locati
 	}
 	for iteration := 1; iteration <= dlm.maximumPutAttempts; iteration++ {
 		slot := dlm.getSlot(&record.Key)
-		oldRecord := dlm.recordArray.Get(slot)
+		oldRecord, err := dlm.recordArray.Get(slot)
+		if err != nil {
+			return err
+		}
 		if !validator.IsValid(oldRecord.Location) {
 			// The existing record may be overwritten directly.
-			dlm.recordArray.Put(slot, record)
+			if err := dlm.recordArray.Put(slot, record); err != nil {
+				return err
+			}
 			hashingDigestLocationMapPutSet.Observe(float64(iteration))
 			return nil
 		}
@@ -185,7 +194,9 @@ func (dlm *hashingDigestLocationMap) Put(digest digest.Digest, validator *Locati
 			// Only allow overwriting an entry if it points
 			// to a newer version of the same blob.
 			if oldRecord.Location.IsOlder(record.Location) {
-				dlm.recordArray.Put(slot, record)
+				if err := dlm.recordArray.Put(slot, record); err != nil {
+					return err
+				}
 				hashingDigestLocationMapPutUpdate.Observe(float64(iteration))
 				return nil
 			}
@@ -197,7 +208,9 @@ func (dlm *hashingDigestLocationMap) Put(digest digest.Digest, validator *Locati
 			// it does point to older data than the record
 			// we're trying to insert. Displace the old
 			// record.
-			dlm.recordArray.Put(slot, record)
+			if err := dlm.recordArray.Put(slot, record); err != nil {
+				return err
+			}
 			record = oldRecord
 		}
 		record.Key.Attempt++
@@ -210,3 +223,72 @@ func (dlm *hashingDigestLocationMap) Put(digest digest.Digest, validator *Locati
 	hashingDigestLocationMapPutTooManyIterations.Inc()
 	return nil
 }
+
+func (dlm *hashingDigestLocationMap) Remove(digest digest.Digest, validator *LocationValidator, location Location) error {
+	key := NewLocationRecordKey(digest)
+	for {
+		slot := dlm.getSlot(&key)
+		record, err := dlm.recordArray.Get(slot)
+		if err != nil {
+			return err
+		}
+		if !validator.IsValid(record.Location) {
+			// Entry is not present.
+			return nil
+		}
+		if record.Key == key {
+			if record.Location != location {
+				// Entry refers to another copy of the
+				// blob, which should be retained.
+				return nil
+			}
+			// Overwrite the entry with one having an
+			// invalid location. This causes Get() to stop
+			// searching at this slot, meaning that entries
+			// that were displaced by this one become
+			// unreachable. As these entries are older, this
+			// is acceptable.
+			return dlm.recordArray.Put(slot, LocationRecord{})
+		}
+		key.Attempt++
+		if key.Attempt >= dlm.maximumGetAttempts {
+			return nil
+		}
+	}
+}
+
+func (dlm *hashingDigestLocationMap) Walk(validator *LocationValidator, position string, walkFunc DigestLocationMapWalkFunc) error {
+	// Positions correspond to the slot at which walking resumes.
+	startSlot := 0
+	if position != "" {
+		var err error
+		if startSlot, err = strconv.Atoi(position); err != nil || startSlot < 0 {
+			return status.Errorf(codes.InvalidArgument, "Invalid position %#v", position)
+		}
+	}
+	for slot := startSlot; slot < dlm.recordsCount; slot++ {
+		record, err := dlm.recordArray.Get(slot)
+		if err != nil {
+			return err
+		}
+		// Skip entries that point to blocks that no longer
+		// exist, and entries that cannot be reached by Get().
+		// The latter may be the case if the record array
+		// contains garbage.
+		if !validator.IsValid(record.Location) ||
+			record.Key.Attempt >= dlm.maximumGetAttempts ||
+			dlm.getSlot(&record.Key) != slot {
+			continue
+		}
+		// Skip entries whose digest cannot be recovered, as
+		// their hashes were truncated when stored.
+		blobDigest, err := digest.NewDigestFromPaddedHashBytes("", record.Key.DigestFunction, record.Key.Digest[:], record.Location.SizeBytes)
+		if err != nil {
+			continue
+		}
+		if err := walkFunc(blobDigest, record.Location, strconv.Itoa(slot+1)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -3,11 +3,15 @@ package local_test
 import (
 	"testing"
 
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
 	"github.com/buildbarn/bb-storage/internal/mock"
 	"github.com/buildbarn/bb-storage/pkg/blobstore/local"
 	"github.com/buildbarn/bb-storage/pkg/digest"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func TestHashingDigestLocationMapPut(t *testing.T) {
@@ -17,8 +21,8 @@ func TestHashingDigestLocationMapPut(t *testing.T) {
 	array := mock.NewMockLocationRecordArray(ctrl)
 	dlm := local.NewHashingDigestLocationMap(array, 10, 0x970aef1f90c7f916, 2, 2)
 
-	digest1 := digest.MustNewDigest("hello", "ca2bd6c9c99e7bc00a440973d6e1a369", 473)
-	digest2 := digest.MustNewDigest("hello", "4942691f5907d5eddb71818f658f2071", 8347)
+	digest1 := digest.MustNewDigest("hello", remoteexecution.DigestFunction_MD5, "ca2bd6c9c99e7bc00a440973d6e1a369", 473)
+	digest2 := digest.MustNewDigest("hello", remoteexecution.DigestFunction_MD5, "4942691f5907d5eddb71818f658f2071", 8347)
 	validator := local.LocationValidator{
 		OldestBlockID: 13,
 		NewestBlockID: 20,
@@ -41,11 +45,11 @@ func TestHashingDigestLocationMapPut(t *testing.T) {
 
 	t.Run("SimpleInsertion", func(t *testing.T) {
 		// An unused slot should be overwritten immediately.
-		array.EXPECT().Get(5).Return(local.LocationRecord{})
+		array.EXPECT().Get(5).Return(local.LocationRecord{}, nil)
 		array.EXPECT().Put(5, local.LocationRecord{
 			Key:      local.NewLocationRecordKey(digest1),
 			Location: newLocation,
-		})
+		}).Return(nil)
 		require.NoError(t, dlm.Put(digest1, &validator, newLocation))
 	})
 
@@ -66,11 +70,11 @@ func TestHashingDigestLocationMapPut(t *testing.T) {
 		array.EXPECT().Get(5).Return(local.LocationRecord{
 			Key:      local.NewLocationRecordKey(digest1),
 			Location: oldLocation,
-		})
+		}, nil)
 		array.EXPECT().Put(5, local.LocationRecord{
 			Key:      local.NewLocationRecordKey(digest1),
 			Location: newLocation,
-		})
+		}).Return(nil)
 		require.NoError(t, dlm.Put(digest1, &validator, newLocation))
 	})
 
@@ -80,7 +84,7 @@ func TestHashingDigestLocationMapPut(t *testing.T) {
 		array.EXPECT().Get(5).Return(local.LocationRecord{
 			Key:      local.NewLocationRecordKey(digest1),
 			Location: newLocation,
-		})
+		}, nil)
 		require.NoError(t, dlm.Put(digest1, &validator, oldLocation))
 	})
 
@@ -91,14 +95,14 @@ func TestHashingDigestLocationMapPut(t *testing.T) {
 		array.EXPECT().Get(5).Return(local.LocationRecord{
 			Key:      local.NewLocationRecordKey(digest2),
 			Location: newLocation,
-		})
-		array.EXPECT().Get(2).Return(local.LocationRecord{})
+		}, nil)
+		array.EXPECT().Get(2).Return(local.LocationRecord{}, nil)
 		locationRecord := local.LocationRecord{
 			Key:      local.NewLocationRecordKey(digest1),
 			Location: oldLocation,
 		}
 		locationRecord.Key.Attempt++
-		array.EXPECT().Put(2, locationRecord)
+		array.EXPECT().Put(2, locationRecord).Return(nil)
 		require.NoError(t, dlm.Put(digest1, &validator, oldLocation))
 	})
 
@@ -109,16 +113,135 @@ func TestHashingDigestLocationMapPut(t *testing.T) {
 			Key:      local.NewLocationRecordKey(digest2),
 			Location: oldLocation,
 		}
-		array.EXPECT().Get(5).Return(locationRecord)
+		array.EXPECT().Get(5).Return(locationRecord, nil)
 		array.EXPECT().Put(5, local.LocationRecord{
 			Key:      local.NewLocationRecordKey(digest1),
 			Location: newLocation,
-		})
-		array.EXPECT().Get(6).Return(local.LocationRecord{})
+		}).Return(nil)
+		array.EXPECT().Get(6).Return(local.LocationRecord{}, nil)
 		locationRecord.Key.Attempt++
-		array.EXPECT().Put(6, locationRecord)
+		array.EXPECT().Put(6, locationRecord).Return(nil)
 		require.NoError(t, dlm.Put(digest1, &validator, newLocation))
 	})
 }
 
+func TestHashingDigestLocationMapRemove(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	array := mock.NewMockLocationRecordArray(ctrl)
+	dlm := local.NewHashingDigestLocationMap(array, 10, 0x970aef1f90c7f916, 2, 2)
+
+	digest1 := digest.MustNewDigest("hello", remoteexecution.DigestFunction_MD5, "ca2bd6c9c99e7bc00a440973d6e1a369", 473)
+	validator := local.LocationValidator{
+		OldestBlockID: 13,
+		NewestBlockID: 20,
+	}
+	oldLocation := local.Location{
+		BlockID:     14,
+		OffsetBytes: 859,
+		SizeBytes:   473,
+	}
+	newLocation := local.Location{
+		BlockID:     17,
+		OffsetBytes: 864,
+		SizeBytes:   473,
+	}
+
+	t.Run("NotFound", func(t *testing.T) {
+		array.EXPECT().Get(5).Return(local.LocationRecord{}, nil)
+		require.NoError(t, dlm.Remove(digest1, &validator, newLocation))
+	})
+
+	t.Run("DifferentLocation", func(t *testing.T) {
+		// Entries referring to another copy of the same blob
+		// should be left alone.
+		array.EXPECT().Get(5).Return(local.LocationRecord{
+			Key:      local.NewLocationRecordKey(digest1),
+			Location: newLocation,
+		}, nil)
+		require.NoError(t, dlm.Remove(digest1, &validator, oldLocation))
+	})
+
+	t.Run("Success", func(t *testing.T) {
+		array.EXPECT().Get(5).Return(local.LocationRecord{
+			Key:      local.NewLocationRecordKey(digest1),
+			Location: newLocation,
+		}, nil)
+		array.EXPECT().Put(5, local.LocationRecord{}).Return(nil)
+		require.NoError(t, dlm.Remove(digest1, &validator, newLocation))
+	})
+}
+
+func TestHashingDigestLocationMapWalk(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	array := mock.NewMockLocationRecordArray(ctrl)
+	dlm := local.NewHashingDigestLocationMap(array, 10, 0x970aef1f90c7f916, 2, 2)
+
+	digest1 := digest.MustNewDigest("hello", remoteexecution.DigestFunction_MD5, "ca2bd6c9c99e7bc00a440973d6e1a369", 473)
+	validator := local.LocationValidator{
+		OldestBlockID: 13,
+		NewestBlockID: 20,
+	}
+	location := local.Location{
+		BlockID:     17,
+		OffsetBytes: 864,
+		SizeBytes:   473,
+	}
+
+	// Only valid entries stored in the slot corresponding to their
+	// key should be reported. Entries stored in other slots are
+	// garbage.
+	for slot := 0; slot < 10; slot++ {
+		switch slot {
+		case 3, 5:
+			array.EXPECT().Get(slot).Return(local.LocationRecord{
+				Key:      local.NewLocationRecordKey(digest1),
+				Location: location,
+			}, nil)
+		case 7:
+			array.EXPECT().Get(slot).Return(local.LocationRecord{
+				Key: local.NewLocationRecordKey(digest1),
+				Location: local.Location{
+					BlockID:     12,
+					OffsetBytes: 923843,
+					SizeBytes:   8975495,
+				},
+			}, nil)
+		default:
+			array.EXPECT().Get(slot).Return(local.LocationRecord{}, nil)
+		}
+	}
+	walkFunc := mock.NewMockDigestLocationMapWalkFunc(ctrl)
+	walkFunc.EXPECT().Call(digest.MustNewDigest("", remoteexecution.DigestFunction_MD5, "ca2bd6c9c99e7bc00a440973d6e1a369", 473), location, "6")
+	require.NoError(t, dlm.Walk(&validator, "", walkFunc.Call))
+
+	// Walking should be resumable at the position returned for the
+	// last entry, without reporting it again.
+	for slot := 6; slot < 10; slot++ {
+		switch slot {
+		case 7:
+			array.EXPECT().Get(slot).Return(local.LocationRecord{
+				Key: local.NewLocationRecordKey(digest1),
+				Location: local.Location{
+					BlockID:     12,
+					OffsetBytes: 923843,
+					SizeBytes:   8975495,
+				},
+			}, nil)
+		default:
+			array.EXPECT().Get(slot).Return(local.LocationRecord{}, nil)
+		}
+	}
+	require.NoError(t, dlm.Walk(&validator, "6", walkFunc.Call))
+
+	// Malformed positions should be rejected.
+	require.Equal(
+		t,
+		status.Error(codes.InvalidArgument, "Invalid position \"hello\""),
+		dlm.Walk(&validator, "hello", walkFunc.Call))
+}
+
 // TODO: Make unit testing coverage more complete.
@@ -5,6 +5,7 @@ import (
 
 	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
 	"github.com/buildbarn/bb-storage/pkg/digest"
+	pb "github.com/buildbarn/bb-storage/pkg/proto/blobstore/local"
 )
 
 type inMemoryBlockAllocator struct {
@@ -21,17 +22,22 @@ func NewInMemoryBlockAllocator(blockSize int) BlockAllocator {
 	}
 }
 
-func (ia *inMemoryBlockAllocator) NewBlock() (Block, error) {
+func (ia *inMemoryBlockAllocator) NewBlock() (Block, *pb.BlockLocation, error) {
 	return inMemoryBlock{
 		data: make([]byte, ia.blockSize),
-	}, nil
+	}, nil, nil
+}
+
+func (ia *inMemoryBlockAllocator) NewBlockAtLocation(location *pb.BlockLocation) (Block, bool) {
+	// Data stored in memory does not survive restarts.
+	return nil, false
 }
 
 type inMemoryBlock struct {
 	data []byte
 }
 
-func (ib inMemoryBlock) Get(digest digest.Digest, offsetBytes int64, sizeBytes int64) buffer.Buffer {
+func (ib inMemoryBlock) Get(digest digest.Digest, offsetBytes int64, sizeBytes int64, repairStrategy buffer.RepairStrategy) buffer.Buffer {
 	return buffer.NewValidatedBufferFromByteSlice(ib.data[offsetBytes : offsetBytes+sizeBytes])
 }
 
@@ -3,6 +3,7 @@ package local_test
 import (
 	"testing"
 
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
 	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
 	"github.com/buildbarn/bb-storage/pkg/blobstore/local"
 	"github.com/buildbarn/bb-storage/pkg/digest"
@@ -10,8 +11,9 @@ import (
 )
 
 func TestInMemoryBlockAllocator(t *testing.T) {
-	block, err := local.NewInMemoryBlockAllocator(1024).NewBlock()
+	block, location, err := local.NewInMemoryBlockAllocator(1024).NewBlock()
 	require.NoError(t, err)
+	require.Nil(t, location)
 
 	// Write an object into the block.
 	require.NoError(t, block.Put(
@@ -20,9 +22,10 @@ func TestInMemoryBlockAllocator(t *testing.T) {
 
 	// Extract it once again, using the right offset and size.
 	data, err := block.Get(
-		digest.MustNewDigest("hello", "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", 456),
+		digest.MustNewDigest("hello", remoteexecution.DigestFunction_SHA256, "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", 456),
 		123,
-		11).ToByteSlice(1024)
+		11,
+		buffer.Irreparable).ToByteSlice(1024)
 	require.NoError(t, err)
 	require.Equal(t, []byte("Hello world"), data)
 
@@ -3,6 +3,7 @@ package local_test
 import (
 	"testing"
 
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
 	"github.com/buildbarn/bb-storage/pkg/blobstore/local"
 	"github.com/buildbarn/bb-storage/pkg/digest"
 	"github.com/stretchr/testify/require"
@@ -12,13 +13,16 @@ func TestInMemoryLocationRecordArray(t *testing.T) {
 	array := local.NewInMemoryLocationRecordArray(1024)
 
 	// Entries should be default initialized.
-	require.Equal(t, local.LocationRecord{}, array.Get(123))
+	record, err := array.Get(123)
+	require.NoError(t, err)
+	require.Equal(t, local.LocationRecord{}, record)
 
 	// Entries should be writable.
 	record1 := local.LocationRecord{
 		Key: local.NewLocationRecordKey(
 			digest.MustNewDigest(
 				"hello",
+				remoteexecution.DigestFunction_MD5,
 				"3e25960a79dbc69b674cd4ec67a72c62",
 				123)),
 		Location: local.Location{
@@ -27,14 +31,17 @@ func TestInMemoryLocationRecordArray(t *testing.T) {
 			SizeBytes:   789,
 		},
 	}
-	array.Put(123, record1)
-	require.Equal(t, record1, array.Get(123))
+	require.NoError(t, array.Put(123, record1))
+	record, err = array.Get(123)
+	require.NoError(t, err)
+	require.Equal(t, record1, record)
 
 	// Entries should be overwritable.
 	record2 := local.LocationRecord{
 		Key: local.NewLocationRecordKey(
 			digest.MustNewDigest(
 				"foo",
+				remoteexecution.DigestFunction_SHA256,
 				"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
 				123)),
 		Location: local.Location{
@@ -43,6 +50,8 @@ func TestInMemoryLocationRecordArray(t *testing.T) {
 			SizeBytes:   58974582,
 		},
 	}
-	array.Put(123, record2)
-	require.Equal(t, record2, array.Get(123))
+	require.NoError(t, array.Put(123, record2))
+	record, err = array.Get(123)
+	require.NoError(t, err)
+	require.Equal(t, record2, record)
 }
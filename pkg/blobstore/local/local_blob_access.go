@@ -8,6 +8,9 @@ import (
 	"github.com/buildbarn/bb-storage/pkg/blobstore"
 	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
 	"github.com/buildbarn/bb-storage/pkg/digest"
+	pb "github.com/buildbarn/bb-storage/pkg/proto/blobstore/local"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/golang/protobuf/ptypes"
 	"github.com/prometheus/client_golang/prometheus"
 
 	"google.golang.org/grpc/codes"
@@ -46,12 +49,14 @@ var (
 // localBlobAccess.
 type sharedBlock struct {
 	b        Block
+	location *pb.BlockLocation
 	refcount uint64
 }
 
-func newSharedBlock(b Block) *sharedBlock {
+func newSharedBlock(b Block, location *pb.BlockLocation) *sharedBlock {
 	return &sharedBlock{
 		b:        b,
+		location: location,
 		refcount: 1,
 	}
 }
@@ -79,7 +84,7 @@ func (sb *sharedBlock) release() {
 // to nil pointer dereferences.
 type deadBlock struct{}
 
-func (db deadBlock) Get(digest digest.Digest, offset int64, sizeBytes int64) buffer.Buffer {
+func (db deadBlock) Get(digest digest.Digest, offset int64, sizeBytes int64, repairStrategy buffer.RepairStrategy) buffer.Buffer {
 	return buffer.NewBufferFromError(status.Error(codes.Internal, "Attempted to read blob from dead block"))
 }
 
@@ -97,14 +102,30 @@ type oldBlock struct {
 type newBlock struct {
 	block  *sharedBlock
 	offset int64
+
+	// The write offset of the block as stored in the persistent
+	// state. Space up to this offset may be allocated without
+	// updating the persistent state.
+	reservedOffset int64
 }
 
+// writeReservationsPerBlock controls how much space is reserved in
+// "new" blocks when persisting state. Every time the write offset of a
+// "new" block exceeds the reserved space, the persistent state is
+// rewritten to reserve another 1/writeReservationsPerBlock of the
+// block. Upon restart, reserved space that was not used is lost.
+const writeReservationsPerBlock = 16
+
 type localBlobAccess struct {
 	sectorSizeBytes       int
 	blockSectorCount      int64
 	blockAllocator        BlockAllocator
 	desiredNewBlocksCount int
 
+	persistentStateStore                PersistentStateStore
+	digestLocationMapHashInitialization uint64
+	persistentStateDirty                bool
+
 	lock                        sync.Mutex
 	refreshLock                 sync.Mutex
 	digestLocationMap           DigestLocationMap
@@ -188,7 +209,18 @@ func unixTime() float64 {
 // being LRU-like. Setting it too high is also not recommended, as this
 // would increase redundancy in the data stored. The "current" group
 // should likely be two or three times as large as the "old" group.
-func NewLocalBlobAccess(digestLocationMap DigestLocationMap, blockAllocator BlockAllocator, name string, sectorSizeBytes int, blockSectorCount int64, oldBlocksCount int, currentBlocksCount int, newBlocksCount int) (blobstore.BlobAccess, error) {
+//
+// By providing a PersistentStateStore and the PersistentState that was
+// read from it, the layout of blocks can be preserved across restarts.
+// This requires the use of a BlockAllocator that returns locations of
+// blocks and a DigestLocationMap that is stored persistently as well,
+// using the hash initialization stored in the PersistentState. In case
+// the PersistentState does not match the configuration of this
+// backend, all previously stored data is discarded.
+//
+// The contents of blocks may be validated by calling Scrub(). Blobs
+// that are corrupted are removed from the DigestLocationMap.
+func NewLocalBlobAccess(digestLocationMap DigestLocationMap, blockAllocator BlockAllocator, persistentStateStore PersistentStateStore, persistentState *pb.PersistentState, name string, sectorSizeBytes int, blockSectorCount int64, oldBlocksCount int, currentBlocksCount int, newBlocksCount int) (blobstore.ScrubbableBlobAccess, error) {
 	localBlobAccessPrometheusMetrics.Do(func() {
 		prometheus.MustRegister(localBlobAccessLastRemovedOldBlockInsertionTime)
 		prometheus.MustRegister(localBlobAccessOldBlobRotationToNew)
@@ -199,6 +231,8 @@ func NewLocalBlobAccess(digestLocationMap DigestLocationMap, blockAllocator Bloc
 		blockSectorCount: blockSectorCount,
 		blockAllocator:   blockAllocator,
 
+		persistentStateStore: persistentStateStore,
+
 		digestLocationMap: digestLocationMap,
 		locationValidator: LocationValidator{
 			OldestBlockID: 1,
@@ -211,33 +245,225 @@ func NewLocalBlobAccess(digestLocationMap DigestLocationMap, blockAllocator Bloc
 		oldBlobRotationToNewFindMissing:  localBlobAccessOldBlobRotationToNew.WithLabelValues(name, "FindMissing"),
 	}
 
-	// Insert placeholders for the initial set of "old" blocks.
 	now := unixTime()
 	ba.lastRemovedOldBlockInsertionTime.Set(now)
+	if persistentState != nil {
+		ba.digestLocationMapHashInitialization = persistentState.DigestLocationMapHashInitialization
+		if ba.restorePersistentState(persistentState, oldBlocksCount, currentBlocksCount, newBlocksCount) {
+			ba.startAllocatingFromBlock(0)
+			return ba, nil
+		}
+
+		// The persistent state could not be restored. Start
+		// with an empty set of blocks, but let block IDs
+		// continue where the previous state left off. This
+		// ensures that entries in the digest-location map that
+		// refer to previously used blocks are treated as
+		// invalid.
+		if oldestBlockID := int(persistentState.OldestBlockId) +
+			len(persistentState.OldBlocks) +
+			len(persistentState.CurrentBlocks) +
+			len(persistentState.NewBlocks); oldestBlockID > ba.locationValidator.OldestBlockID {
+			ba.locationValidator.OldestBlockID = oldestBlockID
+			ba.locationValidator.NewestBlockID = oldestBlockID + oldBlocksCount + currentBlocksCount + newBlocksCount - 1
+		}
+	}
+
+	// Insert placeholders for the initial set of "old" blocks.
 	for i := 0; i < oldBlocksCount; i++ {
 		ba.oldBlocks = append(ba.oldBlocks, oldBlock{
-			block:         newSharedBlock(deadBlock{}),
+			block:         newSharedBlock(deadBlock{}, nil),
 			insertionTime: now,
 		})
 	}
 
 	// Allocate initial set of "new" blocks.
 	for i := 0; i < currentBlocksCount+newBlocksCount; i++ {
-		block, err := blockAllocator.NewBlock()
+		block, location, err := blockAllocator.NewBlock()
 		if err != nil {
-			for _, newBlock := range ba.newBlocks {
-				newBlock.block.release()
-			}
+			ba.releaseAllBlocks()
 			return nil, err
 		}
 		ba.newBlocks = append(ba.newBlocks, newBlock{
-			block: newSharedBlock(block),
+			block: newSharedBlock(block, location),
 		})
 	}
 	ba.startAllocatingFromBlock(0)
+
+	// Store the initial layout of blocks, so that the block IDs
+	// chosen above are preserved.
+	if err := ba.writePersistentState(); err != nil {
+		ba.releaseAllBlocks()
+		return nil, err
+	}
 	return ba, nil
 }
 
+// restorePersistentState reacquires all of the blocks described by a
+// PersistentState. It returns false if the PersistentState does not
+// match the current configuration, or if not all blocks could be
+// reacquired.
+func (ba *localBlobAccess) restorePersistentState(persistentState *pb.PersistentState, oldBlocksCount int, currentBlocksCount int, newBlocksCount int) bool {
+	if persistentState.OldestBlockId < 1 ||
+		persistentState.SectorSizeBytes != int32(ba.sectorSizeBytes) ||
+		persistentState.BlockSectorCount != ba.blockSectorCount ||
+		len(persistentState.OldBlocks) != oldBlocksCount ||
+		len(persistentState.CurrentBlocks)+len(persistentState.NewBlocks) != currentBlocksCount+newBlocksCount ||
+		len(persistentState.NewBlocks) < newBlocksCount {
+		return false
+	}
+
+	for _, blockState := range persistentState.OldBlocks {
+		insertionTime, err := ptypes.Timestamp(blockState.InsertionTime)
+		if err != nil {
+			ba.releaseAllBlocks()
+			return false
+		}
+		block := newSharedBlock(deadBlock{}, nil)
+		if blockState.Location != nil {
+			b, ok := ba.blockAllocator.NewBlockAtLocation(blockState.Location)
+			if !ok {
+				ba.releaseAllBlocks()
+				return false
+			}
+			block = newSharedBlock(b, blockState.Location)
+		}
+		ba.oldBlocks = append(ba.oldBlocks, oldBlock{
+			block:         block,
+			insertionTime: insertionTime.Sub(time.Unix(0, 0)).Seconds(),
+		})
+	}
+	for _, blockState := range persistentState.CurrentBlocks {
+		b, ok := ba.reacquireBlock(blockState.Location)
+		if !ok {
+			ba.releaseAllBlocks()
+			return false
+		}
+		ba.currentBlocks = append(ba.currentBlocks, newSharedBlock(b, blockState.Location))
+	}
+	for _, blockState := range persistentState.NewBlocks {
+		b, ok := ba.reacquireBlock(blockState.Location)
+		if !ok {
+			ba.releaseAllBlocks()
+			return false
+		}
+		// The process may have crashed before all of the
+		// reserved space was used. Continue writing past the
+		// reserved space, as entries in the digest-location map
+		// may refer to any data within it.
+		offset := blockState.WriteOffsetSectors
+		if offset > ba.blockSectorCount {
+			offset = ba.blockSectorCount
+		}
+		ba.newBlocks = append(ba.newBlocks, newBlock{
+			block:          newSharedBlock(b, blockState.Location),
+			offset:         offset,
+			reservedOffset: offset,
+		})
+	}
+
+	oldestBlockID := int(persistentState.OldestBlockId)
+	ba.locationValidator = LocationValidator{
+		OldestBlockID: oldestBlockID,
+		NewestBlockID: oldestBlockID + oldBlocksCount + currentBlocksCount + newBlocksCount - 1,
+	}
+	return true
+}
+
+// reacquireBlock reacquires a "current" or "new" block that was in use
+// prior to a restart.
+func (ba *localBlobAccess) reacquireBlock(location *pb.BlockLocation) (Block, bool) {
+	if location == nil {
+		return nil, false
+	}
+	return ba.blockAllocator.NewBlockAtLocation(location)
+}
+
+// releaseAllBlocks releases all blocks that are part of the
+// localBlobAccess. It is used to clean up after initialization fails.
+func (ba *localBlobAccess) releaseAllBlocks() {
+	for _, oldBlock := range ba.oldBlocks {
+		oldBlock.block.release()
+	}
+	for _, currentBlock := range ba.currentBlocks {
+		currentBlock.release()
+	}
+	for _, newBlock := range ba.newBlocks {
+		newBlock.block.release()
+	}
+	ba.oldBlocks = nil
+	ba.currentBlocks = nil
+	ba.newBlocks = nil
+}
+
+// writePersistentState writes the current layout of blocks into the
+// PersistentStateStore, if one is configured.
+func (ba *localBlobAccess) writePersistentState() error {
+	if ba.persistentStateStore == nil {
+		return nil
+	}
+
+	persistentState := pb.PersistentState{
+		DigestLocationMapHashInitialization: ba.digestLocationMapHashInitialization,
+		OldestBlockId:                       int64(ba.locationValidator.OldestBlockID),
+		SectorSizeBytes:                     int32(ba.sectorSizeBytes),
+		BlockSectorCount:                    ba.blockSectorCount,
+	}
+	for _, oldBlock := range ba.oldBlocks {
+		insertionTime, err := ptypes.TimestampProto(time.Unix(0, int64(oldBlock.insertionTime*1e9)))
+		if err != nil {
+			return util.StatusWrapWithCode(err, codes.Internal, "Failed to convert block insertion time")
+		}
+		persistentState.OldBlocks = append(persistentState.OldBlocks, &pb.BlockState{
+			Location:      oldBlock.block.location,
+			InsertionTime: insertionTime,
+		})
+	}
+	for _, currentBlock := range ba.currentBlocks {
+		persistentState.CurrentBlocks = append(persistentState.CurrentBlocks, &pb.BlockState{
+			Location: currentBlock.location,
+		})
+	}
+	for _, newBlock := range ba.newBlocks {
+		persistentState.NewBlocks = append(persistentState.NewBlocks, &pb.BlockState{
+			Location:           newBlock.block.location,
+			WriteOffsetSectors: newBlock.reservedOffset,
+		})
+	}
+	if err := ba.persistentStateStore.WritePersistentState(&persistentState); err != nil {
+		return util.StatusWrap(err, "Failed to write persistent state")
+	}
+	ba.persistentStateDirty = false
+	return nil
+}
+
+// reserveSpace ensures that space in a "new" block is reserved in the
+// persistent state before it is handed out. This guarantees that after
+// a restart, no data is written to locations that may still be
+// referenced by the digest-location map.
+func (ba *localBlobAccess) reserveSpace(newBlock *newBlock, sectors int64) error {
+	if ba.persistentStateStore == nil {
+		return nil
+	}
+
+	oldReservedOffset := newBlock.reservedOffset
+	if newBlock.offset+sectors > oldReservedOffset {
+		reservedOffset := newBlock.offset + sectors + ba.blockSectorCount/writeReservationsPerBlock
+		if reservedOffset > ba.blockSectorCount {
+			reservedOffset = ba.blockSectorCount
+		}
+		newBlock.reservedOffset = reservedOffset
+		ba.persistentStateDirty = true
+	}
+	if ba.persistentStateDirty {
+		if err := ba.writePersistentState(); err != nil {
+			newBlock.reservedOffset = oldReservedOffset
+			return err
+		}
+	}
+	return nil
+}
+
 // getBlock returns the block associated with a numerical block ID.
 func (ba *localBlobAccess) getBlock(blockID int) (block *sharedBlock, isOld bool) {
 	blockID -= ba.locationValidator.OldestBlockID
@@ -285,7 +511,7 @@ func (ba *localBlobAccess) allocateSpace(sizeBytes int64) (*sharedBlock, Locatio
 			ba.newBlocks = append([]newBlock{}, ba.newBlocks[1:]...)
 		} else {
 			// The initialization phase is way behind us.
-			block, err := ba.blockAllocator.NewBlock()
+			block, location, err := ba.blockAllocator.NewBlock()
 			if err != nil {
 				return nil, Location{}, err
 			}
@@ -297,11 +523,12 @@ func (ba *localBlobAccess) allocateSpace(sizeBytes int64) (*sharedBlock, Locatio
 			})
 			ba.currentBlocks = append(append([]*sharedBlock{}, ba.currentBlocks[1:]...), ba.newBlocks[0].block)
 			ba.newBlocks = append(append([]newBlock{}, ba.newBlocks[1:]...), newBlock{
-				block: newSharedBlock(block),
+				block: newSharedBlock(block, location),
 			})
 			ba.locationValidator.OldestBlockID++
 			ba.locationValidator.NewestBlockID++
 		}
+		ba.persistentStateDirty = true
 		ba.startAllocatingFromBlock(0)
 	}
 
@@ -310,6 +537,9 @@ func (ba *localBlobAccess) allocateSpace(sizeBytes int64) (*sharedBlock, Locatio
 		if ba.allocationAttemptsRemaining > 0 {
 			newBlock := &ba.newBlocks[ba.allocationBlockIndex]
 			if offset := newBlock.offset; ba.blockSectorCount-offset >= sectors {
+				if err := ba.reserveSpace(newBlock, sectors); err != nil {
+					return nil, Location{}, err
+				}
 				ba.allocationAttemptsRemaining--
 				newBlock.offset += sectors
 				return newBlock.block, Location{
@@ -335,10 +565,14 @@ func (ba *localBlobAccess) Get(ctx context.Context, digest digest.Digest) buffer
 		return buffer.NewBufferFromError(err)
 	}
 
+	// TODO: Allow these buffers to be reparable. This isn't
+	// trivial. The repair function may run in the foreground. This
+	// could cause a deadlock against the locking performed by this
+	// function. Corrupted blobs can be removed by Scrub() instead.
 	readBlock, isOld := ba.getBlock(readLocation.BlockID)
 	if !isOld {
 		// Blob was found in a "new" or "current" block.
-		b := readBlock.b.Get(digest, readLocation.OffsetBytes, readLocation.SizeBytes)
+		b := readBlock.b.Get(digest, readLocation.OffsetBytes, readLocation.SizeBytes, buffer.Irreparable)
 		ba.lock.Unlock()
 		return b
 	}
@@ -355,7 +589,7 @@ func (ba *localBlobAccess) Get(ctx context.Context, digest digest.Digest) buffer
 		return buffer.NewBufferFromError(err)
 	}
 	writeBlock.acquire()
-	b := readBlock.b.Get(digest, readLocation.OffsetBytes, readLocation.SizeBytes)
+	b := readBlock.b.Get(digest, readLocation.OffsetBytes, readLocation.SizeBytes, buffer.Irreparable)
 	ba.lock.Unlock()
 
 	// Copy the object while it's been returned. Block until copying
@@ -378,6 +612,18 @@ func (ba *localBlobAccess) Get(ctx context.Context, digest digest.Digest) buffer
 	return b1
 }
 
+func (ba *localBlobAccess) GetReadOnly(ctx context.Context, digest digest.Digest) buffer.Buffer {
+	ba.lock.Lock()
+	defer ba.lock.Unlock()
+
+	readLocation, err := ba.digestLocationMap.Get(digest, &ba.locationValidator)
+	if err != nil {
+		return buffer.NewBufferFromError(err)
+	}
+	readBlock, _ := ba.getBlock(readLocation.BlockID)
+	return readBlock.b.Get(digest, readLocation.OffsetBytes, readLocation.SizeBytes, buffer.Irreparable)
+}
+
 func (ba *localBlobAccess) Put(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
 	sizeBytes, err := b.GetSizeBytes()
 	if err != nil {
@@ -462,7 +708,7 @@ func (ba *localBlobAccess) FindMissing(ctx context.Context, digests digest.Set)
 				if err != nil {
 					return digest.EmptySet, err
 				}
-				b := readBlock.b.Get(blobDigest, readLocation.OffsetBytes, readLocation.SizeBytes)
+				b := readBlock.b.Get(blobDigest, readLocation.OffsetBytes, readLocation.SizeBytes, buffer.Irreparable)
 
 				// Copy the data while unlocked, so that
 				// concurrent requests for non-old data
@@ -491,3 +737,43 @@ func (ba *localBlobAccess) FindMissing(ctx context.Context, digests digest.Set)
 	}
 	return missing.Build(), nil
 }
+
+func (ba *localBlobAccess) Scrub(ctx context.Context, scrubFunc blobstore.ScrubFunc) error {
+	ba.lock.Lock()
+	defer ba.lock.Unlock()
+
+	return ba.digestLocationMap.Walk(&ba.locationValidator, "", func(blobDigest digest.Digest, location Location, position string) error {
+		readBlock, _ := ba.getBlock(location.BlockID)
+		b := readBlock.b.Get(blobDigest, location.OffsetBytes, location.SizeBytes, buffer.Reparable(blobDigest, func() error {
+			ba.lock.Lock()
+			defer ba.lock.Unlock()
+			return ba.digestLocationMap.Remove(blobDigest, &ba.locationValidator, location)
+		}))
+
+		// Validate the blob while unlocked, so that concurrent
+		// requests continue to be serviced.
+		ba.lock.Unlock()
+		err := scrubFunc(blobDigest, b)
+		ba.lock.Lock()
+		return err
+	})
+}
+
+func (ba *localBlobAccess) Enumerate(ctx context.Context, cursor string, enumerateFunc blobstore.EnumerateFunc) error {
+	ba.lock.Lock()
+	defer ba.lock.Unlock()
+
+	// Positions in the digest-location map are used as cursors.
+	return ba.digestLocationMap.Walk(&ba.locationValidator, cursor, func(blobDigest digest.Digest, location Location, position string) error {
+		if err := util.StatusFromContext(ctx); err != nil {
+			return err
+		}
+
+		// Invoke the callback while unlocked, so that concurrent
+		// requests continue to be serviced.
+		ba.lock.Unlock()
+		err := enumerateFunc(blobDigest, position)
+		ba.lock.Lock()
+		return err
+	})
+}
@@ -4,11 +4,15 @@ import (
 	"context"
 	"testing"
 
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
 	"github.com/buildbarn/bb-storage/internal/mock"
 	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
 	"github.com/buildbarn/bb-storage/pkg/blobstore/local"
 	"github.com/buildbarn/bb-storage/pkg/digest"
+	pb "github.com/buildbarn/bb-storage/pkg/proto/blobstore/local"
 	"github.com/golang/mock/gomock"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/timestamp"
 	"github.com/stretchr/testify/require"
 )
 
@@ -23,15 +27,15 @@ func TestLocalBlobAccessAllocationPattern(t *testing.T) {
 	for i := 0; i < 8; i++ {
 		block := mock.NewMockBlock(ctrl)
 		blocks = append(blocks, block)
-		blockAllocator.EXPECT().NewBlock().Return(block, nil)
+		blockAllocator.EXPECT().NewBlock().Return(block, nil, nil)
 	}
-	blobAccess, err := local.NewLocalBlobAccess(digestLocationMap, blockAllocator, "cas", 1, 16, 2, 4, 4)
+	blobAccess, err := local.NewLocalBlobAccess(digestLocationMap, blockAllocator, nil, nil, "cas", 1, 16, 2, 4, 4)
 	require.NoError(t, err)
 
 	// After starting up, there should be a uniform distribution on
 	// the "current" blocks and an inverse exponential distribution
 	// on the "new" blocks.
-	digest := digest.MustNewDigest("example", "3e25960a79dbc69b674cd4ec67a72c62", 11)
+	digest := digest.MustNewDigest("example", remoteexecution.DigestFunction_MD5, "3e25960a79dbc69b674cd4ec67a72c62", 11)
 	allocationAttemptsPerBlock := []int{16, 16, 16, 16, 8, 4, 2, 1}
 	for i := 0; i < 10; i++ {
 		for j := 0; j < len(blocks); j++ {
@@ -48,4 +52,154 @@ func TestLocalBlobAccessAllocationPattern(t *testing.T) {
 	}
 }
 
+func TestLocalBlobAccessPersistentStateRestore(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+	defer ctrl.Finish()
+
+	digestLocationMap := mock.NewMockDigestLocationMap(ctrl)
+	blockAllocator := mock.NewMockBlockAllocator(ctrl)
+	persistentStateStore := mock.NewMockPersistentStateStore(ctrl)
+
+	// Blocks listed in the persistent state should be reacquired
+	// at the locations at which they were stored previously.
+	oldBlock := mock.NewMockBlock(ctrl)
+	blockAllocator.EXPECT().NewBlockAtLocation(&pb.BlockLocation{OffsetSectors: 0}).Return(oldBlock, true)
+	currentBlock := mock.NewMockBlock(ctrl)
+	blockAllocator.EXPECT().NewBlockAtLocation(&pb.BlockLocation{OffsetSectors: 16}).Return(currentBlock, true)
+	newBlock := mock.NewMockBlock(ctrl)
+	blockAllocator.EXPECT().NewBlockAtLocation(&pb.BlockLocation{OffsetSectors: 32}).Return(newBlock, true)
+	persistentState := &pb.PersistentState{
+		DigestLocationMapHashInitialization: 0x3b8cd7b5e2e1d17e,
+		OldestBlockId:                       5,
+		SectorSizeBytes:                     1,
+		BlockSectorCount:                    16,
+		OldBlocks: []*pb.BlockState{
+			{
+				Location:      &pb.BlockLocation{OffsetSectors: 0},
+				InsertionTime: &timestamp.Timestamp{Seconds: 1000},
+			},
+		},
+		CurrentBlocks: []*pb.BlockState{
+			{Location: &pb.BlockLocation{OffsetSectors: 16}},
+		},
+		NewBlocks: []*pb.BlockState{
+			{
+				Location:           &pb.BlockLocation{OffsetSectors: 32},
+				WriteOffsetSectors: 4,
+			},
+		},
+	}
+	blobAccess, err := local.NewLocalBlobAccess(digestLocationMap, blockAllocator, persistentStateStore, persistentState, "cas", 1, 16, 1, 1, 1)
+	require.NoError(t, err)
+
+	// The first write should be placed after the space that was
+	// reserved previously. As this exceeds the reservation, the
+	// persistent state needs to be updated before the blob is
+	// written.
+	digest1 := digest.MustNewDigest("example", remoteexecution.DigestFunction_MD5, "8b1a9953c4611296a827abf8c47804d7", 3)
+	persistentStateStore.EXPECT().WritePersistentState(gomock.Any()).DoAndReturn(
+		func(newPersistentState *pb.PersistentState) error {
+			expectedPersistentState := proto.Clone(persistentState).(*pb.PersistentState)
+			expectedPersistentState.NewBlocks[0].WriteOffsetSectors = 8
+			require.True(t, proto.Equal(expectedPersistentState, newPersistentState))
+			return nil
+		})
+	newBlock.EXPECT().Put(int64(4), gomock.Any()).Return(nil)
+	digestLocationMap.EXPECT().Put(digest1, gomock.Any(), local.Location{
+		BlockID:     7,
+		OffsetBytes: 4,
+		SizeBytes:   3,
+	})
+	require.NoError(t, blobAccess.Put(ctx, digest1, buffer.NewValidatedBufferFromByteSlice([]byte("Foo"))))
+
+	// The second write fits within the reserved space, meaning
+	// that the persistent state does not need to be updated.
+	digest2 := digest.MustNewDigest("example", remoteexecution.DigestFunction_MD5, "0cc175b9c0f1b6a831c399e269772661", 1)
+	newBlock.EXPECT().Put(int64(7), gomock.Any()).Return(nil)
+	digestLocationMap.EXPECT().Put(digest2, gomock.Any(), local.Location{
+		BlockID:     7,
+		OffsetBytes: 7,
+		SizeBytes:   1,
+	})
+	require.NoError(t, blobAccess.Put(ctx, digest2, buffer.NewValidatedBufferFromByteSlice([]byte("a"))))
+}
+
+func TestLocalBlobAccessPersistentStateMismatch(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	digestLocationMap := mock.NewMockDigestLocationMap(ctrl)
+	blockAllocator := mock.NewMockBlockAllocator(ctrl)
+	persistentStateStore := mock.NewMockPersistentStateStore(ctrl)
+
+	// The persistent state contains fewer blocks than configured.
+	// This means the persistent state cannot be restored. New
+	// blocks should be allocated. Block IDs should not overlap with
+	// the ones used previously, as the digest-location map may
+	// still contain entries referring to them.
+	blockAllocator.EXPECT().NewBlock().Return(mock.NewMockBlock(ctrl), &pb.BlockLocation{OffsetSectors: 16}, nil)
+	blockAllocator.EXPECT().NewBlock().Return(mock.NewMockBlock(ctrl), &pb.BlockLocation{OffsetSectors: 48}, nil)
+	blockAllocator.EXPECT().NewBlock().Return(mock.NewMockBlock(ctrl), &pb.BlockLocation{OffsetSectors: 80}, nil)
+	persistentStateStore.EXPECT().WritePersistentState(gomock.Any()).DoAndReturn(
+		func(newPersistentState *pb.PersistentState) error {
+			require.Equal(t, uint64(0x3b8cd7b5e2e1d17e), newPersistentState.DigestLocationMapHashInitialization)
+			require.Equal(t, int64(8), newPersistentState.OldestBlockId)
+			require.Len(t, newPersistentState.OldBlocks, 1)
+			require.Nil(t, newPersistentState.OldBlocks[0].Location)
+			require.Len(t, newPersistentState.CurrentBlocks, 0)
+			require.Len(t, newPersistentState.NewBlocks, 3)
+			require.True(t, proto.Equal(&pb.BlockLocation{OffsetSectors: 16}, newPersistentState.NewBlocks[0].Location))
+			require.True(t, proto.Equal(&pb.BlockLocation{OffsetSectors: 48}, newPersistentState.NewBlocks[1].Location))
+			require.True(t, proto.Equal(&pb.BlockLocation{OffsetSectors: 80}, newPersistentState.NewBlocks[2].Location))
+			require.Equal(t, int32(1), newPersistentState.SectorSizeBytes)
+			require.Equal(t, int64(16), newPersistentState.BlockSectorCount)
+			return nil
+		})
+	_, err := local.NewLocalBlobAccess(digestLocationMap, blockAllocator, persistentStateStore, &pb.PersistentState{
+		DigestLocationMapHashInitialization: 0x3b8cd7b5e2e1d17e,
+		OldestBlockId:                       5,
+		OldBlocks:                           []*pb.BlockState{{}},
+		NewBlocks: []*pb.BlockState{
+			{Location: &pb.BlockLocation{OffsetSectors: 32}},
+			{Location: &pb.BlockLocation{OffsetSectors: 64}},
+		},
+	}, "cas", 1, 16, 1, 2, 1)
+	require.NoError(t, err)
+}
+
+func TestLocalBlobAccessPersistentStateBlockSizeMismatch(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	digestLocationMap := mock.NewMockDigestLocationMap(ctrl)
+	blockAllocator := mock.NewMockBlockAllocator(ctrl)
+	persistentStateStore := mock.NewMockPersistentStateStore(ctrl)
+
+	// The persistent state has the right number of blocks, but was
+	// written with a different block size. As block locations are
+	// expressed in sectors, they cannot be reacquired.
+	blockAllocator.EXPECT().NewBlock().Return(mock.NewMockBlock(ctrl), &pb.BlockLocation{OffsetSectors: 0}, nil)
+	blockAllocator.EXPECT().NewBlock().Return(mock.NewMockBlock(ctrl), &pb.BlockLocation{OffsetSectors: 32}, nil)
+	persistentStateStore.EXPECT().WritePersistentState(gomock.Any()).DoAndReturn(
+		func(newPersistentState *pb.PersistentState) error {
+			require.Equal(t, int64(8), newPersistentState.OldestBlockId)
+			require.Equal(t, int64(32), newPersistentState.BlockSectorCount)
+			return nil
+		})
+	_, err := local.NewLocalBlobAccess(digestLocationMap, blockAllocator, persistentStateStore, &pb.PersistentState{
+		DigestLocationMapHashInitialization: 0x3b8cd7b5e2e1d17e,
+		OldestBlockId:                       5,
+		SectorSizeBytes:                     1,
+		BlockSectorCount:                    16,
+		OldBlocks:                           []*pb.BlockState{{}},
+		CurrentBlocks: []*pb.BlockState{
+			{Location: &pb.BlockLocation{OffsetSectors: 16}},
+		},
+		NewBlocks: []*pb.BlockState{
+			{Location: &pb.BlockLocation{OffsetSectors: 32}},
+		},
+	}, "cas", 1, 32, 1, 1, 1)
+	require.NoError(t, err)
+}
+
 // TODO: Make unit testing coverage more complete.
@@ -3,6 +3,7 @@ package local
 import (
 	"crypto/sha256"
 
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
 	"github.com/buildbarn/bb-storage/pkg/digest"
 )
 
@@ -14,20 +15,45 @@ import (
 // LocationRecords may be stored at alternative, less preferred indices.
 // The Attempt field contains the probing distance at which the record
 // is stored.
+//
+// The DigestFunction field is only set for digest functions that cannot
+// be inferred from the length of the hash (e.g., BLAKE3) and for digest
+// functions whose hashes are truncated (SHA-384 and SHA-512), so that
+// Walk() can skip objects whose digests cannot be recovered. It is stored
+// in the upper eight bits of the attempt, so that records of other
+// digest functions remain compatible with older versions.
 type LocationRecordKey struct {
-	Digest  [sha256.Size]byte
-	Attempt uint32
+	Digest         [sha256.Size]byte
+	DigestFunction remoteexecution.DigestFunction_Value
+	Attempt        uint32
 }
 
 // NewLocationRecordKey creates a LocationRecordKey that corresponds to
 // a given blob digest. It is assumed this key is used to access this
 // record at its preferred index, hence Attempt is zero.
 func NewLocationRecordKey(digest digest.Digest) LocationRecordKey {
-	k := LocationRecordKey{}
+	k := LocationRecordKey{
+		DigestFunction: digest.GetPaddedHashDigestFunction(),
+	}
 	copy(k.Digest[:], digest.GetHashBytes())
 	return k
 }
 
+// getAttemptAndDigestFunction returns the attempt and digest function
+// of the key, packed into a single integer. This is the way in which
+// both fields are stored on disk.
+func (k *LocationRecordKey) getAttemptAndDigestFunction() uint32 {
+	return k.Attempt | uint32(k.DigestFunction)<<24
+}
+
+// setAttemptAndDigestFunction sets the attempt and digest function of
+// the key to values obtained from an integer created by
+// getAttemptAndDigestFunction().
+func (k *LocationRecordKey) setAttemptAndDigestFunction(v uint32) {
+	k.Attempt = v & 0xffffff
+	k.DigestFunction = remoteexecution.DigestFunction_Value(v >> 24)
+}
+
 // Hash a LocationRecordKey using FNV-1a. Instead of using the
 // well-known offset basis of 14695981039346656037, a custom
 // initialization may be provided. This permits mirrored instances to
@@ -46,7 +72,7 @@ func (k *LocationRecordKey) Hash(hashInitialization uint64) uint64 {
 		h ^= uint64(c)
 		h *= 1099511628211
 	}
-	attempt := k.Attempt
+	attempt := k.getAttemptAndDigestFunction()
 	for i := 0; i < 4; i++ {
 		h ^= uint64(attempt & 0xff)
 		h *= 1099511628211
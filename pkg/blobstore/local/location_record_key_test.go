@@ -3,6 +3,7 @@ package local_test
 import (
 	"testing"
 
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
 	"github.com/buildbarn/bb-storage/pkg/blobstore/local"
 	"github.com/buildbarn/bb-storage/pkg/digest"
 	"github.com/stretchr/testify/require"
@@ -12,6 +13,7 @@ func TestLocationRecordKey(t *testing.T) {
 	key := local.NewLocationRecordKey(
 		digest.MustNewDigest(
 			"ignored",
+			remoteexecution.DigestFunction_SHA256,
 			"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
 			123))
 	key.Attempt = 0x11223344
@@ -9,6 +9,7 @@ import (
 	"github.com/buildbarn/bb-storage/pkg/blobstore"
 	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
 	"github.com/buildbarn/bb-storage/pkg/digest"
+	pb "github.com/buildbarn/bb-storage/pkg/proto/blobstore/local"
 	"github.com/prometheus/client_golang/prometheus"
 
 	"google.golang.org/grpc/codes"
@@ -77,6 +78,10 @@ type partitioningBlockAllocator struct {
 // This implementation also ensures that writes against underlying
 // storage are all performed at sector boundaries and sizes. This
 // ensures that no unnecessary reads are performed.
+//
+// Blocks are identified by their offset on underlying storage. This
+// allows LocalBlobAccess to reacquire blocks that were in use prior to
+// a restart through NewBlockAtLocation().
 func NewPartitioningBlockAllocator(f ReadWriterAt, storageType blobstore.StorageType, sectorSizeBytes int, blockSectorCount int64, blockCount int) BlockAllocator {
 	partitioningBlockAllocatorPrometheusMetrics.Do(func() {
 		prometheus.MustRegister(partitioningBlockAllocatorAllocations)
@@ -97,21 +102,40 @@ func NewPartitioningBlockAllocator(f ReadWriterAt, storageType blobstore.Storage
 	return pa
 }
 
-func (pa *partitioningBlockAllocator) NewBlock() (Block, error) {
+func (pa *partitioningBlockAllocator) newBlockAtIndex(i int) (Block, *pb.BlockLocation) {
+	offset := pa.freeOffsets[i]
+	pa.freeOffsets = append(pa.freeOffsets[:i], pa.freeOffsets[i+1:]...)
+	partitioningBlockAllocatorAllocations.Inc()
+	return &partitioningBlock{
+		blockAllocator: pa,
+		offset:         offset,
+		usecount:       1,
+	}, &pb.BlockLocation{OffsetSectors: offset}
+}
+
+func (pa *partitioningBlockAllocator) NewBlock() (Block, *pb.BlockLocation, error) {
 	pa.lock.Lock()
 	defer pa.lock.Unlock()
 
 	if len(pa.freeOffsets) == 0 {
-		return nil, status.Error(codes.ResourceExhausted, "No unused blocks available")
+		return nil, nil, status.Error(codes.ResourceExhausted, "No unused blocks available")
 	}
-	block := &partitioningBlock{
-		blockAllocator: pa,
-		offset:         pa.freeOffsets[0],
-		usecount:       1,
+	block, location := pa.newBlockAtIndex(0)
+	return block, location, nil
+}
+
+func (pa *partitioningBlockAllocator) NewBlockAtLocation(location *pb.BlockLocation) (Block, bool) {
+	pa.lock.Lock()
+	defer pa.lock.Unlock()
+
+	// Only permit reacquiring blocks that are not in use.
+	for i, offset := range pa.freeOffsets {
+		if offset == location.OffsetSectors {
+			block, _ := pa.newBlockAtIndex(i)
+			return block, true
+		}
 	}
-	pa.freeOffsets = pa.freeOffsets[1:]
-	partitioningBlockAllocatorAllocations.Inc()
-	return block, nil
+	return nil, false
 }
 
 type partitioningBlock struct {
@@ -134,15 +158,11 @@ func (pb *partitioningBlock) Release() {
 	}
 }
 
-func (pb *partitioningBlock) Get(digest digest.Digest, offsetBytes int64, sizeBytes int64) buffer.Buffer {
+func (pb *partitioningBlock) Get(digest digest.Digest, offsetBytes int64, sizeBytes int64, repairStrategy buffer.RepairStrategy) buffer.Buffer {
 	if c := atomic.AddInt64(&pb.usecount, 1); c <= 1 {
 		panic(fmt.Sprintf("Get(): Block has invalid reference count %d", c))
 	}
 	partitioningBlockAllocatorGetsStarted.Inc()
-	// TODO: Allow these buffers to be reparable. This isn't
-	// trivial. The repair function may run in the foreground. This
-	// could cause a deadlock against the locking in LocalBlobAccess
-	// itself.
 	return pb.blockAllocator.storageType.NewBufferFromReader(
 		digest,
 		&partitioningBlockReader{
@@ -152,7 +172,8 @@ func (pb *partitioningBlock) Get(digest digest.Digest, offsetBytes int64, sizeBy
 				sizeBytes),
 			block: pb,
 		},
-		buffer.Irreparable)
+		sizeBytes,
+		repairStrategy)
 }
 
 func (pb *partitioningBlock) Put(offsetBytes int64, b buffer.Buffer) error {
@@ -3,11 +3,13 @@ package local_test
 import (
 	"testing"
 
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
 	"github.com/buildbarn/bb-storage/internal/mock"
 	"github.com/buildbarn/bb-storage/pkg/blobstore"
 	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
 	"github.com/buildbarn/bb-storage/pkg/blobstore/local"
 	"github.com/buildbarn/bb-storage/pkg/digest"
+	pb "github.com/buildbarn/bb-storage/pkg/proto/blobstore/local"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/require"
 
@@ -26,13 +28,14 @@ func TestPartitioningBlockAllocator(t *testing.T) {
 	// create ten blocks.
 	var blocks []local.Block
 	for i := 0; i < 10; i++ {
-		block, err := pa.NewBlock()
+		block, location, err := pa.NewBlock()
 		require.NoError(t, err)
+		require.Equal(t, &pb.BlockLocation{OffsetSectors: int64(i) * 100}, location)
 		blocks = append(blocks, block)
 	}
 
 	// Creating an eleventh block should fail.
-	_, err := pa.NewBlock()
+	_, _, err := pa.NewBlock()
 	require.Equal(t, err, status.Error(codes.ResourceExhausted, "No unused blocks available"))
 
 	// Blocks should initially be handed out in order of the offset.
@@ -45,11 +48,12 @@ func TestPartitioningBlockAllocator(t *testing.T) {
 	// possible to reallocate the block as long as the blob hasn't
 	// been consumed.
 	b := blocks[7].Get(
-		digest.MustNewDigest("some-instance", "8b1a9953c4611296a827abf8c47804d7", 5),
+		digest.MustNewDigest("some-instance", remoteexecution.DigestFunction_MD5, "8b1a9953c4611296a827abf8c47804d7", 5),
 		25,
-		5)
+		5,
+		buffer.Irreparable)
 	blocks[7].Release()
-	_, err = pa.NewBlock()
+	_, _, err = pa.NewBlock()
 	require.Equal(t, err, status.Error(codes.ResourceExhausted, "No unused blocks available"))
 
 	// The blob may still be consumed with the block being released.
@@ -66,7 +70,7 @@ func TestPartitioningBlockAllocator(t *testing.T) {
 	// With the blob being consumed, the underlying block should be
 	// released. This means the block can be allocated once again.
 	// It should still start at offset 700.
-	blocks[7], err = pa.NewBlock()
+	blocks[7], _, err = pa.NewBlock()
 	require.NoError(t, err)
 	f.EXPECT().WriteAt([]byte("Hello"), int64(741)).Return(5, nil)
 	require.NoError(t, blocks[7].Put(41, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
@@ -79,10 +83,29 @@ func TestPartitioningBlockAllocator(t *testing.T) {
 		blocks[i].Release()
 	}
 	for _, i := range order {
-		blocks[i], err = pa.NewBlock()
+		blocks[i], _, err = pa.NewBlock()
 		require.NoError(t, err)
 
 		f.EXPECT().WriteAt([]byte("Hello"), int64(100*i+83)).Return(5, nil)
 		require.NoError(t, blocks[i].Put(83, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
 	}
+
+	// Blocks that are in use cannot be reacquired.
+	_, ok := pa.NewBlockAtLocation(&pb.BlockLocation{OffsetSectors: 500})
+	require.False(t, ok)
+
+	// Blocks that have been released can be reacquired by location,
+	// which is used by LocalBlobAccess to reload blocks after a
+	// restart. This should not affect the order in which the other
+	// blocks are handed out.
+	blocks[5].Release()
+	blocks[6].Release()
+	blocks[5], ok = pa.NewBlockAtLocation(&pb.BlockLocation{OffsetSectors: 500})
+	require.True(t, ok)
+	f.EXPECT().WriteAt([]byte("Hello"), int64(512)).Return(5, nil)
+	require.NoError(t, blocks[5].Put(12, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+
+	_, location, err := pa.NewBlock()
+	require.NoError(t, err)
+	require.Equal(t, &pb.BlockLocation{OffsetSectors: 600}, location)
 }
@@ -1,7 +1,11 @@
 package local
 
 import (
+	"sort"
+	"strings"
+
 	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
 
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -43,3 +47,51 @@ func (dlm perInstanceDigestLocationMap) Put(digest digest.Digest, validator *Loc
 	}
 	return m.Put(digest, validator, location)
 }
+
+func (dlm perInstanceDigestLocationMap) Remove(digest digest.Digest, validator *LocationValidator, location Location) error {
+	m, err := dlm.getMap(digest)
+	if err != nil {
+		return err
+	}
+	return m.Remove(digest, validator, location)
+}
+
+func (dlm perInstanceDigestLocationMap) Walk(validator *LocationValidator, position string, walkFunc DigestLocationMapWalkFunc) error {
+	instanceNames := make([]string, 0, len(dlm.maps))
+	for instanceName := range dlm.maps {
+		instanceNames = append(instanceNames, instanceName)
+	}
+	sort.Strings(instanceNames)
+
+	// Positions are prefixed with the instance name, so that
+	// walking can be resumed at the right map.
+	startInstanceName, mapPosition := "", ""
+	if position != "" {
+		i := strings.LastIndexByte(position, '/')
+		if i < 0 {
+			return status.Errorf(codes.InvalidArgument, "Invalid position %#v", position)
+		}
+		startInstanceName, mapPosition = position[:i], position[i+1:]
+	}
+
+	// Entries stored in the underlying maps don't contain an
+	// instance name. Add it to the digests.
+	for _, instanceName := range instanceNames {
+		if instanceName < startInstanceName {
+			continue
+		}
+		if instanceName != startInstanceName {
+			mapPosition = ""
+		}
+		if err := dlm.maps[instanceName].Walk(validator, mapPosition, func(blobDigest digest.Digest, location Location, position string) error {
+			instanceDigest, err := digest.NewDigest(instanceName, blobDigest.GetDigestFunction(), blobDigest.GetHashString(), blobDigest.GetSizeBytes())
+			if err != nil {
+				return err
+			}
+			return walkFunc(instanceDigest, location, instanceName+"/"+position)
+		}); err != nil {
+			return util.StatusWrapf(err, "Instance %#v", instanceName)
+		}
+	}
+	return nil
+}
@@ -3,6 +3,7 @@ package local_test
 import (
 	"testing"
 
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
 	"github.com/buildbarn/bb-storage/internal/mock"
 	"github.com/buildbarn/bb-storage/pkg/blobstore/local"
 	"github.com/buildbarn/bb-storage/pkg/digest"
@@ -23,8 +24,8 @@ func TestPerInstanceDigestLocationMap(t *testing.T) {
 			"valid": validDLM,
 		})
 
-	validDigest := digest.MustNewDigest("valid", "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", 123)
-	invalidDigest := digest.MustNewDigest("invalid", "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", 123)
+	validDigest := digest.MustNewDigest("valid", remoteexecution.DigestFunction_SHA256, "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", 123)
+	invalidDigest := digest.MustNewDigest("invalid", remoteexecution.DigestFunction_SHA256, "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", 123)
 	validator := local.LocationValidator{
 		OldestBlockID: 12,
 		NewestBlockID: 15,
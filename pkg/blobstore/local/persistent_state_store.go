@@ -0,0 +1,19 @@
+package local
+
+import (
+	pb "github.com/buildbarn/bb-storage/pkg/proto/blobstore/local"
+)
+
+// PersistentStateStore is used by LocalBlobAccess to store the layout
+// of its blocks persistently. This allows LocalBlobAccess to reload all
+// data after restarts.
+type PersistentStateStore interface {
+	// ReadPersistentState reads the persistent state that was
+	// written previously. This function returns NotFound in case no
+	// persistent state has been written yet.
+	ReadPersistentState() (*pb.PersistentState, error)
+	// WritePersistentState replaces the persistent state. It should
+	// be implemented in such a way that a crash during a write does
+	// not cause the previously written state to get corrupted.
+	WritePersistentState(persistentState *pb.PersistentState) error
+}
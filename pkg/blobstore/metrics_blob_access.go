@@ -60,7 +60,10 @@ type metricsBlobAccess struct {
 }
 
 // NewMetricsBlobAccess creates an adapter for BlobAccess that adds
-// basic instrumentation in the form of Prometheus metrics.
+// basic instrumentation in the form of Prometheus metrics. Operation
+// durations are partitioned by gRPC status code. The number of
+// operations per status code can be obtained from the _count series
+// of the duration histogram.
 func NewMetricsBlobAccess(blobAccess BlobAccess, clock clock.Clock, name string) BlobAccess {
 	blobAccessOperationsPrometheusMetrics.Do(func() {
 		prometheus.MustRegister(blobAccessOperationsBlobSizeBytes)
@@ -0,0 +1,62 @@
+package blobstore
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// MigrationResults contains statistics on the objects that were
+// processed by Migrate().
+type MigrationResults struct {
+	CopiedObjects   int64
+	ExistingObjects int64
+	InvalidObjects  int64
+	SizeBytes       int64
+}
+
+// Migrate copies all objects stored in a ScrubbableBlobAccess into
+// another BlobAccess. This can be used to switch storage backends
+// (e.g., from circular to local storage) without losing cached data.
+//
+// The source is never altered. Objects are enumerated and read without
+// being refreshed, and objects that turn out to be corrupted are
+// skipped instead of being repaired. Objects that are already present
+// in the destination are skipped, meaning that an interrupted
+// migration may simply be restarted.
+func Migrate(ctx context.Context, source ScrubbableBlobAccess, destination BlobAccess) (MigrationResults, error) {
+	var results MigrationResults
+	err := source.Enumerate(ctx, "", func(blobDigest digest.Digest, cursor string) error {
+		missing, err := destination.FindMissing(ctx, digest.NewSetBuilder().Add(blobDigest).Build())
+		if err != nil {
+			return util.StatusWrapf(err, "Failed to check for existence of object %s in destination", blobDigest)
+		}
+		if missing.Empty() {
+			results.ExistingObjects++
+			return nil
+		}
+
+		if err := destination.Put(ctx, blobDigest, source.GetReadOnly(ctx, blobDigest)); err != nil {
+			// Determine whether the failure is caused by the
+			// source or the destination by reading the object
+			// from the source once more.
+			if sourceErr := source.GetReadOnly(ctx, blobDigest).IntoWriter(ioutil.Discard); sourceErr == nil {
+				return util.StatusWrapf(err, "Failed to copy object %s", blobDigest)
+			} else if status.Code(sourceErr) != codes.NotFound {
+				log.Printf("Object %s is invalid: %s", blobDigest, sourceErr)
+				results.InvalidObjects++
+			}
+			return nil
+		}
+		results.CopiedObjects++
+		results.SizeBytes += blobDigest.GetSizeBytes()
+		return nil
+	})
+	return results, err
+}
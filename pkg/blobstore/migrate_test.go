@@ -0,0 +1,150 @@
+package blobstore_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"strconv"
+	"testing"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestMigrate(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+	defer ctrl.Finish()
+
+	validDigest := digest.MustNewDigest("hello", remoteexecution.DigestFunction_MD5, "8b1a9953c4611296a827abf8c47804d7", 5)
+	existingDigest := digest.MustNewDigest("hello", remoteexecution.DigestFunction_MD5, "5d41402abc4b2a76b9719d911017c592", 5)
+	invalidDigest := digest.MustNewDigest("hello", remoteexecution.DigestFunction_MD5, "3e25960a79dbc69b674cd4ec67a72c62", 5)
+	removedDigest := digest.MustNewDigest("hello", remoteexecution.DigestFunction_MD5, "6f5902ac237024bdd0c176cb93063dc4", 11)
+	validDigests := digest.NewSetBuilder().Add(validDigest).Build()
+	existingDigests := digest.NewSetBuilder().Add(existingDigest).Build()
+	invalidDigests := digest.NewSetBuilder().Add(invalidDigest).Build()
+	removedDigests := digest.NewSetBuilder().Add(removedDigest).Build()
+
+	// Buffers returned by the source should never be repaired, as
+	// the source may not be altered.
+	newSourceBuffer := func(blobDigest digest.Digest, data string) buffer.Buffer {
+		return buffer.NewCASBufferFromReader(blobDigest, ioutil.NopCloser(bytes.NewBufferString(data)), buffer.Irreparable)
+	}
+
+	t.Run("Success", func(t *testing.T) {
+		// The first object should be copied, and the second
+		// object is already present in the destination. The
+		// third object is corrupted, while the fourth object was
+		// removed from the source during the migration. These
+		// should be skipped.
+		source := mock.NewMockScrubbableBlobAccess(ctrl)
+		destination := mock.NewMockBlobAccess(ctrl)
+		source.EXPECT().Enumerate(ctx, "", gomock.Any()).DoAndReturn(
+			func(ctx context.Context, cursor string, enumerateFunc blobstore.EnumerateFunc) error {
+				for i, blobDigest := range []digest.Digest{validDigest, existingDigest, invalidDigest, removedDigest} {
+					if err := enumerateFunc(blobDigest, strconv.Itoa(i+1)); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+
+		destination.EXPECT().FindMissing(ctx, validDigests).Return(validDigests, nil)
+		source.EXPECT().GetReadOnly(ctx, validDigest).Return(newSourceBuffer(validDigest, "Hello"))
+		destination.EXPECT().Put(ctx, validDigest, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+				data, err := b.ToByteSlice(10)
+				require.NoError(t, err)
+				require.Equal(t, []byte("Hello"), data)
+				return nil
+			})
+
+		destination.EXPECT().FindMissing(ctx, existingDigests).Return(digest.EmptySet, nil)
+
+		destination.EXPECT().FindMissing(ctx, invalidDigests).Return(invalidDigests, nil)
+		source.EXPECT().GetReadOnly(ctx, invalidDigest).
+			DoAndReturn(func(ctx context.Context, blobDigest digest.Digest) buffer.Buffer {
+				return newSourceBuffer(invalidDigest, "Hello")
+			}).
+			Times(2)
+		destination.EXPECT().Put(ctx, invalidDigest, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+				_, err := b.ToByteSlice(10)
+				return err
+			})
+
+		destination.EXPECT().FindMissing(ctx, removedDigests).Return(removedDigests, nil)
+		source.EXPECT().GetReadOnly(ctx, removedDigest).
+			Return(buffer.NewBufferFromError(status.Error(codes.NotFound, "Blob not found"))).
+			Times(2)
+		destination.EXPECT().Put(ctx, removedDigest, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+				_, err := b.ToByteSlice(20)
+				return err
+			})
+
+		results, err := blobstore.Migrate(ctx, source, destination)
+		require.NoError(t, err)
+		require.Equal(t, blobstore.MigrationResults{
+			CopiedObjects:   1,
+			ExistingObjects: 1,
+			InvalidObjects:  1,
+			SizeBytes:       5,
+		}, results)
+	})
+
+	t.Run("DestinationFailure", func(t *testing.T) {
+		// Failures writing into the destination should cause the
+		// migration to be aborted, as the object in the source
+		// is still intact.
+		source := mock.NewMockScrubbableBlobAccess(ctrl)
+		destination := mock.NewMockBlobAccess(ctrl)
+		source.EXPECT().Enumerate(ctx, "", gomock.Any()).DoAndReturn(
+			func(ctx context.Context, cursor string, enumerateFunc blobstore.EnumerateFunc) error {
+				return enumerateFunc(validDigest, "1")
+			})
+		destination.EXPECT().FindMissing(ctx, validDigests).Return(validDigests, nil)
+		source.EXPECT().GetReadOnly(ctx, validDigest).
+			DoAndReturn(func(ctx context.Context, blobDigest digest.Digest) buffer.Buffer {
+				return newSourceBuffer(validDigest, "Hello")
+			}).
+			Times(2)
+		destination.EXPECT().Put(ctx, validDigest, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+				b.Discard()
+				return status.Error(codes.ResourceExhausted, "Out of disk space")
+			})
+
+		_, err := blobstore.Migrate(ctx, source, destination)
+		require.Equal(t, status.Error(codes.ResourceExhausted, "Failed to copy object 8b1a9953c4611296a827abf8c47804d7-5-hello: Out of disk space"), err)
+	})
+
+	t.Run("DestinationFindMissingFailure", func(t *testing.T) {
+		source := mock.NewMockScrubbableBlobAccess(ctrl)
+		destination := mock.NewMockBlobAccess(ctrl)
+		source.EXPECT().Enumerate(ctx, "", gomock.Any()).DoAndReturn(
+			func(ctx context.Context, cursor string, enumerateFunc blobstore.EnumerateFunc) error {
+				return enumerateFunc(validDigest, "1")
+			})
+		destination.EXPECT().FindMissing(ctx, validDigests).Return(digest.EmptySet, status.Error(codes.Unavailable, "Server offline"))
+
+		_, err := blobstore.Migrate(ctx, source, destination)
+		require.Equal(t, status.Error(codes.Unavailable, "Failed to check for existence of object 8b1a9953c4611296a827abf8c47804d7-5-hello in destination: Server offline"), err)
+	})
+
+	t.Run("EnumerationFailure", func(t *testing.T) {
+		source := mock.NewMockScrubbableBlobAccess(ctrl)
+		destination := mock.NewMockBlobAccess(ctrl)
+		source.EXPECT().Enumerate(ctx, "", gomock.Any()).Return(status.Error(codes.Internal, "I/O error"))
+
+		_, err := blobstore.Migrate(ctx, source, destination)
+		require.Equal(t, status.Error(codes.Internal, "I/O error"), err)
+	})
+}
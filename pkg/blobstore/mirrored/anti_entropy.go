@@ -0,0 +1,239 @@
+package mirrored
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/clock"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	antiEntropyPrometheusMetrics sync.Once
+
+	antiEntropyObjects = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "mirrored_anti_entropy_objects_total",
+			Help:      "Number of objects observed by anti-entropy passes, partitioned by the backends in which they were present.",
+		},
+		[]string{"name", "location"})
+	antiEntropyReplications = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "mirrored_anti_entropy_replications_total",
+			Help:      "Number of objects replicated by anti-entropy passes.",
+		},
+		[]string{"name", "direction", "result"})
+	antiEntropyPassesCompleted = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "mirrored_anti_entropy_passes_completed_total",
+			Help:      "Number of times anti-entropy completed a pass over all objects stored in a pair of backends.",
+		},
+		[]string{"name"})
+)
+
+// AntiEntropyResults contains statistics on the objects that were
+// processed by a single pass of AntiEntropy.
+type AntiEntropyResults struct {
+	ObjectsOnlyInA     int64
+	ObjectsOnlyInB     int64
+	ObjectsInBoth      int64
+	ReplicationsFailed int64
+}
+
+// AntiEntropy repairs inconsistencies between a pair of mirrored
+// backends. Whereas MirroredBlobAccess only repairs inconsistencies
+// for objects that are accessed by clients, AntiEntropy periodically
+// enumerates the full contents of both backends and replicates objects
+// that are only present in one of the backends.
+//
+// Objects are enumerated in batches, whose existence is checked in
+// the other backend by calling FindMissing(). As only a single batch is
+// held in memory at a time, the amount of memory used does not depend
+// on the number of objects stored. The contents of both backends are
+// deliberately not compared using digest.GetDifferenceAndIntersection(),
+// as that would require enumerating both backends into memory in their
+// entirety. The downside of this approach is that objects present in
+// both backends are checked twice if replication in both directions is
+// enabled, once while enumerating each of the backends.
+type AntiEntropy struct {
+	backendA       blobstore.EnumerableBlobAccess
+	backendB       blobstore.EnumerableBlobAccess
+	replicatorAToB BlobReplicator
+	replicatorBToA BlobReplicator
+	clock          clock.Clock
+	name           string
+	batchSize      int
+
+	objectsOnlyInA            prometheus.Counter
+	objectsOnlyInB            prometheus.Counter
+	objectsInBoth             prometheus.Counter
+	replicationsAToBSucceeded prometheus.Counter
+	replicationsAToBFailed    prometheus.Counter
+	replicationsBToASucceeded prometheus.Counter
+	replicationsBToAFailed    prometheus.Counter
+	passesCompleted           prometheus.Counter
+}
+
+// NewAntiEntropy creates an AntiEntropy for a pair of backends.
+// Objects are processed in batches of at most batchSize objects. The
+// replicator from backend B to backend A may be nil, in which case the
+// contents of backend B are not enumerated, and objects only present
+// in backend B are left alone. This may be used if the opposite
+// direction is handled by a separate process.
+func NewAntiEntropy(backendA blobstore.EnumerableBlobAccess, backendB blobstore.EnumerableBlobAccess, replicatorAToB BlobReplicator, replicatorBToA BlobReplicator, clock clock.Clock, name string, batchSize int) *AntiEntropy {
+	antiEntropyPrometheusMetrics.Do(func() {
+		prometheus.MustRegister(antiEntropyObjects)
+		prometheus.MustRegister(antiEntropyReplications)
+		prometheus.MustRegister(antiEntropyPassesCompleted)
+	})
+
+	return &AntiEntropy{
+		backendA:       backendA,
+		backendB:       backendB,
+		replicatorAToB: replicatorAToB,
+		replicatorBToA: replicatorBToA,
+		clock:          clock,
+		name:           name,
+		batchSize:      batchSize,
+
+		objectsOnlyInA:            antiEntropyObjects.WithLabelValues(name, "A"),
+		objectsOnlyInB:            antiEntropyObjects.WithLabelValues(name, "B"),
+		objectsInBoth:             antiEntropyObjects.WithLabelValues(name, "Both"),
+		replicationsAToBSucceeded: antiEntropyReplications.WithLabelValues(name, "AToB", "Succeeded"),
+		replicationsAToBFailed:    antiEntropyReplications.WithLabelValues(name, "AToB", "Failed"),
+		replicationsBToASucceeded: antiEntropyReplications.WithLabelValues(name, "BToA", "Succeeded"),
+		replicationsBToAFailed:    antiEntropyReplications.WithLabelValues(name, "BToA", "Failed"),
+		passesCompleted:           antiEntropyPassesCompleted.WithLabelValues(name),
+	}
+}
+
+// antiEntropyPassResults contains statistics on the objects that were
+// processed while enumerating the contents of a single backend.
+type antiEntropyPassResults struct {
+	objectsOnlyInSource int64
+	objectsInBoth       int64
+	replicationsFailed  int64
+}
+
+// replicateMissing enumerates all objects stored in a source backend
+// in batches, and replicates the objects in every batch that are
+// absent in the target backend. Failures to replicate a batch are
+// logged, but don't cause the remaining batches to be skipped.
+func (ae *AntiEntropy) replicateMissing(ctx context.Context, source blobstore.EnumerableBlobAccess, sourceName string, target blobstore.EnumerableBlobAccess, targetName string, replicator BlobReplicator, succeeded prometheus.Counter, failed prometheus.Counter) (antiEntropyPassResults, error) {
+	var results antiEntropyPassResults
+	processBatch := func(digests digest.Set) error {
+		if err := util.StatusFromContext(ctx); err != nil {
+			return err
+		}
+		missing, err := target.FindMissing(ctx, digests)
+		if err != nil {
+			return util.StatusWrapf(err, "Failed to find missing objects in %s", targetName)
+		}
+		missingCount := missing.Length()
+		results.objectsOnlyInSource += int64(missingCount)
+		results.objectsInBoth += int64(digests.Length() - missingCount)
+		if missingCount == 0 {
+			return nil
+		}
+
+		if err := replicator.ReplicateMultiple(ctx, missing); err == nil {
+			succeeded.Add(float64(missingCount))
+		} else {
+			log.Printf("Anti-entropy %#v: Failed to replicate %d objects from %s to %s: %s", ae.name, missingCount, sourceName, targetName, err)
+			results.replicationsFailed += int64(missingCount)
+			failed.Add(float64(missingCount))
+		}
+		return nil
+	}
+
+	batch := digest.NewSetBuilder()
+	var batchErr error
+	if err := source.Enumerate(ctx, "", func(blobDigest digest.Digest, cursor string) error {
+		batch.Add(blobDigest)
+		if batch.Length() < ae.batchSize {
+			return nil
+		}
+		digests := batch.Build()
+		batch = digest.NewSetBuilder()
+		batchErr = processBatch(digests)
+		return batchErr
+	}); err != nil {
+		if batchErr != nil {
+			return results, batchErr
+		}
+		return results, util.StatusWrapf(err, "Failed to enumerate %s", sourceName)
+	}
+	if batch.Length() > 0 {
+		if err := processBatch(batch.Build()); err != nil {
+			return results, err
+		}
+	}
+	return results, nil
+}
+
+// RunOnce performs a single pass over all objects stored in both
+// backends, replicating objects that are only present in one of them.
+func (ae *AntiEntropy) RunOnce(ctx context.Context) (AntiEntropyResults, error) {
+	var results AntiEntropyResults
+	resultsAToB, err := ae.replicateMissing(ctx, ae.backendA, "backend A", ae.backendB, "backend B", ae.replicatorAToB, ae.replicationsAToBSucceeded, ae.replicationsAToBFailed)
+	results.ObjectsOnlyInA = resultsAToB.objectsOnlyInSource
+	results.ObjectsInBoth = resultsAToB.objectsInBoth
+	results.ReplicationsFailed = resultsAToB.replicationsFailed
+	ae.objectsOnlyInA.Add(float64(resultsAToB.objectsOnlyInSource))
+	ae.objectsInBoth.Add(float64(resultsAToB.objectsInBoth))
+	if err != nil {
+		return results, err
+	}
+
+	if ae.replicatorBToA != nil {
+		// Objects present in both backends have already been
+		// counted while enumerating backend A.
+		resultsBToA, err := ae.replicateMissing(ctx, ae.backendB, "backend B", ae.backendA, "backend A", ae.replicatorBToA, ae.replicationsBToASucceeded, ae.replicationsBToAFailed)
+		results.ObjectsOnlyInB = resultsBToA.objectsOnlyInSource
+		results.ReplicationsFailed += resultsBToA.replicationsFailed
+		ae.objectsOnlyInB.Add(float64(resultsBToA.objectsOnlyInSource))
+		if err != nil {
+			return results, err
+		}
+	}
+	ae.passesCompleted.Inc()
+	return results, nil
+}
+
+// Run performs passes over all objects stored in both backends
+// repeatedly, waiting for a fixed amount of time between passes. This
+// function returns when the provided context is cancelled.
+func (ae *AntiEntropy) Run(ctx context.Context, interval time.Duration) {
+	for {
+		if results, err := ae.RunOnce(ctx); err == nil {
+			log.Printf(
+				"Anti-entropy %#v: Pass completed, %d objects only in backend A, %d objects only in backend B, %d objects in both backends, %d failed replications",
+				ae.name,
+				results.ObjectsOnlyInA,
+				results.ObjectsOnlyInB,
+				results.ObjectsInBoth,
+				results.ReplicationsFailed)
+		} else if ctx.Err() == nil {
+			log.Printf("Anti-entropy %#v: Pass failed: %s", ae.name, err)
+		}
+
+		timer, t := ae.clock.NewTimer(interval)
+		select {
+		case <-t:
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package mirrored_test
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/mirrored"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestAntiEntropy(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+	defer ctrl.Finish()
+
+	backendA := mock.NewMockEnumerableBlobAccess(ctrl)
+	backendB := mock.NewMockEnumerableBlobAccess(ctrl)
+	replicatorAToB := mock.NewMockBlobReplicator(ctrl)
+	replicatorBToA := mock.NewMockBlobReplicator(ctrl)
+	clock := mock.NewMockClock(ctrl)
+	antiEntropy := mirrored.NewAntiEntropy(backendA, backendB, replicatorAToB, replicatorBToA, clock, "cas", 2)
+
+	digest1 := digest.MustNewDigest("", remoteexecution.DigestFunction_MD5, "00000000000000000000000000000001", 1)
+	digest2 := digest.MustNewDigest("", remoteexecution.DigestFunction_MD5, "00000000000000000000000000000002", 2)
+	digest3 := digest.MustNewDigest("", remoteexecution.DigestFunction_MD5, "00000000000000000000000000000003", 3)
+	digest4 := digest.MustNewDigest("", remoteexecution.DigestFunction_MD5, "00000000000000000000000000000004", 4)
+	digest5 := digest.MustNewDigest("", remoteexecution.DigestFunction_MD5, "00000000000000000000000000000005", 5)
+	enumerate := func(digests ...digest.Digest) func(ctx context.Context, cursor string, enumerateFunc blobstore.EnumerateFunc) error {
+		return func(ctx context.Context, cursor string, enumerateFunc blobstore.EnumerateFunc) error {
+			for i, blobDigest := range digests {
+				if err := enumerateFunc(blobDigest, strconv.Itoa(i+1)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+
+	t.Run("EnumerationFailure", func(t *testing.T) {
+		backendA.EXPECT().Enumerate(ctx, "", gomock.Any()).DoAndReturn(enumerate(digest1))
+		backendB.EXPECT().FindMissing(ctx, digest.NewSetBuilder().Add(digest1).Build()).Return(digest.EmptySet, nil)
+		backendB.EXPECT().Enumerate(ctx, "", gomock.Any()).Return(status.Error(codes.Unavailable, "Server offline"))
+
+		_, err := antiEntropy.RunOnce(ctx)
+		require.Equal(t, status.Error(codes.Unavailable, "Failed to enumerate backend B: Server offline"), err)
+	})
+
+	t.Run("FindMissingFailure", func(t *testing.T) {
+		// Failures checking for the existence of objects should
+		// cause the enumeration to be aborted.
+		backendA.EXPECT().Enumerate(ctx, "", gomock.Any()).DoAndReturn(enumerate(digest1, digest2, digest3))
+		backendB.EXPECT().FindMissing(ctx, digest.NewSetBuilder().Add(digest1).Add(digest2).Build()).
+			Return(digest.EmptySet, status.Error(codes.Unavailable, "Server offline"))
+
+		_, err := antiEntropy.RunOnce(ctx)
+		require.Equal(t, status.Error(codes.Unavailable, "Failed to find missing objects in backend B: Server offline"), err)
+	})
+
+	t.Run("Success", func(t *testing.T) {
+		// Objects should be enumerated in batches, whose
+		// existence is checked in the other backend. Objects
+		// only present in one of the backends should be
+		// replicated. Failures to replicate a batch should not
+		// prevent other batches from being replicated.
+		backendA.EXPECT().Enumerate(ctx, "", gomock.Any()).DoAndReturn(enumerate(digest4, digest1, digest2, digest3))
+		backendB.EXPECT().FindMissing(ctx, digest.NewSetBuilder().Add(digest4).Add(digest1).Build()).
+			Return(digest.NewSetBuilder().Add(digest4).Add(digest1).Build(), nil)
+		replicatorAToB.EXPECT().ReplicateMultiple(ctx, digest.NewSetBuilder().Add(digest4).Add(digest1).Build()).
+			Return(status.Error(codes.Internal, "Disk on fire"))
+		backendB.EXPECT().FindMissing(ctx, digest.NewSetBuilder().Add(digest2).Add(digest3).Build()).
+			Return(digest.NewSetBuilder().Add(digest3).Build(), nil)
+		replicatorAToB.EXPECT().ReplicateMultiple(ctx, digest.NewSetBuilder().Add(digest3).Build())
+		backendB.EXPECT().Enumerate(ctx, "", gomock.Any()).DoAndReturn(enumerate(digest2, digest5))
+		backendA.EXPECT().FindMissing(ctx, digest.NewSetBuilder().Add(digest2).Add(digest5).Build()).
+			Return(digest.NewSetBuilder().Add(digest5).Build(), nil)
+		replicatorBToA.EXPECT().ReplicateMultiple(ctx, digest.NewSetBuilder().Add(digest5).Build())
+
+		results, err := antiEntropy.RunOnce(ctx)
+		require.NoError(t, err)
+		require.Equal(t, mirrored.AntiEntropyResults{
+			ObjectsOnlyInA:     3,
+			ObjectsOnlyInB:     1,
+			ObjectsInBoth:      1,
+			ReplicationsFailed: 2,
+		}, results)
+	})
+}
@@ -0,0 +1,465 @@
+package mirrored
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/clock"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	degradableMirroredBlobAccessPrometheusMetrics sync.Once
+
+	degradableMirroredBlobAccessBackendAvailable = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "mirrored_blob_access_backend_available",
+			Help:      "Whether a backend of MirroredBlobAccess is considered to be available (1) or is bypassed due to being unavailable (0).",
+		},
+		[]string{"name", "backend"})
+	degradableMirroredBlobAccessJournalObjects = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "mirrored_blob_access_journal_objects",
+			Help:      "Number of objects written while a backend of MirroredBlobAccess was unavailable, which still need to be replicated to it.",
+		},
+		[]string{"name", "backend"})
+	degradableMirroredBlobAccessJournalOverflows = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "mirrored_blob_access_journal_overflows_total",
+			Help:      "Number of objects written while a backend of MirroredBlobAccess was unavailable, which could not be recorded due to the journal being full.",
+		},
+		[]string{"name", "backend"})
+	degradableMirroredBlobAccessJournalReplayedObjects = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "mirrored_blob_access_journal_replayed_objects_total",
+			Help:      "Number of objects in the journal that were replicated to a backend of MirroredBlobAccess after it became available again.",
+		},
+		[]string{"name", "backend", "result"})
+
+	degradableMirroredBlobAccessesLock sync.Mutex
+	degradableMirroredBlobAccesses     = map[string][]*degradableMirroredBlobAccess{}
+
+	// Objects whose existence is checked when probing whether a
+	// bypassed backend has become available again. The empty blob
+	// is used, as the outcome of the request is irrelevant.
+	degradableMirroredBlobAccessProbeDigests = newDegradableMirroredBlobAccessProbeDigests()
+)
+
+func newDegradableMirroredBlobAccessProbeDigests() digest.Set {
+	emptyBlobDigest := digest.MustNewDigest("", remoteexecution.DigestFunction_SHA256, "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", 0)
+	return digest.NewSetBuilder().Add(emptyBlobDigest).Build()
+}
+
+// DegradedModeConfiguration contains the parameters that determine
+// when a backend of MirroredBlobAccess is considered to be unavailable,
+// and how writes are recorded while it is.
+type DegradedModeConfiguration struct {
+	// The number of consecutive requests that need to fail with
+	// code UNAVAILABLE before the backend is bypassed.
+	FailureThreshold int
+	// The amount of time to wait before probing a bypassed
+	// backend, to test whether it has become available.
+	RetryInterval time.Duration
+	// The maximum number of objects that may be recorded in the
+	// journal while the backend is bypassed.
+	MaximumJournalSize int
+}
+
+type degradableBackend struct {
+	name       string
+	base       blobstore.BlobAccess
+	blobAccess blobstore.BlobAccess
+	replicator BlobReplicator
+	clock      clock.Clock
+	config     DegradedModeConfiguration
+
+	lock                sync.Mutex
+	consecutiveFailures int
+	unavailable         bool
+	retryTime           time.Time
+	journal             map[digest.Digest]struct{}
+
+	available              prometheus.Gauge
+	journalObjects         prometheus.Gauge
+	journalOverflows       prometheus.Counter
+	replayedObjectsSuccess prometheus.Counter
+	replayedObjectsFailure prometheus.Counter
+}
+
+func newDegradableBackend(name string, backendName string, base blobstore.BlobAccess, replicator BlobReplicator, clock clock.Clock, config DegradedModeConfiguration) *degradableBackend {
+	be := &degradableBackend{
+		name:       backendName,
+		base:       base,
+		replicator: replicator,
+		clock:      clock,
+		config:     config,
+		journal:    map[digest.Digest]struct{}{},
+
+		available:              degradableMirroredBlobAccessBackendAvailable.WithLabelValues(name, backendName),
+		journalObjects:         degradableMirroredBlobAccessJournalObjects.WithLabelValues(name, backendName),
+		journalOverflows:       degradableMirroredBlobAccessJournalOverflows.WithLabelValues(name, backendName),
+		replayedObjectsSuccess: degradableMirroredBlobAccessJournalReplayedObjects.WithLabelValues(name, backendName, "Success"),
+		replayedObjectsFailure: degradableMirroredBlobAccessJournalReplayedObjects.WithLabelValues(name, backendName, "Failure"),
+	}
+	be.blobAccess = &circuitBreakingBlobAccess{
+		base:    base,
+		backend: be,
+	}
+	be.available.Set(1)
+	be.journalObjects.Set(0)
+	return be
+}
+
+// isAvailable returns whether requests should be sent to the backend.
+// Client requests are never sent to a bypassed backend. Instead, it is
+// probed every retry interval to test whether it has become available
+// again.
+func (be *degradableBackend) isAvailable() bool {
+	be.lock.Lock()
+	defer be.lock.Unlock()
+	if !be.unavailable {
+		return true
+	}
+	if now := be.clock.Now(); !now.Before(be.retryTime) {
+		be.retryTime = now.Add(be.config.RetryInterval)
+		go be.probe()
+	}
+	return false
+}
+
+// probe sends a synthetic FindMissing() request to a bypassed backend.
+// The backend is only considered to be available again if the request
+// succeeds.
+func (be *degradableBackend) probe() {
+	ctx, cancel := be.clock.NewContextWithTimeout(context.Background(), be.config.RetryInterval)
+	defer cancel()
+	if _, err := be.base.FindMissing(ctx, degradableMirroredBlobAccessProbeDigests); err != nil {
+		log.Printf("%s is still unavailable: %s", be.name, err)
+		return
+	}
+	be.recordResult(nil)
+}
+
+// getState returns whether the backend is currently being bypassed,
+// regardless of whether it is due to be retried, and the number of
+// objects in its journal.
+func (be *degradableBackend) getState() (bool, int) {
+	be.lock.Lock()
+	defer be.lock.Unlock()
+	return be.unavailable, len(be.journal)
+}
+
+// recordResult updates the state of the circuit breaker, based on the
+// outcome of a request sent to the backend.
+func (be *degradableBackend) recordResult(err error) {
+	be.lock.Lock()
+	defer be.lock.Unlock()
+	if status.Code(err) == codes.Unavailable {
+		be.consecutiveFailures++
+		if !be.unavailable && be.consecutiveFailures >= be.config.FailureThreshold {
+			log.Printf("%s is unavailable, bypassing it: %s", be.name, err)
+			be.unavailable = true
+			be.retryTime = be.clock.Now().Add(be.config.RetryInterval)
+			be.available.Set(0)
+		}
+		return
+	}
+
+	be.consecutiveFailures = 0
+	if be.unavailable {
+		log.Printf("%s is available again, replaying %d journaled objects", be.name, len(be.journal))
+		be.unavailable = false
+		be.available.Set(1)
+		be.replayJournal()
+	}
+}
+
+// addToJournal records that an object was written while the backend
+// was being bypassed.
+func (be *degradableBackend) addToJournal(digests digest.Set) {
+	be.lock.Lock()
+	defer be.lock.Unlock()
+	for _, blobDigest := range digests.Items() {
+		if _, ok := be.journal[blobDigest]; !ok {
+			if len(be.journal) >= be.config.MaximumJournalSize {
+				be.journalOverflows.Inc()
+				continue
+			}
+			be.journal[blobDigest] = struct{}{}
+		}
+	}
+	be.journalObjects.Set(float64(len(be.journal)))
+}
+
+// replayJournal replicates all objects in the journal to the backend
+// asynchronously. Objects for which replication fails are added to the
+// journal once again.
+func (be *degradableBackend) replayJournal() {
+	if len(be.journal) == 0 {
+		return
+	}
+	digests := digest.NewSetBuilder()
+	for blobDigest := range be.journal {
+		digests.Add(blobDigest)
+	}
+	be.journal = map[digest.Digest]struct{}{}
+	be.journalObjects.Set(0)
+
+	go func(digests digest.Set) {
+		if err := be.replicator.ReplicateMultiple(context.Background(), digests); err != nil {
+			log.Printf("Failed to replay journal of %s: %s", be.name, err)
+			be.replayedObjectsFailure.Add(float64(digests.Length()))
+			be.addToJournal(digests)
+		} else {
+			be.replayedObjectsSuccess.Add(float64(digests.Length()))
+		}
+	}(digests.Build())
+}
+
+type degradableMirroredBlobAccess struct {
+	name     string
+	mirrored blobstore.BlobAccess
+	backendA *degradableBackend
+	backendB *degradableBackend
+}
+
+// NewDegradableMirroredBlobAccess creates a MirroredBlobAccess that
+// remains available when one of its backends is unavailable. A circuit
+// breaker is used to detect backends returning UNAVAILABLE. While a
+// backend is bypassed, requests are only sent to the other backend.
+// Objects written during this time are recorded in a bounded journal.
+// When the backend becomes available again, objects in the journal are
+// replicated to it.
+//
+// Objects that could not be recorded in the journal due to it being
+// full are still repaired by MirroredBlobAccess upon access.
+//
+// The state of the instance is reported by HandleDegradedState() under
+// the provided name, until RemoveDegradableMirroredBlobAccess() is
+// called.
+func NewDegradableMirroredBlobAccess(name string, backendA blobstore.BlobAccess, backendB blobstore.BlobAccess, replicatorAToB BlobReplicator, replicatorBToA BlobReplicator, clock clock.Clock, config DegradedModeConfiguration) blobstore.BlobAccess {
+	degradableMirroredBlobAccessPrometheusMetrics.Do(func() {
+		prometheus.MustRegister(degradableMirroredBlobAccessBackendAvailable)
+		prometheus.MustRegister(degradableMirroredBlobAccessJournalObjects)
+		prometheus.MustRegister(degradableMirroredBlobAccessJournalOverflows)
+		prometheus.MustRegister(degradableMirroredBlobAccessJournalReplayedObjects)
+	})
+
+	degradableA := newDegradableBackend(name, "Backend A", backendA, replicatorBToA, clock, config)
+	degradableB := newDegradableBackend(name, "Backend B", backendB, replicatorAToB, clock, config)
+	ba := &degradableMirroredBlobAccess{
+		name:     name,
+		mirrored: NewMirroredBlobAccess(degradableA.blobAccess, degradableB.blobAccess, replicatorAToB, replicatorBToA),
+		backendA: degradableA,
+		backendB: degradableB,
+	}
+
+	// Multiple instances may temporarily use the same name while
+	// the configuration is reloaded. Report the most recent one.
+	degradableMirroredBlobAccessesLock.Lock()
+	degradableMirroredBlobAccesses[name] = append(degradableMirroredBlobAccesses[name], ba)
+	degradableMirroredBlobAccessesLock.Unlock()
+	return ba
+}
+
+// RemoveDegradableMirroredBlobAccess removes an instance created by
+// NewDegradableMirroredBlobAccess() from the instances reported by
+// HandleDegradedState(). It should be called when the instance is no
+// longer used.
+func RemoveDegradableMirroredBlobAccess(blobAccess blobstore.BlobAccess) {
+	ba := blobAccess.(*degradableMirroredBlobAccess)
+	degradableMirroredBlobAccessesLock.Lock()
+	defer degradableMirroredBlobAccessesLock.Unlock()
+
+	instances := degradableMirroredBlobAccesses[ba.name]
+	for i, instance := range instances {
+		if instance == ba {
+			instances = append(instances[:i], instances[i+1:]...)
+			break
+		}
+	}
+	if len(instances) > 0 {
+		degradableMirroredBlobAccesses[ba.name] = instances
+		return
+	}
+
+	// No other instances use the same name, meaning its metrics
+	// may be removed.
+	delete(degradableMirroredBlobAccesses, ba.name)
+	for _, backend := range []*degradableBackend{ba.backendA, ba.backendB} {
+		degradableMirroredBlobAccessBackendAvailable.DeleteLabelValues(ba.name, backend.name)
+		degradableMirroredBlobAccessJournalObjects.DeleteLabelValues(ba.name, backend.name)
+		degradableMirroredBlobAccessJournalOverflows.DeleteLabelValues(ba.name, backend.name)
+		degradableMirroredBlobAccessJournalReplayedObjects.DeleteLabelValues(ba.name, backend.name, "Success")
+		degradableMirroredBlobAccessJournalReplayedObjects.DeleteLabelValues(ba.name, backend.name, "Failure")
+	}
+}
+
+// getSurvivingBackend returns the backend that should exclusively be
+// used if the other backend is bypassed, followed by the bypassed
+// backend. It returns nil if requests should be sent to both backends.
+func (ba *degradableMirroredBlobAccess) getSurvivingBackend() (*degradableBackend, *degradableBackend) {
+	availableA, availableB := ba.backendA.isAvailable(), ba.backendB.isAvailable()
+	if availableA && !availableB {
+		return ba.backendA, ba.backendB
+	}
+	if !availableA && availableB {
+		return ba.backendB, ba.backendA
+	}
+	return nil, nil
+}
+
+func (ba *degradableMirroredBlobAccess) Get(ctx context.Context, digest digest.Digest) buffer.Buffer {
+	surviving, _ := ba.getSurvivingBackend()
+	if surviving == nil {
+		return ba.mirrored.Get(ctx, digest)
+	}
+	return buffer.WithErrorHandler(
+		surviving.blobAccess.Get(ctx, digest),
+		&backendNamingErrorHandler{name: surviving.name})
+}
+
+func (ba *degradableMirroredBlobAccess) Put(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+	surviving, bypassed := ba.getSurvivingBackend()
+	if surviving == nil {
+		return ba.mirrored.Put(ctx, blobDigest, b)
+	}
+	if err := surviving.blobAccess.Put(ctx, blobDigest, b); err != nil {
+		return util.StatusWrap(err, surviving.name)
+	}
+	bypassed.addToJournal(digest.NewSetBuilder().Add(blobDigest).Build())
+	return nil
+}
+
+func (ba *degradableMirroredBlobAccess) FindMissing(ctx context.Context, digests digest.Set) (digest.Set, error) {
+	surviving, _ := ba.getSurvivingBackend()
+	if surviving == nil {
+		return ba.mirrored.FindMissing(ctx, digests)
+	}
+	missing, err := surviving.blobAccess.FindMissing(ctx, digests)
+	if err != nil {
+		return digest.EmptySet, util.StatusWrap(err, surviving.name)
+	}
+	return missing, nil
+}
+
+func (ba *degradableMirroredBlobAccess) Enumerate(ctx context.Context, cursor string, enumerateFunc blobstore.EnumerateFunc) error {
+	// Don't enumerate the contents of a bypassed backend. Objects
+	// written while it is bypassed are stored in the other backend.
+	backends := []blobstore.BlobAccess{ba.backendA.base, ba.backendB.base}
+	if surviving, _ := ba.getSurvivingBackend(); surviving == ba.backendA {
+		backends[1] = nil
+	} else if surviving == ba.backendB {
+		backends[0] = nil
+	}
+	return blobstore.EnumerateSequentially(ctx, backends, cursor, enumerateFunc)
+}
+
+// backendNamingErrorHandler prepends the name of the backend to
+// errors returned by buffers, similar to MirroredBlobAccess.
+type backendNamingErrorHandler struct {
+	name string
+}
+
+func (eh *backendNamingErrorHandler) OnError(err error) (buffer.Buffer, error) {
+	return nil, util.StatusWrap(err, eh.name)
+}
+
+func (eh *backendNamingErrorHandler) Done() {}
+
+// circuitBreakingBlobAccess is a decorator for BlobAccess that reports
+// the outcome of all requests to the circuit breaker of a backend.
+type circuitBreakingBlobAccess struct {
+	base    blobstore.BlobAccess
+	backend *degradableBackend
+}
+
+func (ba *circuitBreakingBlobAccess) Get(ctx context.Context, digest digest.Digest) buffer.Buffer {
+	return buffer.WithErrorHandler(
+		ba.base.Get(ctx, digest),
+		&circuitBreakingErrorHandler{backend: ba.backend})
+}
+
+func (ba *circuitBreakingBlobAccess) Put(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+	err := ba.base.Put(ctx, digest, b)
+	ba.backend.recordResult(err)
+	return err
+}
+
+func (ba *circuitBreakingBlobAccess) FindMissing(ctx context.Context, digests digest.Set) (digest.Set, error) {
+	missing, err := ba.base.FindMissing(ctx, digests)
+	ba.backend.recordResult(err)
+	return missing, err
+}
+
+type circuitBreakingErrorHandler struct {
+	backend *degradableBackend
+	err     error
+}
+
+func (eh *circuitBreakingErrorHandler) OnError(err error) (buffer.Buffer, error) {
+	eh.err = err
+	return nil, err
+}
+
+func (eh *circuitBreakingErrorHandler) Done() {
+	eh.backend.recordResult(eh.err)
+}
+
+// HandleDegradedState is an HTTP handler that reports which backends
+// of MirroredBlobAccess instances with degraded mode enabled are being
+// bypassed. It returns HTTP 503 if any of the backends is bypassed.
+// Because the instances remain functional in that case, this handler
+// is not suitable for use as a liveness probe.
+func HandleDegradedState(w http.ResponseWriter, r *http.Request) {
+	degradableMirroredBlobAccessesLock.Lock()
+	names := make([]string, 0, len(degradableMirroredBlobAccesses))
+	blobAccesses := make(map[string]*degradableMirroredBlobAccess, len(degradableMirroredBlobAccesses))
+	for name, instances := range degradableMirroredBlobAccesses {
+		names = append(names, name)
+		blobAccesses[name] = instances[len(instances)-1]
+	}
+	degradableMirroredBlobAccessesLock.Unlock()
+	sort.Strings(names)
+
+	var lines []string
+	degraded := false
+	for _, name := range names {
+		ba := blobAccesses[name]
+		for _, backend := range []*degradableBackend{ba.backendA, ba.backendB} {
+			if unavailable, journalObjects := backend.getState(); unavailable {
+				lines = append(lines, fmt.Sprintf("%s: %s unavailable, %d journaled objects\n", name, backend.name, journalObjects))
+				degraded = true
+			} else {
+				lines = append(lines, fmt.Sprintf("%s: %s available\n", name, backend.name))
+			}
+		}
+	}
+	if degraded {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	for _, line := range lines {
+		fmt.Fprint(w, line)
+	}
+}
@@ -0,0 +1,139 @@
+package mirrored_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/mirrored"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestDegradableMirroredBlobAccess(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+	defer ctrl.Finish()
+
+	backendA := mock.NewMockBlobAccess(ctrl)
+	backendB := mock.NewMockBlobAccess(ctrl)
+	replicatorAToB := mock.NewMockBlobReplicator(ctrl)
+	replicatorBToA := mock.NewMockBlobReplicator(ctrl)
+	clock := mock.NewMockClock(ctrl)
+	blobAccess := mirrored.NewDegradableMirroredBlobAccess(
+		"cas_mirrored",
+		backendA,
+		backendB,
+		replicatorAToB,
+		replicatorBToA,
+		clock,
+		mirrored.DegradedModeConfiguration{
+			FailureThreshold:   2,
+			RetryInterval:      time.Minute,
+			MaximumJournalSize: 1,
+		})
+
+	digest1 := digest.MustNewDigest("default", remoteexecution.DigestFunction_MD5, "8b1a9953c4611296a827abf8c47804d7", 5)
+	digest2 := digest.MustNewDigest("default", remoteexecution.DigestFunction_MD5, "5d41402abc4b2a76b9719d911017c592", 5)
+	putSuccess := func(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+		_, err := b.ToByteSlice(10)
+		return err
+	}
+	putFailure := func(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+		b.Discard()
+		return status.Error(codes.Unavailable, "Server offline")
+	}
+	getDegradedState := func() (int, string) {
+		w := httptest.NewRecorder()
+		mirrored.HandleDegradedState(w, httptest.NewRequest(http.MethodGet, "/-/mirrored", nil))
+		return w.Code, w.Body.String()
+	}
+
+	// Initially, requests should be sent to both backends. Backend
+	// A becoming unavailable causes requests to fail, until the
+	// failure threshold is reached.
+	code, body := getDegradedState()
+	require.Equal(t, http.StatusOK, code)
+	require.Contains(t, body, "cas_mirrored: Backend A available\ncas_mirrored: Backend B available\n")
+	clock.EXPECT().Now().Return(time.Unix(1000, 0))
+	for i := 0; i < 2; i++ {
+		backendA.EXPECT().Put(gomock.Any(), digest1, gomock.Any()).DoAndReturn(putFailure)
+		backendB.EXPECT().Put(gomock.Any(), digest1, gomock.Any()).DoAndReturn(putSuccess)
+		require.Equal(
+			t,
+			status.Error(codes.Unavailable, "Backend A: Server offline"),
+			blobAccess.Put(ctx, digest1, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+	}
+
+	// After reaching the failure threshold, backend A should be
+	// bypassed. Writes should be recorded in the journal, which
+	// is bounded in size.
+	backendB.EXPECT().Put(ctx, digest1, gomock.Any()).DoAndReturn(putSuccess)
+	backendB.EXPECT().Put(ctx, digest2, gomock.Any()).DoAndReturn(putSuccess)
+	clock.EXPECT().Now().Return(time.Unix(1010, 0)).Times(2)
+	require.NoError(t, blobAccess.Put(ctx, digest1, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+	require.NoError(t, blobAccess.Put(ctx, digest2, buffer.NewValidatedBufferFromByteSlice([]byte("hello"))))
+
+	clock.EXPECT().Now().Return(time.Unix(1020, 0))
+	backendB.EXPECT().Get(ctx, digest1).Return(buffer.NewBufferFromError(status.Error(codes.NotFound, "Object not found")))
+	_, err := blobAccess.Get(ctx, digest1).ToByteSlice(10)
+	require.Equal(t, status.Error(codes.NotFound, "Backend B: Object not found"), err)
+
+	clock.EXPECT().Now().Return(time.Unix(1030, 0))
+	backendB.EXPECT().FindMissing(ctx, digest.NewSetBuilder().Add(digest2).Build()).Return(digest.EmptySet, nil)
+	missing, err := blobAccess.FindMissing(ctx, digest.NewSetBuilder().Add(digest2).Build())
+	require.NoError(t, err)
+	require.Equal(t, digest.EmptySet, missing)
+
+	code, body = getDegradedState()
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.Contains(t, body, "cas_mirrored: Backend A unavailable, 1 journaled objects\ncas_mirrored: Backend B available\n")
+
+	// Once the retry interval has passed, backend A should be
+	// probed. Client requests should not be sent to it. As the
+	// probe succeeds, the journal should be replayed.
+	clock.EXPECT().Now().Return(time.Unix(1060, 0))
+	backendB.EXPECT().FindMissing(ctx, digest.NewSetBuilder().Add(digest2).Build()).Return(digest.EmptySet, nil)
+	clock.EXPECT().NewContextWithTimeout(gomock.Any(), time.Minute).DoAndReturn(context.WithTimeout)
+	probeDigests := digest.NewSetBuilder().
+		Add(digest.MustNewDigest("", remoteexecution.DigestFunction_SHA256, "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", 0)).
+		Build()
+	backendA.EXPECT().FindMissing(gomock.Any(), probeDigests).Return(probeDigests, nil)
+	replayed := make(chan struct{})
+	replicatorBToA.EXPECT().ReplicateMultiple(gomock.Any(), digest.NewSetBuilder().Add(digest1).Build()).DoAndReturn(
+		func(ctx context.Context, digests digest.Set) error {
+			close(replayed)
+			return nil
+		})
+	missing, err = blobAccess.FindMissing(ctx, digest.NewSetBuilder().Add(digest2).Build())
+	require.NoError(t, err)
+	require.Equal(t, digest.EmptySet, missing)
+	<-replayed
+
+	// Successive requests should be sent to both backends.
+	backendA.EXPECT().FindMissing(ctx, digest.NewSetBuilder().Add(digest2).Build()).Return(digest.EmptySet, nil)
+	backendB.EXPECT().FindMissing(ctx, digest.NewSetBuilder().Add(digest2).Build()).Return(digest.EmptySet, nil)
+	replicatorAToB.EXPECT().ReplicateMultiple(ctx, digest.EmptySet)
+	replicatorBToA.EXPECT().ReplicateMultiple(ctx, digest.EmptySet)
+	missing, err = blobAccess.FindMissing(ctx, digest.NewSetBuilder().Add(digest2).Build())
+	require.NoError(t, err)
+	require.Equal(t, digest.EmptySet, missing)
+
+	code, body = getDegradedState()
+	require.Equal(t, http.StatusOK, code)
+	require.Contains(t, body, "cas_mirrored: Backend A available\ncas_mirrored: Backend B available\n")
+
+	// Once removed, the instance should no longer be reported.
+	mirrored.RemoveDegradableMirroredBlobAccess(blobAccess)
+	code, body = getDegradedState()
+	require.Equal(t, http.StatusOK, code)
+	require.NotContains(t, body, "cas_mirrored")
+}
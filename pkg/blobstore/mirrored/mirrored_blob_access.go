@@ -146,6 +146,11 @@ func (ba *mirroredBlobAccess) FindMissing(ctx context.Context, digests digest.Se
 	return missingFromBoth, nil
 }
 
+func (ba *mirroredBlobAccess) Enumerate(ctx context.Context, cursor string, enumerateFunc blobstore.EnumerateFunc) error {
+	// Objects stored in both backends are reported twice.
+	return blobstore.EnumerateSequentially(ctx, []blobstore.BlobAccess{ba.backendA, ba.backendB}, cursor, enumerateFunc)
+}
+
 type mirroredErrorHandler struct {
 	firstBackendName  string
 	secondBackendName string
@@ -0,0 +1,651 @@
+package mirrored
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/clock"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/filesystem"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	persistentQueuedBlobReplicatorPrometheusMetrics sync.Once
+
+	persistentQueuedBlobReplicatorPendingObjects = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "persistent_queued_blob_replicator_pending_objects",
+			Help:      "Number of objects queued for replication, including ones for which replication is in progress.",
+		})
+	persistentQueuedBlobReplicatorOldestPendingObjectAgeSeconds = prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "persistent_queued_blob_replicator_oldest_pending_object_age_seconds",
+			Help:      "Amount of time the oldest object queued for replication has been waiting.",
+		},
+		getOldestPendingReplicationAge)
+	persistentQueuedBlobReplicatorReplications = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "persistent_queued_blob_replicator_replications_total",
+			Help:      "Number of attempts to replicate objects.",
+		},
+		[]string{"result"})
+	persistentQueuedBlobReplicatorReplicationsSucceeded = persistentQueuedBlobReplicatorReplications.WithLabelValues("Succeeded")
+	persistentQueuedBlobReplicatorReplicationsFailed    = persistentQueuedBlobReplicatorReplications.WithLabelValues("Failed")
+	persistentQueuedBlobReplicatorReplicationsCancelled = persistentQueuedBlobReplicatorReplications.WithLabelValues("Cancelled")
+	persistentQueuedBlobReplicatorReplicationsAbandoned = persistentQueuedBlobReplicatorReplications.WithLabelValues("Abandoned")
+	persistentQueuedBlobReplicatorQueuedDurationSeconds = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "persistent_queued_blob_replicator_queued_duration_seconds",
+			Help:      "Amount of time objects were queued until they were replicated successfully.",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 4.0, 12),
+		})
+
+	persistentQueuedBlobReplicatorsLock sync.Mutex
+	persistentQueuedBlobReplicators     []*persistentQueuedBlobReplicator
+)
+
+const (
+	// Name of the file in which the queue is stored.
+	persistentQueueFilename = "queue"
+	// Name of the file that is used while compacting the queue.
+	persistentQueueCompactionFilename = "queue.new"
+	// Minimum number of records that need to be present in the file
+	// before it is compacted.
+	persistentQueueMinimumCompactionRecords = 1024
+)
+
+// PendingReplication contains the state of an object that is queued
+// for replication by a ReplicationQueue.
+type PendingReplication struct {
+	Digest          digest.Digest
+	QueuedTime      time.Time
+	Attempts        int
+	NextAttemptTime time.Time
+	LastError       error
+}
+
+// ReplicationQueue is a BlobReplicator that performs replications
+// asynchronously. Replications that are pending may be listed and
+// cancelled.
+type ReplicationQueue interface {
+	BlobReplicator
+
+	ListPendingReplications() []PendingReplication
+	CancelReplications(digests digest.Set) (int, error)
+}
+
+type persistentQueueEntry struct {
+	PendingReplication
+	heapIndex int
+	cancelled bool
+}
+
+// persistentQueueHeap is a binary heap of queue entries that are not
+// in progress, sorted by the time at which they may be attempted.
+type persistentQueueHeap []*persistentQueueEntry
+
+func (h persistentQueueHeap) Len() int {
+	return len(h)
+}
+
+func (h persistentQueueHeap) Less(i int, j int) bool {
+	if !h[i].NextAttemptTime.Equal(h[j].NextAttemptTime) {
+		return h[i].NextAttemptTime.Before(h[j].NextAttemptTime)
+	}
+	return h[i].QueuedTime.Before(h[j].QueuedTime)
+}
+
+func (h persistentQueueHeap) Swap(i int, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].heapIndex = i
+	h[j].heapIndex = j
+}
+
+func (h *persistentQueueHeap) Push(x interface{}) {
+	e := x.(*persistentQueueEntry)
+	e.heapIndex = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *persistentQueueHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	e.heapIndex = -1
+	return e
+}
+
+type persistentQueuedBlobReplicator struct {
+	source            blobstore.BlobAccess
+	base              BlobReplicator
+	clock             clock.Clock
+	directory         filesystem.Directory
+	initialRetryDelay time.Duration
+	maximumRetryDelay time.Duration
+	wakeup            chan struct{}
+	recordsWakeup     chan struct{}
+
+	lock               sync.Mutex
+	entries            map[digest.Digest]*persistentQueueEntry
+	readyEntries       persistentQueueHeap
+	file               filesystem.FileAppender
+	fileRecords        int
+	pendingRecords     []string
+	pendingRecordsDone []chan<- error
+}
+
+// NewPersistentQueuedBlobReplicator creates a BlobReplicator that
+// queues requests and performs them asynchronously. The queue is
+// stored in a directory on disk, so that pending replications are not
+// lost when the process is restarted.
+//
+// Replications are performed with a configurable concurrency. Failed
+// replications are retried with exponential backoff, until they either
+// succeed or are cancelled. Replications that fail with NOT_FOUND or
+// INVALID_ARGUMENT are abandoned, as retrying them is futile.
+//
+// Records are written to the queue by a single goroutine, so that
+// records generated concurrently are synchronized to stable storage
+// using a single fsync() call. This is done without holding the lock
+// protecting the queue.
+func NewPersistentQueuedBlobReplicator(source blobstore.BlobAccess, base BlobReplicator, clock clock.Clock, directory filesystem.Directory, concurrency int, initialRetryDelay time.Duration, maximumRetryDelay time.Duration) (ReplicationQueue, error) {
+	persistentQueuedBlobReplicatorPrometheusMetrics.Do(func() {
+		prometheus.MustRegister(persistentQueuedBlobReplicatorPendingObjects)
+		prometheus.MustRegister(persistentQueuedBlobReplicatorOldestPendingObjectAgeSeconds)
+		prometheus.MustRegister(persistentQueuedBlobReplicatorReplications)
+		prometheus.MustRegister(persistentQueuedBlobReplicatorQueuedDurationSeconds)
+	})
+
+	br := &persistentQueuedBlobReplicator{
+		source:            source,
+		base:              base,
+		clock:             clock,
+		directory:         directory,
+		initialRetryDelay: initialRetryDelay,
+		maximumRetryDelay: maximumRetryDelay,
+		wakeup:            make(chan struct{}, 1),
+		recordsWakeup:     make(chan struct{}, 1),
+		entries:           map[digest.Digest]*persistentQueueEntry{},
+	}
+	if err := br.load(); err != nil {
+		return nil, err
+	}
+
+	// Rewrite the queue to discard completed replications, and
+	// any records that were only written partially.
+	br.lock.Lock()
+	err := br.compact()
+	br.lock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	persistentQueuedBlobReplicatorsLock.Lock()
+	persistentQueuedBlobReplicators = append(persistentQueuedBlobReplicators, br)
+	persistentQueuedBlobReplicatorsLock.Unlock()
+
+	go br.writeRecords()
+	for i := 0; i < concurrency; i++ {
+		go br.run()
+	}
+	return br, nil
+}
+
+// newAddRecord creates a record that is written to the queue when an
+// object is queued for replication. Records are separated by newlines
+// and fields by spaces. The instance name is stored as a quoted
+// string, as it may contain arbitrary characters.
+func newAddRecord(blobDigest digest.Digest, queuedTime time.Time) string {
+	return fmt.Sprintf(
+		"A %d %d %s %d %q\n",
+		queuedTime.UnixNano(),
+		blobDigest.GetDigestFunction(),
+		blobDigest.GetHashString(),
+		blobDigest.GetSizeBytes(),
+		blobDigest.GetInstance())
+}
+
+// newDoneRecord creates a record that is written to the queue when
+// replication of an object has completed or has been cancelled.
+func newDoneRecord(blobDigest digest.Digest) string {
+	return fmt.Sprintf(
+		"D %d %s %d %q\n",
+		blobDigest.GetDigestFunction(),
+		blobDigest.GetHashString(),
+		blobDigest.GetSizeBytes(),
+		blobDigest.GetInstance())
+}
+
+// parseRecordDigest parses the digest that is stored at the end of
+// every record.
+func parseRecordDigest(fields []string) (digest.Digest, error) {
+	digestFunction, err := strconv.ParseInt(fields[0], 10, 32)
+	if err != nil {
+		return digest.BadDigest, status.Errorf(codes.InvalidArgument, "Invalid digest function %#v", fields[0])
+	}
+	sizeBytes, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return digest.BadDigest, status.Errorf(codes.InvalidArgument, "Invalid size %#v", fields[2])
+	}
+	instance, err := strconv.Unquote(fields[3])
+	if err != nil {
+		return digest.BadDigest, status.Errorf(codes.InvalidArgument, "Invalid instance name %#v", fields[3])
+	}
+	return digest.NewDigest(instance, remoteexecution.DigestFunction_Value(digestFunction), fields[1], sizeBytes)
+}
+
+// parseRecord parses a single record stored in the queue. It returns
+// whether the record indicates that the object was queued or that its
+// replication completed.
+func parseRecord(record string) (digest.Digest, time.Time, bool, error) {
+	if strings.HasPrefix(record, "A ") {
+		fields := strings.SplitN(record[2:], " ", 5)
+		if len(fields) != 5 {
+			return digest.BadDigest, time.Time{}, false, status.Error(codes.InvalidArgument, "Record has an incorrect number of fields")
+		}
+		queuedTime, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			return digest.BadDigest, time.Time{}, false, status.Errorf(codes.InvalidArgument, "Invalid timestamp %#v", fields[0])
+		}
+		blobDigest, err := parseRecordDigest(fields[1:])
+		if err != nil {
+			return digest.BadDigest, time.Time{}, false, err
+		}
+		return blobDigest, time.Unix(0, queuedTime), true, nil
+	} else if strings.HasPrefix(record, "D ") {
+		fields := strings.SplitN(record[2:], " ", 4)
+		if len(fields) != 4 {
+			return digest.BadDigest, time.Time{}, false, status.Error(codes.InvalidArgument, "Record has an incorrect number of fields")
+		}
+		blobDigest, err := parseRecordDigest(fields)
+		if err != nil {
+			return digest.BadDigest, time.Time{}, false, err
+		}
+		return blobDigest, time.Time{}, false, nil
+	}
+	return digest.BadDigest, time.Time{}, false, status.Error(codes.InvalidArgument, "Unknown record type")
+}
+
+// load the contents of the queue from disk.
+func (br *persistentQueuedBlobReplicator) load() error {
+	f, err := br.directory.OpenRead(persistentQueueFilename)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return util.StatusWrapWithCode(err, codes.Internal, "Failed to open queue")
+	}
+	data, err := ioutil.ReadAll(io.NewSectionReader(f, 0, math.MaxInt64))
+	f.Close()
+	if err != nil {
+		return util.StatusWrapWithCode(err, codes.Internal, "Failed to read queue")
+	}
+
+	// Only process complete records, as the final record may have
+	// been written partially. Malformed records are skipped, so that
+	// a single corrupted record does not prevent the remainder of
+	// the queue from being processed.
+	records := strings.Split(string(data), "\n")
+	for i, record := range records[:len(records)-1] {
+		blobDigest, queuedTime, added, err := parseRecord(record)
+		if err != nil {
+			log.Printf("Skipping malformed record at line %d of queue: %s", i+1, err)
+			continue
+		}
+		if added {
+			if _, ok := br.entries[blobDigest]; !ok {
+				e := &persistentQueueEntry{
+					PendingReplication: PendingReplication{
+						Digest:          blobDigest,
+						QueuedTime:      queuedTime,
+						NextAttemptTime: queuedTime,
+					},
+				}
+				br.entries[blobDigest] = e
+				heap.Push(&br.readyEntries, e)
+			}
+		} else if e, ok := br.entries[blobDigest]; ok {
+			heap.Remove(&br.readyEntries, e.heapIndex)
+			delete(br.entries, blobDigest)
+		}
+	}
+	persistentQueuedBlobReplicatorPendingObjects.Add(float64(len(br.entries)))
+	return nil
+}
+
+// compact the queue on disk, so that it only contains records for
+// objects that are still pending.
+func (br *persistentQueuedBlobReplicator) compact() error {
+	if err := br.directory.Remove(persistentQueueCompactionFilename); err != nil && !os.IsNotExist(err) {
+		return util.StatusWrapWithCode(err, codes.Internal, "Failed to remove stale compacted queue")
+	}
+	f, err := br.directory.OpenAppend(persistentQueueCompactionFilename, filesystem.CreateExcl(0644))
+	if err != nil {
+		return util.StatusWrapWithCode(err, codes.Internal, "Failed to create compacted queue")
+	}
+	var records strings.Builder
+	for _, e := range br.entries {
+		records.WriteString(newAddRecord(e.Digest, e.QueuedTime))
+	}
+	if _, err := f.Write([]byte(records.String())); err != nil {
+		f.Close()
+		return util.StatusWrapWithCode(err, codes.Internal, "Failed to write compacted queue")
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return util.StatusWrapWithCode(err, codes.Internal, "Failed to synchronize compacted queue")
+	}
+	if err := f.Close(); err != nil {
+		return util.StatusWrapWithCode(err, codes.Internal, "Failed to close compacted queue")
+	}
+	if err := br.directory.Rename(persistentQueueCompactionFilename, br.directory, persistentQueueFilename); err != nil {
+		return util.StatusWrapWithCode(err, codes.Internal, "Failed to replace queue")
+	}
+	if err := br.directory.Sync(); err != nil {
+		return util.StatusWrapWithCode(err, codes.Internal, "Failed to synchronize queue directory")
+	}
+
+	if br.file != nil {
+		br.file.Close()
+		br.file = nil
+	}
+	f, err = br.directory.OpenAppend(persistentQueueFilename, filesystem.DontCreate)
+	if err != nil {
+		return util.StatusWrapWithCode(err, codes.Internal, "Failed to reopen queue")
+	}
+	br.file = f
+	br.fileRecords = len(br.entries)
+	return nil
+}
+
+// appendRecords schedules records to be written to the queue on disk.
+// The returned channel yields the outcome once the records have been
+// synchronized to stable storage. This function must be called while
+// holding the lock, while the channel should be read without holding
+// it.
+func (br *persistentQueuedBlobReplicator) appendRecords(records []string) <-chan error {
+	done := make(chan error, 1)
+	br.pendingRecords = append(br.pendingRecords, records...)
+	br.pendingRecordsDone = append(br.pendingRecordsDone, done)
+	select {
+	case br.recordsWakeup <- struct{}{}:
+	default:
+	}
+	return done
+}
+
+// writeRecords writes records scheduled by appendRecords() to the
+// queue on disk. All records that are scheduled while the previous
+// batch is being written are synchronized to stable storage at once.
+// The file is compacted if it contains many records of completed
+// replications.
+func (br *persistentQueuedBlobReplicator) writeRecords() {
+	for range br.recordsWakeup {
+		br.lock.Lock()
+		records, done := br.pendingRecords, br.pendingRecordsDone
+		br.pendingRecords, br.pendingRecordsDone = nil, nil
+		file := br.file
+		br.lock.Unlock()
+		if len(done) == 0 {
+			continue
+		}
+
+		var err error
+		if file == nil {
+			err = status.Error(codes.Internal, "Queue is not opened, due to an earlier failure")
+		} else if _, writeErr := file.Write([]byte(strings.Join(records, ""))); writeErr != nil {
+			err = util.StatusWrapWithCode(writeErr, codes.Internal, "Failed to write to queue")
+		} else if syncErr := file.Sync(); syncErr != nil {
+			err = util.StatusWrapWithCode(syncErr, codes.Internal, "Failed to synchronize queue")
+		} else {
+			br.lock.Lock()
+			br.fileRecords += len(records)
+			if br.fileRecords > persistentQueueMinimumCompactionRecords && br.fileRecords > 2*len(br.entries) {
+				err = br.compact()
+			}
+			br.lock.Unlock()
+		}
+		for _, c := range done {
+			c <- err
+		}
+	}
+}
+
+// signal wakes up one of the goroutines waiting for entries to become
+// ready.
+func (br *persistentQueuedBlobReplicator) signal() {
+	select {
+	case br.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+func (br *persistentQueuedBlobReplicator) ReplicateSingle(ctx context.Context, blobDigest digest.Digest) buffer.Buffer {
+	// Serve the read request from the source, while letting the
+	// replication go through the queue.
+	if err := br.ReplicateMultiple(ctx, digest.NewSetBuilder().Add(blobDigest).Build()); err != nil {
+		return buffer.NewBufferFromError(util.StatusWrap(err, "Failed to queue replication"))
+	}
+	return br.source.Get(ctx, blobDigest)
+}
+
+func (br *persistentQueuedBlobReplicator) ReplicateMultiple(ctx context.Context, digests digest.Set) error {
+	// Only queue objects that aren't queued already. Replications
+	// are only acknowledged once their records are persisted.
+	br.lock.Lock()
+	now := br.clock.Now()
+	var records []string
+	for _, blobDigest := range digests.Items() {
+		if _, ok := br.entries[blobDigest]; !ok {
+			e := &persistentQueueEntry{
+				PendingReplication: PendingReplication{
+					Digest:          blobDigest,
+					QueuedTime:      now,
+					NextAttemptTime: now,
+				},
+			}
+			br.entries[blobDigest] = e
+			heap.Push(&br.readyEntries, e)
+			records = append(records, newAddRecord(blobDigest, now))
+		}
+	}
+	if len(records) == 0 {
+		br.lock.Unlock()
+		return nil
+	}
+	persistentQueuedBlobReplicatorPendingObjects.Add(float64(len(records)))
+	br.signal()
+	done := br.appendRecords(records)
+	br.lock.Unlock()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return util.StatusFromContext(ctx)
+	}
+}
+
+// getNextEntry returns the next entry that is ready to be replicated.
+// If no entries are ready, it returns the amount of time to wait.
+func (br *persistentQueuedBlobReplicator) getNextEntry() (*persistentQueueEntry, time.Duration, bool) {
+	br.lock.Lock()
+	defer br.lock.Unlock()
+
+	if len(br.readyEntries) == 0 {
+		return nil, 0, false
+	}
+	e := br.readyEntries[0]
+	if d := e.NextAttemptTime.Sub(br.clock.Now()); d > 0 {
+		return nil, d, true
+	}
+	heap.Pop(&br.readyEntries)
+	if len(br.readyEntries) > 0 {
+		// Let other goroutines pick up remaining entries.
+		br.signal()
+	}
+	return e, 0, false
+}
+
+// completeEntry processes the outcome of a replication attempt.
+func (br *persistentQueuedBlobReplicator) completeEntry(e *persistentQueueEntry, err error) {
+	br.lock.Lock()
+	e.Attempts++
+	if e.cancelled {
+		// Entry was already removed by CancelReplications().
+		br.lock.Unlock()
+		return
+	}
+	switch status.Code(err) {
+	case codes.OK:
+		persistentQueuedBlobReplicatorReplicationsSucceeded.Inc()
+		persistentQueuedBlobReplicatorQueuedDurationSeconds.Observe(br.clock.Now().Sub(e.QueuedTime).Seconds())
+	case codes.InvalidArgument, codes.NotFound:
+		// The object is malformed or no longer present in the
+		// source. Retrying will not cause it to succeed.
+		log.Printf("Abandoning replication of %s: %s", e.Digest, err)
+		persistentQueuedBlobReplicatorReplicationsAbandoned.Inc()
+	default:
+		persistentQueuedBlobReplicatorReplicationsFailed.Inc()
+		// Apply exponential backoff, while preventing the
+		// delay from overflowing.
+		delay := br.maximumRetryDelay
+		if shift := uint(e.Attempts - 1); shift < 63 && br.initialRetryDelay <= br.maximumRetryDelay>>shift {
+			delay = br.initialRetryDelay << shift
+		}
+		e.LastError = err
+		e.NextAttemptTime = br.clock.Now().Add(delay)
+		heap.Push(&br.readyEntries, e)
+		br.signal()
+		br.lock.Unlock()
+		return
+	}
+	done := br.appendRecords([]string{newDoneRecord(e.Digest)})
+	br.lock.Unlock()
+
+	if err := <-done; err != nil {
+		// Failing to record completion only causes the object
+		// to be replicated once more after a restart.
+		log.Printf("Failed to record completion of replication of %s: %s", e.Digest, err)
+	}
+
+	// Only remove the entry once its completion is persisted, so
+	// that it is not reported as completed prematurely. It may
+	// have been cancelled in the meantime.
+	br.lock.Lock()
+	if !e.cancelled {
+		persistentQueuedBlobReplicatorPendingObjects.Dec()
+		delete(br.entries, e.Digest)
+	}
+	br.lock.Unlock()
+}
+
+// run replications for entries in the queue. This function is
+// executed by every goroutine that performs replications.
+func (br *persistentQueuedBlobReplicator) run() {
+	for {
+		e, d, hasTimeout := br.getNextEntry()
+		if e != nil {
+			err := br.base.ReplicateMultiple(context.Background(), digest.NewSetBuilder().Add(e.Digest).Build())
+			if err != nil {
+				log.Printf("Failed to replicate %s: %s", e.Digest, err)
+			}
+			br.completeEntry(e, err)
+		} else if hasTimeout {
+			timer, t := br.clock.NewTimer(d)
+			select {
+			case <-t:
+			case <-br.wakeup:
+				timer.Stop()
+			}
+		} else {
+			<-br.wakeup
+		}
+	}
+}
+
+func (br *persistentQueuedBlobReplicator) ListPendingReplications() []PendingReplication {
+	br.lock.Lock()
+	defer br.lock.Unlock()
+
+	pendingReplications := make([]PendingReplication, 0, len(br.entries))
+	for _, e := range br.entries {
+		pendingReplications = append(pendingReplications, e.PendingReplication)
+	}
+	return pendingReplications
+}
+
+func (br *persistentQueuedBlobReplicator) CancelReplications(digests digest.Set) (int, error) {
+	br.lock.Lock()
+	var records []string
+	for _, blobDigest := range digests.Items() {
+		if e, ok := br.entries[blobDigest]; ok {
+			if e.heapIndex >= 0 {
+				heap.Remove(&br.readyEntries, e.heapIndex)
+			} else {
+				// Replication is in progress. Discard its
+				// outcome.
+				e.cancelled = true
+			}
+			delete(br.entries, blobDigest)
+			records = append(records, newDoneRecord(blobDigest))
+		}
+	}
+	if len(records) == 0 {
+		br.lock.Unlock()
+		return 0, nil
+	}
+	persistentQueuedBlobReplicatorReplicationsCancelled.Add(float64(len(records)))
+	persistentQueuedBlobReplicatorPendingObjects.Sub(float64(len(records)))
+	done := br.appendRecords(records)
+	br.lock.Unlock()
+	return len(records), <-done
+}
+
+// getOldestPendingReplicationAge computes the amount of time the
+// oldest object has been queued across all instances of
+// PersistentQueuedBlobReplicator.
+func getOldestPendingReplicationAge() float64 {
+	persistentQueuedBlobReplicatorsLock.Lock()
+	replicators := persistentQueuedBlobReplicators
+	persistentQueuedBlobReplicatorsLock.Unlock()
+
+	oldestAge := 0.0
+	for _, br := range replicators {
+		br.lock.Lock()
+		now := br.clock.Now()
+		for _, e := range br.entries {
+			if age := now.Sub(e.QueuedTime).Seconds(); age > oldestAge {
+				oldestAge = age
+			}
+		}
+		br.lock.Unlock()
+	}
+	return oldestAge
+}
@@ -0,0 +1,195 @@
+package mirrored_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/mirrored"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/filesystem"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestPersistentQueuedBlobReplicator(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+	defer ctrl.Finish()
+
+	path := filepath.Join(os.Getenv("TEST_TMPDIR"), t.Name())
+	require.NoError(t, os.Mkdir(path, 0777))
+	directory, err := filesystem.NewLocalDirectory(path)
+	require.NoError(t, err)
+	defer directory.Close()
+
+	source := mock.NewMockBlobAccess(ctrl)
+	base := mock.NewMockBlobReplicator(ctrl)
+
+	// Let the clock be adjustable, as it is called into from
+	// background goroutines.
+	var nowLock sync.Mutex
+	now := time.Unix(1000, 0)
+	setNow := func(t time.Time) {
+		nowLock.Lock()
+		now = t
+		nowLock.Unlock()
+	}
+	clock := mock.NewMockClock(ctrl)
+	clock.EXPECT().Now().DoAndReturn(func() time.Time {
+		nowLock.Lock()
+		defer nowLock.Unlock()
+		return now
+	}).AnyTimes()
+
+	digest1 := digest.MustNewDigest("default", remoteexecution.DigestFunction_MD5, "8b1a9953c4611296a827abf8c47804d7", 5)
+	digest2 := digest.MustNewDigest("default", remoteexecution.DigestFunction_MD5, "5d41402abc4b2a76b9719d911017c592", 5)
+
+	t.Run("Enqueue", func(t *testing.T) {
+		// Without any concurrency, replications remain queued.
+		queue, err := mirrored.NewPersistentQueuedBlobReplicator(source, base, clock, directory, 0, time.Second, time.Minute)
+		require.NoError(t, err)
+		require.NoError(t, queue.ReplicateMultiple(ctx, digest.NewSetBuilder().Add(digest1).Add(digest2).Build()))
+
+		// Queueing objects that are already queued should have
+		// no effect.
+		setNow(time.Unix(1010, 0))
+		require.NoError(t, queue.ReplicateMultiple(ctx, digest.NewSetBuilder().Add(digest1).Build()))
+		require.ElementsMatch(t, []mirrored.PendingReplication{
+			{Digest: digest1, QueuedTime: time.Unix(1000, 0), NextAttemptTime: time.Unix(1000, 0)},
+			{Digest: digest2, QueuedTime: time.Unix(1000, 0), NextAttemptTime: time.Unix(1000, 0)},
+		}, queue.ListPendingReplications())
+
+		// Cancelled replications should be removed from the queue.
+		cancelled, err := queue.CancelReplications(digest.NewSetBuilder().Add(digest2).Build())
+		require.NoError(t, err)
+		require.Equal(t, 1, cancelled)
+		cancelled, err = queue.CancelReplications(digest.NewSetBuilder().Add(digest2).Build())
+		require.NoError(t, err)
+		require.Equal(t, 0, cancelled)
+		require.Equal(t, []mirrored.PendingReplication{
+			{Digest: digest1, QueuedTime: time.Unix(1000, 0), NextAttemptTime: time.Unix(1000, 0)},
+		}, queue.ListPendingReplications())
+	})
+
+	t.Run("Retry", func(t *testing.T) {
+		// After a restart, the queue should be reloaded from disk.
+		// Replications that fail should be retried after the
+		// initial retry delay.
+		timer := mock.NewMockTimer(ctrl)
+		timer.EXPECT().Stop().Return(true).AnyTimes()
+		timerChan := make(chan time.Time, 1)
+		clock.EXPECT().NewTimer(time.Second).Return(timer, timerChan).AnyTimes()
+
+		failed := make(chan struct{})
+		base.EXPECT().ReplicateMultiple(gomock.Any(), digest.NewSetBuilder().Add(digest1).Build()).DoAndReturn(
+			func(ctx context.Context, digests digest.Set) error {
+				close(failed)
+				return status.Error(codes.Unavailable, "Server offline")
+			})
+		succeeded := make(chan struct{})
+		base.EXPECT().ReplicateMultiple(gomock.Any(), digest.NewSetBuilder().Add(digest1).Build()).DoAndReturn(
+			func(ctx context.Context, digests digest.Set) error {
+				close(succeeded)
+				return nil
+			})
+
+		queue, err := mirrored.NewPersistentQueuedBlobReplicator(source, base, clock, directory, 1, time.Second, time.Minute)
+		require.NoError(t, err)
+		<-failed
+
+		setNow(time.Unix(1011, 0))
+		timerChan <- time.Unix(1011, 0)
+		<-succeeded
+
+		require.Eventually(t, func() bool {
+			return len(queue.ListPendingReplications()) == 0
+		}, 10*time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("Completed", func(t *testing.T) {
+		// Replications that completed should not be reloaded.
+		queue, err := mirrored.NewPersistentQueuedBlobReplicator(source, base, clock, directory, 0, time.Second, time.Minute)
+		require.NoError(t, err)
+		require.Empty(t, queue.ListPendingReplications())
+	})
+
+	t.Run("Abandoned", func(t *testing.T) {
+		// Replications that fail with NOT_FOUND should not be
+		// retried, as the object is absent in the source.
+		// Instance names containing spaces and newlines should
+		// be stored without corrupting the queue.
+		digest3 := digest.MustNewDigest("hello\nworld ", remoteexecution.DigestFunction_MD5, "6f5902ac237024bdd0c176cb93063dc4", 11)
+		abandoned := make(chan struct{})
+		base.EXPECT().ReplicateMultiple(gomock.Any(), digest.NewSetBuilder().Add(digest3).Build()).DoAndReturn(
+			func(ctx context.Context, digests digest.Set) error {
+				close(abandoned)
+				return status.Error(codes.NotFound, "Object not found")
+			})
+
+		queue, err := mirrored.NewPersistentQueuedBlobReplicator(source, base, clock, directory, 1, time.Second, time.Minute)
+		require.NoError(t, err)
+		require.NoError(t, queue.ReplicateMultiple(ctx, digest.NewSetBuilder().Add(digest3).Build()))
+		<-abandoned
+
+		require.Eventually(t, func() bool {
+			return len(queue.ListPendingReplications()) == 0
+		}, 10*time.Second, 10*time.Millisecond)
+
+		queue, err = mirrored.NewPersistentQueuedBlobReplicator(source, base, clock, directory, 0, time.Second, time.Minute)
+		require.NoError(t, err)
+		require.Empty(t, queue.ListPendingReplications())
+	})
+
+	t.Run("MalformedRecords", func(t *testing.T) {
+		// Malformed records should be skipped, instead of
+		// preventing the queue from being loaded.
+		f, err := os.OpenFile(filepath.Join(path, "queue"), os.O_APPEND|os.O_WRONLY, 0)
+		require.NoError(t, err)
+		_, err = f.WriteString(
+			"A 1000000000000 3 8b1a9953c4611296a827abf8c47804d7 5 unquoted\n" +
+				"Garbage\n" +
+				"A 1000000000000 3 5d41402abc4b2a76b9719d911017c592 5 \"hello world\"\n")
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+
+		queue, err := mirrored.NewPersistentQueuedBlobReplicator(source, base, clock, directory, 0, time.Second, time.Minute)
+		require.NoError(t, err)
+		require.Equal(t, []mirrored.PendingReplication{
+			{
+				Digest:          digest.MustNewDigest("hello world", remoteexecution.DigestFunction_MD5, "5d41402abc4b2a76b9719d911017c592", 5),
+				QueuedTime:      time.Unix(1000, 0),
+				NextAttemptTime: time.Unix(1000, 0),
+			},
+		}, queue.ListPendingReplications())
+	})
+
+	t.Run("ConcurrentEnqueue", func(t *testing.T) {
+		// Objects queued concurrently should all be persisted,
+		// even though their records are written in batches.
+		queue, err := mirrored.NewPersistentQueuedBlobReplicator(source, base, clock, directory, 0, time.Second, time.Minute)
+		require.NoError(t, err)
+		var wg sync.WaitGroup
+		for i := 0; i < 100; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				blobDigest := digest.MustNewDigest("default", remoteexecution.DigestFunction_MD5, fmt.Sprintf("%032x", i), 5)
+				require.NoError(t, queue.ReplicateMultiple(ctx, digest.NewSetBuilder().Add(blobDigest).Build()))
+			}(i)
+		}
+		wg.Wait()
+
+		queue, err = mirrored.NewPersistentQueuedBlobReplicator(source, base, clock, directory, 0, time.Second, time.Minute)
+		require.NoError(t, err)
+		require.Len(t, queue.ListPendingReplications(), 101)
+	})
+}
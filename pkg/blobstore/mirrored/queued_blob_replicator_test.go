@@ -5,6 +5,7 @@ import (
 	"testing"
 	"time"
 
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
 	"github.com/buildbarn/bb-storage/internal/mock"
 	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
 	"github.com/buildbarn/bb-storage/pkg/blobstore/mirrored"
@@ -28,7 +29,7 @@ func TestQueuedBlobReplicatorReplicateSingle(t *testing.T) {
 		source,
 		baseReplicator,
 		digest.NewExistenceCache(clock, digest.KeyWithoutInstance, 10, time.Minute, eviction.NewLRUSet()))
-	helloDigest := digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5)
+	helloDigest := digest.MustNewDigest("hello", remoteexecution.DigestFunction_MD5, "8b1a9953c4611296a827abf8c47804d7", 5)
 	helloDigests := digest.NewSetBuilder().Add(helloDigest).Build()
 
 	t.Run("Success", func(t *testing.T) {
@@ -129,7 +130,7 @@ func TestQueuedBlobReplicatorReplicateMultiple(t *testing.T) {
 		baseReplicator,
 		digest.NewExistenceCache(clock, digest.KeyWithoutInstance, 10, time.Minute, eviction.NewLRUSet()))
 	helloDigests := digest.NewSetBuilder().
-		Add(digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5)).
+		Add(digest.MustNewDigest("hello", remoteexecution.DigestFunction_MD5, "8b1a9953c4611296a827abf8c47804d7", 5)).
 		Build()
 
 	t.Run("Success", func(t *testing.T) {
@@ -38,6 +38,7 @@ func (br *remoteBlobReplicator) ReplicateSingle(ctx context.Context, digest dige
 			BlobDigests: []*remoteexecution.Digest{
 				digest.GetPartialDigest(),
 			},
+			DigestFunction: digest.GetDigestFunction(),
 		})
 		t.Finish(err)
 	}()
@@ -45,20 +46,20 @@ func (br *remoteBlobReplicator) ReplicateSingle(ctx context.Context, digest dige
 }
 
 func (br *remoteBlobReplicator) ReplicateMultiple(ctx context.Context, digests digest.Set) error {
-	// Partition all digests by instance name, as the
-	// ReplicateBlobs() RPC can only process digests for a single
-	// instance. This is not a serious limitation, as digest sets
-	// are unlikely to contain digests for multiple instance names.
-	perInstanceDigests := map[string][]*remoteexecution.Digest{}
-	for _, digest := range digests.Items() {
-		instanceName := digest.GetInstance()
-		perInstanceDigests[instanceName] = append(perInstanceDigests[instanceName], digest.GetPartialDigest())
-	}
-	for instanceName, blobDigests := range perInstanceDigests {
-		// Call ReplicateBlobs() for each instance.
+	// Partition all digests by instance name and digest function,
+	// as the ReplicateBlobs() RPC can only process digests for a
+	// single instance and digest function. This is not a serious
+	// limitation, as digest sets are unlikely to contain digests
+	// for multiple instance names.
+	for _, partition := range digests.PartitionByInstanceAndDigestFunction() {
+		// Call ReplicateBlobs() for each partition.
+		firstDigest, _ := partition.First()
 		request := replicator.ReplicateBlobsRequest{
-			InstanceName: instanceName,
-			BlobDigests:  blobDigests,
+			InstanceName:   firstDigest.GetInstance(),
+			DigestFunction: firstDigest.GetDigestFunction(),
+		}
+		for _, blobDigest := range partition.Items() {
+			request.BlobDigests = append(request.BlobDigests, blobDigest.GetPartialDigest())
 		}
 		if _, err := br.replicatorClient.ReplicateBlobs(ctx, &request); err != nil {
 			return err
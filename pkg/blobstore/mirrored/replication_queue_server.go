@@ -0,0 +1,161 @@
+package mirrored
+
+import (
+	"context"
+	"encoding/base64"
+	"sort"
+	"time"
+
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	replicator_pb "github.com/buildbarn/bb-storage/pkg/proto/replicator"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// replicationQueueDefaultPageSize is the number of replication
+// operations returned by ListPendingReplications() if the client does
+// not specify a page size.
+const replicationQueueDefaultPageSize = 1000
+
+type replicationQueueServer struct {
+	queue ReplicationQueue
+}
+
+// NewReplicationQueueServer creates a gRPC stub for the
+// ReplicationQueue service that forwards all calls to a
+// ReplicationQueue.
+func NewReplicationQueueServer(queue ReplicationQueue) replicator_pb.ReplicationQueueServer {
+	return replicationQueueServer{
+		queue: queue,
+	}
+}
+
+// pendingReplicationLess is the order in which pending replications
+// are returned by ListPendingReplications(). Objects queued at the
+// same time are sorted by digest, so that pages are well-defined.
+func pendingReplicationLess(queuedTimeA time.Time, digestA digest.Digest, queuedTimeB time.Time, digestB digest.Digest) bool {
+	if !queuedTimeA.Equal(queuedTimeB) {
+		return queuedTimeA.Before(queuedTimeB)
+	}
+	return digestA.String() < digestB.String()
+}
+
+func encodeListPendingReplicationsPageToken(pendingReplication *replicator_pb.PendingReplication) (string, error) {
+	data, err := proto.Marshal(&replicator_pb.ListPendingReplicationsPageToken{
+		QueuedTimestamp: pendingReplication.QueuedTimestamp,
+		InstanceName:    pendingReplication.InstanceName,
+		BlobDigest:      pendingReplication.BlobDigest,
+		DigestFunction:  pendingReplication.DigestFunction,
+	})
+	if err != nil {
+		return "", util.StatusWrapWithCode(err, codes.Internal, "Failed to marshal page token")
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func decodeListPendingReplicationsPageToken(encodedPageToken string) (time.Time, digest.Digest, error) {
+	data, err := base64.RawURLEncoding.DecodeString(encodedPageToken)
+	if err != nil {
+		return time.Time{}, digest.BadDigest, status.Error(codes.InvalidArgument, "Page token is not valid base64")
+	}
+	var pageToken replicator_pb.ListPendingReplicationsPageToken
+	if err := proto.Unmarshal(data, &pageToken); err != nil {
+		return time.Time{}, digest.BadDigest, util.StatusWrapWithCode(err, codes.InvalidArgument, "Failed to unmarshal page token")
+	}
+	queuedTime, err := ptypes.Timestamp(pageToken.QueuedTimestamp)
+	if err != nil {
+		return time.Time{}, digest.BadDigest, util.StatusWrapWithCode(err, codes.InvalidArgument, "Invalid queued time in page token")
+	}
+	blobDigest, err := digest.NewDigestFromPartialDigest(pageToken.InstanceName, pageToken.DigestFunction, pageToken.BlobDigest)
+	if err != nil {
+		return time.Time{}, digest.BadDigest, util.StatusWrap(err, "Invalid digest in page token")
+	}
+	return queuedTime, blobDigest, nil
+}
+
+func (rqs replicationQueueServer) ListPendingReplications(ctx context.Context, request *replicator_pb.ListPendingReplicationsRequest) (*replicator_pb.ListPendingReplicationsResponse, error) {
+	pageSize := replicationQueueDefaultPageSize
+	if request.PageSize != 0 {
+		pageSize = int(request.PageSize)
+	}
+
+	pendingReplications := rqs.queue.ListPendingReplications()
+	sort.Slice(pendingReplications, func(i, j int) bool {
+		return pendingReplicationLess(
+			pendingReplications[i].QueuedTime, pendingReplications[i].Digest,
+			pendingReplications[j].QueuedTime, pendingReplications[j].Digest)
+	})
+
+	// Skip replication operations that were returned previously.
+	if request.PageToken != "" {
+		lastQueuedTime, lastDigest, err := decodeListPendingReplicationsPageToken(request.PageToken)
+		if err != nil {
+			return nil, err
+		}
+		pendingReplications = pendingReplications[sort.Search(len(pendingReplications), func(i int) bool {
+			return pendingReplicationLess(
+				lastQueuedTime, lastDigest,
+				pendingReplications[i].QueuedTime, pendingReplications[i].Digest)
+		}):]
+	}
+	hasMore := len(pendingReplications) > pageSize
+	if hasMore {
+		pendingReplications = pendingReplications[:pageSize]
+	}
+
+	response := &replicator_pb.ListPendingReplicationsResponse{
+		PendingReplications: make([]*replicator_pb.PendingReplication, 0, len(pendingReplications)),
+	}
+	for _, pendingReplication := range pendingReplications {
+		queuedTimestamp, err := ptypes.TimestampProto(pendingReplication.QueuedTime)
+		if err != nil {
+			return nil, util.StatusWrapf(err, "Invalid queued time for object %s", pendingReplication.Digest)
+		}
+		nextAttemptTimestamp, err := ptypes.TimestampProto(pendingReplication.NextAttemptTime)
+		if err != nil {
+			return nil, util.StatusWrapf(err, "Invalid next attempt time for object %s", pendingReplication.Digest)
+		}
+		entry := &replicator_pb.PendingReplication{
+			InstanceName:         pendingReplication.Digest.GetInstance(),
+			BlobDigest:           pendingReplication.Digest.GetPartialDigest(),
+			DigestFunction:       pendingReplication.Digest.GetDigestFunction(),
+			QueuedTimestamp:      queuedTimestamp,
+			Attempts:             uint32(pendingReplication.Attempts),
+			NextAttemptTimestamp: nextAttemptTimestamp,
+		}
+		if pendingReplication.LastError != nil {
+			entry.LastError = status.Convert(pendingReplication.LastError).Proto()
+		}
+		response.PendingReplications = append(response.PendingReplications, entry)
+	}
+	if hasMore {
+		nextPageToken, err := encodeListPendingReplicationsPageToken(response.PendingReplications[len(response.PendingReplications)-1])
+		if err != nil {
+			return nil, err
+		}
+		response.NextPageToken = nextPageToken
+	}
+	return response, nil
+}
+
+func (rqs replicationQueueServer) CancelReplications(ctx context.Context, request *replicator_pb.CancelReplicationsRequest) (*replicator_pb.CancelReplicationsResponse, error) {
+	digests := digest.NewSetBuilder()
+	for i, blobDigest := range request.BlobDigests {
+		d, err := digest.NewDigestFromPartialDigest(request.InstanceName, request.DigestFunction, blobDigest)
+		if err != nil {
+			return nil, util.StatusWrapf(err, "Digest at index %d", i)
+		}
+		digests.Add(d)
+	}
+	cancelled, err := rqs.queue.CancelReplications(digests.Build())
+	if err != nil {
+		return nil, err
+	}
+	return &replicator_pb.CancelReplicationsResponse{
+		CancelledReplications: uint32(cancelled),
+	}, nil
+}
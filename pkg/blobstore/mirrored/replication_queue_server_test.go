@@ -0,0 +1,74 @@
+package mirrored_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/mirrored"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	replicator_pb "github.com/buildbarn/bb-storage/pkg/proto/replicator"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestReplicationQueueServerListPendingReplications(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+	defer ctrl.Finish()
+
+	queue := mock.NewMockReplicationQueue(ctrl)
+	server := mirrored.NewReplicationQueueServer(queue)
+
+	digest1 := digest.MustNewDigest("default", remoteexecution.DigestFunction_MD5, "8b1a9953c4611296a827abf8c47804d7", 5)
+	digest2 := digest.MustNewDigest("default", remoteexecution.DigestFunction_MD5, "5d41402abc4b2a76b9719d911017c592", 5)
+	digest3 := digest.MustNewDigest("default", remoteexecution.DigestFunction_MD5, "6f5902ac237024bdd0c176cb93063dc4", 11)
+
+	t.Run("InvalidPageToken", func(t *testing.T) {
+		queue.EXPECT().ListPendingReplications().Return(nil)
+
+		_, err := server.ListPendingReplications(ctx, &replicator_pb.ListPendingReplicationsRequest{
+			PageToken: "!",
+		})
+		require.Equal(t, status.Error(codes.InvalidArgument, "Page token is not valid base64"), err)
+	})
+
+	t.Run("Paginated", func(t *testing.T) {
+		// Objects should be returned in the order in which
+		// they were queued, using the digest to break ties.
+		queue.EXPECT().ListPendingReplications().Return([]mirrored.PendingReplication{
+			{Digest: digest3, QueuedTime: time.Unix(1010, 0), NextAttemptTime: time.Unix(1010, 0)},
+			{Digest: digest1, QueuedTime: time.Unix(1000, 0), NextAttemptTime: time.Unix(1000, 0)},
+			{Digest: digest2, QueuedTime: time.Unix(1000, 0), NextAttemptTime: time.Unix(1000, 0)},
+		})
+
+		response, err := server.ListPendingReplications(ctx, &replicator_pb.ListPendingReplicationsRequest{
+			PageSize: 2,
+		})
+		require.NoError(t, err)
+		require.Len(t, response.PendingReplications, 2)
+		require.Equal(t, "5d41402abc4b2a76b9719d911017c592", response.PendingReplications[0].BlobDigest.Hash)
+		require.Equal(t, "8b1a9953c4611296a827abf8c47804d7", response.PendingReplications[1].BlobDigest.Hash)
+		require.NotEmpty(t, response.NextPageToken)
+
+		// Objects that completed in the meantime should not
+		// affect subsequent pages.
+		queue.EXPECT().ListPendingReplications().Return([]mirrored.PendingReplication{
+			{Digest: digest3, QueuedTime: time.Unix(1010, 0), NextAttemptTime: time.Unix(1010, 0)},
+			{Digest: digest2, QueuedTime: time.Unix(1000, 0), NextAttemptTime: time.Unix(1000, 0)},
+		})
+
+		response, err = server.ListPendingReplications(ctx, &replicator_pb.ListPendingReplicationsRequest{
+			PageSize:  2,
+			PageToken: response.NextPageToken,
+		})
+		require.NoError(t, err)
+		require.Len(t, response.PendingReplications, 1)
+		require.Equal(t, "6f5902ac237024bdd0c176cb93063dc4", response.PendingReplications[0].BlobDigest.Hash)
+		require.Empty(t, response.NextPageToken)
+	})
+}
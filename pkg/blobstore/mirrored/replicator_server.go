@@ -24,7 +24,7 @@ func NewReplicatorServer(replicator BlobReplicator) replicator_pb.ReplicatorServ
 func (rs replicatorServer) ReplicateBlobs(ctx context.Context, request *replicator_pb.ReplicateBlobsRequest) (*empty.Empty, error) {
 	digests := digest.NewSetBuilder()
 	for i, blobDigest := range request.BlobDigests {
-		d, err := digest.NewDigestFromPartialDigest(request.InstanceName, blobDigest)
+		d, err := digest.NewDigestFromPartialDigest(request.InstanceName, request.DigestFunction, blobDigest)
 		if err != nil {
 			return nil, util.StatusWrapf(err, "Digest at index %d", i)
 		}
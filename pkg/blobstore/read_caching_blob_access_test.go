@@ -4,6 +4,7 @@ import (
 	"context"
 	"testing"
 
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
 	"github.com/buildbarn/bb-storage/internal/mock"
 	"github.com/buildbarn/bb-storage/pkg/blobstore"
 	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
@@ -22,7 +23,7 @@ func TestReadCachingBlobAccessGet(t *testing.T) {
 	slowBlobAccess := mock.NewMockBlobAccess(ctrl)
 	fastBlobAccess := mock.NewMockBlobAccess(ctrl)
 	blobAccess := blobstore.NewReadCachingBlobAccess(slowBlobAccess, fastBlobAccess)
-	blobDigest := digest.MustNewDigest("default", "64ec88ca00b268e5ba1a35678a1b5316d212f4f366b2477232534a8aeca37f3c", 11)
+	blobDigest := digest.MustNewDigest("default", remoteexecution.DigestFunction_SHA256, "64ec88ca00b268e5ba1a35678a1b5316d212f4f366b2477232534a8aeca37f3c", 11)
 
 	t.Run("Fast", func(t *testing.T) {
 		// Provide a blob that can be served by the fast backend
@@ -99,7 +100,7 @@ func TestReadCachingBlobAccessPut(t *testing.T) {
 	slowBlobAccess := mock.NewMockBlobAccess(ctrl)
 	fastBlobAccess := mock.NewMockBlobAccess(ctrl)
 	blobAccess := blobstore.NewReadCachingBlobAccess(slowBlobAccess, fastBlobAccess)
-	blobDigest := digest.MustNewDigest("default", "64ec88ca00b268e5ba1a35678a1b5316d212f4f366b2477232534a8aeca37f3c", 11)
+	blobDigest := digest.MustNewDigest("default", remoteexecution.DigestFunction_SHA256, "64ec88ca00b268e5ba1a35678a1b5316d212f4f366b2477232534a8aeca37f3c", 11)
 	buffer := buffer.NewValidatedBufferFromByteSlice([]byte("Hello, world"))
 
 	// Write calls should always be forwarded to the slow backend,
@@ -120,8 +121,8 @@ func TestReadCachingBlobAccessFindMissing(t *testing.T) {
 	fastBlobAccess := mock.NewMockBlobAccess(ctrl)
 	blobAccess := blobstore.NewReadCachingBlobAccess(slowBlobAccess, fastBlobAccess)
 	digests := digest.NewSetBuilder().
-		Add(digest.MustNewDigest("default", "64ec88ca00b268e5ba1a35678a1b5316d212f4f366b2477232534a8aeca37f3c", 11)).
-		Add(digest.MustNewDigest("default", "82e35a63ceba37e9646434c5dd412ea577147f1e4a41ccde1614253187e3dbf9", 7)).
+		Add(digest.MustNewDigest("default", remoteexecution.DigestFunction_SHA256, "64ec88ca00b268e5ba1a35678a1b5316d212f4f366b2477232534a8aeca37f3c", 11)).
+		Add(digest.MustNewDigest("default", remoteexecution.DigestFunction_SHA256, "82e35a63ceba37e9646434c5dd412ea577147f1e4a41ccde1614253187e3dbf9", 7)).
 		Build()
 
 	// FindMissing calls go to the slow backend, as that should act
@@ -3,6 +3,10 @@ package blobstore
 import (
 	"context"
 	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
@@ -11,6 +15,7 @@ import (
 	"github.com/go-redis/redis"
 
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 // RedisClient is an interface that contains the set of functions of the
@@ -129,3 +134,91 @@ func (ba *redisBlobAccess) FindMissing(ctx context.Context, digests digest.Set)
 	}
 	return missing.Build(), nil
 }
+
+func (ba *redisBlobAccess) Enumerate(ctx context.Context, cursor string, enumerateFunc EnumerateFunc) error {
+	clusterClient, ok := ba.redisClient.(*redis.ClusterClient)
+	if !ok {
+		return ba.enumerateNode(ctx, ba.redisClient, cursor, enumerateFunc)
+	}
+
+	// SCAN only iterates over the keys stored on a single node.
+	// Iterate over all master nodes in the cluster in a stable
+	// order, prefixing cursors with the address of the node.
+	var lock sync.Mutex
+	nodes := map[string]*redis.Client{}
+	if err := clusterClient.ForEachMaster(func(client *redis.Client) error {
+		lock.Lock()
+		nodes[client.Options().Addr] = client
+		lock.Unlock()
+		return nil
+	}); err != nil {
+		return util.StatusWrapWithCode(err, codes.Unavailable, "Failed to obtain cluster nodes")
+	}
+	addresses := make([]string, 0, len(nodes))
+	for address := range nodes {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+
+	startAddress, nodeCursor := "", ""
+	if cursor != "" {
+		i := strings.LastIndexByte(cursor, '/')
+		if i < 0 {
+			return status.Errorf(codes.InvalidArgument, "Invalid cursor %#v", cursor)
+		}
+		startAddress, nodeCursor = cursor[:i], cursor[i+1:]
+	}
+	for _, address := range addresses {
+		if address < startAddress {
+			continue
+		}
+		if address != startAddress {
+			nodeCursor = ""
+		}
+		if err := ba.enumerateNode(ctx, nodes[address], nodeCursor, func(blobDigest digest.Digest, cursor string) error {
+			return enumerateFunc(blobDigest, address+"/"+cursor)
+		}); err != nil {
+			return util.StatusWrapf(err, "Node %s", address)
+		}
+	}
+	return nil
+}
+
+// enumerateNode iterates over all keys stored on a single Redis node
+// using SCAN. Keys that do not correspond to objects of this storage
+// type are ignored, as the database may be shared with other storage
+// types.
+//
+// SCAN returns keys in batches. Cursors refer to the start of the
+// batch, meaning that resuming enumeration may cause objects to be
+// reported more than once.
+func (ba *redisBlobAccess) enumerateNode(ctx context.Context, client redis.Cmdable, cursor string, enumerateFunc EnumerateFunc) error {
+	var scanCursor uint64
+	if cursor != "" {
+		var err error
+		if scanCursor, err = strconv.ParseUint(cursor, 10, 64); err != nil {
+			return status.Errorf(codes.InvalidArgument, "Invalid cursor %#v", cursor)
+		}
+	}
+	for {
+		if err := util.StatusFromContext(ctx); err != nil {
+			return err
+		}
+		keys, nextScanCursor, err := client.Scan(scanCursor, "", 1000).Result()
+		if err != nil {
+			return util.StatusWrapWithCode(err, codes.Unavailable, "Failed to scan keys")
+		}
+		batchCursor := strconv.FormatUint(scanCursor, 10)
+		for _, key := range keys {
+			if blobDigest, err := ba.storageType.ParseDigestKey(key); err == nil {
+				if err := enumerateFunc(blobDigest, batchCursor); err != nil {
+					return err
+				}
+			}
+		}
+		if nextScanCursor == 0 {
+			return nil
+		}
+		scanCursor = nextScanCursor
+	}
+}
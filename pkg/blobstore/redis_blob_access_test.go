@@ -4,10 +4,12 @@ import (
 	"context"
 	"testing"
 
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
 	"github.com/buildbarn/bb-storage/internal/mock"
 	"github.com/buildbarn/bb-storage/pkg/blobstore"
 	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
 	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/go-redis/redis"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/require"
 
@@ -24,7 +26,7 @@ func TestRedisBlobAccessContextCanceled(t *testing.T) {
 
 	canceledCtx, cancel := context.WithCancel(ctx)
 	cancel()
-	blobDigest := digest.MustNewDigest("example", "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", 0)
+	blobDigest := digest.MustNewDigest("example", remoteexecution.DigestFunction_SHA256, "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", 0)
 
 	// Calls to Get(), Put() and FindMissing() should not yield
 	// calls into the Redis client if the context associated with
@@ -43,3 +45,47 @@ func TestRedisBlobAccessContextCanceled(t *testing.T) {
 	_, err = blobAccess.FindMissing(canceledCtx, digest.EmptySet)
 	require.Equal(t, err, status.Error(codes.Canceled, "context canceled"))
 }
+
+func TestRedisBlobAccessEnumerate(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+	defer ctrl.Finish()
+
+	redisClient := mock.NewMockRedisClient(ctrl)
+	blobAccess := blobstore.NewRedisBlobAccess(redisClient, blobstore.CASStorageType, 0, 0, 0).(blobstore.EnumerableBlobAccess)
+	enumerateFunc := mock.NewMockEnumerateFunc(ctrl)
+
+	t.Run("FromStart", func(t *testing.T) {
+		// Keys should be scanned until the cursor returned by
+		// Redis becomes zero. Keys that don't correspond to
+		// objects stored in the Content Addressable Storage
+		// should be ignored.
+		redisClient.EXPECT().Scan(uint64(0), "", int64(1000)).Return(redis.NewScanCmdResult(
+			[]string{
+				"8b1a9953c4611296a827abf8c47804d7-5",
+				"8b1a9953c4611296a827abf8c47804d7-5-example",
+			}, 42, nil))
+		enumerateFunc.EXPECT().Call(digest.MustNewDigest("", remoteexecution.DigestFunction_MD5, "8b1a9953c4611296a827abf8c47804d7", 5), "0")
+		redisClient.EXPECT().Scan(uint64(42), "", int64(1000)).Return(redis.NewScanCmdResult(
+			[]string{
+				"blake3-af1349b9f5f9a1a6a0404dea36dcc9499bcb25c9adc112b7cc9a93cae41f3262-0",
+			}, 0, nil))
+		enumerateFunc.EXPECT().Call(digest.MustNewDigest("", remoteexecution.DigestFunction_BLAKE3, "af1349b9f5f9a1a6a0404dea36dcc9499bcb25c9adc112b7cc9a93cae41f3262", 0), "42")
+
+		require.NoError(t, blobAccess.Enumerate(ctx, "", enumerateFunc.Call))
+	})
+
+	t.Run("Resume", func(t *testing.T) {
+		// Providing a cursor should cause the scan to resume
+		// from that point.
+		redisClient.EXPECT().Scan(uint64(42), "", int64(1000)).Return(redis.NewScanCmdResult(nil, 0, nil))
+
+		require.NoError(t, blobAccess.Enumerate(ctx, "42", enumerateFunc.Call))
+	})
+
+	t.Run("InvalidCursor", func(t *testing.T) {
+		require.Equal(
+			t,
+			status.Error(codes.InvalidArgument, "Invalid cursor \"hello\""),
+			blobAccess.Enumerate(ctx, "hello", enumerateFunc.Call))
+	})
+}
@@ -47,7 +47,7 @@ func (ba *remoteBlobAccess) Get(ctx context.Context, digest digest.Digest) buffe
 		resp.Body.Close()
 		return buffer.NewBufferFromError(status.Error(codes.NotFound, url))
 	case http.StatusOK:
-		return ba.storageType.NewBufferFromReader(digest, resp.Body, buffer.Irreparable)
+		return ba.storageType.NewBufferFromReader(digest, resp.Body, resp.ContentLength, buffer.Irreparable)
 	default:
 		resp.Body.Close()
 		return buffer.NewBufferFromError(convertHTTPUnexpectedStatus(resp))
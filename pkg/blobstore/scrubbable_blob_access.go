@@ -0,0 +1,36 @@
+package blobstore
+
+import (
+	"context"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+)
+
+// ScrubFunc is the callback type that is invoked by
+// ScrubbableBlobAccess.Scrub() for every object stored in a backend.
+// Reading the contents of the buffer causes them to be validated
+// against the digest. Upon failure, the buffer's RepairStrategy
+// removes the object from the backend.
+type ScrubFunc func(digest digest.Digest, b buffer.Buffer) error
+
+// ScrubbableBlobAccess is implemented by storage backends that store
+// data locally and are thus capable of enumerating and validating
+// their own contents. This is used to detect objects that got
+// corrupted, for example due to the system crashing.
+type ScrubbableBlobAccess interface {
+	EnumerableBlobAccess
+
+	// GetReadOnly returns the contents of an object, like Get().
+	// Unlike Get(), it does not cause the object to be refreshed,
+	// and data inconsistencies are not repaired. This permits
+	// copying the contents of a backend without altering it.
+	GetReadOnly(ctx context.Context, digest digest.Digest) buffer.Buffer
+
+	// Scrub invokes a callback for every object stored in the
+	// backend. Unlike Get(), it does not cause objects to be
+	// refreshed. Objects may be added and removed concurrently,
+	// meaning that there is no guarantee that every object is
+	// visited.
+	Scrub(ctx context.Context, scrubFunc ScrubFunc) error
+}
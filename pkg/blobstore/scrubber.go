@@ -0,0 +1,161 @@
+package blobstore
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/clock"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	scrubberPrometheusMetrics sync.Once
+
+	scrubberObjects = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "scrubber_objects_total",
+			Help:      "Number of objects whose contents were validated by the scrubber.",
+		},
+		[]string{"name", "result"})
+	scrubberBytes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "scrubber_bytes_total",
+			Help:      "Number of bytes of data whose contents were validated by the scrubber.",
+		},
+		[]string{"name"})
+	scrubberPassesCompleted = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "scrubber_passes_completed_total",
+			Help:      "Number of times the scrubber completed a pass over all objects stored in a backend.",
+		},
+		[]string{"name"})
+)
+
+// ScrubResults contains statistics on the objects that were processed
+// by a single pass of a Scrubber.
+type ScrubResults struct {
+	ValidObjects   int64
+	InvalidObjects int64
+	SizeBytes      int64
+}
+
+// Scrubber validates the contents of all objects stored in a
+// ScrubbableBlobAccess. Objects whose contents don't match up with
+// their digest are removed from storage by the RepairStrategy of the
+// buffers provided by the backend.
+//
+// To prevent the scrubber from using all available I/O bandwidth, the
+// rate at which data is read may be limited.
+type Scrubber struct {
+	blobAccess            ScrubbableBlobAccess
+	clock                 clock.Clock
+	name                  string
+	maximumBytesPerSecond int64
+
+	objectsValid    prometheus.Counter
+	objectsInvalid  prometheus.Counter
+	bytes           prometheus.Counter
+	passesCompleted prometheus.Counter
+}
+
+// NewScrubber creates a Scrubber for a given storage backend. When
+// maximumBytesPerSecond is zero, no rate limiting is performed.
+func NewScrubber(blobAccess ScrubbableBlobAccess, clock clock.Clock, name string, maximumBytesPerSecond int64) *Scrubber {
+	scrubberPrometheusMetrics.Do(func() {
+		prometheus.MustRegister(scrubberObjects)
+		prometheus.MustRegister(scrubberBytes)
+		prometheus.MustRegister(scrubberPassesCompleted)
+	})
+
+	return &Scrubber{
+		blobAccess:            blobAccess,
+		clock:                 clock,
+		name:                  name,
+		maximumBytesPerSecond: maximumBytesPerSecond,
+
+		objectsValid:    scrubberObjects.WithLabelValues(name, "Valid"),
+		objectsInvalid:  scrubberObjects.WithLabelValues(name, "Invalid"),
+		bytes:           scrubberBytes.WithLabelValues(name),
+		passesCompleted: scrubberPassesCompleted.WithLabelValues(name),
+	}
+}
+
+// wait blocks until the average rate at which data has been read
+// during the current pass drops below the configured maximum.
+func (s *Scrubber) wait(ctx context.Context, timeStart time.Time, sizeBytes int64) error {
+	if s.maximumBytesPerSecond > 0 {
+		timeReady := timeStart.Add(time.Duration(float64(sizeBytes) / float64(s.maximumBytesPerSecond) * float64(time.Second)))
+		if d := timeReady.Sub(s.clock.Now()); d > 0 {
+			timer, t := s.clock.NewTimer(d)
+			select {
+			case <-t:
+			case <-ctx.Done():
+				timer.Stop()
+			}
+		}
+	}
+	return util.StatusFromContext(ctx)
+}
+
+// ScrubOnce performs a single pass over all objects stored in the
+// backend, validating their contents. Invalid objects are logged.
+func (s *Scrubber) ScrubOnce(ctx context.Context) (ScrubResults, error) {
+	var results ScrubResults
+	timeStart := s.clock.Now()
+	if err := s.blobAccess.Scrub(ctx, func(blobDigest digest.Digest, b buffer.Buffer) error {
+		if err := b.IntoWriter(ioutil.Discard); err == nil {
+			results.ValidObjects++
+			s.objectsValid.Inc()
+		} else {
+			log.Printf("Scrubber %#v: Object %s is invalid: %s", s.name, blobDigest, err)
+			results.InvalidObjects++
+			s.objectsInvalid.Inc()
+		}
+		sizeBytes := blobDigest.GetSizeBytes()
+		results.SizeBytes += sizeBytes
+		s.bytes.Add(float64(sizeBytes))
+		return s.wait(ctx, timeStart, results.SizeBytes)
+	}); err != nil {
+		return results, err
+	}
+	s.passesCompleted.Inc()
+	return results, nil
+}
+
+// Run performs passes over all objects stored in the backend
+// repeatedly, waiting for a fixed amount of time between passes. This
+// function returns when the provided context is cancelled.
+func (s *Scrubber) Run(ctx context.Context, interval time.Duration) {
+	for {
+		if results, err := s.ScrubOnce(ctx); err == nil {
+			log.Printf(
+				"Scrubber %#v: Pass completed, %d valid objects, %d invalid objects, %d bytes",
+				s.name,
+				results.ValidObjects,
+				results.InvalidObjects,
+				results.SizeBytes)
+		} else if ctx.Err() == nil {
+			log.Printf("Scrubber %#v: Pass failed: %s", s.name, err)
+		}
+
+		timer, t := s.clock.NewTimer(interval)
+		select {
+		case <-t:
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package blobstore_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestScrubberScrubOnce(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	ctx := context.Background()
+	blobAccess := mock.NewMockScrubbableBlobAccess(ctrl)
+	clock := mock.NewMockClock(ctrl)
+	scrubber := blobstore.NewScrubber(blobAccess, clock, "cas_local_block_device", 5)
+
+	t.Run("Success", func(t *testing.T) {
+		// The first object is valid, while the second object is
+		// corrupted. The corrupted object should be repaired.
+		validDigest := digest.MustNewDigest("hello", remoteexecution.DigestFunction_MD5, "8b1a9953c4611296a827abf8c47804d7", 5)
+		invalidDigest := digest.MustNewDigest("hello", remoteexecution.DigestFunction_MD5, "3e25960a79dbc69b674cd4ec67a72c62", 5)
+		repairFunc := mock.NewMockRepairFunc(ctrl)
+		repairFunc.EXPECT().Call()
+		blobAccess.EXPECT().Scrub(ctx, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, scrubFunc blobstore.ScrubFunc) error {
+				if err := scrubFunc(
+					validDigest,
+					buffer.NewCASBufferFromReader(
+						validDigest,
+						ioutil.NopCloser(bytes.NewBufferString("Hello")),
+						buffer.Irreparable)); err != nil {
+					return err
+				}
+				return scrubFunc(
+					invalidDigest,
+					buffer.NewCASBufferFromReader(
+						invalidDigest,
+						ioutil.NopCloser(bytes.NewBufferString("Hello")),
+						buffer.Reparable(invalidDigest, repairFunc.Call)))
+			})
+
+		// Reading the first object should be rate limited, as
+		// it was read too quickly.
+		clock.EXPECT().Now().Return(time.Unix(1000, 0))
+		clock.EXPECT().Now().Return(time.Unix(1000, 500000000))
+		timer := mock.NewMockTimer(ctrl)
+		timerChannel := make(chan time.Time, 1)
+		timerChannel <- time.Unix(1001, 0)
+		clock.EXPECT().NewTimer(500*time.Millisecond).Return(timer, timerChannel)
+		clock.EXPECT().Now().Return(time.Unix(1003, 0))
+
+		results, err := scrubber.ScrubOnce(ctx)
+		require.NoError(t, err)
+		require.Equal(t, blobstore.ScrubResults{
+			ValidObjects:   1,
+			InvalidObjects: 1,
+			SizeBytes:      10,
+		}, results)
+	})
+
+	t.Run("Failure", func(t *testing.T) {
+		// Errors returned by the backend should be propagated.
+		clock.EXPECT().Now().Return(time.Unix(1010, 0))
+		blobAccess.EXPECT().Scrub(ctx, gomock.Any()).Return(status.Error(codes.Internal, "Failed to read digest-location map"))
+
+		_, err := scrubber.ScrubOnce(ctx)
+		require.Equal(t, status.Error(codes.Internal, "Failed to read digest-location map"), err)
+	})
+}
@@ -0,0 +1,196 @@
+package sharding
+
+import (
+	"context"
+	"sync"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/mirrored"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	rebalancingBlobAccessPrometheusMetrics sync.Once
+
+	rebalancingBlobAccessFindMissingObjects = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "rebalancing_blob_access_find_missing_objects_total",
+			Help:      "Number of objects checked for existence by FindMissing(), by the placement at which they were found.",
+		},
+		[]string{"placement"})
+	rebalancingBlobAccessFindMissingObjectsCurrent  = rebalancingBlobAccessFindMissingObjects.WithLabelValues("Current")
+	rebalancingBlobAccessFindMissingObjectsPrevious = rebalancingBlobAccessFindMissingObjects.WithLabelValues("Previous")
+	rebalancingBlobAccessFindMissingObjectsMissing  = rebalancingBlobAccessFindMissingObjects.WithLabelValues("Missing")
+
+	rebalancingBlobAccessGetFallbacks = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "rebalancing_blob_access_get_fallbacks_total",
+			Help:      "Number of Get() calls for objects absent at the current placement, causing the previous placement to be consulted.",
+		})
+
+	rebalancingBlobAccessMigrations = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "rebalancing_blob_access_migrations_total",
+			Help:      "Number of objects for which migration from the previous to the current placement completed, or that were dropped due to the migration queue being full.",
+		},
+		[]string{"result"})
+	rebalancingBlobAccessMigrationsSucceeded = rebalancingBlobAccessMigrations.WithLabelValues("Succeeded")
+	rebalancingBlobAccessMigrationsNotFound  = rebalancingBlobAccessMigrations.WithLabelValues("NotFound")
+	rebalancingBlobAccessMigrationsFailed    = rebalancingBlobAccessMigrations.WithLabelValues("Failed")
+	rebalancingBlobAccessMigrationsDropped   = rebalancingBlobAccessMigrations.WithLabelValues("Dropped")
+
+	rebalancingBlobAccessMigrationsPending = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "rebalancing_blob_access_migrations_pending",
+			Help:      "Number of objects that are currently queued or being migrated from the previous to the current placement.",
+		})
+)
+
+var rebalancingBlobAccessMigrationQueueMetrics = replicationQueueMetrics{
+	succeeded: rebalancingBlobAccessMigrationsSucceeded,
+	notFound:  rebalancingBlobAccessMigrationsNotFound,
+	failed:    rebalancingBlobAccessMigrationsFailed,
+	dropped:   rebalancingBlobAccessMigrationsDropped,
+	pending:   rebalancingBlobAccessMigrationsPending,
+}
+
+type rebalancingBlobAccess struct {
+	current    blobstore.BlobAccess
+	previous   blobstore.BlobAccess
+	migrations *replicationQueue
+}
+
+// NewRebalancingBlobAccess creates a BlobAccess that can be used while
+// the shard map of ShardingBlobAccess is changed (e.g., by adding
+// shards or changing weights). It keeps both the current and the
+// previous placement of objects. Requests are served from the current
+// placement first, falling back to the previous placement.
+//
+// Objects that are found at the previous placement but not at the
+// current placement are migrated asynchronously, using the provided
+// BlobReplicator. Migrations are deduplicated and placed in a queue
+// of bounded size that is processed by a bounded number of
+// goroutines.
+//
+// Migrations are only triggered by requests. There is no metric
+// reporting the fraction of the keyspace that has not been migrated
+// yet, as that would require enumerating the previous placement. A
+// ratio of objects found at the previous placement of zero thus only
+// indicates that objects that are being requested have been migrated.
+// Objects that are not requested while rebalancing are lost when the
+// previous shard map is removed.
+func NewRebalancingBlobAccess(current blobstore.BlobAccess, previous blobstore.BlobAccess, replicator mirrored.BlobReplicator, maximumQueuedMigrations int, migrationConcurrency int) blobstore.BlobAccess {
+	rebalancingBlobAccessPrometheusMetrics.Do(func() {
+		prometheus.MustRegister(rebalancingBlobAccessFindMissingObjects)
+		prometheus.MustRegister(rebalancingBlobAccessGetFallbacks)
+		prometheus.MustRegister(rebalancingBlobAccessMigrations)
+		prometheus.MustRegister(rebalancingBlobAccessMigrationsPending)
+	})
+
+	return &rebalancingBlobAccess{
+		current:  current,
+		previous: previous,
+		migrations: newReplicationQueue(
+			replicator,
+			maximumQueuedMigrations,
+			migrationConcurrency,
+			"Migration to current placement",
+			&rebalancingBlobAccessMigrationQueueMetrics),
+	}
+}
+
+func (ba *rebalancingBlobAccess) Get(ctx context.Context, digest digest.Digest) buffer.Buffer {
+	return buffer.WithErrorHandler(
+		ba.current.Get(ctx, digest),
+		&rebalancingErrorHandler{
+			blobAccess: ba,
+			context:    ctx,
+			digest:     digest,
+		})
+}
+
+func (ba *rebalancingBlobAccess) Put(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+	return ba.current.Put(ctx, digest, b)
+}
+
+func (ba *rebalancingBlobAccess) FindMissing(ctx context.Context, digests digest.Set) (digest.Set, error) {
+	missingFromCurrent, err := ba.current.FindMissing(ctx, digests)
+	if err != nil {
+		return digest.EmptySet, util.StatusWrap(err, "Current placement")
+	}
+	rebalancingBlobAccessFindMissingObjectsCurrent.Add(float64(digests.Length() - missingFromCurrent.Length()))
+	if missingFromCurrent.Empty() {
+		return digest.EmptySet, nil
+	}
+
+	missingFromBoth, err := ba.previous.FindMissing(ctx, missingFromCurrent)
+	if err != nil {
+		return digest.EmptySet, util.StatusWrap(err, "Previous placement")
+	}
+	onlyPrevious, _, _ := digest.GetDifferenceAndIntersection(missingFromCurrent, missingFromBoth)
+	rebalancingBlobAccessFindMissingObjectsPrevious.Add(float64(onlyPrevious.Length()))
+	rebalancingBlobAccessFindMissingObjectsMissing.Add(float64(missingFromBoth.Length()))
+	if !onlyPrevious.Empty() {
+		ba.migrations.enqueue(onlyPrevious)
+	}
+	return missingFromBoth, nil
+}
+
+func (ba *rebalancingBlobAccess) Enumerate(ctx context.Context, cursor string, enumerateFunc blobstore.EnumerateFunc) error {
+	// Objects that have been migrated are present at both
+	// placements, causing them to be reported twice. Objects that
+	// have not been migrated yet are only reported as part of the
+	// previous placement.
+	return blobstore.EnumerateSequentially(ctx, []blobstore.BlobAccess{ba.current, ba.previous}, cursor, enumerateFunc)
+}
+
+type rebalancingErrorHandler struct {
+	blobAccess        *rebalancingBlobAccess
+	context           context.Context
+	digest            digest.Digest
+	attemptedPrevious bool
+	failedPrevious    bool
+}
+
+func (eh *rebalancingErrorHandler) OnError(err error) (buffer.Buffer, error) {
+	if eh.attemptedPrevious {
+		eh.failedPrevious = true
+	}
+	if status.Code(err) != codes.NotFound {
+		if eh.attemptedPrevious {
+			return nil, util.StatusWrap(err, "Previous placement")
+		}
+		return nil, util.StatusWrap(err, "Current placement")
+	}
+	if eh.attemptedPrevious {
+		return nil, err
+	}
+
+	// The object is absent at the current placement. It may not
+	// have been migrated yet.
+	eh.attemptedPrevious = true
+	rebalancingBlobAccessGetFallbacks.Inc()
+	return eh.blobAccess.previous.Get(eh.context, eh.digest), nil
+}
+
+func (eh *rebalancingErrorHandler) Done() {
+	// Only migrate the object if it was found at the previous
+	// placement.
+	if eh.attemptedPrevious && !eh.failedPrevious {
+		eh.blobAccess.migrations.enqueue(digest.NewSetBuilder().Add(eh.digest).Build())
+	}
+}
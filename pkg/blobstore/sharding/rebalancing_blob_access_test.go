@@ -0,0 +1,184 @@
+package sharding_test
+
+import (
+	"context"
+	"testing"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/sharding"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRebalancingBlobAccessGet(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	ctx := context.Background()
+	current := mock.NewMockBlobAccess(ctrl)
+	previous := mock.NewMockBlobAccess(ctrl)
+	replicator := mock.NewMockBlobReplicator(ctrl)
+	blobAccess := sharding.NewRebalancingBlobAccess(current, previous, replicator, 100, 1)
+
+	blobDigest := digest.MustNewDigest("default", remoteexecution.DigestFunction_MD5, "8b1a9953c4611296a827abf8c47804d7", 5)
+
+	t.Run("CurrentPlacement", func(t *testing.T) {
+		current.EXPECT().Get(ctx, blobDigest).Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+
+		data, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(10)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+	})
+
+	t.Run("PreviousPlacement", func(t *testing.T) {
+		// The object has not been migrated yet. It should be
+		// returned from its previous placement, while being
+		// migrated in the background.
+		current.EXPECT().Get(ctx, blobDigest).Return(buffer.NewBufferFromError(status.Error(codes.NotFound, "Object not found")))
+		previous.EXPECT().Get(ctx, blobDigest).Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+		migrated := make(chan struct{})
+		replicator.EXPECT().ReplicateMultiple(gomock.Any(), digest.NewSetBuilder().Add(blobDigest).Build()).DoAndReturn(
+			func(ctx context.Context, digests digest.Set) error {
+				close(migrated)
+				return nil
+			})
+
+		data, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(10)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+		<-migrated
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		// The object is absent at both placements. The error
+		// of the previous placement should be returned. There
+		// is nothing to migrate.
+		current.EXPECT().Get(ctx, blobDigest).Return(buffer.NewBufferFromError(status.Error(codes.NotFound, "Object not found")))
+		previous.EXPECT().Get(ctx, blobDigest).Return(buffer.NewBufferFromError(status.Error(codes.NotFound, "Object not found")))
+
+		_, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(10)
+		require.Equal(t, status.Error(codes.NotFound, "Object not found"), err)
+	})
+
+	t.Run("CurrentPlacementFailure", func(t *testing.T) {
+		// Errors other than NotFound should not cause the
+		// previous placement to be consulted.
+		current.EXPECT().Get(ctx, blobDigest).Return(buffer.NewBufferFromError(status.Error(codes.Unavailable, "Server offline")))
+
+		_, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(10)
+		require.Equal(t, status.Error(codes.Unavailable, "Current placement: Server offline"), err)
+	})
+}
+
+func TestRebalancingBlobAccessFindMissing(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	ctx := context.Background()
+	current := mock.NewMockBlobAccess(ctrl)
+	previous := mock.NewMockBlobAccess(ctrl)
+	replicator := mock.NewMockBlobReplicator(ctrl)
+	blobAccess := sharding.NewRebalancingBlobAccess(current, previous, replicator, 100, 1)
+
+	digestCurrent := digest.MustNewDigest("default", remoteexecution.DigestFunction_MD5, "00000000000000000000000000000001", 1)
+	digestPrevious := digest.MustNewDigest("default", remoteexecution.DigestFunction_MD5, "00000000000000000000000000000002", 2)
+	digestMissing := digest.MustNewDigest("default", remoteexecution.DigestFunction_MD5, "00000000000000000000000000000003", 3)
+
+	t.Run("Success", func(t *testing.T) {
+		// Objects only present at the previous placement should
+		// be reported as present and be migrated.
+		current.EXPECT().FindMissing(ctx, digest.NewSetBuilder().Add(digestCurrent).Add(digestPrevious).Add(digestMissing).Build()).
+			Return(digest.NewSetBuilder().Add(digestPrevious).Add(digestMissing).Build(), nil)
+		previous.EXPECT().FindMissing(ctx, digest.NewSetBuilder().Add(digestPrevious).Add(digestMissing).Build()).
+			Return(digest.NewSetBuilder().Add(digestMissing).Build(), nil)
+		migrated := make(chan struct{})
+		replicator.EXPECT().ReplicateMultiple(gomock.Any(), digest.NewSetBuilder().Add(digestPrevious).Build()).DoAndReturn(
+			func(ctx context.Context, digests digest.Set) error {
+				close(migrated)
+				return nil
+			})
+
+		missing, err := blobAccess.FindMissing(ctx, digest.NewSetBuilder().Add(digestCurrent).Add(digestPrevious).Add(digestMissing).Build())
+		require.NoError(t, err)
+		require.Equal(t, digest.NewSetBuilder().Add(digestMissing).Build(), missing)
+		<-migrated
+	})
+
+	t.Run("AllPresentAtCurrentPlacement", func(t *testing.T) {
+		// The previous placement should not be consulted if
+		// all objects have already been migrated.
+		current.EXPECT().FindMissing(ctx, digest.NewSetBuilder().Add(digestCurrent).Build()).Return(digest.EmptySet, nil)
+
+		missing, err := blobAccess.FindMissing(ctx, digest.NewSetBuilder().Add(digestCurrent).Build())
+		require.NoError(t, err)
+		require.Equal(t, digest.EmptySet, missing)
+	})
+
+	t.Run("PreviousPlacementFailure", func(t *testing.T) {
+		current.EXPECT().FindMissing(ctx, digest.NewSetBuilder().Add(digestPrevious).Build()).
+			Return(digest.NewSetBuilder().Add(digestPrevious).Build(), nil)
+		previous.EXPECT().FindMissing(ctx, digest.NewSetBuilder().Add(digestPrevious).Build()).
+			Return(digest.EmptySet, status.Error(codes.Unavailable, "Server offline"))
+
+		_, err := blobAccess.FindMissing(ctx, digest.NewSetBuilder().Add(digestPrevious).Build())
+		require.Equal(t, status.Error(codes.Unavailable, "Previous placement: Server offline"), err)
+	})
+}
+
+func TestRebalancingBlobAccessMigrationQueue(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	ctx := context.Background()
+	current := mock.NewMockBlobAccess(ctrl)
+	previous := mock.NewMockBlobAccess(ctrl)
+	replicator := mock.NewMockBlobReplicator(ctrl)
+	blobAccess := sharding.NewRebalancingBlobAccess(current, previous, replicator, 2, 1)
+
+	digest1 := digest.MustNewDigest("default", remoteexecution.DigestFunction_MD5, "00000000000000000000000000000001", 1)
+	digest2 := digest.MustNewDigest("default", remoteexecution.DigestFunction_MD5, "00000000000000000000000000000002", 2)
+	digest3 := digest.MustNewDigest("default", remoteexecution.DigestFunction_MD5, "00000000000000000000000000000003", 3)
+	findMissingOnlyPrevious := func(digests digest.Set) {
+		current.EXPECT().FindMissing(ctx, digests).Return(digests, nil)
+		previous.EXPECT().FindMissing(ctx, digests).Return(digest.EmptySet, nil)
+		missing, err := blobAccess.FindMissing(ctx, digests)
+		require.NoError(t, err)
+		require.Equal(t, digest.EmptySet, missing)
+	}
+
+	// Let the first migration block, so that successive requests
+	// observe it as being in progress.
+	migrationStarted := make(chan struct{})
+	migrationUnblock := make(chan struct{})
+	replicator.EXPECT().ReplicateMultiple(gomock.Any(), digest.NewSetBuilder().Add(digest1).Build()).DoAndReturn(
+		func(ctx context.Context, digests digest.Set) error {
+			close(migrationStarted)
+			<-migrationUnblock
+			return nil
+		})
+	findMissingOnlyPrevious(digest.NewSetBuilder().Add(digest1).Build())
+	<-migrationStarted
+
+	// Requesting the same object again should not cause it to be
+	// migrated once more.
+	findMissingOnlyPrevious(digest.NewSetBuilder().Add(digest1).Build())
+
+	// With a queue size of two, only one of the other objects can
+	// be queued. The other one should be dropped.
+	findMissingOnlyPrevious(digest.NewSetBuilder().Add(digest2).Add(digest3).Build())
+
+	migrationDone := make(chan struct{})
+	replicator.EXPECT().ReplicateMultiple(gomock.Any(), digest.NewSetBuilder().Add(digest2).Build()).DoAndReturn(
+		func(ctx context.Context, digests digest.Set) error {
+			close(migrationDone)
+			return nil
+		})
+	close(migrationUnblock)
+	<-migrationDone
+}
@@ -0,0 +1,480 @@
+package sharding
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/mirrored"
+	"github.com/buildbarn/bb-storage/pkg/clock"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	replicatedShardingBlobAccessPrometheusMetrics sync.Once
+
+	replicatedShardingBlobAccessRepairs = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "replicated_sharding_blob_access_repairs_total",
+			Help:      "Number of objects for which repairing a replica completed, or that were dropped due to the repair queue being full.",
+		},
+		[]string{"result"})
+
+	replicatedShardingBlobAccessRepairsPending = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "replicated_sharding_blob_access_repairs_pending",
+			Help:      "Number of objects that are currently queued or being repaired.",
+		})
+
+	replicatedShardingBlobAccessRepairQueueMetrics = replicationQueueMetrics{
+		succeeded: replicatedShardingBlobAccessRepairs.WithLabelValues("Succeeded"),
+		notFound:  replicatedShardingBlobAccessRepairs.WithLabelValues("NotFound"),
+		failed:    replicatedShardingBlobAccessRepairs.WithLabelValues("Failed"),
+		dropped:   replicatedShardingBlobAccessRepairs.WithLabelValues("Dropped"),
+		pending:   replicatedShardingBlobAccessRepairsPending,
+	}
+)
+
+// replicatedShardingReadChunkSizeBytes is the size of the first chunk
+// of data that is read from replicas by Get(), to determine which
+// replica responds first.
+const replicatedShardingReadChunkSizeBytes = 64 * 1024
+
+type replicatedShardingBlobAccess struct {
+	backends           []blobstore.BlobAccess
+	repairs            []*replicationQueue
+	shardPermuter      ShardPermuter
+	storageType        blobstore.StorageType
+	hashInitialization uint64
+	replicationFactor  int
+	writeQuorum        int
+	clock              clock.Clock
+	hedgeDelay         time.Duration
+}
+
+// NewReplicatedShardingBlobAccess is an adapter for BlobAccess that
+// partitions requests across backends by hashing the digest, like
+// ShardingBlobAccess. Instead of storing every object on a single
+// backend, it is stored on replicationFactor distinct backends
+// returned by the ShardPermuter. This permits the system to remain
+// available when individual backends are unavailable.
+//
+// Get() calls are hedged. They are sent to the first replica, and to
+// the next replica if the previous one fails or does not start
+// returning data within hedgeDelay. Data is returned from the replica
+// that responds first. Put() calls are sent to all replicas, and
+// succeed if at least writeQuorum replicas succeed. FindMissing() only
+// reports objects as missing if none of the replicas have it.
+//
+// When an object is found to be absent on one of its replicas, it is
+// repaired asynchronously by calling into the BlobReplicator
+// corresponding with that replica. Repairs are deduplicated and placed
+// in a queue of bounded size per replica. Replicas that return
+// corrupted data are repaired in the same way, and the object is
+// obtained from the remaining replicas instead, if the data had not
+// been handed out to the caller yet. The list of replicators may be
+// nil, in which case no repairs are performed.
+//
+// The number of undrained backends must be at least equal to the
+// replication factor.
+func NewReplicatedShardingBlobAccess(backends []blobstore.BlobAccess, replicators []mirrored.BlobReplicator, shardPermuter ShardPermuter, storageType blobstore.StorageType, hashInitialization uint64, replicationFactor int, writeQuorum int, clock clock.Clock, hedgeDelay time.Duration, maximumQueuedRepairs int, repairConcurrency int) blobstore.BlobAccess {
+	replicatedShardingBlobAccessPrometheusMetrics.Do(func() {
+		prometheus.MustRegister(replicatedShardingBlobAccessRepairs)
+		prometheus.MustRegister(replicatedShardingBlobAccessRepairsPending)
+	})
+
+	var repairs []*replicationQueue
+	if replicators != nil {
+		repairs = make([]*replicationQueue, len(replicators))
+		for i, replicator := range replicators {
+			if replicator != nil {
+				repairs[i] = newReplicationQueue(
+					replicator,
+					maximumQueuedRepairs,
+					repairConcurrency,
+					fmt.Sprintf("Repair of shard %d", i),
+					&replicatedShardingBlobAccessRepairQueueMetrics)
+			}
+		}
+	}
+	return &replicatedShardingBlobAccess{
+		backends:           backends,
+		repairs:            repairs,
+		shardPermuter:      shardPermuter,
+		storageType:        storageType,
+		hashInitialization: hashInitialization,
+		replicationFactor:  replicationFactor,
+		writeQuorum:        writeQuorum,
+		clock:              clock,
+		hedgeDelay:         hedgeDelay,
+	}
+}
+
+// repair queues objects for asynchronous repair on a replica.
+func (ba *replicatedShardingBlobAccess) repair(replica int, digests digest.Set) {
+	if ba.repairs != nil {
+		ba.repairs[replica].enqueue(digests)
+	}
+}
+
+// getReplicas returns the indices of the distinct undrained backends
+// on which an object is stored.
+func (ba *replicatedShardingBlobAccess) getReplicas(digest digest.Digest) []int {
+	replicas := make([]int, 0, ba.replicationFactor)
+	ba.shardPermuter.GetShard(getDigestHash(ba.storageType, ba.hashInitialization, digest), func(index int) bool {
+		if ba.backends[index] == nil {
+			return true
+		}
+		for _, replica := range replicas {
+			if replica == index {
+				return true
+			}
+		}
+		replicas = append(replicas, index)
+		return len(replicas) < ba.replicationFactor
+	})
+	return replicas
+}
+
+// replicatedGetResult contains the outcome of reading the first chunk
+// of data of an object from one of its replicas.
+type replicatedGetResult struct {
+	attempt   int
+	sizeBytes int64
+	r         buffer.ChunkReader
+	chunk     []byte
+	err       error
+}
+
+func (ba *replicatedShardingBlobAccess) Get(ctx context.Context, blobDigest digest.Digest) buffer.Buffer {
+	replicas := ba.getReplicas(blobDigest)
+	errorHandler := &replicatedShardingErrorHandler{
+		blobAccess: ba,
+		context:    ctx,
+		digest:     blobDigest,
+		replicas:   replicas,
+	}
+	return buffer.WithErrorHandler(
+		ba.getFromReplicas(ctx, blobDigest, replicas, errorHandler.repairCorruptedReplica),
+		errorHandler)
+}
+
+// getFromReplicas performs a hedged read of an object against a list
+// of replicas. The provided function is called if the data returned
+// by the replica that responded first turns out to be corrupted.
+func (ba *replicatedShardingBlobAccess) getFromReplicas(ctx context.Context, blobDigest digest.Digest, replicas []int, repairCorruptedReplica func(replica int) error) buffer.Buffer {
+	results := make(chan replicatedGetResult, len(replicas))
+	cancels := make([]context.CancelFunc, 0, len(replicas))
+	startAttempt := func() {
+		attempt := len(cancels)
+		attemptCtx, cancel := context.WithCancel(ctx)
+		cancels = append(cancels, cancel)
+		go func() {
+			b := ba.backends[replicas[attempt]].Get(attemptCtx, blobDigest)
+			sizeBytes, err := b.GetSizeBytes()
+			if err != nil {
+				b.Discard()
+				results <- replicatedGetResult{attempt: attempt, err: err}
+				return
+			}
+			r := b.ToChunkReader(0, replicatedShardingReadChunkSizeBytes)
+			chunk, err := r.Read()
+			if err != nil && err != io.EOF {
+				r.Close()
+			}
+			results <- replicatedGetResult{
+				attempt:   attempt,
+				sizeBytes: sizeBytes,
+				r:         r,
+				chunk:     chunk,
+				err:       err,
+			}
+		}()
+	}
+
+	startAttempt()
+	outstanding := 1
+	var missingReplicas []int
+	var lastErr, notFoundErr error
+	for outstanding > 0 {
+		// Consult the next replica if the ones consulted so far
+		// don't respond in time.
+		var timer clock.Timer
+		var hedge <-chan time.Time
+		if len(cancels) < len(replicas) {
+			timer, hedge = ba.clock.NewTimer(ba.hedgeDelay)
+		}
+		select {
+		case <-hedge:
+			startAttempt()
+			outstanding++
+		case result := <-results:
+			if timer != nil {
+				timer.Stop()
+			}
+			outstanding--
+			if result.err == nil || result.err == io.EOF {
+				// Data was obtained from this replica.
+				// Abandon the other attempts and repair
+				// replicas that lack the object.
+				for attempt, cancel := range cancels {
+					if attempt != result.attempt {
+						cancel()
+					}
+				}
+				go func(outstanding int) {
+					for i := 0; i < outstanding; i++ {
+						if result := <-results; result.err == nil || result.err == io.EOF {
+							result.r.Close()
+						}
+					}
+				}(outstanding)
+				for _, replica := range missingReplicas {
+					ba.repair(replica, digest.NewSetBuilder().Add(blobDigest).Build())
+				}
+				replica := replicas[result.attempt]
+				return ba.storageType.NewBufferFromReader(
+					blobDigest,
+					buffer.NewChunkReaderBackedReader(&replicatedChunkReader{
+						ChunkReader: result.r,
+						firstChunk:  result.chunk,
+						firstErr:    result.err,
+						cancel:      cancels[result.attempt],
+					}),
+					result.sizeBytes,
+					buffer.Reparable(blobDigest, func() error {
+						return repairCorruptedReplica(replica)
+					}))
+			}
+
+			cancels[result.attempt]()
+			replica := replicas[result.attempt]
+			if status.Code(result.err) == codes.NotFound {
+				missingReplicas = append(missingReplicas, replica)
+				notFoundErr = result.err
+			} else {
+				lastErr = util.StatusWrapf(result.err, "Shard %d", replica)
+			}
+			if len(cancels) < len(replicas) {
+				startAttempt()
+				outstanding++
+			}
+		}
+	}
+
+	// None of the replicas returned the object. Prefer returning
+	// errors other than NotFound, as the object may be stored on
+	// one of the replicas that could not be contacted.
+	if lastErr != nil {
+		return buffer.NewBufferFromError(lastErr)
+	}
+	return buffer.NewBufferFromError(notFoundErr)
+}
+
+// replicatedShardingErrorHandler is used by
+// replicatedShardingBlobAccess.Get() to retry reads against other
+// replicas if the replica that responded first returned corrupted
+// data.
+type replicatedShardingErrorHandler struct {
+	blobAccess *replicatedShardingBlobAccess
+	context    context.Context
+	digest     digest.Digest
+	replicas   []int
+	corrupted  bool
+}
+
+// repairCorruptedReplica is invoked when a replica returned data not
+// matching the digest. It queues the replica for repair and excludes
+// it from subsequent attempts.
+func (eh *replicatedShardingErrorHandler) repairCorruptedReplica(replica int) error {
+	remaining := make([]int, 0, len(eh.replicas))
+	for _, r := range eh.replicas {
+		if r != replica {
+			remaining = append(remaining, r)
+		}
+	}
+	eh.replicas = remaining
+	eh.corrupted = true
+
+	ba := eh.blobAccess
+	if ba.repairs == nil {
+		return status.Errorf(codes.Unimplemented, "No replicator configured to repair shard %d", replica)
+	}
+	ba.repair(replica, digest.NewSetBuilder().Add(eh.digest).Build())
+	return nil
+}
+
+func (eh *replicatedShardingErrorHandler) OnError(err error) (buffer.Buffer, error) {
+	if !eh.corrupted || len(eh.replicas) == 0 {
+		return nil, err
+	}
+	eh.corrupted = false
+	return eh.blobAccess.getFromReplicas(eh.context, eh.digest, eh.replicas, eh.repairCorruptedReplica), nil
+}
+
+func (eh *replicatedShardingErrorHandler) Done() {}
+
+// replicatedChunkReader is returned by
+// replicatedShardingBlobAccess.Get(). It returns the first chunk of
+// data that was read from the replica that responded first, followed
+// by the remaining data of that replica.
+type replicatedChunkReader struct {
+	buffer.ChunkReader
+	firstChunk []byte
+	firstErr   error
+	cancel     context.CancelFunc
+	readFirst  bool
+}
+
+func (r *replicatedChunkReader) Read() ([]byte, error) {
+	if !r.readFirst {
+		r.readFirst = true
+		return r.firstChunk, r.firstErr
+	}
+	return r.ChunkReader.Read()
+}
+
+func (r *replicatedChunkReader) Close() {
+	r.ChunkReader.Close()
+	r.cancel()
+}
+
+func (ba *replicatedShardingBlobAccess) Put(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+	// Store the object on all replicas in parallel.
+	replicas := ba.getReplicas(digest)
+	errs := make(chan error, len(replicas))
+	for i, replica := range replicas {
+		bReplica := b
+		if i < len(replicas)-1 {
+			bReplica, b = b.CloneStream()
+		}
+		go func(replica int, b buffer.Buffer) {
+			if err := ba.backends[replica].Put(ctx, digest, b); err != nil {
+				errs <- util.StatusWrapf(err, "Shard %d", replica)
+			} else {
+				errs <- nil
+			}
+		}(replica, bReplica)
+	}
+
+	// Writes only need to succeed on a quorum of replicas.
+	// Replicas that failed are repaired once the object is
+	// accessed.
+	var firstErr error
+	succeeded := 0
+	for range replicas {
+		if err := <-errs; err == nil {
+			succeeded++
+		} else if firstErr == nil {
+			firstErr = err
+		}
+	}
+	if succeeded < ba.writeQuorum {
+		return firstErr
+	}
+	return nil
+}
+
+type replicatedFindMissingResults struct {
+	replica int
+	missing map[digest.Digest]struct{}
+	err     error
+}
+
+func (ba *replicatedShardingBlobAccess) FindMissing(ctx context.Context, digests digest.Set) (digest.Set, error) {
+	// Determine which backends to contact.
+	replicasPerDigest := make(map[digest.Digest][]int, digests.Length())
+	digestsPerBackend := map[int]digest.SetBuilder{}
+	for _, blobDigest := range digests.Items() {
+		replicas := ba.getReplicas(blobDigest)
+		replicasPerDigest[blobDigest] = replicas
+		for _, replica := range replicas {
+			if _, ok := digestsPerBackend[replica]; !ok {
+				digestsPerBackend[replica] = digest.NewSetBuilder()
+			}
+			digestsPerBackend[replica].Add(blobDigest)
+		}
+	}
+
+	// Asynchronously call FindMissing() on backends.
+	resultsChan := make(chan replicatedFindMissingResults, len(digestsPerBackend))
+	for replica, digests := range digestsPerBackend {
+		go func(replica int, digests digest.SetBuilder) {
+			missing, err := ba.backends[replica].FindMissing(ctx, digests.Build())
+			results := replicatedFindMissingResults{replica: replica, err: err}
+			if err == nil {
+				results.missing = make(map[digest.Digest]struct{}, missing.Length())
+				for _, blobDigest := range missing.Items() {
+					results.missing[blobDigest] = struct{}{}
+				}
+			}
+			resultsChan <- results
+		}(replica, digests)
+	}
+	resultsPerBackend := make(map[int]replicatedFindMissingResults, len(digestsPerBackend))
+	for i := 0; i < len(digestsPerBackend); i++ {
+		results := <-resultsChan
+		resultsPerBackend[results.replica] = results
+	}
+
+	// Objects are only missing if none of the replicas have them.
+	// Objects are present if at least one replica has them, in
+	// which case replicas that lack them are repaired.
+	missingDigests := digest.NewSetBuilder()
+	digestsToRepair := map[int]digest.SetBuilder{}
+	for _, blobDigest := range digests.Items() {
+		var missingReplicas []int
+		present := false
+		var err error
+		for _, replica := range replicasPerDigest[blobDigest] {
+			results := resultsPerBackend[replica]
+			if results.err != nil {
+				err = util.StatusWrapf(results.err, "Shard %d", replica)
+			} else if _, ok := results.missing[blobDigest]; ok {
+				missingReplicas = append(missingReplicas, replica)
+			} else {
+				present = true
+			}
+		}
+		if present {
+			if ba.repairs != nil {
+				for _, replica := range missingReplicas {
+					if _, ok := digestsToRepair[replica]; !ok {
+						digestsToRepair[replica] = digest.NewSetBuilder()
+					}
+					digestsToRepair[replica].Add(blobDigest)
+				}
+			}
+		} else if len(missingReplicas) > 0 {
+			missingDigests.Add(blobDigest)
+		} else {
+			// None of the replicas could be contacted.
+			return digest.EmptySet, err
+		}
+	}
+
+	// Repair replicas asynchronously, as the objects are still
+	// present on other replicas.
+	for replica, digests := range digestsToRepair {
+		ba.repair(replica, digests.Build())
+	}
+	return missingDigests.Build(), nil
+}
+
+func (ba *replicatedShardingBlobAccess) Enumerate(ctx context.Context, cursor string, enumerateFunc blobstore.EnumerateFunc) error {
+	// Objects are reported once for every shard storing a replica.
+	return blobstore.EnumerateSequentially(ctx, ba.backends, cursor, enumerateFunc)
+}
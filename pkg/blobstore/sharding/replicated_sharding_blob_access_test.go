@@ -0,0 +1,343 @@
+package sharding_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/mirrored"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/sharding"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// newMockShardPermuter creates a ShardPermuter that always returns the
+// same sequence of shards, regardless of the hash.
+func newMockShardPermuter(ctrl *gomock.Controller, shards ...int) sharding.ShardPermuter {
+	shardPermuter := mock.NewMockShardPermuter(ctrl)
+	shardPermuter.EXPECT().GetShard(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(hash uint64, selector sharding.ShardSelector) {
+			for _, shard := range shards {
+				if !selector(shard) {
+					return
+				}
+			}
+			panic("Shard permuter exhausted")
+		}).AnyTimes()
+	return shardPermuter
+}
+
+// expectHedgeTimer expects the creation of a hedge timer by Get(). If
+// fire is set, the timer expires immediately. Otherwise it is expected
+// to be stopped.
+func expectHedgeTimer(ctrl *gomock.Controller, clock *mock.MockClock, fire bool) {
+	timer := mock.NewMockTimer(ctrl)
+	timerChannel := make(chan time.Time, 1)
+	if fire {
+		timerChannel <- time.Unix(1000, 0)
+	} else {
+		timer.EXPECT().Stop().Return(true)
+	}
+	clock.EXPECT().NewTimer(time.Second).Return(timer, timerChannel)
+}
+
+func TestReplicatedShardingBlobAccessGet(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	ctx := context.Background()
+	backend0 := mock.NewMockBlobAccess(ctrl)
+	backend2 := mock.NewMockBlobAccess(ctrl)
+	replicator0 := mock.NewMockBlobReplicator(ctrl)
+	replicator2 := mock.NewMockBlobReplicator(ctrl)
+	clock := mock.NewMockClock(ctrl)
+	blobAccess := sharding.NewReplicatedShardingBlobAccess(
+		[]blobstore.BlobAccess{backend0, nil, backend2},
+		[]mirrored.BlobReplicator{replicator0, nil, replicator2},
+		// Shard 1 is drained, while shard 0 is returned twice.
+		// The object should be stored on shards 2 and 0.
+		newMockShardPermuter(ctrl, 1, 2, 2, 0),
+		blobstore.CASStorageType,
+		0,
+		2,
+		2,
+		clock,
+		time.Second,
+		100,
+		1)
+
+	blobDigest := digest.MustNewDigest("default", remoteexecution.DigestFunction_MD5, "8b1a9953c4611296a827abf8c47804d7", 5)
+	blobDigests := digest.NewSetBuilder().Add(blobDigest).Build()
+
+	t.Run("FirstReplica", func(t *testing.T) {
+		expectHedgeTimer(ctrl, clock, false)
+		backend2.EXPECT().Get(gomock.Any(), blobDigest).Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+
+		data, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(10)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+	})
+
+	t.Run("SecondReplica", func(t *testing.T) {
+		// If the first replica is unavailable, the object
+		// should be obtained from the second replica.
+		expectHedgeTimer(ctrl, clock, false)
+		backend2.EXPECT().Get(gomock.Any(), blobDigest).Return(buffer.NewBufferFromError(status.Error(codes.Unavailable, "Server offline")))
+		backend0.EXPECT().Get(gomock.Any(), blobDigest).Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+
+		data, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(10)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+	})
+
+	t.Run("Hedged", func(t *testing.T) {
+		// If the first replica does not respond within the
+		// hedge delay, the second replica should be consulted
+		// in parallel. The request against the first replica
+		// should be cancelled once the second replica responds.
+		expectHedgeTimer(ctrl, clock, true)
+		cancelled := make(chan struct{})
+		backend2.EXPECT().Get(gomock.Any(), blobDigest).DoAndReturn(
+			func(ctx context.Context, blobDigest digest.Digest) buffer.Buffer {
+				<-ctx.Done()
+				close(cancelled)
+				return buffer.NewBufferFromError(util.StatusFromContext(ctx))
+			})
+		backend0.EXPECT().Get(gomock.Any(), blobDigest).Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+
+		data, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(10)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+		<-cancelled
+	})
+
+	t.Run("Repair", func(t *testing.T) {
+		// If the first replica does not contain the object, it
+		// should be obtained from the second replica. The first
+		// replica should be repaired asynchronously.
+		expectHedgeTimer(ctrl, clock, false)
+		backend2.EXPECT().Get(gomock.Any(), blobDigest).Return(buffer.NewBufferFromError(status.Error(codes.NotFound, "Object not found")))
+		backend0.EXPECT().Get(gomock.Any(), blobDigest).Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+		repaired := make(chan struct{})
+		replicator2.EXPECT().ReplicateMultiple(gomock.Any(), blobDigests).DoAndReturn(
+			func(ctx context.Context, digests digest.Set) error {
+				close(repaired)
+				return nil
+			})
+
+		data, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(10)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+		<-repaired
+	})
+
+	t.Run("CorruptedReplica", func(t *testing.T) {
+		// If the first replica returns corrupted data, it
+		// should be repaired. The object should be obtained
+		// from the second replica instead.
+		corruptedDigest := digest.MustNewDigest("default", remoteexecution.DigestFunction_MD5, "d1bf93299de1b68e6d382c893bf1215f", 5)
+		expectHedgeTimer(ctrl, clock, false)
+		backend2.EXPECT().Get(gomock.Any(), corruptedDigest).Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+		backend0.EXPECT().Get(gomock.Any(), corruptedDigest).Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hallo")))
+		repaired := make(chan struct{})
+		replicator2.EXPECT().ReplicateMultiple(gomock.Any(), digest.NewSetBuilder().Add(corruptedDigest).Build()).DoAndReturn(
+			func(ctx context.Context, digests digest.Set) error {
+				close(repaired)
+				return nil
+			})
+
+		data, err := blobAccess.Get(ctx, corruptedDigest).ToByteSlice(10)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hallo"), data)
+		<-repaired
+	})
+
+	t.Run("AllReplicasCorrupted", func(t *testing.T) {
+		// If all replicas return corrupted data, all of them
+		// should be repaired and an error should be returned.
+		corruptedDigest := digest.MustNewDigest("default", remoteexecution.DigestFunction_MD5, "14ab8485b1a592211d78a61b5f73a510", 5)
+		corruptedDigests := digest.NewSetBuilder().Add(corruptedDigest).Build()
+		expectHedgeTimer(ctrl, clock, false)
+		backend2.EXPECT().Get(gomock.Any(), corruptedDigest).Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+		backend0.EXPECT().Get(gomock.Any(), corruptedDigest).Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+		repaired0 := make(chan struct{})
+		replicator0.EXPECT().ReplicateMultiple(gomock.Any(), corruptedDigests).DoAndReturn(
+			func(ctx context.Context, digests digest.Set) error {
+				close(repaired0)
+				return nil
+			})
+		repaired2 := make(chan struct{})
+		replicator2.EXPECT().ReplicateMultiple(gomock.Any(), corruptedDigests).DoAndReturn(
+			func(ctx context.Context, digests digest.Set) error {
+				close(repaired2)
+				return nil
+			})
+
+		_, err := blobAccess.Get(ctx, corruptedDigest).ToByteSlice(10)
+		require.Equal(t, status.Error(codes.Internal, "Buffer has checksum 8b1a9953c4611296a827abf8c47804d7, while 14ab8485b1a592211d78a61b5f73a510 was expected"), err)
+		<-repaired0
+		<-repaired2
+	})
+
+	t.Run("AllReplicasMissing", func(t *testing.T) {
+		// Objects absent on all replicas cannot be repaired.
+		expectHedgeTimer(ctrl, clock, false)
+		backend2.EXPECT().Get(gomock.Any(), blobDigest).Return(buffer.NewBufferFromError(status.Error(codes.NotFound, "Object not found")))
+		backend0.EXPECT().Get(gomock.Any(), blobDigest).Return(buffer.NewBufferFromError(status.Error(codes.NotFound, "Object not found")))
+
+		_, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(10)
+		require.Equal(t, status.Error(codes.NotFound, "Object not found"), err)
+	})
+
+	t.Run("AllReplicasUnavailable", func(t *testing.T) {
+		expectHedgeTimer(ctrl, clock, false)
+		backend2.EXPECT().Get(gomock.Any(), blobDigest).Return(buffer.NewBufferFromError(status.Error(codes.Unavailable, "Server offline")))
+		backend0.EXPECT().Get(gomock.Any(), blobDigest).Return(buffer.NewBufferFromError(status.Error(codes.Unavailable, "Server offline")))
+
+		_, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(10)
+		require.Equal(t, status.Error(codes.Unavailable, "Shard 0: Server offline"), err)
+	})
+}
+
+func TestReplicatedShardingBlobAccessPut(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	ctx := context.Background()
+	backend0 := mock.NewMockBlobAccess(ctrl)
+	backend1 := mock.NewMockBlobAccess(ctrl)
+	newBlobAccess := func(writeQuorum int) blobstore.BlobAccess {
+		return sharding.NewReplicatedShardingBlobAccess(
+			[]blobstore.BlobAccess{backend0, backend1},
+			nil,
+			newMockShardPermuter(ctrl, 1, 0),
+			blobstore.CASStorageType,
+			0,
+			2,
+			writeQuorum,
+			mock.NewMockClock(ctrl),
+			time.Second,
+			100,
+			1)
+	}
+
+	blobDigest := digest.MustNewDigest("default", remoteexecution.DigestFunction_MD5, "8b1a9953c4611296a827abf8c47804d7", 5)
+	putSuccess := func(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+		data, err := b.ToByteSlice(10)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+		return nil
+	}
+	putFailure := func(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+		b.Discard()
+		return status.Error(codes.Unavailable, "Server offline")
+	}
+
+	t.Run("Success", func(t *testing.T) {
+		backend0.EXPECT().Put(ctx, blobDigest, gomock.Any()).DoAndReturn(putSuccess)
+		backend1.EXPECT().Put(ctx, blobDigest, gomock.Any()).DoAndReturn(putSuccess)
+
+		require.NoError(t, newBlobAccess(2).Put(ctx, blobDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+	})
+
+	t.Run("PartialFailureWithoutQuorum", func(t *testing.T) {
+		// By default, writes need to succeed on all replicas.
+		backend0.EXPECT().Put(ctx, blobDigest, gomock.Any()).DoAndReturn(putFailure)
+		backend1.EXPECT().Put(ctx, blobDigest, gomock.Any()).DoAndReturn(putSuccess)
+
+		require.Equal(
+			t,
+			status.Error(codes.Unavailable, "Shard 0: Server offline"),
+			newBlobAccess(2).Put(ctx, blobDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+	})
+
+	t.Run("PartialFailureWithQuorum", func(t *testing.T) {
+		// With a lower write quorum, writes may fail on some
+		// of the replicas.
+		backend0.EXPECT().Put(ctx, blobDigest, gomock.Any()).DoAndReturn(putFailure)
+		backend1.EXPECT().Put(ctx, blobDigest, gomock.Any()).DoAndReturn(putSuccess)
+
+		require.NoError(t, newBlobAccess(1).Put(ctx, blobDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+	})
+
+	t.Run("CompleteFailure", func(t *testing.T) {
+		backend0.EXPECT().Put(ctx, blobDigest, gomock.Any()).DoAndReturn(putFailure)
+		backend1.EXPECT().Put(ctx, blobDigest, gomock.Any()).DoAndReturn(putFailure)
+
+		err := newBlobAccess(1).Put(ctx, blobDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+		require.Equal(t, codes.Unavailable, status.Code(err))
+	})
+}
+
+func TestReplicatedShardingBlobAccessFindMissing(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	ctx := context.Background()
+	backend0 := mock.NewMockBlobAccess(ctrl)
+	backend1 := mock.NewMockBlobAccess(ctrl)
+	replicator0 := mock.NewMockBlobReplicator(ctrl)
+	replicator1 := mock.NewMockBlobReplicator(ctrl)
+	blobAccess := sharding.NewReplicatedShardingBlobAccess(
+		[]blobstore.BlobAccess{backend0, backend1},
+		[]mirrored.BlobReplicator{replicator0, replicator1},
+		newMockShardPermuter(ctrl, 1, 0),
+		blobstore.CASStorageType,
+		0,
+		2,
+		2,
+		mock.NewMockClock(ctrl),
+		time.Second,
+		100,
+		1)
+
+	digestPresent := digest.MustNewDigest("default", remoteexecution.DigestFunction_MD5, "00000000000000000000000000000001", 1)
+	digestMissing := digest.MustNewDigest("default", remoteexecution.DigestFunction_MD5, "00000000000000000000000000000002", 2)
+	allDigests := digest.NewSetBuilder().Add(digestPresent).Add(digestMissing).Build()
+
+	t.Run("Repair", func(t *testing.T) {
+		// Objects present on only one replica should be
+		// reported as present and be repaired asynchronously.
+		backend0.EXPECT().FindMissing(ctx, allDigests).Return(allDigests, nil)
+		backend1.EXPECT().FindMissing(ctx, allDigests).Return(digest.NewSetBuilder().Add(digestMissing).Build(), nil)
+		repaired := make(chan struct{})
+		replicator0.EXPECT().ReplicateMultiple(gomock.Any(), digest.NewSetBuilder().Add(digestPresent).Build()).DoAndReturn(
+			func(ctx context.Context, digests digest.Set) error {
+				close(repaired)
+				return nil
+			})
+
+		missing, err := blobAccess.FindMissing(ctx, allDigests)
+		require.NoError(t, err)
+		require.Equal(t, digest.NewSetBuilder().Add(digestMissing).Build(), missing)
+		<-repaired
+	})
+
+	t.Run("PartialFailure", func(t *testing.T) {
+		// Unavailability of a single replica should not cause
+		// the call to fail.
+		backend0.EXPECT().FindMissing(ctx, allDigests).Return(digest.EmptySet, status.Error(codes.Unavailable, "Server offline"))
+		backend1.EXPECT().FindMissing(ctx, allDigests).Return(digest.NewSetBuilder().Add(digestMissing).Build(), nil)
+
+		missing, err := blobAccess.FindMissing(ctx, allDigests)
+		require.NoError(t, err)
+		require.Equal(t, digest.NewSetBuilder().Add(digestMissing).Build(), missing)
+	})
+
+	t.Run("CompleteFailure", func(t *testing.T) {
+		backend0.EXPECT().FindMissing(ctx, allDigests).Return(digest.EmptySet, status.Error(codes.Unavailable, "Server offline"))
+		backend1.EXPECT().FindMissing(ctx, allDigests).Return(digest.EmptySet, status.Error(codes.Unavailable, "Server offline"))
+
+		_, err := blobAccess.FindMissing(ctx, allDigests)
+		require.Equal(t, codes.Unavailable, status.Code(err))
+	})
+}
@@ -0,0 +1,124 @@
+package sharding
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/mirrored"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// replicationQueueBatchSize is the maximum number of objects that is
+// passed to a single call to BlobReplicator.ReplicateMultiple().
+const replicationQueueBatchSize = 100
+
+// replicationQueueMetrics contains the Prometheus metrics that are
+// updated by replicationQueue.
+type replicationQueueMetrics struct {
+	succeeded prometheus.Counter
+	notFound  prometheus.Counter
+	failed    prometheus.Counter
+	dropped   prometheus.Counter
+	pending   prometheus.Gauge
+}
+
+// replicationQueue replicates objects in the background using a
+// BlobReplicator. Objects are deduplicated and placed in a queue of
+// bounded size that is processed by a bounded number of goroutines.
+// Goroutines are only launched while the queue is non-empty.
+//
+// Replication is performed in the background, as it should complete
+// even if the client that triggered it disconnects.
+type replicationQueue struct {
+	replicator       mirrored.BlobReplicator
+	maximumQueueSize int
+	concurrency      int
+	description      string
+	metrics          *replicationQueueMetrics
+
+	lock sync.Mutex
+	// Objects that are queued or are being replicated. These are
+	// tracked to prevent objects from being replicated redundantly.
+	pending map[digest.Digest]struct{}
+	queued  []digest.Digest
+	workers int
+}
+
+func newReplicationQueue(replicator mirrored.BlobReplicator, maximumQueueSize int, concurrency int, description string, metrics *replicationQueueMetrics) *replicationQueue {
+	return &replicationQueue{
+		replicator:       replicator,
+		maximumQueueSize: maximumQueueSize,
+		concurrency:      concurrency,
+		description:      description,
+		metrics:          metrics,
+		pending:          map[digest.Digest]struct{}{},
+	}
+}
+
+// enqueue objects for replication. Objects that are already queued are
+// ignored. Objects are discarded if the queue is full.
+func (q *replicationQueue) enqueue(digests digest.Set) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	for _, blobDigest := range digests.Items() {
+		if _, ok := q.pending[blobDigest]; ok {
+			continue
+		}
+		if len(q.pending) >= q.maximumQueueSize {
+			q.metrics.dropped.Inc()
+			continue
+		}
+		q.pending[blobDigest] = struct{}{}
+		q.queued = append(q.queued, blobDigest)
+		q.metrics.pending.Inc()
+	}
+	for q.workers < q.concurrency && q.workers < len(q.queued) {
+		q.workers++
+		go q.process()
+	}
+}
+
+// process takes batches of objects from the queue and replicates
+// them, until the queue is empty.
+func (q *replicationQueue) process() {
+	q.lock.Lock()
+	for len(q.queued) > 0 {
+		n := len(q.queued)
+		if n > replicationQueueBatchSize {
+			n = replicationQueueBatchSize
+		}
+		digestsBuilder := digest.NewSetBuilder()
+		for _, blobDigest := range q.queued[:n] {
+			digestsBuilder.Add(blobDigest)
+		}
+		digests := digestsBuilder.Build()
+		q.queued = q.queued[n:]
+		q.lock.Unlock()
+
+		count := float64(digests.Length())
+		if err := q.replicator.ReplicateMultiple(context.Background(), digests); err == nil {
+			q.metrics.succeeded.Add(count)
+		} else if status.Code(err) == codes.NotFound {
+			// Objects may have been removed from the
+			// source in the meantime.
+			q.metrics.notFound.Add(count)
+		} else {
+			log.Printf("%s: Failed to replicate %d objects: %s", q.description, digests.Length(), err)
+			q.metrics.failed.Add(count)
+		}
+
+		q.lock.Lock()
+		for _, blobDigest := range digests.Items() {
+			delete(q.pending, blobDigest)
+		}
+		q.metrics.pending.Sub(count)
+	}
+	q.workers--
+	q.lock.Unlock()
+}
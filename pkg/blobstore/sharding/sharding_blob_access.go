@@ -27,17 +27,22 @@ func NewShardingBlobAccess(backends []blobstore.BlobAccess, shardPermuter ShardP
 	}
 }
 
-func (ba *shardingBlobAccess) getBackend(digest digest.Digest) blobstore.BlobAccess {
+// getDigestHash computes the hash of a digest that is provided to the
+// ShardPermuter.
+func getDigestHash(storageType blobstore.StorageType, hashInitialization uint64, digest digest.Digest) uint64 {
 	// Hash the key using FNV-1a.
-	h := ba.hashInitialization
-	for _, c := range ba.storageType.GetDigestKey(digest) {
+	h := hashInitialization
+	for _, c := range storageType.GetDigestKey(digest) {
 		h ^= uint64(c)
 		h *= 1099511628211
 	}
+	return h
+}
 
+func (ba *shardingBlobAccess) getBackend(digest digest.Digest) blobstore.BlobAccess {
 	// Keep requesting shards until matching one that is undrained.
 	var backend blobstore.BlobAccess
-	ba.shardPermuter.GetShard(h, func(index int) bool {
+	ba.shardPermuter.GetShard(getDigestHash(ba.storageType, ba.hashInitialization, digest), func(index int) bool {
 		backend = ba.backends[index]
 		return backend == nil
 	})
@@ -97,3 +102,7 @@ func (ba *shardingBlobAccess) FindMissing(ctx context.Context, digests digest.Se
 	}
 	return digest.GetUnion(missingDigestSets), nil
 }
+
+func (ba *shardingBlobAccess) Enumerate(ctx context.Context, cursor string, enumerateFunc blobstore.EnumerateFunc) error {
+	return blobstore.EnumerateSequentially(ctx, ba.backends, cursor, enumerateFunc)
+}
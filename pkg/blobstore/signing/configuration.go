@@ -0,0 +1,125 @@
+package signing
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+
+	"github.com/buildbarn/bb-storage/pkg/auth"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/clock"
+	pb "github.com/buildbarn/bb-storage/pkg/proto/configuration/signing"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/golang/protobuf/ptypes"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// parsePEMBlock extracts the contents of the first PEM block of a
+// given type.
+func parsePEMBlock(data string, blockType string) ([]byte, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, status.Error(codes.InvalidArgument, "Key does not contain a PEM block")
+	}
+	if block.Type != blockType {
+		return nil, status.Errorf(codes.InvalidArgument, "Key contains a PEM block of type %#v, while %#v was expected", block.Type, blockType)
+	}
+	return block.Bytes, nil
+}
+
+func newSigningKeyFromConfiguration(configuration *pb.SigningKey) (Key, error) {
+	switch kind := configuration.Key.(type) {
+	case *pb.SigningKey_HmacSha256Secret:
+		return NewHMACSHA256Key(kind.HmacSha256Secret), nil
+	case *pb.SigningKey_Ed25519PrivateKey:
+		der, err := parsePEMBlock(kind.Ed25519PrivateKey, "PRIVATE KEY")
+		if err != nil {
+			return nil, err
+		}
+		privateKey, err := x509.ParsePKCS8PrivateKey(der)
+		if err != nil {
+			return nil, util.StatusWrapWithCode(err, codes.InvalidArgument, "Failed to parse private key")
+		}
+		ed25519Key, ok := privateKey.(ed25519.PrivateKey)
+		if !ok {
+			return nil, status.Error(codes.InvalidArgument, "Private key is not an Ed25519 key")
+		}
+		return NewEd25519PrivateKey(ed25519Key), nil
+	default:
+		return nil, status.Error(codes.InvalidArgument, "Signing key does not contain a key")
+	}
+}
+
+func newVerificationKeyFromConfiguration(configuration *pb.VerificationKey) (VerificationKey, error) {
+	var verificationKey VerificationKey
+	switch kind := configuration.Key.(type) {
+	case *pb.VerificationKey_HmacSha256Secret:
+		verificationKey.Key = NewHMACSHA256Key(kind.HmacSha256Secret)
+	case *pb.VerificationKey_Ed25519PublicKey:
+		der, err := parsePEMBlock(kind.Ed25519PublicKey, "PUBLIC KEY")
+		if err != nil {
+			return VerificationKey{}, err
+		}
+		publicKey, err := x509.ParsePKIXPublicKey(der)
+		if err != nil {
+			return VerificationKey{}, util.StatusWrapWithCode(err, codes.InvalidArgument, "Failed to parse public key")
+		}
+		ed25519Key, ok := publicKey.(ed25519.PublicKey)
+		if !ok {
+			return VerificationKey{}, status.Error(codes.InvalidArgument, "Public key is not an Ed25519 key")
+		}
+		verificationKey.Key = NewEd25519PublicKey(ed25519Key)
+	default:
+		return VerificationKey{}, status.Error(codes.InvalidArgument, "Verification key does not contain a key")
+	}
+	if configuration.ExpirationTime != nil {
+		expirationTime, err := ptypes.Timestamp(configuration.ExpirationTime)
+		if err != nil {
+			return VerificationKey{}, util.StatusWrapWithCode(err, codes.InvalidArgument, "Invalid expiration time")
+		}
+		verificationKey.ExpirationTime = expirationTime
+	}
+	return verificationKey, nil
+}
+
+// NewSigningBlobAccessFromConfiguration creates a SigningBlobAccess
+// based on keys specified in a configuration file.
+func NewSigningBlobAccessFromConfiguration(actionCache blobstore.BlobAccess, configuration *pb.ActionResultSigningConfiguration, clock clock.Clock, maximumMessageSizeBytes int) (blobstore.BlobAccess, error) {
+	var signingKeyID string
+	var signingKey Key
+	var trustedWriters auth.Authorizer
+	if signingKeyConfiguration := configuration.SigningKey; signingKeyConfiguration != nil {
+		var err error
+		signingKeyID = signingKeyConfiguration.KeyId
+		signingKey, err = newSigningKeyFromConfiguration(signingKeyConfiguration)
+		if err != nil {
+			return nil, util.StatusWrapf(err, "Signing key %#v", signingKeyID)
+		}
+
+		// Don't fall back to permitting all clients, as that
+		// would cause untrusted writes to be signed.
+		if configuration.TrustedWriters == nil {
+			return nil, status.Error(codes.InvalidArgument, "Trusted writers must be specified if a signing key is provided")
+		}
+		trustedWriters, err = auth.NewAuthorizerFromConfiguration(configuration.TrustedWriters)
+		if err != nil {
+			return nil, util.StatusWrap(err, "Failed to create trusted writers authorizer")
+		}
+	}
+
+	verificationKeys := make(map[string]VerificationKey, len(configuration.VerificationKeys))
+	for _, verificationKeyConfiguration := range configuration.VerificationKeys {
+		keyID := verificationKeyConfiguration.KeyId
+		if _, ok := verificationKeys[keyID]; ok || (signingKey != nil && keyID == signingKeyID) {
+			return nil, status.Errorf(codes.InvalidArgument, "Multiple keys with identifier %#v", keyID)
+		}
+		verificationKey, err := newVerificationKeyFromConfiguration(verificationKeyConfiguration)
+		if err != nil {
+			return nil, util.StatusWrapf(err, "Verification key %#v", keyID)
+		}
+		verificationKeys[keyID] = verificationKey
+	}
+	return NewSigningBlobAccess(actionCache, signingKeyID, signingKey, trustedWriters, verificationKeys, clock, maximumMessageSizeBytes), nil
+}
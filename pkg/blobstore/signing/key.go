@@ -0,0 +1,83 @@
+package signing
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Key is a cryptographic key that can be used to compute and validate
+// signatures of entries stored in the Action Cache.
+type Key interface {
+	Sign(message []byte) ([]byte, error)
+	Verify(message []byte, signature []byte) bool
+}
+
+type hmacSHA256Key struct {
+	secret []byte
+}
+
+// NewHMACSHA256Key creates a Key that computes signatures using
+// HMAC-SHA256. As the secret is shared between all parties, any party
+// capable of validating signatures is also capable of creating them.
+func NewHMACSHA256Key(secret []byte) Key {
+	return hmacSHA256Key{
+		secret: secret,
+	}
+}
+
+func (k hmacSHA256Key) Sign(message []byte) ([]byte, error) {
+	mac := hmac.New(sha256.New, k.secret)
+	mac.Write(message)
+	return mac.Sum(nil), nil
+}
+
+func (k hmacSHA256Key) Verify(message []byte, signature []byte) bool {
+	expectedSignature, _ := k.Sign(message)
+	return hmac.Equal(signature, expectedSignature)
+}
+
+type ed25519PrivateKey struct {
+	privateKey ed25519.PrivateKey
+}
+
+// NewEd25519PrivateKey creates a Key that computes signatures using
+// Ed25519.
+func NewEd25519PrivateKey(privateKey ed25519.PrivateKey) Key {
+	return ed25519PrivateKey{
+		privateKey: privateKey,
+	}
+}
+
+func (k ed25519PrivateKey) Sign(message []byte) ([]byte, error) {
+	return ed25519.Sign(k.privateKey, message), nil
+}
+
+func (k ed25519PrivateKey) Verify(message []byte, signature []byte) bool {
+	return ed25519.Verify(k.privateKey.Public().(ed25519.PublicKey), message, signature)
+}
+
+type ed25519PublicKey struct {
+	publicKey ed25519.PublicKey
+}
+
+// NewEd25519PublicKey creates a Key that validates signatures computed
+// using Ed25519. It cannot be used to compute signatures. This permits
+// instances that only serve reads to validate signatures without
+// having access to the private key.
+func NewEd25519PublicKey(publicKey ed25519.PublicKey) Key {
+	return ed25519PublicKey{
+		publicKey: publicKey,
+	}
+}
+
+func (k ed25519PublicKey) Sign(message []byte) ([]byte, error) {
+	return nil, status.Error(codes.FailedPrecondition, "Ed25519 public keys cannot be used to compute signatures")
+}
+
+func (k ed25519PublicKey) Verify(message []byte, signature []byte) bool {
+	return ed25519.Verify(k.publicKey, message, signature)
+}
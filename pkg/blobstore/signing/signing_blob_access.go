@@ -0,0 +1,199 @@
+package signing
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/buildbarn/bb-storage/pkg/auth"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/clock"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	ac_pb "github.com/buildbarn/bb-storage/pkg/proto/ac"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	signingBlobAccessPrometheusMetrics sync.Once
+
+	signingBlobAccessVerifications = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "signing_blob_access_verifications_total",
+			Help:      "Number of Action Cache entries of which the signature was verified, partitioned by result.",
+		},
+		[]string{"result"})
+	signingBlobAccessVerificationsValid            = signingBlobAccessVerifications.WithLabelValues("Valid")
+	signingBlobAccessVerificationsUnsigned         = signingBlobAccessVerifications.WithLabelValues("Unsigned")
+	signingBlobAccessVerificationsUnknownKey       = signingBlobAccessVerifications.WithLabelValues("UnknownKey")
+	signingBlobAccessVerificationsExpiredKey       = signingBlobAccessVerifications.WithLabelValues("ExpiredKey")
+	signingBlobAccessVerificationsInvalidSignature = signingBlobAccessVerifications.WithLabelValues("InvalidSignature")
+
+	signingBlobAccessWrites = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "signing_blob_access_writes_total",
+			Help:      "Number of attempts to write Action Cache entries, partitioned by whether they were signed or rejected.",
+		},
+		[]string{"result"})
+	signingBlobAccessWritesSigned   = signingBlobAccessWrites.WithLabelValues("Signed")
+	signingBlobAccessWritesRejected = signingBlobAccessWrites.WithLabelValues("Rejected")
+)
+
+// VerificationKey is a key that is accepted when verifying the
+// signature of an entry stored in the Action Cache.
+type VerificationKey struct {
+	Key Key
+
+	// Time at which signatures made with this key are no longer
+	// accepted. The key never expires if the zero value is used.
+	ExpirationTime time.Time
+}
+
+type signingBlobAccess struct {
+	blobstore.BlobAccess
+	signingKeyID            string
+	signingKey              Key
+	trustedWriters          auth.Authorizer
+	verificationKeys        map[string]VerificationKey
+	clock                   clock.Clock
+	maximumMessageSizeBytes int
+}
+
+// NewSigningBlobAccess creates a wrapper around an Action Cache (AC)
+// that signs ActionResult messages as they are written, and verifies
+// their signature as they are read. Entries that are unsigned, signed
+// with an unknown or expired key, or that have been tampered with are
+// treated as if non-existent. This prevents cache poisoning by parties
+// that have write access to the underlying storage, but not to the
+// signing key.
+//
+// Only clients that are permitted to perform OperationActionCacheWrite
+// by the trusted writers Authorizer may write ActionResult messages,
+// which are then signed. Writes by other clients are rejected, as
+// storing them would overwrite entries written by trusted clients.
+// This ensures that clients that are merely permitted to write to the
+// Action Cache cannot poison it.
+//
+// The signing key is implicitly accepted for verification. If no
+// signing key is provided, writes are rejected.
+func NewSigningBlobAccess(actionCache blobstore.BlobAccess, signingKeyID string, signingKey Key, trustedWriters auth.Authorizer, verificationKeys map[string]VerificationKey, clock clock.Clock, maximumMessageSizeBytes int) blobstore.BlobAccess {
+	signingBlobAccessPrometheusMetrics.Do(func() {
+		prometheus.MustRegister(signingBlobAccessVerifications)
+		prometheus.MustRegister(signingBlobAccessWrites)
+	})
+
+	allVerificationKeys := make(map[string]VerificationKey, len(verificationKeys)+1)
+	for keyID, key := range verificationKeys {
+		allVerificationKeys[keyID] = key
+	}
+	if signingKey != nil {
+		allVerificationKeys[signingKeyID] = VerificationKey{Key: signingKey}
+	}
+	return &signingBlobAccess{
+		BlobAccess:              actionCache,
+		signingKeyID:            signingKeyID,
+		signingKey:              signingKey,
+		trustedWriters:          trustedWriters,
+		verificationKeys:        allVerificationKeys,
+		clock:                   clock,
+		maximumMessageSizeBytes: maximumMessageSizeBytes,
+	}
+}
+
+// getSignedMessage returns the message over which the signature of an
+// ActionResult is computed. The digest of the action is included, so
+// that signed entries cannot be copied to other actions.
+func getSignedMessage(actionDigest digest.Digest, actionResultData []byte) []byte {
+	key := actionDigest.GetKey(digest.KeyWithInstance)
+	message := make([]byte, 0, len(key)+1+len(actionResultData))
+	message = append(message, key...)
+	message = append(message, 0)
+	return append(message, actionResultData...)
+}
+
+func (ba *signingBlobAccess) Get(ctx context.Context, actionDigest digest.Digest) buffer.Buffer {
+	data, err := ba.BlobAccess.Get(ctx, actionDigest).ToByteSlice(ba.maximumMessageSizeBytes)
+	if err != nil {
+		return buffer.NewBufferFromError(err)
+	}
+
+	// Extract the trailer containing the signature. It is only
+	// accepted if it is placed at the end of the entry, so that the
+	// serialized ActionResult preceding it can be recovered.
+	var trailer ac_pb.SignedActionResultTrailer
+	if err := proto.Unmarshal(data, &trailer); err != nil || trailer.Signature == nil {
+		signingBlobAccessVerificationsUnsigned.Inc()
+		return buffer.NewBufferFromError(status.Error(codes.NotFound, "Action result is not signed"))
+	}
+	// Fields of the ActionResult are retained as unknown fields of
+	// the trailer. Omit them when reconstructing it.
+	trailerData, err := proto.Marshal(&ac_pb.SignedActionResultTrailer{
+		Signature: trailer.Signature,
+	})
+	if err != nil || !bytes.HasSuffix(data, trailerData) {
+		signingBlobAccessVerificationsUnsigned.Inc()
+		return buffer.NewBufferFromError(status.Error(codes.NotFound, "Action result does not end with a signature"))
+	}
+	actionResultData := data[:len(data)-len(trailerData)]
+
+	keyID := trailer.Signature.KeyId
+	verificationKey, ok := ba.verificationKeys[keyID]
+	if !ok {
+		signingBlobAccessVerificationsUnknownKey.Inc()
+		return buffer.NewBufferFromError(status.Errorf(codes.NotFound, "Action result is signed with unknown key %#v", keyID))
+	}
+	if !verificationKey.ExpirationTime.IsZero() && !ba.clock.Now().Before(verificationKey.ExpirationTime) {
+		signingBlobAccessVerificationsExpiredKey.Inc()
+		return buffer.NewBufferFromError(status.Errorf(codes.NotFound, "Action result is signed with key %#v, which expired at %s", keyID, verificationKey.ExpirationTime.UTC().Format(time.RFC3339)))
+	}
+	if !verificationKey.Key.Verify(getSignedMessage(actionDigest, actionResultData), trailer.Signature.Signature) {
+		signingBlobAccessVerificationsInvalidSignature.Inc()
+		return buffer.NewBufferFromError(status.Errorf(codes.NotFound, "Action result has an invalid signature for key %#v", keyID))
+	}
+	signingBlobAccessVerificationsValid.Inc()
+	return buffer.NewACBufferFromByteSlice(actionResultData, buffer.Irreparable)
+}
+
+func (ba *signingBlobAccess) Put(ctx context.Context, actionDigest digest.Digest, b buffer.Buffer) error {
+	if ba.signingKey == nil {
+		b.Discard()
+		return status.Error(codes.PermissionDenied, "This instance is not permitted to write action results, as no signing key is configured")
+	}
+	if err := ba.trustedWriters.Authorize(ctx, actionDigest.GetInstance(), auth.OperationActionCacheWrite); err != nil {
+		b.Discard()
+		signingBlobAccessWritesRejected.Inc()
+		return util.StatusWrapWithCode(err, codes.PermissionDenied, "Client is not trusted to write signed action results")
+	}
+	actionResultData, err := b.ToByteSlice(ba.maximumMessageSizeBytes)
+	if err != nil {
+		return err
+	}
+	signingBlobAccessWritesSigned.Inc()
+	signature, err := ba.signingKey.Sign(getSignedMessage(actionDigest, actionResultData))
+	if err != nil {
+		return util.StatusWrap(err, "Failed to sign action result")
+	}
+	trailerData, err := proto.Marshal(&ac_pb.SignedActionResultTrailer{
+		Signature: &ac_pb.ActionResultSignature{
+			KeyId:     ba.signingKeyID,
+			Signature: signature,
+		},
+	})
+	if err != nil {
+		return util.StatusWrap(err, "Failed to marshal signature")
+	}
+	signedData := make([]byte, 0, len(actionResultData)+len(trailerData))
+	signedData = append(signedData, actionResultData...)
+	signedData = append(signedData, trailerData...)
+	return ba.BlobAccess.Put(ctx, actionDigest, buffer.NewACBufferFromByteSlice(signedData, buffer.UserProvided))
+}
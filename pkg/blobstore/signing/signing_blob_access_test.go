@@ -0,0 +1,162 @@
+package signing_test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"testing"
+	"time"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/auth"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/signing"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestSigningBlobAccess(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+	defer ctrl.Finish()
+
+	baseActionCache := mock.NewMockBlobAccess(ctrl)
+	trustedWriters := mock.NewMockAuthorizer(ctrl)
+	clock := mock.NewMockClock(ctrl)
+	privateKey := ed25519.NewKeyFromSeed([]byte("0123456789abcdef0123456789abcdef"))
+	blobAccess := signing.NewSigningBlobAccess(
+		baseActionCache,
+		"key2",
+		signing.NewEd25519PrivateKey(privateKey),
+		trustedWriters,
+		map[string]signing.VerificationKey{
+			"key1": {
+				Key:            signing.NewHMACSHA256Key([]byte("secret")),
+				ExpirationTime: time.Unix(2000, 0),
+			},
+		},
+		clock,
+		10000)
+
+	actionDigest := digest.MustNewDigest("default", remoteexecution.DigestFunction_MD5, "8b1a9953c4611296a827abf8c47804d7", 123)
+	otherActionDigest := digest.MustNewDigest("default", remoteexecution.DigestFunction_MD5, "5d41402abc4b2a76b9719d911017c592", 123)
+	actionResult := &remoteexecution.ActionResult{
+		ExitCode: 42,
+	}
+
+	// Sign an ActionResult with the current signing key and capture
+	// the data that is written to storage.
+	var signedData []byte
+	trustedWriters.EXPECT().Authorize(ctx, "default", auth.OperationActionCacheWrite)
+	baseActionCache.EXPECT().Put(ctx, actionDigest, gomock.Any()).DoAndReturn(
+		func(ctx context.Context, actionDigest digest.Digest, b buffer.Buffer) error {
+			data, err := b.ToByteSlice(10000)
+			require.NoError(t, err)
+			signedData = data
+			return nil
+		})
+	require.NoError(t, blobAccess.Put(ctx, actionDigest, buffer.NewACBufferFromActionResult(actionResult, buffer.UserProvided)))
+
+	// Signed entries should remain valid ActionResult messages.
+	storedActionResult, err := buffer.NewACBufferFromByteSlice(signedData, buffer.Irreparable).ToActionResult(10000)
+	require.NoError(t, err)
+	require.Equal(t, int32(42), storedActionResult.ExitCode)
+
+	t.Run("Valid", func(t *testing.T) {
+		baseActionCache.EXPECT().Get(ctx, actionDigest).Return(buffer.NewACBufferFromByteSlice(signedData, buffer.Irreparable))
+
+		returnedActionResult, err := blobAccess.Get(ctx, actionDigest).ToActionResult(10000)
+		require.NoError(t, err)
+		require.True(t, proto.Equal(actionResult, returnedActionResult))
+	})
+
+	t.Run("Unsigned", func(t *testing.T) {
+		baseActionCache.EXPECT().Get(ctx, actionDigest).Return(buffer.NewACBufferFromActionResult(actionResult, buffer.Irreparable))
+
+		_, err := blobAccess.Get(ctx, actionDigest).ToActionResult(10000)
+		require.Equal(t, status.Error(codes.NotFound, "Action result is not signed"), err)
+	})
+
+	t.Run("UntrustedWriter", func(t *testing.T) {
+		// ActionResults written by clients that are not trusted
+		// should be rejected, instead of overwriting the entry
+		// written by a trusted client.
+		trustedWriters.EXPECT().Authorize(ctx, "default", auth.OperationActionCacheWrite).
+			Return(status.Error(codes.PermissionDenied, "Identity \"alice\" is not permitted to perform operation ActionCacheWrite on instance \"default\""))
+		require.Equal(
+			t,
+			status.Error(codes.PermissionDenied, "Client is not trusted to write signed action results: Identity \"alice\" is not permitted to perform operation ActionCacheWrite on instance \"default\""),
+			blobAccess.Put(ctx, actionDigest, buffer.NewACBufferFromActionResult(&remoteexecution.ActionResult{ExitCode: 1}, buffer.UserProvided)))
+
+		baseActionCache.EXPECT().Get(ctx, actionDigest).Return(buffer.NewACBufferFromByteSlice(signedData, buffer.Irreparable))
+		returnedActionResult, err := blobAccess.Get(ctx, actionDigest).ToActionResult(10000)
+		require.NoError(t, err)
+		require.True(t, proto.Equal(actionResult, returnedActionResult))
+	})
+
+	t.Run("Tampered", func(t *testing.T) {
+		tamperedData := append([]byte(nil), signedData...)
+		tamperedData[1]++
+		baseActionCache.EXPECT().Get(ctx, actionDigest).Return(buffer.NewACBufferFromByteSlice(tamperedData, buffer.Irreparable))
+
+		_, err := blobAccess.Get(ctx, actionDigest).ToActionResult(10000)
+		require.Equal(t, status.Error(codes.NotFound, "Action result has an invalid signature for key \"key2\""), err)
+	})
+
+	t.Run("OtherAction", func(t *testing.T) {
+		// Signed entries should not be usable for other actions.
+		baseActionCache.EXPECT().Get(ctx, otherActionDigest).Return(buffer.NewACBufferFromByteSlice(signedData, buffer.Irreparable))
+
+		_, err := blobAccess.Get(ctx, otherActionDigest).ToActionResult(10000)
+		require.Equal(t, status.Error(codes.NotFound, "Action result has an invalid signature for key \"key2\""), err)
+	})
+
+	t.Run("PreviousKey", func(t *testing.T) {
+		// Entries signed with the previous key should be
+		// accepted until the key expires.
+		var previousSignedData []byte
+		baseActionCache.EXPECT().Put(ctx, actionDigest, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, actionDigest digest.Digest, b buffer.Buffer) error {
+				data, err := b.ToByteSlice(10000)
+				require.NoError(t, err)
+				previousSignedData = data
+				return nil
+			})
+		previousBlobAccess := signing.NewSigningBlobAccess(baseActionCache, "key1", signing.NewHMACSHA256Key([]byte("secret")), auth.AllowAuthorizer, nil, clock, 10000)
+		require.NoError(t, previousBlobAccess.Put(ctx, actionDigest, buffer.NewACBufferFromActionResult(actionResult, buffer.UserProvided)))
+
+		baseActionCache.EXPECT().Get(ctx, actionDigest).Return(buffer.NewACBufferFromByteSlice(previousSignedData, buffer.Irreparable))
+		clock.EXPECT().Now().Return(time.Unix(1999, 0))
+		returnedActionResult, err := blobAccess.Get(ctx, actionDigest).ToActionResult(10000)
+		require.NoError(t, err)
+		require.True(t, proto.Equal(actionResult, returnedActionResult))
+
+		baseActionCache.EXPECT().Get(ctx, actionDigest).Return(buffer.NewACBufferFromByteSlice(previousSignedData, buffer.Irreparable))
+		clock.EXPECT().Now().Return(time.Unix(2000, 0))
+		_, err = blobAccess.Get(ctx, actionDigest).ToActionResult(10000)
+		require.Equal(t, status.Error(codes.NotFound, "Action result is signed with key \"key1\", which expired at 1970-01-01T00:33:20Z"), err)
+	})
+
+	t.Run("UnknownKey", func(t *testing.T) {
+		otherBlobAccess := signing.NewSigningBlobAccess(baseActionCache, "", nil, nil, nil, clock, 10000)
+		baseActionCache.EXPECT().Get(ctx, actionDigest).Return(buffer.NewACBufferFromByteSlice(signedData, buffer.Irreparable))
+
+		_, err := otherBlobAccess.Get(ctx, actionDigest).ToActionResult(10000)
+		require.Equal(t, status.Error(codes.NotFound, "Action result is signed with unknown key \"key2\""), err)
+	})
+
+	t.Run("NoSigningKey", func(t *testing.T) {
+		// Instances without a signing key should not permit
+		// writes, as the resulting entries would be unusable.
+		otherBlobAccess := signing.NewSigningBlobAccess(baseActionCache, "", nil, nil, nil, clock, 10000)
+
+		require.Equal(
+			t,
+			status.Error(codes.PermissionDenied, "This instance is not permitted to write action results, as no signing key is configured"),
+			otherBlobAccess.Put(ctx, actionDigest, buffer.NewACBufferFromActionResult(actionResult, buffer.UserProvided)))
+	})
+}
@@ -0,0 +1,63 @@
+package blobstore
+
+import (
+	"context"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+)
+
+type sizeAgnosticBlobAccess struct {
+	blobAccess BlobAccess
+}
+
+// NewSizeAgnosticBlobAccess creates a decorator for BlobAccess that
+// sets the size of all digests to zero before forwarding requests to
+// the backend. This causes objects to be identified by hash only.
+//
+// This decorator may be placed in front of the Action Cache, so that
+// entries written by clients that identify actions by hash only (e.g.,
+// Bazel's HTTP caching protocol) can be shared with clients that
+// provide full digests (e.g., REv2 gRPC clients). It must not be used
+// for the Content Addressable Storage, as the size of an object is
+// needed to validate its contents.
+func NewSizeAgnosticBlobAccess(blobAccess BlobAccess) BlobAccess {
+	return &sizeAgnosticBlobAccess{
+		blobAccess: blobAccess,
+	}
+}
+
+func stripSize(d digest.Digest) digest.Digest {
+	return digest.MustNewDigest(d.GetInstance(), d.GetExplicitDigestFunction(), d.GetHashString(), 0)
+}
+
+func (ba *sizeAgnosticBlobAccess) Get(ctx context.Context, digest digest.Digest) buffer.Buffer {
+	return ba.blobAccess.Get(ctx, stripSize(digest))
+}
+
+func (ba *sizeAgnosticBlobAccess) Put(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+	return ba.blobAccess.Put(ctx, stripSize(digest), b)
+}
+
+func (ba *sizeAgnosticBlobAccess) FindMissing(ctx context.Context, digests digest.Set) (digest.Set, error) {
+	// Multiple digests may only differ in size. Keep track of all
+	// of them, so that they can all be reported as missing.
+	originals := map[digest.Digest][]digest.Digest{}
+	strippedDigests := digest.NewSetBuilder()
+	for _, original := range digests.Items() {
+		stripped := stripSize(original)
+		originals[stripped] = append(originals[stripped], original)
+		strippedDigests.Add(stripped)
+	}
+	strippedMissing, err := ba.blobAccess.FindMissing(ctx, strippedDigests.Build())
+	if err != nil {
+		return digest.EmptySet, err
+	}
+	missing := digest.NewSetBuilder()
+	for _, stripped := range strippedMissing.Items() {
+		for _, original := range originals[stripped] {
+			missing.Add(original)
+		}
+	}
+	return missing.Build(), nil
+}
@@ -0,0 +1,78 @@
+package blobstore_test
+
+import (
+	"context"
+	"testing"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSizeAgnosticBlobAccess(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+	defer ctrl.Finish()
+
+	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
+	blobAccess := blobstore.NewSizeAgnosticBlobAccess(baseBlobAccess)
+
+	actionResult := &remoteexecution.ActionResult{ExitCode: 1}
+
+	t.Run("GetPut", func(t *testing.T) {
+		// Objects written with a full digest should be
+		// stored under a digest with a size of zero.
+		baseBlobAccess.EXPECT().Put(
+			ctx,
+			digest.MustNewDigest("default", remoteexecution.DigestFunction_SHA256, "185f8db32271fe25f561a6fc938b2e264306ec304eda518007d1764826381969", 0),
+			gomock.Any())
+		require.NoError(t, blobAccess.Put(
+			ctx,
+			digest.MustNewDigest("default", remoteexecution.DigestFunction_SHA256, "185f8db32271fe25f561a6fc938b2e264306ec304eda518007d1764826381969", 123),
+			buffer.NewACBufferFromActionResult(actionResult, buffer.UserProvided)))
+
+		baseBlobAccess.EXPECT().Get(
+			ctx,
+			digest.MustNewDigest("default", remoteexecution.DigestFunction_SHA256, "185f8db32271fe25f561a6fc938b2e264306ec304eda518007d1764826381969", 0),
+		).Return(buffer.NewACBufferFromActionResult(actionResult, buffer.Irreparable))
+		_, err := blobAccess.Get(
+			ctx,
+			digest.MustNewDigest("default", remoteexecution.DigestFunction_SHA256, "185f8db32271fe25f561a6fc938b2e264306ec304eda518007d1764826381969", 123),
+		).ToActionResult(1000)
+		require.NoError(t, err)
+	})
+
+	t.Run("FindMissing", func(t *testing.T) {
+		// Digests that only differ in size should all be
+		// reported as missing.
+		baseBlobAccess.EXPECT().FindMissing(
+			ctx,
+			digest.NewSetBuilder().
+				Add(digest.MustNewDigest("default", remoteexecution.DigestFunction_SHA256, "185f8db32271fe25f561a6fc938b2e264306ec304eda518007d1764826381969", 0)).
+				Add(digest.MustNewDigest("default", remoteexecution.DigestFunction_SHA256, "78ae647dc5544d227130a0682a51e30bc7777fbb6d8a8f17007463a3ecd1d524", 0)).
+				Build(),
+		).Return(
+			digest.NewSetBuilder().
+				Add(digest.MustNewDigest("default", remoteexecution.DigestFunction_SHA256, "185f8db32271fe25f561a6fc938b2e264306ec304eda518007d1764826381969", 0)).
+				Build(),
+			nil)
+		missing, err := blobAccess.FindMissing(
+			ctx,
+			digest.NewSetBuilder().
+				Add(digest.MustNewDigest("default", remoteexecution.DigestFunction_SHA256, "185f8db32271fe25f561a6fc938b2e264306ec304eda518007d1764826381969", 5)).
+				Add(digest.MustNewDigest("default", remoteexecution.DigestFunction_SHA256, "185f8db32271fe25f561a6fc938b2e264306ec304eda518007d1764826381969", 6)).
+				Add(digest.MustNewDigest("default", remoteexecution.DigestFunction_SHA256, "78ae647dc5544d227130a0682a51e30bc7777fbb6d8a8f17007463a3ecd1d524", 7)).
+				Build())
+		require.NoError(t, err)
+		require.Equal(
+			t,
+			digest.NewSetBuilder().
+				Add(digest.MustNewDigest("default", remoteexecution.DigestFunction_SHA256, "185f8db32271fe25f561a6fc938b2e264306ec304eda518007d1764826381969", 5)).
+				Add(digest.MustNewDigest("default", remoteexecution.DigestFunction_SHA256, "185f8db32271fe25f561a6fc938b2e264306ec304eda518007d1764826381969", 6)).
+				Build(),
+			missing)
+	})
+}
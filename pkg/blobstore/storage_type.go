@@ -16,11 +16,15 @@ type StorageType interface {
 	// identifier for a blob. This function is, for example, used to
 	// determine the name of keys in S3 and Redis.
 	GetDigestKey(digest digest.Digest) string
+	// ParseDigestKey is the inverse of GetDigestKey(). It is used
+	// by backends to enumerate the objects they store.
+	ParseDigestKey(key string) (digest.Digest, error)
 
 	// NewBufferFromByteSlice creates a buffer from a byte slice
 	// that is either suitable for storage in the CAS or AC.
 	NewBufferFromByteSlice(digest digest.Digest, data []byte, repairStrategy buffer.RepairStrategy) buffer.Buffer
-	// NewBufferFromByteSlice creates a buffer from a reader that is
-	// either suitable for storage in the CAS or AC.
-	NewBufferFromReader(digest digest.Digest, r io.ReadCloser, repairStrategy buffer.RepairStrategy) buffer.Buffer
+	// NewBufferFromReader creates a buffer from a reader that is
+	// either suitable for storage in the CAS or AC. The size of the
+	// object as stored in the backend is provided, or -1 if unknown.
+	NewBufferFromReader(digest digest.Digest, r io.ReadCloser, sizeBytes int64, repairStrategy buffer.RepairStrategy) buffer.Buffer
 }
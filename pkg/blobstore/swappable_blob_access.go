@@ -0,0 +1,55 @@
+package blobstore
+
+import (
+	"context"
+	"sync"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+)
+
+// SwappableBlobAccess is a BlobAccess that forwards all operations to
+// a backend that may be replaced at runtime. It is used to apply
+// changes to the storage configuration without restarting.
+type SwappableBlobAccess interface {
+	BlobAccess
+
+	Swap(blobAccess BlobAccess)
+}
+
+type swappableBlobAccess struct {
+	lock       sync.RWMutex
+	blobAccess BlobAccess
+}
+
+// NewSwappableBlobAccess creates a SwappableBlobAccess that initially
+// forwards all operations to the provided backend.
+func NewSwappableBlobAccess(blobAccess BlobAccess) SwappableBlobAccess {
+	return &swappableBlobAccess{
+		blobAccess: blobAccess,
+	}
+}
+
+func (ba *swappableBlobAccess) get() BlobAccess {
+	ba.lock.RLock()
+	defer ba.lock.RUnlock()
+	return ba.blobAccess
+}
+
+func (ba *swappableBlobAccess) Swap(blobAccess BlobAccess) {
+	ba.lock.Lock()
+	ba.blobAccess = blobAccess
+	ba.lock.Unlock()
+}
+
+func (ba *swappableBlobAccess) Get(ctx context.Context, digest digest.Digest) buffer.Buffer {
+	return ba.get().Get(ctx, digest)
+}
+
+func (ba *swappableBlobAccess) Put(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+	return ba.get().Put(ctx, digest, b)
+}
+
+func (ba *swappableBlobAccess) FindMissing(ctx context.Context, digests digest.Set) (digest.Set, error) {
+	return ba.get().FindMissing(ctx, digests)
+}
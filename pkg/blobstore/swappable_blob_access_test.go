@@ -0,0 +1,38 @@
+package blobstore_test
+
+import (
+	"context"
+	"testing"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSwappableBlobAccess(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+	defer ctrl.Finish()
+
+	digests := digest.NewSetBuilder().
+		Add(digest.MustNewDigest("instance", remoteexecution.DigestFunction_MD5, "8b1a9953c4611296a827abf8c47804d7", 5)).
+		Build()
+
+	// Requests should be forwarded to the initial backend.
+	backend1 := mock.NewMockBlobAccess(ctrl)
+	blobAccess := blobstore.NewSwappableBlobAccess(backend1)
+	backend1.EXPECT().FindMissing(ctx, digests).Return(digests, nil)
+	missing, err := blobAccess.FindMissing(ctx, digests)
+	require.NoError(t, err)
+	require.Equal(t, digests, missing)
+
+	// After swapping, requests should go to the new backend.
+	backend2 := mock.NewMockBlobAccess(ctrl)
+	blobAccess.Swap(backend2)
+	backend2.EXPECT().FindMissing(ctx, digests).Return(digest.EmptySet, nil)
+	missing, err = blobAccess.FindMissing(ctx, digests)
+	require.NoError(t, err)
+	require.Equal(t, digest.EmptySet, missing)
+}
@@ -0,0 +1,102 @@
+package blobstore
+
+import (
+	"context"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+
+	"go.opencensus.io/trace"
+	"google.golang.org/grpc/status"
+)
+
+type tracingBlobAccess struct {
+	blobAccess      BlobAccess
+	storageTypeName string
+	backendType     string
+}
+
+// NewTracingBlobAccess creates an adapter for BlobAccess that creates
+// an OpenCensus trace span for every operation. Spans are annotated
+// with the type of storage and backend, the digests and sizes of the
+// objects involved, and the gRPC status code of the outcome.
+//
+// When applied to every layer of a nested storage configuration, the
+// resulting spans form a tree, making it possible to determine which
+// layer is responsible for slow requests.
+func NewTracingBlobAccess(blobAccess BlobAccess, storageTypeName string, backendType string) BlobAccess {
+	return &tracingBlobAccess{
+		blobAccess:      blobAccess,
+		storageTypeName: storageTypeName,
+		backendType:     backendType,
+	}
+}
+
+func (ba *tracingBlobAccess) startSpan(ctx context.Context, operation string) (context.Context, *trace.Span) {
+	ctx, span := trace.StartSpan(ctx, "BlobAccess."+operation)
+	span.AddAttributes(
+		trace.StringAttribute("storage_type", ba.storageTypeName),
+		trace.StringAttribute("backend_type", ba.backendType))
+	return ctx, span
+}
+
+func setSpanStatus(span *trace.Span, err error) {
+	if err != nil {
+		s := status.Convert(err)
+		span.SetStatus(trace.Status{
+			Code:    int32(s.Code()),
+			Message: s.Message(),
+		})
+	}
+}
+
+func (ba *tracingBlobAccess) Get(ctx context.Context, digest digest.Digest) buffer.Buffer {
+	ctx, span := ba.startSpan(ctx, "Get")
+	span.AddAttributes(
+		trace.StringAttribute("digest", digest.String()),
+		trace.Int64Attribute("size_bytes", digest.GetSizeBytes()))
+	// The span is only ended when the buffer is consumed, as data
+	// is typically transferred at that point in time.
+	return buffer.WithErrorHandler(
+		ba.blobAccess.Get(ctx, digest),
+		&tracingErrorHandler{span: span})
+}
+
+func (ba *tracingBlobAccess) Put(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+	ctx, span := ba.startSpan(ctx, "Put")
+	defer span.End()
+
+	span.AddAttributes(trace.StringAttribute("digest", digest.String()))
+	if sizeBytes, err := b.GetSizeBytes(); err == nil {
+		span.AddAttributes(trace.Int64Attribute("size_bytes", sizeBytes))
+	}
+	err := ba.blobAccess.Put(ctx, digest, b)
+	setSpanStatus(span, err)
+	return err
+}
+
+func (ba *tracingBlobAccess) FindMissing(ctx context.Context, digests digest.Set) (digest.Set, error) {
+	ctx, span := ba.startSpan(ctx, "FindMissing")
+	defer span.End()
+
+	span.AddAttributes(trace.Int64Attribute("digests", int64(digests.Length())))
+	missing, err := ba.blobAccess.FindMissing(ctx, digests)
+	if err == nil {
+		span.AddAttributes(trace.Int64Attribute("missing", int64(missing.Length())))
+	}
+	setSpanStatus(span, err)
+	return missing, err
+}
+
+type tracingErrorHandler struct {
+	span *trace.Span
+}
+
+func (eh *tracingErrorHandler) OnError(err error) (buffer.Buffer, error) {
+	setSpanStatus(eh.span, err)
+	return nil, err
+}
+
+func (eh *tracingErrorHandler) Done() {
+	eh.span.End()
+}
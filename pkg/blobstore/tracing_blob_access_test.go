@@ -0,0 +1,130 @@
+package blobstore_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"sync"
+	"testing"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"go.opencensus.io/trace"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type spanRecorder struct {
+	lock  sync.Mutex
+	spans []*trace.SpanData
+}
+
+func (r *spanRecorder) ExportSpan(s *trace.SpanData) {
+	r.lock.Lock()
+	r.spans = append(r.spans, s)
+	r.lock.Unlock()
+}
+
+func (r *spanRecorder) take() []*trace.SpanData {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	spans := r.spans
+	r.spans = nil
+	return spans
+}
+
+func TestTracingBlobAccess(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+	defer ctrl.Finish()
+
+	recorder := &spanRecorder{}
+	trace.RegisterExporter(recorder)
+	defer trace.UnregisterExporter(recorder)
+	trace.ApplyConfig(trace.Config{DefaultSampler: trace.AlwaysSample()})
+	defer trace.ApplyConfig(trace.Config{DefaultSampler: trace.ProbabilitySampler(1e-4)})
+
+	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
+	blobAccess := blobstore.NewTracingBlobAccess(baseBlobAccess, "cas", "sharding")
+	helloDigest := digest.MustNewDigest("instance", remoteexecution.DigestFunction_MD5, "8b1a9953c4611296a827abf8c47804d7", 5)
+
+	t.Run("GetSuccess", func(t *testing.T) {
+		// The span should only be ended after the buffer has
+		// been consumed.
+		baseBlobAccess.EXPECT().Get(gomock.Any(), helloDigest).
+			Return(buffer.NewCASBufferFromReader(helloDigest, ioutil.NopCloser(bytes.NewBufferString("Hello")), buffer.UserProvided))
+		b := blobAccess.Get(ctx, helloDigest)
+		require.Empty(t, recorder.take())
+
+		data, err := b.ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+
+		spans := recorder.take()
+		require.Len(t, spans, 1)
+		require.Equal(t, "BlobAccess.Get", spans[0].Name)
+		require.Equal(t, map[string]interface{}{
+			"storage_type": "cas",
+			"backend_type": "sharding",
+			"digest":       "8b1a9953c4611296a827abf8c47804d7-5-instance",
+			"size_bytes":   int64(5),
+		}, spans[0].Attributes)
+		require.Equal(t, int32(codes.OK), spans[0].Status.Code)
+	})
+
+	t.Run("GetNotFound", func(t *testing.T) {
+		baseBlobAccess.EXPECT().Get(gomock.Any(), helloDigest).
+			Return(buffer.NewBufferFromError(status.Error(codes.NotFound, "Object not found")))
+		_, err := blobAccess.Get(ctx, helloDigest).ToByteSlice(100)
+		require.Equal(t, status.Error(codes.NotFound, "Object not found"), err)
+
+		spans := recorder.take()
+		require.Len(t, spans, 1)
+		require.Equal(t, trace.Status{
+			Code:    int32(codes.NotFound),
+			Message: "Object not found",
+		}, spans[0].Status)
+	})
+
+	t.Run("Put", func(t *testing.T) {
+		// Spans of the backend should be children of the span
+		// created by the TracingBlobAccess.
+		var parentSpanID trace.SpanID
+		baseBlobAccess.EXPECT().Put(gomock.Any(), helloDigest, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+				parentSpanID = trace.FromContext(ctx).SpanContext().SpanID
+				b.Discard()
+				return status.Error(codes.Unavailable, "Server offline")
+			})
+		require.Equal(
+			t,
+			status.Error(codes.Unavailable, "Server offline"),
+			blobAccess.Put(ctx, helloDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+
+		spans := recorder.take()
+		require.Len(t, spans, 1)
+		require.Equal(t, "BlobAccess.Put", spans[0].Name)
+		require.Equal(t, parentSpanID, spans[0].SpanID)
+		require.Equal(t, int64(5), spans[0].Attributes["size_bytes"])
+		require.Equal(t, int32(codes.Unavailable), spans[0].Status.Code)
+	})
+
+	t.Run("FindMissing", func(t *testing.T) {
+		digests := digest.NewSetBuilder().Add(helloDigest).Build()
+		baseBlobAccess.EXPECT().FindMissing(gomock.Any(), digests).Return(digest.EmptySet, nil)
+		missing, err := blobAccess.FindMissing(ctx, digests)
+		require.NoError(t, err)
+		require.Equal(t, digest.EmptySet, missing)
+
+		spans := recorder.take()
+		require.Len(t, spans, 1)
+		require.Equal(t, "BlobAccess.FindMissing", spans[0].Name)
+		require.Equal(t, int64(1), spans[0].Attributes["digests"])
+		require.Equal(t, int64(0), spans[0].Attributes["missing"])
+	})
+}
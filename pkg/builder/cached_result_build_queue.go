@@ -0,0 +1,187 @@
+package builder
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/pkg/auth"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/eviction"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"google.golang.org/genproto/googleapis/longrunning"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	cachedResultBuildQueuePrometheusMetrics sync.Once
+
+	cachedResultBuildQueueLookups = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "buildbarn",
+			Subsystem: "builder",
+			Name:      "cached_result_build_queue_lookups_total",
+			Help:      "Number of times the Action Cache was consulted by Execute(), partitioned by result.",
+		},
+		[]string{"result"})
+	cachedResultBuildQueueLookupsHit             = cachedResultBuildQueueLookups.WithLabelValues("Hit")
+	cachedResultBuildQueueLookupsMiss            = cachedResultBuildQueueLookups.WithLabelValues("Miss")
+	cachedResultBuildQueueLookupsNonZeroExitCode = cachedResultBuildQueueLookups.WithLabelValues("NonZeroExitCode")
+	cachedResultBuildQueueLookupsUnauthorized    = cachedResultBuildQueueLookups.WithLabelValues("Unauthorized")
+	cachedResultBuildQueueLookupsFailed          = cachedResultBuildQueueLookups.WithLabelValues("Failed")
+)
+
+const (
+	// cachedResultOperationNamePrefix is prepended to the names of
+	// operations returned by cachedResultBuildQueue, so that
+	// WaitExecution() can distinguish them from operations created
+	// by the backend.
+	cachedResultOperationNamePrefix = "cached-result-"
+	// cachedResultRetainedOperations is the maximum number of
+	// operations returned by cachedResultBuildQueue that can be
+	// obtained through WaitExecution().
+	cachedResultRetainedOperations = 1000
+)
+
+type cachedResultBuildQueue struct {
+	base                    BuildQueue
+	actionCache             blobstore.BlobAccess
+	authorizer              auth.Authorizer
+	maximumMessageSizeBytes int
+
+	lock        sync.Mutex
+	operations  map[string]*longrunning.Operation
+	evictionSet eviction.Set
+}
+
+// NewCachedResultBuildQueue creates a decorator for BuildQueue that
+// looks up actions in the Action Cache before forwarding calls to
+// Execute(). If a result is present, a completed operation is returned
+// to the client immediately, without contacting the backend. This
+// reduces the load on schedulers in case clients call Execute() for
+// actions that have already been built.
+//
+// Lookups are skipped if the client requests this by setting
+// skip_cache_lookup, or if it is not permitted to read from the Action
+// Cache. Results with a non-zero exit code are ignored, as the action
+// should be retried in case it failed spuriously.
+//
+// The most recently returned operations are retained, so that clients
+// may call WaitExecution() on them. Names of these operations carry a
+// distinct prefix. Calls to WaitExecution() for other operations are
+// forwarded to the backend.
+func NewCachedResultBuildQueue(base BuildQueue, actionCache blobstore.BlobAccess, authorizer auth.Authorizer, maximumMessageSizeBytes int) BuildQueue {
+	cachedResultBuildQueuePrometheusMetrics.Do(func() {
+		prometheus.MustRegister(cachedResultBuildQueueLookups)
+	})
+
+	return &cachedResultBuildQueue{
+		base:                    base,
+		actionCache:             actionCache,
+		authorizer:              authorizer,
+		maximumMessageSizeBytes: maximumMessageSizeBytes,
+
+		operations:  map[string]*longrunning.Operation{},
+		evictionSet: eviction.NewFIFOSet(),
+	}
+}
+
+// addOperation retains a completed operation, so that it may be
+// obtained through WaitExecution().
+func (bq *cachedResultBuildQueue) addOperation(operation *longrunning.Operation) {
+	bq.lock.Lock()
+	defer bq.lock.Unlock()
+
+	if len(bq.operations) >= cachedResultRetainedOperations {
+		delete(bq.operations, bq.evictionSet.Peek())
+		bq.evictionSet.Remove()
+	}
+	bq.evictionSet.Insert(operation.Name)
+	bq.operations[operation.Name] = operation
+}
+
+// getCachedResult returns a completed operation for an action if its
+// result is present in the Action Cache. Nil is returned otherwise.
+func (bq *cachedResultBuildQueue) getCachedResult(ctx context.Context, in *remoteexecution.ExecuteRequest) (*longrunning.Operation, error) {
+	actionDigest, err := digest.NewDigestFromPartialDigest(in.InstanceName, in.DigestFunction, in.ActionDigest)
+	if err != nil {
+		return nil, util.StatusWrap(err, "Failed to extract digest for action")
+	}
+	if err := bq.authorizer.Authorize(ctx, actionDigest.GetInstance(), auth.OperationActionCacheRead); err != nil {
+		cachedResultBuildQueueLookupsUnauthorized.Inc()
+		return nil, nil
+	}
+	actionResult, err := bq.actionCache.Get(ctx, actionDigest).ToActionResult(bq.maximumMessageSizeBytes)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			cachedResultBuildQueueLookupsMiss.Inc()
+			return nil, nil
+		}
+		return nil, util.StatusWrap(err, "Failed to obtain action result")
+	}
+	if actionResult.ExitCode != 0 {
+		cachedResultBuildQueueLookupsNonZeroExitCode.Inc()
+		return nil, nil
+	}
+
+	metadata, err := ptypes.MarshalAny(&remoteexecution.ExecuteOperationMetadata{
+		Stage:        remoteexecution.ExecutionStage_COMPLETED,
+		ActionDigest: in.ActionDigest,
+	})
+	if err != nil {
+		return nil, util.StatusWrap(err, "Failed to marshal operation metadata")
+	}
+	response, err := ptypes.MarshalAny(&remoteexecution.ExecuteResponse{
+		Result:       actionResult,
+		CachedResult: true,
+	})
+	if err != nil {
+		return nil, util.StatusWrap(err, "Failed to marshal execute response")
+	}
+	operation := &longrunning.Operation{
+		Name:     cachedResultOperationNamePrefix + uuid.Must(uuid.NewRandom()).String(),
+		Metadata: metadata,
+		Done:     true,
+		Result:   &longrunning.Operation_Response{Response: response},
+	}
+	bq.addOperation(operation)
+	cachedResultBuildQueueLookupsHit.Inc()
+	return operation, nil
+}
+
+func (bq *cachedResultBuildQueue) GetCapabilities(ctx context.Context, in *remoteexecution.GetCapabilitiesRequest) (*remoteexecution.ServerCapabilities, error) {
+	return bq.base.GetCapabilities(ctx, in)
+}
+
+func (bq *cachedResultBuildQueue) Execute(in *remoteexecution.ExecuteRequest, out remoteexecution.Execution_ExecuteServer) error {
+	if !in.SkipCacheLookup {
+		// Failures to consult the Action Cache are not fatal, as
+		// the action can still be executed.
+		if operation, err := bq.getCachedResult(out.Context(), in); err != nil {
+			cachedResultBuildQueueLookupsFailed.Inc()
+		} else if operation != nil {
+			return out.Send(operation)
+		}
+	}
+	return bq.base.Execute(in, out)
+}
+
+func (bq *cachedResultBuildQueue) WaitExecution(in *remoteexecution.WaitExecutionRequest, out remoteexecution.Execution_WaitExecutionServer) error {
+	if !strings.HasPrefix(in.Name, cachedResultOperationNamePrefix) {
+		return bq.base.WaitExecution(in, out)
+	}
+	bq.lock.Lock()
+	operation, ok := bq.operations[in.Name]
+	bq.lock.Unlock()
+	if !ok {
+		return status.Errorf(codes.NotFound, "Operation %#v not found", in.Name)
+	}
+	return out.Send(operation)
+}
@@ -0,0 +1,158 @@
+package builder_test
+
+import (
+	"context"
+	"testing"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/auth"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/builder"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/genproto/googleapis/longrunning"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestCachedResultBuildQueueExecute(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+	defer ctrl.Finish()
+
+	baseBuildQueue := mock.NewMockBuildQueue(ctrl)
+	actionCache := mock.NewMockBlobAccess(ctrl)
+	authorizer := mock.NewMockAuthorizer(ctrl)
+	buildQueue := builder.NewCachedResultBuildQueue(baseBuildQueue, actionCache, authorizer, 10000)
+
+	request := &remoteexecution.ExecuteRequest{
+		InstanceName: "default",
+		ActionDigest: &remoteexecution.Digest{
+			Hash:      "8b1a9953c4611296a827abf8c47804d7",
+			SizeBytes: 123,
+		},
+	}
+	actionDigest := digest.MustNewDigest("default", remoteexecution.DigestFunction_MD5, "8b1a9953c4611296a827abf8c47804d7", 123)
+
+	var cachedOperation *longrunning.Operation
+	t.Run("Hit", func(t *testing.T) {
+		// Results present in the Action Cache should be returned
+		// as a completed operation, without calling into the
+		// backend.
+		actionResult := &remoteexecution.ActionResult{ExitCode: 0}
+		authorizer.EXPECT().Authorize(ctx, "default", auth.OperationActionCacheRead)
+		actionCache.EXPECT().Get(ctx, actionDigest).Return(buffer.NewACBufferFromActionResult(actionResult, buffer.Irreparable))
+		executeServer := mock.NewMockExecution_ExecuteServer(ctrl)
+		executeServer.EXPECT().Context().Return(ctx).AnyTimes()
+		executeServer.EXPECT().Send(gomock.Any()).DoAndReturn(func(operation *longrunning.Operation) error {
+			require.True(t, operation.Done)
+
+			var metadata remoteexecution.ExecuteOperationMetadata
+			require.NoError(t, ptypes.UnmarshalAny(operation.Metadata, &metadata))
+			require.True(t, proto.Equal(&remoteexecution.ExecuteOperationMetadata{
+				Stage:        remoteexecution.ExecutionStage_COMPLETED,
+				ActionDigest: request.ActionDigest,
+			}, &metadata))
+
+			var response remoteexecution.ExecuteResponse
+			require.NoError(t, ptypes.UnmarshalAny(operation.GetResponse(), &response))
+			require.True(t, proto.Equal(&remoteexecution.ExecuteResponse{
+				Result:       actionResult,
+				CachedResult: true,
+			}, &response))
+			cachedOperation = operation
+			return nil
+		})
+
+		require.NoError(t, buildQueue.Execute(request, executeServer))
+	})
+
+	t.Run("WaitExecutionCached", func(t *testing.T) {
+		// Operations returned by Execute() should be resolvable
+		// through WaitExecution(), without calling into the
+		// backend.
+		waitExecutionServer := mock.NewMockExecution_WaitExecutionServer(ctrl)
+		waitExecutionServer.EXPECT().Send(cachedOperation)
+
+		require.NoError(t, buildQueue.WaitExecution(&remoteexecution.WaitExecutionRequest{Name: cachedOperation.Name}, waitExecutionServer))
+	})
+
+	t.Run("WaitExecutionCachedUnknown", func(t *testing.T) {
+		waitExecutionServer := mock.NewMockExecution_WaitExecutionServer(ctrl)
+
+		require.Equal(
+			t,
+			status.Error(codes.NotFound, "Operation \"cached-result-3a6b1f5e-0000-0000-0000-000000000000\" not found"),
+			buildQueue.WaitExecution(&remoteexecution.WaitExecutionRequest{Name: "cached-result-3a6b1f5e-0000-0000-0000-000000000000"}, waitExecutionServer))
+	})
+
+	t.Run("WaitExecutionBackend", func(t *testing.T) {
+		// Other operations should be forwarded to the backend.
+		waitExecutionServer := mock.NewMockExecution_WaitExecutionServer(ctrl)
+		waitExecutionRequest := &remoteexecution.WaitExecutionRequest{Name: "3a6b1f5e-0000-0000-0000-000000000000"}
+		baseBuildQueue.EXPECT().WaitExecution(waitExecutionRequest, waitExecutionServer)
+
+		require.NoError(t, buildQueue.WaitExecution(waitExecutionRequest, waitExecutionServer))
+	})
+
+	t.Run("Miss", func(t *testing.T) {
+		authorizer.EXPECT().Authorize(ctx, "default", auth.OperationActionCacheRead)
+		actionCache.EXPECT().Get(ctx, actionDigest).Return(buffer.NewBufferFromError(status.Error(codes.NotFound, "Object not found")))
+		executeServer := mock.NewMockExecution_ExecuteServer(ctrl)
+		executeServer.EXPECT().Context().Return(ctx).AnyTimes()
+		baseBuildQueue.EXPECT().Execute(request, executeServer).Return(status.Error(codes.Unavailable, "Scheduler offline"))
+
+		require.Equal(t, status.Error(codes.Unavailable, "Scheduler offline"), buildQueue.Execute(request, executeServer))
+	})
+
+	t.Run("NonZeroExitCode", func(t *testing.T) {
+		// Results of actions that failed should not be returned,
+		// as the action should be executed once more.
+		authorizer.EXPECT().Authorize(ctx, "default", auth.OperationActionCacheRead)
+		actionCache.EXPECT().Get(ctx, actionDigest).Return(buffer.NewACBufferFromActionResult(&remoteexecution.ActionResult{ExitCode: 42}, buffer.Irreparable))
+		executeServer := mock.NewMockExecution_ExecuteServer(ctrl)
+		executeServer.EXPECT().Context().Return(ctx).AnyTimes()
+		baseBuildQueue.EXPECT().Execute(request, executeServer)
+
+		require.NoError(t, buildQueue.Execute(request, executeServer))
+	})
+
+	t.Run("LookupFailure", func(t *testing.T) {
+		// Failures to read from the Action Cache should not
+		// prevent the action from being executed.
+		authorizer.EXPECT().Authorize(ctx, "default", auth.OperationActionCacheRead)
+		actionCache.EXPECT().Get(ctx, actionDigest).Return(buffer.NewBufferFromError(status.Error(codes.Internal, "Disk on fire")))
+		executeServer := mock.NewMockExecution_ExecuteServer(ctrl)
+		executeServer.EXPECT().Context().Return(ctx).AnyTimes()
+		baseBuildQueue.EXPECT().Execute(request, executeServer)
+
+		require.NoError(t, buildQueue.Execute(request, executeServer))
+	})
+
+	t.Run("Unauthorized", func(t *testing.T) {
+		// Clients that may not read from the Action Cache should
+		// not be able to obtain its contents through Execute().
+		authorizer.EXPECT().Authorize(ctx, "default", auth.OperationActionCacheRead).Return(status.Error(codes.PermissionDenied, "Permission denied"))
+		executeServer := mock.NewMockExecution_ExecuteServer(ctrl)
+		executeServer.EXPECT().Context().Return(ctx).AnyTimes()
+		baseBuildQueue.EXPECT().Execute(request, executeServer)
+
+		require.NoError(t, buildQueue.Execute(request, executeServer))
+	})
+
+	t.Run("SkipCacheLookup", func(t *testing.T) {
+		skipRequest := &remoteexecution.ExecuteRequest{
+			InstanceName:    "default",
+			SkipCacheLookup: true,
+			ActionDigest:    request.ActionDigest,
+		}
+		executeServer := mock.NewMockExecution_ExecuteServer(ctrl)
+		baseBuildQueue.EXPECT().Execute(skipRequest, executeServer)
+
+		require.NoError(t, buildQueue.Execute(skipRequest, executeServer))
+	})
+}
@@ -0,0 +1,48 @@
+package builder
+
+import (
+	"context"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/golang/protobuf/proto"
+)
+
+type compressionAnnouncingBuildQueue struct {
+	base        BuildQueue
+	compressors []remoteexecution.Compressor_Value
+}
+
+// NewCompressionAnnouncingBuildQueue alters the response of
+// GetCapabilities() to announce that the ByteStream service supports
+// transferring data using "compressed-blobs" resource names, using a
+// given set of compressors.
+func NewCompressionAnnouncingBuildQueue(base BuildQueue, compressors []remoteexecution.Compressor_Value) BuildQueue {
+	return &compressionAnnouncingBuildQueue{
+		base:        base,
+		compressors: compressors,
+	}
+}
+
+func (bq *compressionAnnouncingBuildQueue) GetCapabilities(ctx context.Context, in *remoteexecution.GetCapabilitiesRequest) (*remoteexecution.ServerCapabilities, error) {
+	// Extract underlying capabilities.
+	oldCapabilities, err := bq.base.GetCapabilities(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+
+	// If CacheCapabilities are provided, alter them to announce
+	// the compressors that are supported.
+	newCapabilities := proto.Clone(oldCapabilities).(*remoteexecution.ServerCapabilities)
+	if cacheCapabilities := newCapabilities.CacheCapabilities; cacheCapabilities != nil {
+		cacheCapabilities.SupportedCompressors = append([]remoteexecution.Compressor_Value(nil), bq.compressors...)
+	}
+	return newCapabilities, nil
+}
+
+func (bq *compressionAnnouncingBuildQueue) Execute(in *remoteexecution.ExecuteRequest, out remoteexecution.Execution_ExecuteServer) error {
+	return bq.base.Execute(in, out)
+}
+
+func (bq *compressionAnnouncingBuildQueue) WaitExecution(in *remoteexecution.WaitExecutionRequest, out remoteexecution.Execution_WaitExecutionServer) error {
+	return bq.base.WaitExecution(in, out)
+}
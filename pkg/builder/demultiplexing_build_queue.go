@@ -33,9 +33,20 @@ func NewDemultiplexingBuildQueue(buildQueueGetter BuildQueueGetter) BuildQueue {
 	}
 }
 
+// CheckInstanceName returns an error if an instance name cannot be
+// served through NewDemultiplexingBuildQueue(). Instance names may not
+// contain pipe characters, as these are used to separate instance
+// names from operation names.
+func CheckInstanceName(instanceName string) error {
+	if strings.ContainsRune(instanceName, '|') {
+		return status.Error(codes.InvalidArgument, "Instance name cannot contain a pipe character")
+	}
+	return nil
+}
+
 func (bq *demultiplexingBuildQueue) GetCapabilities(ctx context.Context, in *remoteexecution.GetCapabilitiesRequest) (*remoteexecution.ServerCapabilities, error) {
-	if strings.ContainsRune(in.InstanceName, '|') {
-		return nil, status.Errorf(codes.InvalidArgument, "Instance name cannot contain a pipe character")
+	if err := CheckInstanceName(in.InstanceName); err != nil {
+		return nil, err
 	}
 	backend, err := bq.buildQueueGetter(in.InstanceName)
 	if err != nil {
@@ -45,8 +56,8 @@ func (bq *demultiplexingBuildQueue) GetCapabilities(ctx context.Context, in *rem
 }
 
 func (bq *demultiplexingBuildQueue) Execute(in *remoteexecution.ExecuteRequest, out remoteexecution.Execution_ExecuteServer) error {
-	if strings.ContainsRune(in.InstanceName, '|') {
-		return status.Errorf(codes.InvalidArgument, "Instance name cannot contain a pipe character")
+	if err := CheckInstanceName(in.InstanceName); err != nil {
+		return err
 	}
 	backend, err := bq.buildQueueGetter(in.InstanceName)
 	if err != nil {
@@ -59,22 +70,39 @@ func (bq *demultiplexingBuildQueue) Execute(in *remoteexecution.ExecuteRequest,
 }
 
 func (bq *demultiplexingBuildQueue) WaitExecution(in *remoteexecution.WaitExecutionRequest, out remoteexecution.Execution_WaitExecutionServer) error {
-	target := strings.SplitN(in.Name, "|", 2)
-	if len(target) != 2 {
+	instanceName, operationName, ok := splitOperationName(in.Name)
+	if !ok {
 		return status.Errorf(codes.InvalidArgument, "Unable to extract instance from operation name")
 	}
-	backend, err := bq.buildQueueGetter(target[0])
+	backend, err := bq.buildQueueGetter(instanceName)
 	if err != nil {
-		return util.StatusWrapf(err, "Failed to obtain backend for instance %#v", target[0])
+		return util.StatusWrapf(err, "Failed to obtain backend for instance %#v", instanceName)
 	}
 	requestCopy := *in
-	requestCopy.Name = target[1]
-	return backend.WaitExecution(in, &operationNamePrepender{
+	requestCopy.Name = operationName
+	return backend.WaitExecution(&requestCopy, &operationNamePrepender{
 		Execution_ExecuteServer: out,
-		prefix:                  target[1],
+		prefix:                  instanceName,
 	})
 }
 
+// splitOperationName splits an operation name that was prefixed by
+// operationNamePrepender back into its prefix and the original
+// operation name. As prefixes cannot contain pipe characters, it is
+// safe to split on the first occurrence, even if the original
+// operation name contains pipe characters itself.
+func splitOperationName(name string) (string, string, bool) {
+	target := strings.SplitN(name, "|", 2)
+	if len(target) != 2 {
+		return "", "", false
+	}
+	return target[0], target[1], true
+}
+
+// operationNamePrepender is a decorator for Execution_ExecuteServer
+// that prefixes the names of operations that are sent to the client.
+// The prefix and the original operation name can be extracted from
+// the resulting name using splitOperationName().
 type operationNamePrepender struct {
 	remoteexecution.Execution_ExecuteServer
 	prefix string
@@ -10,10 +10,17 @@ import (
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/require"
 
+	"google.golang.org/genproto/googleapis/longrunning"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
 
+func TestCheckInstanceName(t *testing.T) {
+	require.NoError(t, builder.CheckInstanceName(""))
+	require.NoError(t, builder.CheckInstanceName("Hello/World"))
+	require.Equal(t, status.Error(codes.InvalidArgument, "Instance name cannot contain a pipe character"), builder.CheckInstanceName("Hello|World"))
+}
+
 func TestDemultiplexingBuildQueueBadInstanceName(t *testing.T) {
 	ctrl, ctx := gomock.WithContext(context.Background(), t)
 	defer ctrl.Finish()
@@ -73,4 +80,45 @@ func TestDemultiplexingBuildQueueFailedToGetBackend(t *testing.T) {
 	require.Equal(t, status.Error(codes.NotFound, "Failed to obtain backend for instance \"Nonexistent backend\": Backend not found"), err)
 }
 
+func TestDemultiplexingBuildQueueOperationNames(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	buildQueueGetter := mock.NewMockBuildQueueGetter(ctrl)
+	demultiplexingBuildQueue := builder.NewDemultiplexingBuildQueue(buildQueueGetter.Call)
+
+	// Operation names returned by Execute() should be prefixed with
+	// the instance name.
+	backend := mock.NewMockBuildQueue(ctrl)
+	buildQueueGetter.EXPECT().Call("main").Return(backend, nil)
+	request := &remoteexecution.ExecuteRequest{
+		InstanceName: "main",
+		ActionDigest: &remoteexecution.Digest{
+			Hash:      "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+			SizeBytes: 0,
+		},
+	}
+	backend.EXPECT().Execute(request, gomock.Any()).DoAndReturn(
+		func(in *remoteexecution.ExecuteRequest, out remoteexecution.Execution_ExecuteServer) error {
+			return out.Send(&longrunning.Operation{Name: "x86|a1b2c3"})
+		})
+	executeServer := mock.NewMockExecution_ExecuteServer(ctrl)
+	executeServer.EXPECT().Send(&longrunning.Operation{Name: "main|x86|a1b2c3"})
+	require.NoError(t, demultiplexingBuildQueue.Execute(request, executeServer))
+
+	// WaitExecution() should strip the instance name before
+	// forwarding the request, and add it to the operations that
+	// are returned. Pipe characters in the original operation name
+	// should be preserved.
+	buildQueueGetter.EXPECT().Call("main").Return(backend, nil)
+	backend.EXPECT().WaitExecution(&remoteexecution.WaitExecutionRequest{Name: "x86|a1b2c3"}, gomock.Any()).DoAndReturn(
+		func(in *remoteexecution.WaitExecutionRequest, out remoteexecution.Execution_WaitExecutionServer) error {
+			return out.Send(&longrunning.Operation{Name: "x86|a1b2c3", Done: true})
+		})
+	waitExecutionServer := mock.NewMockExecution_WaitExecutionServer(ctrl)
+	waitExecutionServer.EXPECT().Send(&longrunning.Operation{Name: "main|x86|a1b2c3", Done: true})
+	require.NoError(t, demultiplexingBuildQueue.WaitExecution(&remoteexecution.WaitExecutionRequest{
+		Name: "main|x86|a1b2c3",
+	}, waitExecutionServer))
+}
+
 // TODO(edsch): Improve coverage.
@@ -0,0 +1,245 @@
+package builder
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/pkg/clock"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/genproto/googleapis/longrunning"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// FailoverBuildQueue is an implementation of BuildQueue that forwards
+// requests to one of multiple equivalent backends, such as replicas of
+// a scheduler that share their state.
+type FailoverBuildQueue struct {
+	backends               []BuildQueue
+	clock                  clock.Clock
+	retryDelay             time.Duration
+	maximumRetries         int
+	unhealthyRetryInterval time.Duration
+
+	lock    sync.Mutex
+	healthy []bool
+	// Times at which requests may be forwarded to unhealthy
+	// backends again, so that they can be marked healthy without
+	// relying on health checks.
+	retryTimes []time.Time
+}
+
+// NewFailoverBuildQueue creates a BuildQueue that forwards requests to
+// the first healthy backend out of a list of backends. Backends are
+// considered to be unhealthy if requests fail with UNAVAILABLE, or if
+// they fail the health checks performed by RunHealthChecks(). They are
+// considered to be healthy again once a request or health check
+// succeeds. To ensure preferred backends are used again after they
+// recover, a single request is forwarded to an unhealthy backend
+// whenever unhealthyRetryInterval has passed since it last failed.
+//
+// When an Execute() or WaitExecution() stream breaks due to a backend
+// becoming unavailable, the stream is re-established transparently by
+// calling WaitExecution() on the next healthy backend, using the name
+// of the last operation that was received. If that backend does not
+// know about the operation, Execute() requests are resubmitted.
+func NewFailoverBuildQueue(backends []BuildQueue, clock clock.Clock, retryDelay time.Duration, maximumRetries int, unhealthyRetryInterval time.Duration) *FailoverBuildQueue {
+	healthy := make([]bool, len(backends))
+	for i := range healthy {
+		healthy[i] = true
+	}
+	return &FailoverBuildQueue{
+		backends:               backends,
+		clock:                  clock,
+		retryDelay:             retryDelay,
+		maximumRetries:         maximumRetries,
+		unhealthyRetryInterval: unhealthyRetryInterval,
+		healthy:                healthy,
+		retryTimes:             make([]time.Time, len(backends)),
+	}
+}
+
+// RunHealthChecks periodically calls GetCapabilities() against all
+// backends to determine whether they are healthy. This function
+// blocks until the provided context is cancelled.
+func (bq *FailoverBuildQueue) RunHealthChecks(ctx context.Context, instanceName string, interval time.Duration) {
+	for {
+		for i, backend := range bq.backends {
+			checkCtx, cancel := bq.clock.NewContextWithTimeout(ctx, interval)
+			_, err := backend.GetCapabilities(checkCtx, &remoteexecution.GetCapabilitiesRequest{
+				InstanceName: instanceName,
+			})
+			cancel()
+			if ctx.Err() != nil {
+				return
+			}
+			bq.setHealthy(i, err)
+		}
+
+		timer, t := bq.clock.NewTimer(interval)
+		select {
+		case <-t:
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+}
+
+func (bq *FailoverBuildQueue) setHealthy(i int, err error) {
+	bq.lock.Lock()
+	defer bq.lock.Unlock()
+	healthy := err == nil
+	if !healthy {
+		bq.retryTimes[i] = bq.clock.Now().Add(bq.unhealthyRetryInterval)
+	}
+	if bq.healthy[i] != healthy {
+		if healthy {
+			log.Printf("Scheduler backend %d became healthy", i)
+		} else {
+			log.Printf("Scheduler backend %d became unhealthy: %s", i, err)
+		}
+		bq.healthy[i] = healthy
+	}
+}
+
+// getBackend returns the index of the backend that should be used for
+// a given attempt. Healthy backends are preferred. Unhealthy backends
+// that precede them are retried periodically. If no backends are
+// healthy, all backends are tried in a round-robin fashion.
+func (bq *FailoverBuildQueue) getBackend(attempt int) int {
+	bq.lock.Lock()
+	defer bq.lock.Unlock()
+	var now time.Time
+	for i, healthy := range bq.healthy {
+		if healthy {
+			return i
+		}
+		if now.IsZero() {
+			now = bq.clock.Now()
+		}
+		if !now.Before(bq.retryTimes[i]) {
+			// Only let a single request through until the
+			// outcome of this request is known.
+			bq.retryTimes[i] = now.Add(bq.unhealthyRetryInterval)
+			return i
+		}
+	}
+	return attempt % len(bq.backends)
+}
+
+// waitForRetry blocks until the next attempt may be performed.
+func (bq *FailoverBuildQueue) waitForRetry(ctx context.Context) error {
+	timer, t := bq.clock.NewTimer(bq.retryDelay)
+	select {
+	case <-t:
+		return nil
+	case <-ctx.Done():
+		timer.Stop()
+		return util.StatusFromContext(ctx)
+	}
+}
+
+// GetCapabilities forwards the request to the first healthy backend.
+func (bq *FailoverBuildQueue) GetCapabilities(ctx context.Context, in *remoteexecution.GetCapabilitiesRequest) (*remoteexecution.ServerCapabilities, error) {
+	for attempt := 0; ; attempt++ {
+		i := bq.getBackend(attempt)
+		capabilities, err := bq.backends[i].GetCapabilities(ctx, in)
+		if status.Code(err) != codes.Unavailable {
+			bq.setHealthy(i, nil)
+			return capabilities, err
+		}
+		bq.setHealthy(i, err)
+		if attempt >= bq.maximumRetries {
+			return nil, err
+		}
+		if err := bq.waitForRetry(ctx); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Execute forwards the request to the first healthy backend. If the
+// backend becomes unavailable, the operation is resumed against
+// another backend.
+func (bq *FailoverBuildQueue) Execute(in *remoteexecution.ExecuteRequest, out remoteexecution.Execution_ExecuteServer) error {
+	return bq.forwardOperation(in, "", out)
+}
+
+// WaitExecution forwards the request to the first healthy backend. If
+// the backend becomes unavailable, the operation is resumed against
+// another backend.
+func (bq *FailoverBuildQueue) WaitExecution(in *remoteexecution.WaitExecutionRequest, out remoteexecution.Execution_WaitExecutionServer) error {
+	return bq.forwardOperation(nil, in.Name, out)
+}
+
+// forwardOperation forwards an operation to a backend, retrying
+// against other backends in case of failures. If an ExecuteRequest is
+// provided, the action is resubmitted in case the operation is
+// unknown to the backend.
+func (bq *FailoverBuildQueue) forwardOperation(in *remoteexecution.ExecuteRequest, operationName string, out remoteexecution.Execution_ExecuteServer) error {
+	for attempt := 0; ; attempt++ {
+		i := bq.getBackend(attempt)
+		backend := bq.backends[i]
+		recorder := operationNameRecorder{
+			Execution_ExecuteServer: out,
+			operationName:           operationName,
+		}
+		var err error
+		if operationName == "" {
+			err = backend.Execute(in, &recorder)
+		} else {
+			err = backend.WaitExecution(&remoteexecution.WaitExecutionRequest{
+				Name: operationName,
+			}, &recorder)
+			if in != nil && recorder.sendErr == nil && status.Code(err) == codes.NotFound {
+				// The backend no longer knows about the
+				// operation, e.g. because all of its
+				// replicas restarted. Resubmit the action.
+				recorder.operationName = ""
+				err = backend.Execute(in, &recorder)
+			}
+		}
+
+		// Errors sending operations to the client are never
+		// retried, as the client has gone away.
+		if recorder.sendErr != nil {
+			return recorder.sendErr
+		}
+		if status.Code(err) != codes.Unavailable {
+			bq.setHealthy(i, nil)
+			return err
+		}
+		bq.setHealthy(i, err)
+		if attempt >= bq.maximumRetries {
+			return err
+		}
+		operationName = recorder.operationName
+		if err := bq.waitForRetry(out.Context()); err != nil {
+			return err
+		}
+	}
+}
+
+// operationNameRecorder is a decorator for Execution_ExecuteServer
+// that keeps track of the name of the last operation sent to the
+// client, so that the operation can be resumed if the stream from the
+// backend breaks.
+type operationNameRecorder struct {
+	remoteexecution.Execution_ExecuteServer
+	operationName string
+	sendErr       error
+}
+
+func (nr *operationNameRecorder) Send(operation *longrunning.Operation) error {
+	if err := nr.Execution_ExecuteServer.Send(operation); err != nil {
+		nr.sendErr = err
+		return err
+	}
+	nr.operationName = operation.Name
+	return nil
+}
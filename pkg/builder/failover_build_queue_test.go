@@ -0,0 +1,256 @@
+package builder_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/builder"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/genproto/googleapis/longrunning"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func expectRetryDelay(ctrl *gomock.Controller, clock *mock.MockClock) {
+	timer := mock.NewMockTimer(ctrl)
+	timerChannel := make(chan time.Time, 1)
+	timerChannel <- time.Unix(1000, 0)
+	clock.EXPECT().NewTimer(time.Second).Return(timer, timerChannel)
+}
+
+func TestFailoverBuildQueueExecute(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+	defer ctrl.Finish()
+
+	request := &remoteexecution.ExecuteRequest{
+		InstanceName: "default",
+		ActionDigest: &remoteexecution.Digest{
+			Hash:      "8b1a9953c4611296a827abf8c47804d7",
+			SizeBytes: 123,
+		},
+	}
+
+	t.Run("Success", func(t *testing.T) {
+		replica1 := mock.NewMockBuildQueue(ctrl)
+		replica2 := mock.NewMockBuildQueue(ctrl)
+		clock := mock.NewMockClock(ctrl)
+		clock.EXPECT().Now().Return(time.Unix(1000, 0)).AnyTimes()
+		buildQueue := builder.NewFailoverBuildQueue([]builder.BuildQueue{replica1, replica2}, clock, time.Second, 3, time.Minute)
+
+		executeServer := mock.NewMockExecution_ExecuteServer(ctrl)
+		replica1.EXPECT().Execute(request, gomock.Any())
+
+		require.NoError(t, buildQueue.Execute(request, executeServer))
+	})
+
+	t.Run("StreamBroken", func(t *testing.T) {
+		// If the stream breaks after an operation has been
+		// received, it should be resumed by calling
+		// WaitExecution() against another replica.
+		replica1 := mock.NewMockBuildQueue(ctrl)
+		replica2 := mock.NewMockBuildQueue(ctrl)
+		clock := mock.NewMockClock(ctrl)
+		clock.EXPECT().Now().Return(time.Unix(1000, 0)).AnyTimes()
+		buildQueue := builder.NewFailoverBuildQueue([]builder.BuildQueue{replica1, replica2}, clock, time.Second, 3, time.Minute)
+
+		executeServer := mock.NewMockExecution_ExecuteServer(ctrl)
+		executeServer.EXPECT().Context().Return(ctx).AnyTimes()
+		replica1.EXPECT().Execute(request, gomock.Any()).DoAndReturn(
+			func(in *remoteexecution.ExecuteRequest, out remoteexecution.Execution_ExecuteServer) error {
+				require.NoError(t, out.Send(&longrunning.Operation{Name: "a1b2c3"}))
+				return status.Error(codes.Unavailable, "Connection reset by peer")
+			})
+		executeServer.EXPECT().Send(&longrunning.Operation{Name: "a1b2c3"})
+		expectRetryDelay(ctrl, clock)
+		replica2.EXPECT().WaitExecution(&remoteexecution.WaitExecutionRequest{Name: "a1b2c3"}, gomock.Any()).DoAndReturn(
+			func(in *remoteexecution.WaitExecutionRequest, out remoteexecution.Execution_WaitExecutionServer) error {
+				return out.Send(&longrunning.Operation{Name: "a1b2c3", Done: true})
+			})
+		executeServer.EXPECT().Send(&longrunning.Operation{Name: "a1b2c3", Done: true})
+
+		require.NoError(t, buildQueue.Execute(request, executeServer))
+
+		// As the first replica is now considered unhealthy,
+		// successive requests should go to the second replica.
+		replica2.EXPECT().Execute(request, gomock.Any())
+
+		require.NoError(t, buildQueue.Execute(request, executeServer))
+	})
+
+	t.Run("OperationLost", func(t *testing.T) {
+		// If the operation is no longer known after the stream
+		// breaks, the action should be resubmitted.
+		replica1 := mock.NewMockBuildQueue(ctrl)
+		clock := mock.NewMockClock(ctrl)
+		clock.EXPECT().Now().Return(time.Unix(1000, 0)).AnyTimes()
+		buildQueue := builder.NewFailoverBuildQueue([]builder.BuildQueue{replica1}, clock, time.Second, 3, time.Minute)
+
+		executeServer := mock.NewMockExecution_ExecuteServer(ctrl)
+		executeServer.EXPECT().Context().Return(ctx).AnyTimes()
+		replica1.EXPECT().Execute(request, gomock.Any()).DoAndReturn(
+			func(in *remoteexecution.ExecuteRequest, out remoteexecution.Execution_ExecuteServer) error {
+				require.NoError(t, out.Send(&longrunning.Operation{Name: "a1b2c3"}))
+				return status.Error(codes.Unavailable, "Server shutting down")
+			})
+		executeServer.EXPECT().Send(&longrunning.Operation{Name: "a1b2c3"})
+		expectRetryDelay(ctrl, clock)
+		replica1.EXPECT().WaitExecution(&remoteexecution.WaitExecutionRequest{Name: "a1b2c3"}, gomock.Any()).
+			Return(status.Error(codes.NotFound, "Operation not found"))
+		replica1.EXPECT().Execute(request, gomock.Any()).DoAndReturn(
+			func(in *remoteexecution.ExecuteRequest, out remoteexecution.Execution_ExecuteServer) error {
+				return out.Send(&longrunning.Operation{Name: "d4e5f6", Done: true})
+			})
+		executeServer.EXPECT().Send(&longrunning.Operation{Name: "d4e5f6", Done: true})
+
+		require.NoError(t, buildQueue.Execute(request, executeServer))
+	})
+
+	t.Run("RetryUnhealthy", func(t *testing.T) {
+		// Without health checks, an unhealthy replica should
+		// periodically receive a single request, so that it is
+		// used again once it has recovered.
+		replica1 := mock.NewMockBuildQueue(ctrl)
+		replica2 := mock.NewMockBuildQueue(ctrl)
+		clock := mock.NewMockClock(ctrl)
+		buildQueue := builder.NewFailoverBuildQueue([]builder.BuildQueue{replica1, replica2}, clock, time.Second, 3, time.Minute)
+
+		executeServer := mock.NewMockExecution_ExecuteServer(ctrl)
+		executeServer.EXPECT().Context().Return(ctx).AnyTimes()
+		replica1.EXPECT().Execute(request, gomock.Any()).Return(status.Error(codes.Unavailable, "Connection refused"))
+		clock.EXPECT().Now().Return(time.Unix(1000, 0))
+		expectRetryDelay(ctrl, clock)
+		clock.EXPECT().Now().Return(time.Unix(1001, 0))
+		replica2.EXPECT().Execute(request, gomock.Any())
+
+		require.NoError(t, buildQueue.Execute(request, executeServer))
+
+		// Before the retry interval has passed, requests should
+		// go to the second replica.
+		clock.EXPECT().Now().Return(time.Unix(1059, 0))
+		replica2.EXPECT().Execute(request, gomock.Any())
+
+		require.NoError(t, buildQueue.Execute(request, executeServer))
+
+		// After the retry interval has passed, the first replica
+		// should be tried again. As it succeeds, it should be
+		// considered healthy again.
+		clock.EXPECT().Now().Return(time.Unix(1060, 0))
+		replica1.EXPECT().Execute(request, gomock.Any())
+
+		require.NoError(t, buildQueue.Execute(request, executeServer))
+
+		replica1.EXPECT().Execute(request, gomock.Any())
+
+		require.NoError(t, buildQueue.Execute(request, executeServer))
+	})
+
+	t.Run("ClientGone", func(t *testing.T) {
+		// Failures to send operations to the client should not
+		// cause the request to be retried.
+		replica1 := mock.NewMockBuildQueue(ctrl)
+		replica2 := mock.NewMockBuildQueue(ctrl)
+		clock := mock.NewMockClock(ctrl)
+		clock.EXPECT().Now().Return(time.Unix(1000, 0)).AnyTimes()
+		buildQueue := builder.NewFailoverBuildQueue([]builder.BuildQueue{replica1, replica2}, clock, time.Second, 3, time.Minute)
+
+		executeServer := mock.NewMockExecution_ExecuteServer(ctrl)
+		replica1.EXPECT().Execute(request, gomock.Any()).DoAndReturn(
+			func(in *remoteexecution.ExecuteRequest, out remoteexecution.Execution_ExecuteServer) error {
+				return out.Send(&longrunning.Operation{Name: "a1b2c3"})
+			})
+		executeServer.EXPECT().Send(&longrunning.Operation{Name: "a1b2c3"}).Return(status.Error(codes.Unavailable, "Transport is closing"))
+
+		require.Equal(t, status.Error(codes.Unavailable, "Transport is closing"), buildQueue.Execute(request, executeServer))
+	})
+
+	t.Run("RetriesExhausted", func(t *testing.T) {
+		replica1 := mock.NewMockBuildQueue(ctrl)
+		replica2 := mock.NewMockBuildQueue(ctrl)
+		clock := mock.NewMockClock(ctrl)
+		clock.EXPECT().Now().Return(time.Unix(1000, 0)).AnyTimes()
+		buildQueue := builder.NewFailoverBuildQueue([]builder.BuildQueue{replica1, replica2}, clock, time.Second, 2, time.Minute)
+
+		// With all replicas unavailable, they should be tried
+		// in a round-robin fashion.
+		executeServer := mock.NewMockExecution_ExecuteServer(ctrl)
+		executeServer.EXPECT().Context().Return(ctx).AnyTimes()
+		gomock.InOrder(
+			replica1.EXPECT().Execute(request, gomock.Any()).Return(status.Error(codes.Unavailable, "Connection refused")),
+			replica2.EXPECT().Execute(request, gomock.Any()).Return(status.Error(codes.Unavailable, "Connection refused")),
+			replica1.EXPECT().Execute(request, gomock.Any()).Return(status.Error(codes.Unavailable, "Connection refused")))
+		expectRetryDelay(ctrl, clock)
+		expectRetryDelay(ctrl, clock)
+
+		require.Equal(t, status.Error(codes.Unavailable, "Connection refused"), buildQueue.Execute(request, executeServer))
+	})
+}
+
+func TestFailoverBuildQueueWaitExecution(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+	defer ctrl.Finish()
+
+	replica1 := mock.NewMockBuildQueue(ctrl)
+	replica2 := mock.NewMockBuildQueue(ctrl)
+	clock := mock.NewMockClock(ctrl)
+	clock.EXPECT().Now().Return(time.Unix(1000, 0)).AnyTimes()
+	buildQueue := builder.NewFailoverBuildQueue([]builder.BuildQueue{replica1, replica2}, clock, time.Second, 3, time.Minute)
+
+	// Without an ExecuteRequest, operations that are no longer
+	// known cannot be resubmitted. The error should be returned
+	// to the client.
+	waitExecutionServer := mock.NewMockExecution_WaitExecutionServer(ctrl)
+	waitExecutionServer.EXPECT().Context().Return(ctx).AnyTimes()
+	replica1.EXPECT().WaitExecution(&remoteexecution.WaitExecutionRequest{Name: "a1b2c3"}, gomock.Any()).
+		Return(status.Error(codes.Unavailable, "Connection reset by peer"))
+	expectRetryDelay(ctrl, clock)
+	replica2.EXPECT().WaitExecution(&remoteexecution.WaitExecutionRequest{Name: "a1b2c3"}, gomock.Any()).
+		Return(status.Error(codes.NotFound, "Operation not found"))
+
+	require.Equal(
+		t,
+		status.Error(codes.NotFound, "Operation not found"),
+		buildQueue.WaitExecution(&remoteexecution.WaitExecutionRequest{Name: "a1b2c3"}, waitExecutionServer))
+}
+
+func TestFailoverBuildQueueRunHealthChecks(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+	defer ctrl.Finish()
+
+	replica1 := mock.NewMockBuildQueue(ctrl)
+	replica2 := mock.NewMockBuildQueue(ctrl)
+	clock := mock.NewMockClock(ctrl)
+	clock.EXPECT().Now().Return(time.Unix(1000, 0)).AnyTimes()
+	buildQueue := builder.NewFailoverBuildQueue([]builder.BuildQueue{replica1, replica2}, clock, time.Second, 3, time.Minute)
+
+	// Perform a single round of health checks, in which the first
+	// replica fails.
+	healthCheckCtx, cancel := context.WithCancel(ctx)
+	clock.EXPECT().NewContextWithTimeout(gomock.Any(), 10*time.Second).
+		DoAndReturn(func(parent context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+			return context.WithCancel(parent)
+		}).
+		Times(2)
+	replica1.EXPECT().GetCapabilities(gomock.Any(), &remoteexecution.GetCapabilitiesRequest{InstanceName: "default"}).
+		Return(nil, status.Error(codes.Unavailable, "Connection refused"))
+	replica2.EXPECT().GetCapabilities(gomock.Any(), &remoteexecution.GetCapabilitiesRequest{InstanceName: "default"}).
+		Return(&remoteexecution.ServerCapabilities{}, nil)
+	timer := mock.NewMockTimer(ctrl)
+	clock.EXPECT().NewTimer(10 * time.Second).DoAndReturn(func(d time.Duration) (*mock.MockTimer, <-chan time.Time) {
+		cancel()
+		return timer, nil
+	})
+	timer.EXPECT().Stop().Return(true)
+
+	buildQueue.RunHealthChecks(healthCheckCtx, "default", 10*time.Second)
+
+	// Requests should now be forwarded to the second replica.
+	executeServer := mock.NewMockExecution_ExecuteServer(ctrl)
+	replica2.EXPECT().Execute(gomock.Any(), gomock.Any())
+
+	require.NoError(t, buildQueue.Execute(&remoteexecution.ExecuteRequest{}, executeServer))
+}
@@ -0,0 +1,142 @@
+package builder
+
+import (
+	"context"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/pkg/auth"
+	"github.com/buildbarn/bb-storage/pkg/cas"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/golang/protobuf/proto"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// PlatformRoutingRule is a rule used by the platform routing build
+// queue to determine to which backend an action should be forwarded.
+type PlatformRoutingRule struct {
+	// Name of the backend. This name is stored in operation names,
+	// so that calls to WaitExecution() may be forwarded to the same
+	// backend. It may not contain a pipe character.
+	Name string
+
+	// Platform properties that an action must have for this rule
+	// to match. Properties of the action that are not listed are
+	// ignored.
+	PlatformProperties map[string]string
+
+	BuildQueue BuildQueue
+}
+
+func (r *PlatformRoutingRule) matches(platform *remoteexecution.Platform) bool {
+	for name, value := range r.PlatformProperties {
+		found := false
+		for _, property := range platform.GetProperties() {
+			if property.Name == name && property.Value == value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+type platformRoutingBuildQueue struct {
+	contentAddressableStorage cas.ContentAddressableStorage
+	authorizer                auth.Authorizer
+	rules                     []PlatformRoutingRule
+	backendsByName            map[string]BuildQueue
+}
+
+// NewPlatformRoutingBuildQueue creates an adapter for the Execution
+// service to forward requests to different backends, based on the
+// platform properties of the action that is executed. The Action and
+// Command messages are loaded from the Content Addressable Storage,
+// and the backend of the first matching rule is used. As this is done
+// on behalf of the client, the client must be permitted to read from
+// the Content Addressable Storage.
+//
+// Operation names returned by backends are prefixed with the name of
+// the backend, so that successive calls to WaitExecution() may be
+// forwarded to the same backend. Calls to GetCapabilities() are
+// forwarded to the backend of the first rule, as all backends are
+// assumed to have identical capabilities. At least one rule must be
+// provided.
+func NewPlatformRoutingBuildQueue(contentAddressableStorage cas.ContentAddressableStorage, authorizer auth.Authorizer, rules []PlatformRoutingRule) BuildQueue {
+	backendsByName := map[string]BuildQueue{}
+	for _, rule := range rules {
+		backendsByName[rule.Name] = rule.BuildQueue
+	}
+	return &platformRoutingBuildQueue{
+		contentAddressableStorage: contentAddressableStorage,
+		authorizer:                authorizer,
+		rules:                     rules,
+		backendsByName:            backendsByName,
+	}
+}
+
+func (bq *platformRoutingBuildQueue) GetCapabilities(ctx context.Context, in *remoteexecution.GetCapabilitiesRequest) (*remoteexecution.ServerCapabilities, error) {
+	return bq.rules[0].BuildQueue.GetCapabilities(ctx, in)
+}
+
+func (bq *platformRoutingBuildQueue) getMatchingRule(ctx context.Context, in *remoteexecution.ExecuteRequest) (*PlatformRoutingRule, error) {
+	actionDigest, err := digest.NewDigestFromPartialDigest(in.InstanceName, in.DigestFunction, in.ActionDigest)
+	if err != nil {
+		return nil, util.StatusWrap(err, "Failed to extract digest for action")
+	}
+	if err := bq.authorizer.Authorize(ctx, in.InstanceName, auth.OperationContentAddressableStorageRead); err != nil {
+		return nil, err
+	}
+	action, err := bq.contentAddressableStorage.GetAction(ctx, actionDigest)
+	if err != nil {
+		return nil, util.StatusWrap(err, "Failed to obtain action")
+	}
+	commandDigest, err := actionDigest.NewDerivedDigest(action.CommandDigest)
+	if err != nil {
+		return nil, util.StatusWrap(err, "Failed to extract digest for command")
+	}
+	command, err := bq.contentAddressableStorage.GetCommand(ctx, commandDigest)
+	if err != nil {
+		return nil, util.StatusWrap(err, "Failed to obtain command")
+	}
+
+	for i := range bq.rules {
+		if rule := &bq.rules[i]; rule.matches(command.Platform) {
+			return rule, nil
+		}
+	}
+	return nil, status.Errorf(codes.FailedPrecondition, "No scheduler is available for platform %#v", proto.CompactTextString(command.Platform))
+}
+
+func (bq *platformRoutingBuildQueue) Execute(in *remoteexecution.ExecuteRequest, out remoteexecution.Execution_ExecuteServer) error {
+	rule, err := bq.getMatchingRule(out.Context(), in)
+	if err != nil {
+		return err
+	}
+	return rule.BuildQueue.Execute(in, &operationNamePrepender{
+		Execution_ExecuteServer: out,
+		prefix:                  rule.Name,
+	})
+}
+
+func (bq *platformRoutingBuildQueue) WaitExecution(in *remoteexecution.WaitExecutionRequest, out remoteexecution.Execution_WaitExecutionServer) error {
+	backendName, operationName, ok := splitOperationName(in.Name)
+	if !ok {
+		return status.Errorf(codes.InvalidArgument, "Unable to extract scheduler from operation name")
+	}
+	backend, ok := bq.backendsByName[backendName]
+	if !ok {
+		return status.Errorf(codes.NotFound, "Operation name refers to unknown scheduler %#v", backendName)
+	}
+	requestCopy := *in
+	requestCopy.Name = operationName
+	return backend.WaitExecution(&requestCopy, &operationNamePrepender{
+		Execution_ExecuteServer: out,
+		prefix:                  backendName,
+	})
+}
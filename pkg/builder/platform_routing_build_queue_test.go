@@ -0,0 +1,200 @@
+package builder_test
+
+import (
+	"context"
+	"testing"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/auth"
+	"github.com/buildbarn/bb-storage/pkg/builder"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/genproto/googleapis/longrunning"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestPlatformRoutingBuildQueueExecute(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+	defer ctrl.Finish()
+
+	contentAddressableStorage := mock.NewMockContentAddressableStorage(ctrl)
+	authorizer := mock.NewMockAuthorizer(ctrl)
+	armBuildQueue := mock.NewMockBuildQueue(ctrl)
+	x86BuildQueue := mock.NewMockBuildQueue(ctrl)
+	buildQueue := builder.NewPlatformRoutingBuildQueue(
+		contentAddressableStorage,
+		authorizer,
+		[]builder.PlatformRoutingRule{
+			{
+				Name: "arm",
+				PlatformProperties: map[string]string{
+					"OSFamily": "Linux",
+					"cpu":      "aarch64",
+				},
+				BuildQueue: armBuildQueue,
+			},
+			{
+				Name: "x86",
+				PlatformProperties: map[string]string{
+					"OSFamily": "Linux",
+				},
+				BuildQueue: x86BuildQueue,
+			},
+		})
+
+	request := &remoteexecution.ExecuteRequest{
+		InstanceName: "default",
+		ActionDigest: &remoteexecution.Digest{
+			Hash:      "8b1a9953c4611296a827abf8c47804d7",
+			SizeBytes: 123,
+		},
+	}
+	actionDigest := digest.MustNewDigest("default", remoteexecution.DigestFunction_MD5, "8b1a9953c4611296a827abf8c47804d7", 123)
+	commandDigest := digest.MustNewDigest("default", remoteexecution.DigestFunction_MD5, "5d41402abc4b2a76b9719d911017c592", 456)
+	contentAddressableStorage.EXPECT().GetAction(ctx, actionDigest).Return(&remoteexecution.Action{
+		CommandDigest: &remoteexecution.Digest{
+			Hash:      "5d41402abc4b2a76b9719d911017c592",
+			SizeBytes: 456,
+		},
+	}, nil).AnyTimes()
+
+	t.Run("FirstRule", func(t *testing.T) {
+		// Actions should be forwarded to the backend of the
+		// first matching rule. Operation names should be
+		// prefixed with the name of the backend.
+		authorizer.EXPECT().Authorize(ctx, "default", auth.OperationContentAddressableStorageRead)
+		contentAddressableStorage.EXPECT().GetCommand(ctx, commandDigest).Return(&remoteexecution.Command{
+			Platform: &remoteexecution.Platform{
+				Properties: []*remoteexecution.Platform_Property{
+					{Name: "OSFamily", Value: "Linux"},
+					{Name: "cpu", Value: "aarch64"},
+				},
+			},
+		}, nil)
+		executeServer := mock.NewMockExecution_ExecuteServer(ctrl)
+		executeServer.EXPECT().Context().Return(ctx).AnyTimes()
+		armBuildQueue.EXPECT().Execute(request, gomock.Any()).DoAndReturn(
+			func(in *remoteexecution.ExecuteRequest, out remoteexecution.Execution_ExecuteServer) error {
+				return out.Send(&longrunning.Operation{Name: "a1b2c3"})
+			})
+		executeServer.EXPECT().Send(&longrunning.Operation{Name: "arm|a1b2c3"})
+
+		require.NoError(t, buildQueue.Execute(request, executeServer))
+	})
+
+	t.Run("SecondRule", func(t *testing.T) {
+		authorizer.EXPECT().Authorize(ctx, "default", auth.OperationContentAddressableStorageRead)
+		contentAddressableStorage.EXPECT().GetCommand(ctx, commandDigest).Return(&remoteexecution.Command{
+			Platform: &remoteexecution.Platform{
+				Properties: []*remoteexecution.Platform_Property{
+					{Name: "OSFamily", Value: "Linux"},
+					{Name: "cpu", Value: "x86_64"},
+				},
+			},
+		}, nil)
+		executeServer := mock.NewMockExecution_ExecuteServer(ctrl)
+		executeServer.EXPECT().Context().Return(ctx).AnyTimes()
+		x86BuildQueue.EXPECT().Execute(request, gomock.Any())
+
+		require.NoError(t, buildQueue.Execute(request, executeServer))
+	})
+
+	t.Run("NoMatchingRule", func(t *testing.T) {
+		authorizer.EXPECT().Authorize(ctx, "default", auth.OperationContentAddressableStorageRead)
+		contentAddressableStorage.EXPECT().GetCommand(ctx, commandDigest).Return(&remoteexecution.Command{
+			Platform: &remoteexecution.Platform{
+				Properties: []*remoteexecution.Platform_Property{
+					{Name: "OSFamily", Value: "Windows"},
+				},
+			},
+		}, nil)
+		executeServer := mock.NewMockExecution_ExecuteServer(ctrl)
+		executeServer.EXPECT().Context().Return(ctx).AnyTimes()
+
+		require.Equal(
+			t,
+			status.Error(codes.FailedPrecondition, "No scheduler is available for platform \"properties:<name:\\\"OSFamily\\\" value:\\\"Windows\\\" > \""),
+			buildQueue.Execute(request, executeServer))
+	})
+
+	t.Run("Unauthorized", func(t *testing.T) {
+		// Clients that may not read from the Content
+		// Addressable Storage should not be able to use the
+		// scheduler to probe for its contents.
+		authorizer.EXPECT().Authorize(ctx, "default", auth.OperationContentAddressableStorageRead).
+			Return(status.Error(codes.PermissionDenied, "Permission denied"))
+		executeServer := mock.NewMockExecution_ExecuteServer(ctrl)
+		executeServer.EXPECT().Context().Return(ctx).AnyTimes()
+
+		require.Equal(
+			t,
+			status.Error(codes.PermissionDenied, "Permission denied"),
+			buildQueue.Execute(request, executeServer))
+	})
+
+	t.Run("MissingCommand", func(t *testing.T) {
+		authorizer.EXPECT().Authorize(ctx, "default", auth.OperationContentAddressableStorageRead)
+		contentAddressableStorage.EXPECT().GetCommand(ctx, commandDigest).Return(nil, status.Error(codes.NotFound, "Object not found"))
+		executeServer := mock.NewMockExecution_ExecuteServer(ctrl)
+		executeServer.EXPECT().Context().Return(ctx).AnyTimes()
+
+		require.Equal(
+			t,
+			status.Error(codes.NotFound, "Failed to obtain command: Object not found"),
+			buildQueue.Execute(request, executeServer))
+	})
+}
+
+func TestPlatformRoutingBuildQueueWaitExecution(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	contentAddressableStorage := mock.NewMockContentAddressableStorage(ctrl)
+	authorizer := mock.NewMockAuthorizer(ctrl)
+	armBuildQueue := mock.NewMockBuildQueue(ctrl)
+	buildQueue := builder.NewPlatformRoutingBuildQueue(
+		contentAddressableStorage,
+		authorizer,
+		[]builder.PlatformRoutingRule{
+			{
+				Name:       "arm",
+				BuildQueue: armBuildQueue,
+			},
+		})
+
+	t.Run("Success", func(t *testing.T) {
+		// The backend name should be stripped from the
+		// operation name prior to forwarding, and added again
+		// to operations that are returned.
+		waitExecutionServer := mock.NewMockExecution_WaitExecutionServer(ctrl)
+		armBuildQueue.EXPECT().WaitExecution(&remoteexecution.WaitExecutionRequest{Name: "a1b2c3"}, gomock.Any()).DoAndReturn(
+			func(in *remoteexecution.WaitExecutionRequest, out remoteexecution.Execution_WaitExecutionServer) error {
+				return out.Send(&longrunning.Operation{Name: "a1b2c3", Done: true})
+			})
+		waitExecutionServer.EXPECT().Send(&longrunning.Operation{Name: "arm|a1b2c3", Done: true})
+
+		require.NoError(t, buildQueue.WaitExecution(&remoteexecution.WaitExecutionRequest{Name: "arm|a1b2c3"}, waitExecutionServer))
+	})
+
+	t.Run("UnknownBackend", func(t *testing.T) {
+		waitExecutionServer := mock.NewMockExecution_WaitExecutionServer(ctrl)
+
+		require.Equal(
+			t,
+			status.Error(codes.NotFound, "Operation name refers to unknown scheduler \"x86\""),
+			buildQueue.WaitExecution(&remoteexecution.WaitExecutionRequest{Name: "x86|a1b2c3"}, waitExecutionServer))
+	})
+
+	t.Run("MalformedName", func(t *testing.T) {
+		waitExecutionServer := mock.NewMockExecution_WaitExecutionServer(ctrl)
+
+		require.Equal(
+			t,
+			status.Error(codes.InvalidArgument, "Unable to extract scheduler from operation name"),
+			buildQueue.WaitExecution(&remoteexecution.WaitExecutionRequest{Name: "a1b2c3"}, waitExecutionServer))
+	})
+}
@@ -5,6 +5,7 @@ import (
 	"io"
 	"testing"
 
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
 	"github.com/buildbarn/bb-storage/internal/mock"
 	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
 	"github.com/buildbarn/bb-storage/pkg/cas"
@@ -45,7 +46,7 @@ func TestBlobAccessContentAddressableStoragePutFileSuccess(t *testing.T) {
 	// Operations that should appear against the BlobAccess. Read
 	// all the data to ensure all file operations are triggered.
 	blobAccess := mock.NewMockBlobAccess(ctrl)
-	helloWorldDigest := digest.MustNewDigest("default-scheduler", "3e25960a79dbc69b674cd4ec67a72c62", 11)
+	helloWorldDigest := digest.MustNewDigest("default-scheduler", remoteexecution.DigestFunction_MD5, "3e25960a79dbc69b674cd4ec67a72c62", 11)
 	blobAccess.EXPECT().Put(ctx, helloWorldDigest, gomock.Any()).DoAndReturn(
 		func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
 			data, err := b.ToByteSlice(100)
@@ -59,7 +60,7 @@ func TestBlobAccessContentAddressableStoragePutFileSuccess(t *testing.T) {
 		ctx,
 		directory,
 		"hello",
-		digest.MustNewDigest("default-scheduler", "d41d8cd98f00b204e9800998ecf8427e", 123))
+		digest.MustNewDigest("default-scheduler", remoteexecution.DigestFunction_MD5, "d41d8cd98f00b204e9800998ecf8427e", 123))
 	require.NoError(t, err)
 	require.Equal(t, digest, helloWorldDigest)
 }
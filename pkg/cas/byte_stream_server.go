@@ -3,68 +3,220 @@ package cas
 import (
 	"context"
 	"io"
-	"strconv"
+	"io/ioutil"
+	"log"
+	"os"
 	"strings"
+	"sync"
+	"time"
 
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/pkg/auth"
 	"github.com/buildbarn/bb-storage/pkg/blobstore"
 	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/clock"
 	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/filesystem"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/google/uuid"
 
 	"google.golang.org/genproto/googleapis/bytestream"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
 
-// parseResourceNameWrite parses resource name strings in one of the following two forms:
+// parseResourceNameWrite parses resource name strings in one of the
+// following four forms:
 //
 // - uploads/${uuid}/blobs/${hash}/${size}
 // - ${instance}/uploads/${uuid}/blobs/${hash}/${size}
+// - uploads/${uuid}/compressed-blobs/${compressor}/${hash}/${size}
+// - ${instance}/uploads/${uuid}/compressed-blobs/${compressor}/${hash}/${size}
 //
-// In the process, the hash, size and instance are extracted.
-func parseResourceNameWrite(resourceName string) (digest.Digest, error) {
+// For digest functions that cannot be inferred from the length of the
+// hash, the hash is preceded by the name of the digest function.
+//
+// In the process, the hash, size, instance, compressor and upload UUID
+// are extracted.
+func parseResourceNameWrite(resourceName string) (digest.Digest, remoteexecution.Compressor_Value, string, error) {
 	fields := strings.FieldsFunc(resourceName, func(r rune) bool { return r == '/' })
 	l := len(fields)
-	if (l != 5 && l != 6) || fields[l-5] != "uploads" || fields[l-3] != "blobs" {
-		return digest.BadDigest, status.Errorf(codes.InvalidArgument, "Invalid resource naming scheme")
+	blobsIndex := -1
+	for i := l - 3; i >= 0 && i >= l-5; i-- {
+		if fields[i] == "blobs" || fields[i] == "compressed-blobs" {
+			blobsIndex = i
+			break
+		}
+	}
+	if blobsIndex < 0 {
+		return digest.BadDigest, remoteexecution.Compressor_IDENTITY, "", status.Error(codes.InvalidArgument, "Invalid resource naming scheme")
 	}
-	size, err := strconv.ParseInt(fields[l-1], 10, 64)
+	uploadsIndex := blobsIndex - 2
+	if (uploadsIndex != 0 && uploadsIndex != 1) || fields[uploadsIndex] != "uploads" {
+		return digest.BadDigest, remoteexecution.Compressor_IDENTITY, "", status.Error(codes.InvalidArgument, "Invalid resource naming scheme")
+	}
+
+	// Strip the upload UUID from the resource name, so that the
+	// remainder can be parsed as a regular read resource name.
+	d, compressor, err := digest.NewDigestFromBytestreamPath(
+		strings.Join(append(fields[:uploadsIndex:uploadsIndex], fields[blobsIndex:]...), "/"))
 	if err != nil {
-		return digest.BadDigest, status.Errorf(codes.InvalidArgument, "Invalid resource naming scheme")
+		return digest.BadDigest, remoteexecution.Compressor_IDENTITY, "", err
 	}
-	instance := ""
-	if l == 6 {
-		instance = fields[0]
+	return d, compressor, fields[uploadsIndex+1], nil
+}
+
+// SupportedCompressors is the list of compressors that may be used in
+// "compressed-blobs" resource names. It may be announced to clients
+// through GetCapabilities().
+var SupportedCompressors = []remoteexecution.Compressor_Value{
+	remoteexecution.Compressor_ZSTD,
+	remoteexecution.Compressor_DEFLATE,
+}
+
+// getCompressionAlgorithm converts a compressor that is part of a
+// resource name to the compression algorithm used to implement it.
+func getCompressionAlgorithm(compressor remoteexecution.Compressor_Value) (blobstore.CompressionAlgorithm, error) {
+	switch compressor {
+	case remoteexecution.Compressor_ZSTD:
+		return blobstore.CompressionAlgorithmZstd, nil
+	case remoteexecution.Compressor_DEFLATE:
+		return blobstore.CompressionAlgorithmDeflate, nil
+	default:
+		return 0, status.Errorf(codes.InvalidArgument, "Unsupported compressor %s", compressor)
+	}
+}
+
+// newCompressingChunkReader returns a ChunkReader that yields the
+// contents of a buffer in compressed form, starting at a given offset
+// within the compressed stream. Compression is performed by a separate
+// goroutine that is terminated when the ChunkReader is closed.
+func newCompressingChunkReader(b buffer.Buffer, algorithm blobstore.CompressionAlgorithm, off int64, maximumChunkSizeBytes int) (buffer.ChunkReader, error) {
+	if off < 0 {
+		b.Discard()
+		return nil, status.Errorf(codes.InvalidArgument, "Negative read offset: %d", off)
+	}
+	pr, pw := io.Pipe()
+	w, err := blobstore.NewCompressingWriter(algorithm, pw)
+	if err != nil {
+		b.Discard()
+		return nil, err
+	}
+	go func() {
+		err := b.IntoWriter(w)
+		if closeErr := w.Close(); err == nil {
+			err = closeErr
+		}
+		pw.CloseWithError(err)
+	}()
+
+	if n, err := io.CopyN(ioutil.Discard, pr, off); err == io.EOF {
+		pr.Close()
+		return nil, status.Errorf(codes.InvalidArgument, "Compressed object is %d bytes in size, while a read at offset %d was requested", n, off)
+	} else if err != nil {
+		pr.Close()
+		return nil, err
 	}
-	return digest.NewDigest(instance, fields[l-2], size)
+	return buffer.NewReaderBackedChunkReader(pr, maximumChunkSizeBytes), nil
+}
+
+// byteStreamUpload holds the state of an upload whose data is written
+// into the staging directory, so that it may be resumed after the
+// client got disconnected.
+type byteStreamUpload struct {
+	digest             digest.Digest
+	compressor         remoteexecution.Compressor_Value
+	committedSizeBytes int64
+	inProgress         bool
+	lastActivity       time.Time
+}
+
+// matches returns whether an upload was started for a given digest and
+// compressor, meaning it may be resumed or queried.
+func (u *byteStreamUpload) matches(digest digest.Digest, compressor remoteexecution.Compressor_Value) bool {
+	return u.digest == digest && u.compressor == compressor
 }
 
 type byteStreamServer struct {
-	blobAccess    blobstore.BlobAccess
-	readChunkSize int
+	blobAccess             blobstore.BlobAccess
+	readChunkSize          int
+	uploadStagingDirectory filesystem.Directory
+	uploadIdleTimeout      time.Duration
+	maximumUploadSizeBytes int64
+	clock                  clock.Clock
+	authorizer             auth.Authorizer
+
+	lock             sync.Mutex
+	uploads          map[string]*byteStreamUpload
+	uploadsSizeBytes int64
 }
 
 // NewByteStreamServer creates a GRPC service for reading blobs from and
 // writing blobs to a BlobAccess. It is used by Bazel to access the
 // Content Addressable Storage (CAS).
-func NewByteStreamServer(blobAccess blobstore.BlobAccess, readChunkSize int) bytestream.ByteStreamServer {
+//
+// When an upload staging directory is provided, data sent through
+// Write() is first stored in a file named after the upload UUID in
+// the resource name. Only when the client finishes the write, the file
+// is copied into the BlobAccess. This permits clients to resume
+// interrupted uploads at the offset reported by QueryWriteStatus().
+// The staging directory is assumed to be empty at startup.
+//
+// Uploads that have not received any data for uploadIdleTimeout are
+// considered to be abandoned. Their data is removed from the staging
+// directory. The total amount of data stored in the staging directory
+// is limited to maximumUploadSizeBytes. When exceeded, the least
+// recently active uploads that are not in progress are removed. If
+// that does not free up enough space, writes fail with
+// RESOURCE_EXHAUSTED.
+//
+// Every request is checked against an Authorizer before the backend is
+// accessed.
+func NewByteStreamServer(blobAccess blobstore.BlobAccess, readChunkSize int, uploadStagingDirectory filesystem.Directory, uploadIdleTimeout time.Duration, maximumUploadSizeBytes int64, clock clock.Clock, authorizer auth.Authorizer) bytestream.ByteStreamServer {
 	return &byteStreamServer{
-		blobAccess:    blobAccess,
-		readChunkSize: readChunkSize,
+		blobAccess:             blobAccess,
+		readChunkSize:          readChunkSize,
+		uploadStagingDirectory: uploadStagingDirectory,
+		uploadIdleTimeout:      uploadIdleTimeout,
+		maximumUploadSizeBytes: maximumUploadSizeBytes,
+		clock:                  clock,
+		authorizer:             authorizer,
+
+		uploads: map[string]*byteStreamUpload{},
 	}
 }
 
 func (s *byteStreamServer) Read(in *bytestream.ReadRequest, out bytestream.ByteStream_ReadServer) error {
-	if in.ReadLimit != 0 {
-		return status.Error(codes.Unimplemented, "This service does not support downloading partial files")
+	if in.ReadLimit < 0 {
+		return status.Errorf(codes.InvalidArgument, "Negative read limit: %d", in.ReadLimit)
 	}
-	digest, err := digest.NewDigestFromBytestreamPath(in.ResourceName)
+	digest, compressor, err := digest.NewDigestFromBytestreamPath(in.ResourceName)
 	if err != nil {
 		return err
 	}
+	if err := s.authorizer.Authorize(out.Context(), digest.GetInstance(), auth.OperationContentAddressableStorageRead); err != nil {
+		return err
+	}
 
-	r := s.blobAccess.Get(out.Context(), digest).ToChunkReader(in.ReadOffset, s.readChunkSize)
+	// For compressed reads, the read offset and limit apply to the
+	// compressed data.
+	var r buffer.ChunkReader
+	if compressor == remoteexecution.Compressor_IDENTITY {
+		r = s.blobAccess.Get(out.Context(), digest).ToChunkReader(in.ReadOffset, s.readChunkSize)
+	} else {
+		algorithm, err := getCompressionAlgorithm(compressor)
+		if err != nil {
+			return err
+		}
+		r, err = newCompressingChunkReader(s.blobAccess.Get(out.Context(), digest), algorithm, in.ReadOffset, s.readChunkSize)
+		if err != nil {
+			return err
+		}
+	}
 	defer r.Close()
 
+	// A read limit of zero indicates that there is no limit.
+	bytesRemaining := in.ReadLimit
 	for {
 		readBuf, readErr := r.Read()
 		if readErr == io.EOF {
@@ -73,9 +225,13 @@ func (s *byteStreamServer) Read(in *bytestream.ReadRequest, out bytestream.ByteS
 		if readErr != nil {
 			return readErr
 		}
+		if in.ReadLimit != 0 && int64(len(readBuf)) >= bytesRemaining {
+			return out.Send(&bytestream.ReadResponse{Data: readBuf[:bytesRemaining]})
+		}
 		if writeErr := out.Send(&bytestream.ReadResponse{Data: readBuf}); writeErr != nil {
 			return writeErr
 		}
+		bytesRemaining -= int64(len(readBuf))
 	}
 }
 
@@ -127,25 +283,302 @@ func (s *byteStreamServer) Write(stream bytestream.ByteStream_WriteServer) error
 	if err != nil {
 		return err
 	}
-	digest, err := parseResourceNameWrite(request.ResourceName)
+	digest, compressor, uploadID, err := parseResourceNameWrite(request.ResourceName)
 	if err != nil {
 		return err
 	}
+	if err := s.authorizer.Authorize(stream.Context(), digest.GetInstance(), auth.OperationContentAddressableStorageWrite); err != nil {
+		return err
+	}
+	if s.uploadStagingDirectory != nil {
+		return s.writeStaged(stream, request, digest, compressor, uploadID)
+	}
 	r := &byteStreamWriteServerChunkReader{stream: stream}
 	if err := r.setRequest(request); err != nil {
 		return err
 	}
+	if compressor == remoteexecution.Compressor_IDENTITY {
+		if err := s.blobAccess.Put(
+			stream.Context(),
+			digest,
+			buffer.NewCASBufferFromChunkReader(digest, r, buffer.UserProvided)); err != nil {
+			return err
+		}
+		return stream.SendAndClose(&bytestream.WriteResponse{
+			CommittedSize: digest.GetSizeBytes(),
+		})
+	}
+
+	// Decompress data while it is being received. The committed
+	// size reported back to the client is expressed in terms of
+	// compressed data.
+	algorithm, err := getCompressionAlgorithm(compressor)
+	if err != nil {
+		return err
+	}
+	decompressingReader, err := blobstore.NewDecompressingReader(algorithm, buffer.NewChunkReaderBackedReader(r))
+	if err != nil {
+		return err
+	}
 	if err := s.blobAccess.Put(
 		stream.Context(),
 		digest,
-		buffer.NewCASBufferFromChunkReader(digest, r, buffer.UserProvided)); err != nil {
+		buffer.NewCASBufferFromReader(digest, decompressingReader, buffer.UserProvided)); err != nil {
 		return err
 	}
+
+	// The compressed stream may contain trailing data that was not
+	// consumed by the decompressor. Drain it to obtain the full size.
+	for !r.finishedWrite {
+		if _, err := r.Read(); err != nil {
+			return err
+		}
+	}
 	return stream.SendAndClose(&bytestream.WriteResponse{
-		CommittedSize: digest.GetSizeBytes(),
+		CommittedSize: r.writeOffset,
+	})
+}
+
+// getUploadFilename converts the upload UUID that is part of a
+// resource name to the name of a file in the staging directory.
+func getUploadFilename(uploadID string) (string, error) {
+	u, err := uuid.Parse(uploadID)
+	if err != nil {
+		return "", status.Errorf(codes.InvalidArgument, "Invalid upload UUID %#v", uploadID)
+	}
+	return u.String(), nil
+}
+
+// startUpload marks an upload as being in progress. Uploads may only be
+// resumed at the offset up to which data has been committed, or
+// restarted from the beginning.
+func (s *byteStreamServer) startUpload(digest digest.Digest, compressor remoteexecution.Compressor_Value, filename string, writeOffset int64) (*byteStreamUpload, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := s.clock.Now()
+	s.removeIdleUploads(now)
+	upload, ok := s.uploads[filename]
+	if ok && upload.inProgress {
+		return nil, status.Errorf(codes.Aborted, "Upload %s is already in progress", filename)
+	}
+	if writeOffset == 0 {
+		if ok {
+			s.uploadsSizeBytes -= upload.committedSizeBytes
+		}
+		upload = &byteStreamUpload{
+			digest:     digest,
+			compressor: compressor,
+		}
+		s.uploads[filename] = upload
+	} else if !ok || !upload.matches(digest, compressor) || upload.committedSizeBytes != writeOffset {
+		expectedOffset := int64(0)
+		if ok && upload.matches(digest, compressor) {
+			expectedOffset = upload.committedSizeBytes
+		}
+		return nil, status.Errorf(codes.InvalidArgument, "Attempted to write at offset %d, while %d was expected", writeOffset, expectedOffset)
+	}
+	upload.inProgress = true
+	upload.lastActivity = now
+	return upload, nil
+}
+
+// growUpload increases the committed size of an upload that is in
+// progress. Space in the staging directory is reclaimed by removing
+// the least recently active uploads that are not in progress.
+func (s *byteStreamServer) growUpload(upload *byteStreamUpload, committedSizeBytes int64) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := s.clock.Now()
+	s.removeIdleUploads(now)
+	growthBytes := committedSizeBytes - upload.committedSizeBytes
+	for s.uploadsSizeBytes+growthBytes > s.maximumUploadSizeBytes {
+		var oldestFilename string
+		var oldestUpload *byteStreamUpload
+		for filename, candidate := range s.uploads {
+			if !candidate.inProgress && (oldestUpload == nil || candidate.lastActivity.Before(oldestUpload.lastActivity)) {
+				oldestFilename, oldestUpload = filename, candidate
+			}
+		}
+		if oldestUpload == nil {
+			return status.Errorf(codes.ResourceExhausted, "Upload staging directory cannot hold another %d bytes, as %d of %d bytes are in use", growthBytes, s.uploadsSizeBytes, s.maximumUploadSizeBytes)
+		}
+		s.removeUpload(oldestFilename)
+	}
+	upload.committedSizeBytes = committedSizeBytes
+	upload.lastActivity = now
+	s.uploadsSizeBytes += growthBytes
+	return nil
+}
+
+func (s *byteStreamServer) stopUpload(upload *byteStreamUpload) {
+	s.lock.Lock()
+	upload.inProgress = false
+	upload.lastActivity = s.clock.Now()
+	s.lock.Unlock()
+}
+
+// removeIdleUploads removes all uploads that are not in progress and
+// have not received any data for the configured idle timeout.
+func (s *byteStreamServer) removeIdleUploads(now time.Time) {
+	for filename, upload := range s.uploads {
+		if !upload.inProgress && !now.Before(upload.lastActivity.Add(s.uploadIdleTimeout)) {
+			s.removeUpload(filename)
+		}
+	}
+}
+
+// removeUpload removes an upload and its staging file. The staging
+// file is removed while the lock is held, so that it cannot be
+// recreated by an upload using the same UUID in the meantime.
+func (s *byteStreamServer) removeUpload(filename string) {
+	if upload, ok := s.uploads[filename]; ok {
+		s.uploadsSizeBytes -= upload.committedSizeBytes
+		delete(s.uploads, filename)
+	}
+	if err := s.uploadStagingDirectory.Remove(filename); err != nil && !os.IsNotExist(err) {
+		log.Printf("Failed to remove upload staging file %#v: %s", filename, err)
+	}
+}
+
+// discardUpload removes all state associated with an upload, either
+// because it completed or because the data turned out to be invalid.
+func (s *byteStreamServer) discardUpload(filename string) {
+	s.lock.Lock()
+	s.removeUpload(filename)
+	s.lock.Unlock()
+}
+
+func (s *byteStreamServer) writeStaged(stream bytestream.ByteStream_WriteServer, request *bytestream.WriteRequest, digest digest.Digest, compressor remoteexecution.Compressor_Value, uploadID string) error {
+	filename, err := getUploadFilename(uploadID)
+	if err != nil {
+		return err
+	}
+	var algorithm blobstore.CompressionAlgorithm
+	if compressor != remoteexecution.Compressor_IDENTITY {
+		if algorithm, err = getCompressionAlgorithm(compressor); err != nil {
+			return err
+		}
+	}
+	upload, err := s.startUpload(digest, compressor, filename, request.WriteOffset)
+	if err != nil {
+		return err
+	}
+	defer s.stopUpload(upload)
+
+	f, err := s.uploadStagingDirectory.OpenReadWrite(filename, filesystem.CreateReuse(0600))
+	if err != nil {
+		return util.StatusWrapWithCode(err, codes.Internal, "Failed to open upload staging file")
+	}
+	defer f.Close()
+	if request.WriteOffset == 0 {
+		if err := f.Truncate(0); err != nil {
+			return util.StatusWrapWithCode(err, codes.Internal, "Failed to truncate upload staging file")
+		}
+	}
+
+	// Append data to the staging file, updating the committed size
+	// after every chunk, so that QueryWriteStatus() reports
+	// progress.
+	committedSizeBytes := request.WriteOffset
+	for {
+		if request.WriteOffset != committedSizeBytes {
+			return status.Errorf(codes.InvalidArgument, "Attempted to write at offset %d, while %d was expected", request.WriteOffset, committedSizeBytes)
+		}
+		// The size of compressed data cannot be bounded in
+		// advance, as incompressible data may grow slightly.
+		if newSizeBytes := committedSizeBytes + int64(len(request.Data)); compressor == remoteexecution.Compressor_IDENTITY && newSizeBytes > digest.GetSizeBytes() {
+			return status.Errorf(codes.InvalidArgument, "Attempted to write %d bytes, while the blob is only %d bytes in size", newSizeBytes, digest.GetSizeBytes())
+		}
+		if err := s.growUpload(upload, committedSizeBytes+int64(len(request.Data))); err != nil {
+			return err
+		}
+		if _, err := f.WriteAt(request.Data, committedSizeBytes); err != nil {
+			s.discardUpload(filename)
+			return util.StatusWrapWithCode(err, codes.Internal, "Failed to write to upload staging file")
+		}
+		committedSizeBytes += int64(len(request.Data))
+		if request.FinishWrite {
+			break
+		}
+
+		request, err = stream.Recv()
+		if err == io.EOF {
+			return status.Error(codes.InvalidArgument, "Client closed stream without finishing write")
+		} else if err != nil {
+			return err
+		}
+	}
+
+	// Copy the staging file into the BlobAccess, decompressing it
+	// if needed. Keep the staging file around in case of transient
+	// failures, so that the client may retry by only finishing the
+	// write.
+	var r io.ReadCloser = ioutil.NopCloser(io.NewSectionReader(f, 0, committedSizeBytes))
+	if compressor != remoteexecution.Compressor_IDENTITY {
+		if r, err = blobstore.NewDecompressingReader(algorithm, r); err != nil {
+			s.discardUpload(filename)
+			return err
+		}
+	}
+	if err := s.blobAccess.Put(
+		stream.Context(),
+		digest,
+		buffer.NewCASBufferFromReader(digest, r, buffer.UserProvided)); err != nil {
+		if status.Code(err) == codes.InvalidArgument {
+			s.discardUpload(filename)
+		}
+		return err
+	}
+	s.discardUpload(filename)
+	return stream.SendAndClose(&bytestream.WriteResponse{
+		CommittedSize: committedSizeBytes,
 	})
 }
 
 func (s *byteStreamServer) QueryWriteStatus(ctx context.Context, in *bytestream.QueryWriteStatusRequest) (*bytestream.QueryWriteStatusResponse, error) {
-	return nil, status.Error(codes.Unimplemented, "This service does not support querying write status")
+	blobDigest, compressor, uploadID, err := parseResourceNameWrite(in.ResourceName)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.authorizer.Authorize(ctx, blobDigest.GetInstance(), auth.OperationContentAddressableStorageWrite); err != nil {
+		return nil, err
+	}
+
+	// Uploads of objects that are already present may be skipped
+	// entirely.
+	missing, err := s.blobAccess.FindMissing(ctx, digest.NewSetBuilder().Add(blobDigest).Build())
+	if err != nil {
+		return nil, err
+	}
+	if missing.Empty() {
+		// The size of the object in compressed form is not
+		// known, in which case -1 is reported.
+		committedSizeBytes := blobDigest.GetSizeBytes()
+		if compressor != remoteexecution.Compressor_IDENTITY {
+			committedSizeBytes = -1
+		}
+		return &bytestream.QueryWriteStatusResponse{
+			CommittedSize: committedSizeBytes,
+			Complete:      true,
+		}, nil
+	}
+
+	// Report the progress of an upload stored in the staging
+	// directory.
+	committedSizeBytes := int64(0)
+	if s.uploadStagingDirectory != nil {
+		if filename, err := getUploadFilename(uploadID); err == nil {
+			s.lock.Lock()
+			s.removeIdleUploads(s.clock.Now())
+			if upload, ok := s.uploads[filename]; ok && upload.matches(blobDigest, compressor) {
+				committedSizeBytes = upload.committedSizeBytes
+			}
+			s.lock.Unlock()
+		}
+	}
+	return &bytestream.QueryWriteStatusResponse{
+		CommittedSize: committedSizeBytes,
+	}, nil
 }
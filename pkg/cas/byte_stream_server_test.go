@@ -1,16 +1,24 @@
 package cas_test
 
 import (
+	"bytes"
 	"context"
 	"io"
 	"net"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
 	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/auth"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
 	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
 	"github.com/buildbarn/bb-storage/pkg/cas"
+	"github.com/buildbarn/bb-storage/pkg/clock"
 	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/filesystem"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/require"
 
@@ -21,6 +29,18 @@ import (
 	"google.golang.org/grpc/test/bufconn"
 )
 
+// compress data using a given compression algorithm, so that it may be
+// transferred through "compressed-blobs" resource names.
+func compress(t *testing.T, algorithm blobstore.CompressionAlgorithm, data []byte) []byte {
+	var b bytes.Buffer
+	w, err := blobstore.NewCompressingWriter(algorithm, &b)
+	require.NoError(t, err)
+	_, err = w.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return b.Bytes()
+}
+
 func TestByteStreamServer(t *testing.T) {
 	ctrl, ctx := gomock.WithContext(context.Background(), t)
 	defer ctrl.Finish()
@@ -29,7 +49,7 @@ func TestByteStreamServer(t *testing.T) {
 	l := bufconn.Listen(1 << 20)
 	server := grpc.NewServer()
 	blobAccess := mock.NewMockBlobAccess(ctrl)
-	bytestream.RegisterByteStreamServer(server, cas.NewByteStreamServer(blobAccess, 10))
+	bytestream.RegisterByteStreamServer(server, cas.NewByteStreamServer(blobAccess, 10, nil, 0, 0, clock.SystemClock, auth.AllowAuthorizer))
 	go func() {
 		require.NoError(t, server.Serve(l))
 	}()
@@ -85,7 +105,7 @@ func TestByteStreamServer(t *testing.T) {
 		// Attempt to fetch the small blob without an instance name.
 		blobAccess.EXPECT().Get(
 			gomock.Any(),
-			digest.MustNewDigest("", "09f7e02f1290be211da707a266f153b3", 5),
+			digest.MustNewDigest("", remoteexecution.DigestFunction_MD5, "09f7e02f1290be211da707a266f153b3", 5),
 		).Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
 
 		req, err := client.Read(ctx, &bytestream.ReadRequest{
@@ -103,7 +123,7 @@ func TestByteStreamServer(t *testing.T) {
 		// Attempt to fetch the large blob with an instance name.
 		blobAccess.EXPECT().Get(
 			gomock.Any(),
-			digest.MustNewDigest("debian8", "3538d378083b9afa5ffad767f7269509", 22),
+			digest.MustNewDigest("debian8", remoteexecution.DigestFunction_MD5, "3538d378083b9afa5ffad767f7269509", 22),
 		).Return(buffer.NewValidatedBufferFromByteSlice([]byte("This is a long message")))
 
 		req, err := client.Read(ctx, &bytestream.ReadRequest{
@@ -127,7 +147,7 @@ func TestByteStreamServer(t *testing.T) {
 		// Attempt to fetch a blob with a negative offset.
 		blobAccess.EXPECT().Get(
 			gomock.Any(),
-			digest.MustNewDigest("ubuntu1804", "6fc422233a40a75a1f028e11c3cd1140", 7),
+			digest.MustNewDigest("ubuntu1804", remoteexecution.DigestFunction_MD5, "6fc422233a40a75a1f028e11c3cd1140", 7),
 		).Return(buffer.NewValidatedBufferFromByteSlice([]byte("Goodbye")))
 
 		req, err := client.Read(ctx, &bytestream.ReadRequest{
@@ -143,7 +163,7 @@ func TestByteStreamServer(t *testing.T) {
 		// of the blob.
 		blobAccess.EXPECT().Get(
 			gomock.Any(),
-			digest.MustNewDigest("ubuntu1804", "ad3c8ac9eef32188da352082244b3598", 13),
+			digest.MustNewDigest("ubuntu1804", remoteexecution.DigestFunction_MD5, "ad3c8ac9eef32188da352082244b3598", 13),
 		).Return(buffer.NewValidatedBufferFromByteSlice([]byte("short message")))
 
 		req, err := client.Read(ctx, &bytestream.ReadRequest{
@@ -158,7 +178,7 @@ func TestByteStreamServer(t *testing.T) {
 		// Attempt to fetch a lblob with an instance name and offset.
 		blobAccess.EXPECT().Get(
 			gomock.Any(),
-			digest.MustNewDigest("ubuntu1804", "da39a3ee5e6b4b0d3255bfef95601890", 19),
+			digest.MustNewDigest("ubuntu1804", remoteexecution.DigestFunction_MD5, "da39a3ee5e6b4b0d3255bfef95601890", 19),
 		).Return(buffer.NewValidatedBufferFromByteSlice([]byte("This offset message")))
 
 		req, err := client.Read(ctx, &bytestream.ReadRequest{
@@ -176,11 +196,45 @@ func TestByteStreamServer(t *testing.T) {
 		require.Equal(t, io.EOF, err)
 	})
 
+	t.Run("ReadSuccessWithLimit", func(t *testing.T) {
+		// Attempt to fetch a part of a blob, where the read limit
+		// causes the final chunk to be truncated.
+		blobAccess.EXPECT().Get(
+			gomock.Any(),
+			digest.MustNewDigest("ubuntu1804", remoteexecution.DigestFunction_MD5, "da39a3ee5e6b4b0d3255bfef95601890", 19),
+		).Return(buffer.NewValidatedBufferFromByteSlice([]byte("This offset message")))
+
+		req, err := client.Read(ctx, &bytestream.ReadRequest{
+			ResourceName: "ubuntu1804/blobs/da39a3ee5e6b4b0d3255bfef95601890/19",
+			ReadOffset:   2,
+			ReadLimit:    13,
+		})
+		require.NoError(t, err)
+		readResponse, err := req.Recv()
+		require.NoError(t, err)
+		require.Equal(t, []byte("is offset "), readResponse.Data)
+		readResponse, err = req.Recv()
+		require.NoError(t, err)
+		require.Equal(t, []byte("mes"), readResponse.Data)
+		_, err = req.Recv()
+		require.Equal(t, io.EOF, err)
+	})
+
+	t.Run("ReadNegativeReadLimit", func(t *testing.T) {
+		req, err := client.Read(ctx, &bytestream.ReadRequest{
+			ResourceName: "ubuntu1804/blobs/da39a3ee5e6b4b0d3255bfef95601890/19",
+			ReadLimit:    -1,
+		})
+		require.NoError(t, err)
+		_, err = req.Recv()
+		require.Equal(t, status.Error(codes.InvalidArgument, "Negative read limit: -1"), err)
+	})
+
 	t.Run("ReadNonexistentBlob", func(t *testing.T) {
 		// Attempt to fetch a nonexistent blob.
 		blobAccess.EXPECT().Get(
 			gomock.Any(),
-			digest.MustNewDigest("fedora28", "09f34d28e9c8bb445ec996388968a9e8", 7),
+			digest.MustNewDigest("fedora28", remoteexecution.DigestFunction_MD5, "09f34d28e9c8bb445ec996388968a9e8", 7),
 		).Return(buffer.NewBufferFromError(status.Error(codes.NotFound, "Blob not found")))
 
 		req, err := client.Read(ctx, &bytestream.ReadRequest{
@@ -191,6 +245,43 @@ func TestByteStreamServer(t *testing.T) {
 		require.Equal(t, status.Error(codes.NotFound, "Blob not found"), err)
 	})
 
+	t.Run("ReadCompressedUnsupportedCompressor", func(t *testing.T) {
+		// Only lowercase names of compressors are accepted.
+		req, err := client.Read(ctx, &bytestream.ReadRequest{
+			ResourceName: "compressed-blobs/lz4/09f7e02f1290be211da707a266f153b3/5",
+		})
+		require.NoError(t, err)
+		_, err = req.Recv()
+		require.Equal(t, status.Error(codes.InvalidArgument, "Unsupported compressor \"lz4\""), err)
+	})
+
+	t.Run("ReadCompressedSuccess", func(t *testing.T) {
+		// Data should be returned in compressed form, where the
+		// read offset applies to the compressed stream.
+		blobAccess.EXPECT().Get(
+			gomock.Any(),
+			digest.MustNewDigest("debian8", remoteexecution.DigestFunction_MD5, "3538d378083b9afa5ffad767f7269509", 22),
+		).Return(buffer.NewValidatedBufferFromByteSlice([]byte("This is a long message")))
+
+		req, err := client.Read(ctx, &bytestream.ReadRequest{
+			ResourceName: "debian8/compressed-blobs/zstd/3538d378083b9afa5ffad767f7269509/22",
+			ReadOffset:   4,
+		})
+		require.NoError(t, err)
+		compressed := compress(t, blobstore.CompressionAlgorithmZstd, []byte("This is a long message"))
+		var data []byte
+		for {
+			readResponse, err := req.Recv()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+			require.LessOrEqual(t, len(readResponse.Data), 10)
+			data = append(data, readResponse.Data...)
+		}
+		require.Equal(t, compressed[4:], data)
+	})
+
 	t.Run("WriteBadResourceName", func(t *testing.T) {
 		// Attempt to write to a bad resource name.
 		stream, err := client.Write(ctx)
@@ -207,7 +298,7 @@ func TestByteStreamServer(t *testing.T) {
 		// Attempt to write a blob without an instance name.
 		blobAccess.EXPECT().Put(
 			gomock.Any(),
-			digest.MustNewDigest("", "581c1053f832a1c719fb6528a588ccfd", 14),
+			digest.MustNewDigest("", remoteexecution.DigestFunction_MD5, "581c1053f832a1c719fb6528a588ccfd", 14),
 			gomock.Any(),
 		).DoAndReturn(func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
 			data, err := b.ToByteSlice(100)
@@ -232,11 +323,37 @@ func TestByteStreamServer(t *testing.T) {
 		require.Equal(t, int64(14), response.CommittedSize)
 	})
 
+	t.Run("WriteSuccessDigestFunction", func(t *testing.T) {
+		// Resource names may contain the name of the digest
+		// function, which should be used to validate the data.
+		blobAccess.EXPECT().Put(
+			gomock.Any(),
+			digest.MustNewDigest("ubuntu1804", remoteexecution.DigestFunction_BLAKE3, "8470794a55e7c428d670965058801bedf3b448f5cb735b8b6bb9eb8dc32c47d9", 14),
+			gomock.Any(),
+		).DoAndReturn(func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+			data, err := b.ToByteSlice(100)
+			require.NoError(t, err)
+			require.Equal(t, []byte("LaputanMachine"), data)
+			return nil
+		})
+
+		stream, err := client.Write(ctx)
+		require.NoError(t, err)
+		require.NoError(t, stream.Send(&bytestream.WriteRequest{
+			ResourceName: "ubuntu1804/uploads/7de747e0-ab6b-4d83-90cb-11989f84c473/blobs/blake3/8470794a55e7c428d670965058801bedf3b448f5cb735b8b6bb9eb8dc32c47d9/14",
+			Data:         []byte("LaputanMachine"),
+			FinishWrite:  true,
+		}))
+		response, err := stream.CloseAndRecv()
+		require.NoError(t, err)
+		require.Equal(t, int64(14), response.CommittedSize)
+	})
+
 	t.Run("WriteSuccessWithoutFinish", func(t *testing.T) {
 		// Attempt to write without finishing properly.
 		blobAccess.EXPECT().Put(
 			gomock.Any(),
-			digest.MustNewDigest("", "f10e562d8825ec2e17e0d9f58646f8084a658cfa", 6),
+			digest.MustNewDigest("", remoteexecution.DigestFunction_SHA1, "f10e562d8825ec2e17e0d9f58646f8084a658cfa", 6),
 			gomock.Any(),
 		).DoAndReturn(func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
 			_, err := b.ToByteSlice(100)
@@ -258,7 +375,7 @@ func TestByteStreamServer(t *testing.T) {
 		// Attempted to write while finishing twice.
 		blobAccess.EXPECT().Put(
 			gomock.Any(),
-			digest.MustNewDigest("fedora28", "cbd8f7984c654c25512e3d9241ae569f", 3),
+			digest.MustNewDigest("fedora28", remoteexecution.DigestFunction_MD5, "cbd8f7984c654c25512e3d9241ae569f", 3),
 			gomock.Any(),
 		).DoAndReturn(func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
 			_, err := b.ToByteSlice(100)
@@ -286,7 +403,7 @@ func TestByteStreamServer(t *testing.T) {
 		// Attempted to write with a bad write offset.
 		blobAccess.EXPECT().Put(
 			gomock.Any(),
-			digest.MustNewDigest("windows10", "68e109f0f40ca72a15e05cc22786f8e6", 10),
+			digest.MustNewDigest("windows10", remoteexecution.DigestFunction_MD5, "68e109f0f40ca72a15e05cc22786f8e6", 10),
 			gomock.Any(),
 		).DoAndReturn(func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
 			_, err := b.ToByteSlice(100)
@@ -309,10 +426,355 @@ func TestByteStreamServer(t *testing.T) {
 		require.Equal(t, status.Error(codes.InvalidArgument, "Attempted to write at offset 4, while 5 was expected"), err)
 	})
 
-	t.Run("QueryWriteStatus", func(t *testing.T) {
-		_, err := client.QueryWriteStatus(ctx, &bytestream.QueryWriteStatusRequest{
+	t.Run("WriteCompressedSuccess", func(t *testing.T) {
+		// Data should be decompressed before being stored.
+		blobAccess.EXPECT().Put(
+			gomock.Any(),
+			digest.MustNewDigest("", remoteexecution.DigestFunction_MD5, "581c1053f832a1c719fb6528a588ccfd", 14),
+			gomock.Any(),
+		).DoAndReturn(func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+			data, err := b.ToByteSlice(100)
+			require.NoError(t, err)
+			require.Equal(t, []byte("LaputanMachine"), data)
+			return nil
+		})
+
+		compressed := compress(t, blobstore.CompressionAlgorithmDeflate, []byte("LaputanMachine"))
+		stream, err := client.Write(ctx)
+		require.NoError(t, err)
+		require.NoError(t, stream.Send(&bytestream.WriteRequest{
+			ResourceName: "uploads/7de747e0-ab6b-4d83-90cb-11989f84c473/compressed-blobs/deflate/581c1053f832a1c719fb6528a588ccfd/14",
+			Data:         compressed[:5],
+		}))
+		require.NoError(t, stream.Send(&bytestream.WriteRequest{
+			Data:        compressed[5:],
+			WriteOffset: 5,
+			FinishWrite: true,
+		}))
+		response, err := stream.CloseAndRecv()
+		require.NoError(t, err)
+		require.Equal(t, int64(len(compressed)), response.CommittedSize)
+	})
+
+	t.Run("WriteCompressedCorrupted", func(t *testing.T) {
+		// Data that decompresses to something that doesn't
+		// match the digest should be rejected.
+		blobAccess.EXPECT().Put(
+			gomock.Any(),
+			digest.MustNewDigest("", remoteexecution.DigestFunction_MD5, "581c1053f832a1c719fb6528a588ccfd", 14),
+			gomock.Any(),
+		).DoAndReturn(func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+			_, err := b.ToByteSlice(100)
+			require.Equal(t, status.Error(codes.InvalidArgument, "Buffer is at least 15 bytes in size, while 14 bytes were expected"), err)
+			return err
+		})
+
+		stream, err := client.Write(ctx)
+		require.NoError(t, err)
+		require.NoError(t, stream.Send(&bytestream.WriteRequest{
+			ResourceName: "uploads/7de747e0-ab6b-4d83-90cb-11989f84c473/compressed-blobs/deflate/581c1053f832a1c719fb6528a588ccfd/14",
+			Data:         compress(t, blobstore.CompressionAlgorithmDeflate, []byte("LaputanMachines")),
+			FinishWrite:  true,
+		}))
+		_, err = stream.CloseAndRecv()
+		require.Equal(t, status.Error(codes.InvalidArgument, "Buffer is at least 15 bytes in size, while 14 bytes were expected"), err)
+	})
+
+	t.Run("QueryWriteStatusComplete", func(t *testing.T) {
+		// Objects that are already present should be reported as
+		// being complete.
+		blobAccess.EXPECT().FindMissing(
+			gomock.Any(),
+			digest.NewSetBuilder().Add(digest.MustNewDigest("windows10", remoteexecution.DigestFunction_MD5, "68e109f0f40ca72a15e05cc22786f8e6", 10)).Build(),
+		).Return(digest.EmptySet, nil)
+
+		response, err := client.QueryWriteStatus(ctx, &bytestream.QueryWriteStatusRequest{
+			ResourceName: "windows10/uploads/d834d9c2-f3c9-4f30-a698-75fd4be9470d/blobs/68e109f0f40ca72a15e05cc22786f8e6/10",
+		})
+		require.NoError(t, err)
+		require.Equal(t, int64(10), response.CommittedSize)
+		require.True(t, response.Complete)
+	})
+
+	t.Run("QueryWriteStatusWithoutStaging", func(t *testing.T) {
+		// Without a staging directory, uploads can't be resumed.
+		blobAccess.EXPECT().FindMissing(
+			gomock.Any(),
+			digest.NewSetBuilder().Add(digest.MustNewDigest("windows10", remoteexecution.DigestFunction_MD5, "68e109f0f40ca72a15e05cc22786f8e6", 10)).Build(),
+		).Return(digest.NewSetBuilder().Add(digest.MustNewDigest("windows10", remoteexecution.DigestFunction_MD5, "68e109f0f40ca72a15e05cc22786f8e6", 10)).Build(), nil)
+
+		response, err := client.QueryWriteStatus(ctx, &bytestream.QueryWriteStatusRequest{
 			ResourceName: "windows10/uploads/d834d9c2-f3c9-4f30-a698-75fd4be9470d/blobs/68e109f0f40ca72a15e05cc22786f8e6/10",
 		})
-		require.Equal(t, status.Error(codes.Unimplemented, "This service does not support querying write status"), err)
+		require.NoError(t, err)
+		require.Equal(t, int64(0), response.CommittedSize)
+		require.False(t, response.Complete)
+	})
+}
+
+func TestByteStreamServerResumableUpload(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+	defer ctrl.Finish()
+
+	stagingPath := filepath.Join(os.Getenv("TEST_TMPDIR"), t.Name())
+	require.NoError(t, os.Mkdir(stagingPath, 0777))
+	stagingDirectory, err := filesystem.NewLocalDirectory(stagingPath)
+	require.NoError(t, err)
+	defer stagingDirectory.Close()
+
+	// Create an RPC server/client pair.
+	l := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	blobAccess := mock.NewMockBlobAccess(ctrl)
+	bytestream.RegisterByteStreamServer(server, cas.NewByteStreamServer(blobAccess, 10, stagingDirectory, time.Hour, 1<<20, clock.SystemClock, auth.AllowAuthorizer))
+	go func() {
+		require.NoError(t, server.Serve(l))
+	}()
+	conn, err := grpc.DialContext(ctx, "bufnet", grpc.WithDialer(func(string, time.Duration) (net.Conn, error) {
+		return l.Dial()
+	}), grpc.WithInsecure())
+	require.NoError(t, err)
+	defer server.Stop()
+	defer conn.Close()
+	client := bytestream.NewByteStreamClient(conn)
+
+	blobDigest := digest.MustNewDigest("debian8", remoteexecution.DigestFunction_MD5, "581c1053f832a1c719fb6528a588ccfd", 14)
+	resourceName := "debian8/uploads/7de747e0-ab6b-4d83-90cb-11989f84c473/blobs/581c1053f832a1c719fb6528a588ccfd/14"
+	queryWriteStatus := func() *bytestream.QueryWriteStatusResponse {
+		blobAccess.EXPECT().FindMissing(
+			gomock.Any(),
+			digest.NewSetBuilder().Add(blobDigest).Build(),
+		).Return(digest.NewSetBuilder().Add(blobDigest).Build(), nil)
+		response, err := client.QueryWriteStatus(ctx, &bytestream.QueryWriteStatusRequest{
+			ResourceName: resourceName,
+		})
+		require.NoError(t, err)
+		return response
+	}
+
+	t.Run("InvalidUploadUUID", func(t *testing.T) {
+		stream, err := client.Write(ctx)
+		require.NoError(t, err)
+		require.NoError(t, stream.Send(&bytestream.WriteRequest{
+			ResourceName: "debian8/uploads/hello/blobs/581c1053f832a1c719fb6528a588ccfd/14",
+			Data:         []byte("Laputan"),
+		}))
+		_, err = stream.CloseAndRecv()
+		require.Equal(t, status.Error(codes.InvalidArgument, "Invalid upload UUID \"hello\""), err)
+	})
+
+	t.Run("ResumeWithoutUpload", func(t *testing.T) {
+		stream, err := client.Write(ctx)
+		require.NoError(t, err)
+		require.NoError(t, stream.Send(&bytestream.WriteRequest{
+			ResourceName: resourceName,
+			Data:         []byte("Machine"),
+			WriteOffset:  7,
+			FinishWrite:  true,
+		}))
+		_, err = stream.CloseAndRecv()
+		require.Equal(t, status.Error(codes.InvalidArgument, "Attempted to write at offset 7, while 0 was expected"), err)
+	})
+
+	t.Run("Success", func(t *testing.T) {
+		// Upload the first half of the blob, after which the
+		// client disconnects.
+		require.Equal(t, int64(0), queryWriteStatus().CommittedSize)
+		stream, err := client.Write(ctx)
+		require.NoError(t, err)
+		require.NoError(t, stream.Send(&bytestream.WriteRequest{
+			ResourceName: resourceName,
+			Data:         []byte("Laputan"),
+		}))
+		_, err = stream.CloseAndRecv()
+		require.Equal(t, status.Error(codes.InvalidArgument, "Client closed stream without finishing write"), err)
+
+		// The data should have been retained, allowing the client
+		// to resume the upload.
+		response := queryWriteStatus()
+		require.Equal(t, int64(7), response.CommittedSize)
+		require.False(t, response.Complete)
+
+		blobAccess.EXPECT().Put(gomock.Any(), blobDigest, gomock.Any()).
+			DoAndReturn(func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+				data, err := b.ToByteSlice(100)
+				require.NoError(t, err)
+				require.Equal(t, []byte("LaputanMachine"), data)
+				return nil
+			})
+		stream, err = client.Write(ctx)
+		require.NoError(t, err)
+		require.NoError(t, stream.Send(&bytestream.WriteRequest{
+			ResourceName: resourceName,
+			Data:         []byte("Machine"),
+			WriteOffset:  7,
+			FinishWrite:  true,
+		}))
+		writeResponse, err := stream.CloseAndRecv()
+		require.NoError(t, err)
+		require.Equal(t, int64(14), writeResponse.CommittedSize)
+
+		// Completion of the upload should cause the staging file
+		// to be removed.
+		require.Equal(t, int64(0), queryWriteStatus().CommittedSize)
+		children, err := stagingDirectory.ReadDir()
+		require.NoError(t, err)
+		require.Empty(t, children)
+	})
+
+	t.Run("CompressedSuccess", func(t *testing.T) {
+		// Compressed uploads can be resumed as well. Offsets
+		// apply to the compressed data, which should be
+		// decompressed when the upload finishes.
+		compressedResourceName := "debian8/uploads/7de747e0-ab6b-4d83-90cb-11989f84c473/compressed-blobs/zstd/581c1053f832a1c719fb6528a588ccfd/14"
+		compressed := compress(t, blobstore.CompressionAlgorithmZstd, []byte("LaputanMachine"))
+		stream, err := client.Write(ctx)
+		require.NoError(t, err)
+		require.NoError(t, stream.Send(&bytestream.WriteRequest{
+			ResourceName: compressedResourceName,
+			Data:         compressed[:5],
+		}))
+		_, err = stream.CloseAndRecv()
+		require.Equal(t, status.Error(codes.InvalidArgument, "Client closed stream without finishing write"), err)
+
+		// Progress of the compressed upload should not be
+		// reported for the uncompressed resource name.
+		require.Equal(t, int64(0), queryWriteStatus().CommittedSize)
+
+		blobAccess.EXPECT().Put(gomock.Any(), blobDigest, gomock.Any()).
+			DoAndReturn(func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+				data, err := b.ToByteSlice(100)
+				require.NoError(t, err)
+				require.Equal(t, []byte("LaputanMachine"), data)
+				return nil
+			})
+		stream, err = client.Write(ctx)
+		require.NoError(t, err)
+		require.NoError(t, stream.Send(&bytestream.WriteRequest{
+			ResourceName: compressedResourceName,
+			Data:         compressed[5:],
+			WriteOffset:  5,
+			FinishWrite:  true,
+		}))
+		writeResponse, err := stream.CloseAndRecv()
+		require.NoError(t, err)
+		require.Equal(t, int64(len(compressed)), writeResponse.CommittedSize)
+	})
+
+	t.Run("WriteBeyondEnd", func(t *testing.T) {
+		stream, err := client.Write(ctx)
+		require.NoError(t, err)
+		require.NoError(t, stream.Send(&bytestream.WriteRequest{
+			ResourceName: resourceName,
+			Data:         []byte("LaputanMachines"),
+		}))
+		_, err = stream.CloseAndRecv()
+		require.Equal(t, status.Error(codes.InvalidArgument, "Attempted to write 15 bytes, while the blob is only 14 bytes in size"), err)
+	})
+}
+
+func TestByteStreamServerUploadEviction(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+	defer ctrl.Finish()
+
+	stagingPath := filepath.Join(os.Getenv("TEST_TMPDIR"), t.Name())
+	require.NoError(t, os.Mkdir(stagingPath, 0777))
+	stagingDirectory, err := filesystem.NewLocalDirectory(stagingPath)
+	require.NoError(t, err)
+	defer stagingDirectory.Close()
+
+	// Create an RPC server/client pair. Uploads expire after one
+	// minute of inactivity, and at most 10 bytes may be staged.
+	l := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	blobAccess := mock.NewMockBlobAccess(ctrl)
+	clock := mock.NewMockClock(ctrl)
+	var now time.Time
+	clock.EXPECT().Now().DoAndReturn(func() time.Time { return now }).AnyTimes()
+	bytestream.RegisterByteStreamServer(server, cas.NewByteStreamServer(blobAccess, 10, stagingDirectory, time.Minute, 10, clock, auth.AllowAuthorizer))
+	go func() {
+		require.NoError(t, server.Serve(l))
+	}()
+	conn, err := grpc.DialContext(ctx, "bufnet", grpc.WithDialer(func(string, time.Duration) (net.Conn, error) {
+		return l.Dial()
+	}), grpc.WithInsecure())
+	require.NoError(t, err)
+	defer server.Stop()
+	defer conn.Close()
+	client := bytestream.NewByteStreamClient(conn)
+
+	blobDigest := digest.MustNewDigest("debian8", remoteexecution.DigestFunction_MD5, "581c1053f832a1c719fb6528a588ccfd", 14)
+	resourceName1 := "debian8/uploads/7de747e0-ab6b-4d83-90cb-11989f84c473/blobs/581c1053f832a1c719fb6528a588ccfd/14"
+	resourceName2 := "debian8/uploads/1c0e1a2d-4e8f-4a43-9f55-0b8b6f1bd2a9/blobs/581c1053f832a1c719fb6528a588ccfd/14"
+	queryCommittedSize := func(resourceName string) int64 {
+		blobAccess.EXPECT().FindMissing(
+			gomock.Any(),
+			digest.NewSetBuilder().Add(blobDigest).Build(),
+		).Return(digest.NewSetBuilder().Add(blobDigest).Build(), nil)
+		response, err := client.QueryWriteStatus(ctx, &bytestream.QueryWriteStatusRequest{
+			ResourceName: resourceName,
+		})
+		require.NoError(t, err)
+		return response.CommittedSize
+	}
+	write := func(resourceName string, data string, writeOffset int64) error {
+		stream, err := client.Write(ctx)
+		require.NoError(t, err)
+		require.NoError(t, stream.Send(&bytestream.WriteRequest{
+			ResourceName: resourceName,
+			Data:         []byte(data),
+			WriteOffset:  writeOffset,
+		}))
+		_, err = stream.CloseAndRecv()
+		return err
+	}
+
+	t.Run("IdleTimeout", func(t *testing.T) {
+		// Start an upload that is subsequently abandoned.
+		now = time.Unix(1000, 0)
+		require.Equal(
+			t,
+			status.Error(codes.InvalidArgument, "Client closed stream without finishing write"),
+			write(resourceName1, "Laputan", 0))
+
+		// The upload should remain resumable until the idle
+		// timeout has passed, after which it is removed.
+		now = time.Unix(1059, 0)
+		require.Equal(t, int64(7), queryCommittedSize(resourceName1))
+		now = time.Unix(1060, 0)
+		require.Equal(t, int64(0), queryCommittedSize(resourceName1))
+		children, err := stagingDirectory.ReadDir()
+		require.NoError(t, err)
+		require.Empty(t, children)
+	})
+
+	t.Run("SizeLimit", func(t *testing.T) {
+		now = time.Unix(2000, 0)
+		require.Equal(
+			t,
+			status.Error(codes.InvalidArgument, "Client closed stream without finishing write"),
+			write(resourceName1, "Laputan", 0))
+
+		// Starting a second upload would exceed the maximum
+		// size. This should cause the least recently active
+		// upload to be removed.
+		now = time.Unix(2001, 0)
+		require.Equal(
+			t,
+			status.Error(codes.InvalidArgument, "Client closed stream without finishing write"),
+			write(resourceName2, "Laputan", 0))
+		require.Equal(t, int64(0), queryCommittedSize(resourceName1))
+		require.Equal(t, int64(7), queryCommittedSize(resourceName2))
+		children, err := stagingDirectory.ReadDir()
+		require.NoError(t, err)
+		require.Len(t, children, 1)
+
+		// If space cannot be reclaimed by removing other
+		// uploads, writes should fail.
+		require.Equal(
+			t,
+			status.Error(codes.ResourceExhausted, "Upload staging directory cannot hold another 4 bytes, as 7 of 10 bytes are in use"),
+			write(resourceName2, "Mach", 7))
+		require.Equal(t, int64(7), queryCommittedSize(resourceName2))
 	})
 }
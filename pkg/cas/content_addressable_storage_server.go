@@ -2,34 +2,51 @@ package cas
 
 import (
 	"context"
+	"encoding/base64"
 
 	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/pkg/auth"
 	"github.com/buildbarn/bb-storage/pkg/blobstore"
 	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
 	"github.com/buildbarn/bb-storage/pkg/digest"
+	cas_proto "github.com/buildbarn/bb-storage/pkg/proto/cas"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/golang/protobuf/proto"
 
+	"golang.org/x/sync/errgroup"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
 
+// getTreeConcurrency is the maximum number of Directory objects that
+// GetTree() fetches from the Content Addressable Storage in parallel.
+const getTreeConcurrency = 16
+
 type contentAddressableStorageServer struct {
 	contentAddressableStorage blobstore.BlobAccess
 	maximumMessageSizeBytes   int64
+	authorizer                auth.Authorizer
 }
 
 // NewContentAddressableStorageServer creates a GRPC service for serving
 // the contents of a Bazel Content Addressable Storage (CAS) to Bazel.
-func NewContentAddressableStorageServer(contentAddressableStorage blobstore.BlobAccess, maximumMessageSizeBytes int64) remoteexecution.ContentAddressableStorageServer {
+// Every request is checked against an Authorizer before the backend is
+// accessed.
+func NewContentAddressableStorageServer(contentAddressableStorage blobstore.BlobAccess, maximumMessageSizeBytes int64, authorizer auth.Authorizer) remoteexecution.ContentAddressableStorageServer {
 	return &contentAddressableStorageServer{
 		contentAddressableStorage: contentAddressableStorage,
 		maximumMessageSizeBytes:   maximumMessageSizeBytes,
+		authorizer:                authorizer,
 	}
 }
 
 func (s *contentAddressableStorageServer) FindMissingBlobs(ctx context.Context, in *remoteexecution.FindMissingBlobsRequest) (*remoteexecution.FindMissingBlobsResponse, error) {
+	if err := s.authorizer.Authorize(ctx, in.InstanceName, auth.OperationContentAddressableStorageRead); err != nil {
+		return nil, err
+	}
 	inDigests := digest.NewSetBuilder()
 	for _, partialDigest := range in.BlobDigests {
-		digest, err := digest.NewDigestFromPartialDigest(in.InstanceName, partialDigest)
+		digest, err := digest.NewDigestFromPartialDigest(in.InstanceName, in.DigestFunction, partialDigest)
 		if err != nil {
 			return nil, err
 		}
@@ -49,10 +66,13 @@ func (s *contentAddressableStorageServer) FindMissingBlobs(ctx context.Context,
 }
 
 func (s *contentAddressableStorageServer) BatchReadBlobs(ctx context.Context, in *remoteexecution.BatchReadBlobsRequest) (*remoteexecution.BatchReadBlobsResponse, error) {
+	if err := s.authorizer.Authorize(ctx, in.InstanceName, auth.OperationContentAddressableStorageRead); err != nil {
+		return nil, err
+	}
 	bytesRemaining := s.maximumMessageSizeBytes
 	digests := make([]digest.Digest, 0, len(in.Digests))
 	for _, reqDigest := range in.Digests {
-		digest, err := digest.NewDigestFromPartialDigest(in.InstanceName, reqDigest)
+		digest, err := digest.NewDigestFromPartialDigest(in.InstanceName, in.DigestFunction, reqDigest)
 		if err != nil {
 			return nil, err
 		}
@@ -84,9 +104,12 @@ func (s *contentAddressableStorageServer) BatchReadBlobs(ctx context.Context, in
 }
 
 func (s *contentAddressableStorageServer) BatchUpdateBlobs(ctx context.Context, in *remoteexecution.BatchUpdateBlobsRequest) (*remoteexecution.BatchUpdateBlobsResponse, error) {
+	if err := s.authorizer.Authorize(ctx, in.InstanceName, auth.OperationContentAddressableStorageWrite); err != nil {
+		return nil, err
+	}
 	var response remoteexecution.BatchUpdateBlobsResponse
 	for _, request := range in.Requests {
-		digest, err := digest.NewDigestFromPartialDigest(in.InstanceName, request.Digest)
+		digest, err := digest.NewDigestFromPartialDigest(in.InstanceName, in.DigestFunction, request.Digest)
 		if err == nil {
 			err = s.contentAddressableStorage.Put(
 				ctx,
@@ -104,5 +127,155 @@ func (s *contentAddressableStorageServer) BatchUpdateBlobs(ctx context.Context,
 }
 
 func (s *contentAddressableStorageServer) GetTree(in *remoteexecution.GetTreeRequest, stream remoteexecution.ContentAddressableStorage_GetTreeServer) error {
-	return status.Error(codes.Unimplemented, "This service does not support downloading directory trees")
+	if in.PageSize < 0 {
+		return status.Errorf(codes.InvalidArgument, "Negative page size %d", in.PageSize)
+	}
+	rootDigest, err := digest.NewDigestFromPartialDigest(in.InstanceName, in.DigestFunction, in.RootDigest)
+	if err != nil {
+		return util.StatusWrap(err, "Invalid root digest")
+	}
+	ctx := stream.Context()
+	if err := s.authorizer.Authorize(ctx, in.InstanceName, auth.OperationContentAddressableStorageRead); err != nil {
+		return err
+	}
+
+	// Page tokens contain the directories that still need to be
+	// returned, so that the traversal can be resumed without
+	// fetching the directories that were returned previously.
+	pending := []digest.Digest{rootDigest}
+	if in.PageToken != "" {
+		pending, err = decodeGetTreePageToken(rootDigest, in.PageToken)
+		if err != nil {
+			return err
+		}
+	}
+	seen := make(map[digest.Digest]struct{}, len(pending))
+	for _, d := range pending {
+		seen[d] = struct{}{}
+	}
+
+	// Perform a breadth-first traversal of the tree, fetching
+	// directories in batches.
+	var response remoteexecution.GetTreeResponse
+	responseSizeBytes := int64(0)
+	for len(pending) > 0 {
+		batchSize := len(pending)
+		if batchSize > getTreeConcurrency {
+			batchSize = getTreeConcurrency
+		}
+		directories, err := s.getDirectories(ctx, pending[:batchSize])
+		if err != nil {
+			return err
+		}
+
+		for _, directory := range directories {
+			// Flush the current page if adding this
+			// directory would cause it to become too large.
+			// The page token contains this directory and all
+			// directories that are still pending.
+			parentDigest := pending[0]
+			sizeBytes := parentDigest.GetSizeBytes()
+			if len(response.Directories) > 0 && (len(response.Directories) == int(in.PageSize) || responseSizeBytes+sizeBytes > s.maximumMessageSizeBytes) {
+				response.NextPageToken, err = encodeGetTreePageToken(rootDigest, pending)
+				if err != nil {
+					return err
+				}
+				if err := stream.Send(&response); err != nil {
+					return err
+				}
+				response = remoteexecution.GetTreeResponse{}
+				responseSizeBytes = 0
+			}
+			pending = pending[1:]
+			response.Directories = append(response.Directories, directory)
+			responseSizeBytes += sizeBytes
+
+			for _, child := range directory.Directories {
+				childDigest, err := parentDigest.NewDerivedDigest(child.Digest)
+				if err != nil {
+					return util.StatusWrapf(err, "Invalid digest for subdirectory %#v in directory %s", child.Name, parentDigest)
+				}
+				if _, ok := seen[childDigest]; !ok {
+					seen[childDigest] = struct{}{}
+					pending = append(pending, childDigest)
+				}
+			}
+		}
+	}
+	return stream.Send(&response)
+}
+
+// getDirectories fetches a list of Directory objects from the Content
+// Addressable Storage in parallel. Objects that are absent cause a
+// NOT_FOUND error to be returned that contains the digest of the
+// missing object in its details.
+func (s *contentAddressableStorageServer) getDirectories(ctx context.Context, digests []digest.Digest) ([]*remoteexecution.Directory, error) {
+	directories := make([]*remoteexecution.Directory, len(digests))
+	group, groupCtx := errgroup.WithContext(ctx)
+	for i, d := range digests {
+		i, d := i, d
+		group.Go(func() error {
+			data, err := s.contentAddressableStorage.Get(groupCtx, d).ToByteSlice(int(d.GetSizeBytes()))
+			if err != nil {
+				err = util.StatusWrapf(err, "Directory %s", d)
+				if status.Code(err) == codes.NotFound {
+					if st, detailsErr := status.Convert(err).WithDetails(d.GetPartialDigest()); detailsErr == nil {
+						return st.Err()
+					}
+				}
+				return err
+			}
+			var directory remoteexecution.Directory
+			if err := proto.Unmarshal(data, &directory); err != nil {
+				return util.StatusWrapfWithCode(err, codes.InvalidArgument, "Failed to unmarshal directory %s", d)
+			}
+			directories[i] = &directory
+			return nil
+		})
+	}
+	if err := group.Wait(); err != nil {
+		return nil, err
+	}
+	return directories, nil
+}
+
+func encodeGetTreePageToken(rootDigest digest.Digest, pendingDirectories []digest.Digest) (string, error) {
+	pageToken := cas_proto.GetTreePageToken{
+		RootDigest:         rootDigest.GetPartialDigest(),
+		PendingDirectories: make([]*remoteexecution.Digest, 0, len(pendingDirectories)),
+	}
+	for _, d := range pendingDirectories {
+		pageToken.PendingDirectories = append(pageToken.PendingDirectories, d.GetPartialDigest())
+	}
+	data, err := proto.Marshal(&pageToken)
+	if err != nil {
+		return "", util.StatusWrapWithCode(err, codes.Internal, "Failed to marshal page token")
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func decodeGetTreePageToken(rootDigest digest.Digest, encodedPageToken string) ([]digest.Digest, error) {
+	data, err := base64.RawURLEncoding.DecodeString(encodedPageToken)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "Page token is not valid base64")
+	}
+	var pageToken cas_proto.GetTreePageToken
+	if err := proto.Unmarshal(data, &pageToken); err != nil {
+		return nil, util.StatusWrapWithCode(err, codes.InvalidArgument, "Failed to unmarshal page token")
+	}
+	if !proto.Equal(pageToken.RootDigest, rootDigest.GetPartialDigest()) {
+		return nil, status.Error(codes.InvalidArgument, "Page token belongs to a different root directory")
+	}
+	if len(pageToken.PendingDirectories) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Page token does not contain any pending directories")
+	}
+	pendingDirectories := make([]digest.Digest, 0, len(pageToken.PendingDirectories))
+	for _, partialDigest := range pageToken.PendingDirectories {
+		d, err := rootDigest.NewDerivedDigest(partialDigest)
+		if err != nil {
+			return nil, util.StatusWrap(err, "Invalid pending directory digest in page token")
+		}
+		pendingDirectories = append(pendingDirectories, d)
+	}
+	return pendingDirectories, nil
 }
@@ -2,14 +2,17 @@ package cas_test
 
 import (
 	"context"
+	"strconv"
 	"testing"
 
 	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
 	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/auth"
 	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
 	"github.com/buildbarn/bb-storage/pkg/cas"
 	"github.com/buildbarn/bb-storage/pkg/digest"
 	"github.com/golang/mock/gomock"
+	"github.com/golang/protobuf/proto"
 	"github.com/stretchr/testify/require"
 
 	status_pb "google.golang.org/genproto/googleapis/rpc/status"
@@ -21,9 +24,9 @@ func TestContentAddressableStorageServerBatchReadBlobsSuccess(t *testing.T) {
 	ctrl, ctx := gomock.WithContext(context.Background(), t)
 	defer ctrl.Finish()
 
-	digest1 := digest.MustNewDigest("ubuntu1804", "409a7f83ac6b31dc8c77e3ec18038f209bd2f545e0f4177c2e2381aa4e067b49", 123)
-	digest2 := digest.MustNewDigest("ubuntu1804", "0479688f99e8cbc70291ce272876ff8e0db71a0889daf2752884b0996056b4a0", 234)
-	digest3 := digest.MustNewDigest("ubuntu1804", "7821919ee052d21515cf4e36788138a301c18c36931290270aece8d79ea2cca6", 345)
+	digest1 := digest.MustNewDigest("ubuntu1804", remoteexecution.DigestFunction_SHA256, "409a7f83ac6b31dc8c77e3ec18038f209bd2f545e0f4177c2e2381aa4e067b49", 123)
+	digest2 := digest.MustNewDigest("ubuntu1804", remoteexecution.DigestFunction_SHA256, "0479688f99e8cbc70291ce272876ff8e0db71a0889daf2752884b0996056b4a0", 234)
+	digest3 := digest.MustNewDigest("ubuntu1804", remoteexecution.DigestFunction_SHA256, "7821919ee052d21515cf4e36788138a301c18c36931290270aece8d79ea2cca6", 345)
 
 	request := &remoteexecution.BatchReadBlobsRequest{
 		Digests: []*remoteexecution.Digest{
@@ -54,7 +57,7 @@ func TestContentAddressableStorageServerBatchReadBlobsSuccess(t *testing.T) {
 	buf3 := buffer.NewBufferFromError(status.Error(codes.NotFound, "The object you requested could not be found"))
 	contentAddressableStorage.EXPECT().Get(ctx, digest3).Return(buf3)
 
-	contentAddressableStorageServer := cas.NewContentAddressableStorageServer(contentAddressableStorage, 1<<16)
+	contentAddressableStorageServer := cas.NewContentAddressableStorageServer(contentAddressableStorage, 1<<16, auth.AllowAuthorizer)
 
 	response, err := contentAddressableStorageServer.BatchReadBlobs(ctx, request)
 	require.NoError(t, err)
@@ -111,10 +114,193 @@ func TestContentAddressableStorageServerBatchReadBlobsFailure(t *testing.T) {
 
 	contentAddressableStorage := mock.NewMockBlobAccess(ctrl)
 
-	contentAddressableStorageServer := cas.NewContentAddressableStorageServer(contentAddressableStorage, 200)
+	contentAddressableStorageServer := cas.NewContentAddressableStorageServer(contentAddressableStorage, 200, auth.AllowAuthorizer)
 
 	_, err := contentAddressableStorageServer.BatchReadBlobs(ctx, request)
 	require.Equal(t, status.Error(codes.InvalidArgument,
 		"Attempted to read a total of at least 357 bytes, while a maximum of 200 bytes is permitted"),
 		err)
 }
+
+func TestContentAddressableStorageServerGetTree(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+	defer ctrl.Finish()
+
+	// Directory hierarchy where both "a" and "b" contain the
+	// same subdirectory "c". It should only be returned once.
+	directoryC := &remoteexecution.Directory{
+		Files: []*remoteexecution.FileNode{
+			{
+				Name: "hello.txt",
+				Digest: &remoteexecution.Digest{
+					Hash:      "409a7f83ac6b31dc8c77e3ec18038f209bd2f545e0f4177c2e2381aa4e067b49",
+					SizeBytes: 5,
+				},
+			},
+		},
+	}
+	dataC, err := proto.Marshal(directoryC)
+	require.NoError(t, err)
+	partialDigestC := &remoteexecution.Digest{
+		Hash:      "7821919ee052d21515cf4e36788138a301c18c36931290270aece8d79ea2cca6",
+		SizeBytes: int64(len(dataC)),
+	}
+	directoryA := &remoteexecution.Directory{
+		Directories: []*remoteexecution.DirectoryNode{
+			{Name: "c", Digest: partialDigestC},
+		},
+	}
+	dataA, err := proto.Marshal(directoryA)
+	require.NoError(t, err)
+	partialDigestA := &remoteexecution.Digest{
+		Hash:      "0479688f99e8cbc70291ce272876ff8e0db71a0889daf2752884b0996056b4a0",
+		SizeBytes: int64(len(dataA)),
+	}
+	directoryB := &remoteexecution.Directory{
+		Directories: []*remoteexecution.DirectoryNode{
+			{Name: "c", Digest: partialDigestC},
+		},
+		Symlinks: []*remoteexecution.SymlinkNode{
+			{Name: "link", Target: "c"},
+		},
+	}
+	dataB, err := proto.Marshal(directoryB)
+	require.NoError(t, err)
+	partialDigestB := &remoteexecution.Digest{
+		Hash:      "3dfcd4d2b8c44ca7cd2d2f14e4d8f0d8f0d1c0e8b8d7ad1b0b2b8c77e3ec1803",
+		SizeBytes: int64(len(dataB)),
+	}
+	directoryRoot := &remoteexecution.Directory{
+		Directories: []*remoteexecution.DirectoryNode{
+			{Name: "a", Digest: partialDigestA},
+			{Name: "b", Digest: partialDigestB},
+		},
+	}
+	dataRoot, err := proto.Marshal(directoryRoot)
+	require.NoError(t, err)
+	partialDigestRoot := &remoteexecution.Digest{
+		Hash:      "8b1a9953c4611296a827abf8c47804d7e6c49c6b2f3d5c3a1e8a5f1b2c3d4e5f",
+		SizeBytes: int64(len(dataRoot)),
+	}
+
+	contentAddressableStorage := mock.NewMockBlobAccess(ctrl)
+	expectGet := func(partialDigest *remoteexecution.Digest, data []byte) {
+		contentAddressableStorage.EXPECT().Get(
+			gomock.Any(),
+			digest.MustNewDigest("ubuntu1804", remoteexecution.DigestFunction_UNKNOWN, partialDigest.Hash, partialDigest.SizeBytes),
+		).Return(buffer.NewValidatedBufferFromByteSlice(data))
+	}
+	contentAddressableStorageServer := cas.NewContentAddressableStorageServer(contentAddressableStorage, 1<<16, auth.AllowAuthorizer)
+
+	// Request the full tree with a page size of two. The second
+	// page should be obtainable by resuming from the page token
+	// returned as part of the first page.
+	expectGet(partialDigestRoot, dataRoot)
+	expectGet(partialDigestA, dataA)
+	expectGet(partialDigestB, dataB)
+	expectGet(partialDigestC, dataC)
+	var responses []*remoteexecution.GetTreeResponse
+	stream := mock.NewMockContentAddressableStorage_GetTreeServer(ctrl)
+	stream.EXPECT().Context().Return(ctx).AnyTimes()
+	stream.EXPECT().Send(gomock.Any()).DoAndReturn(func(response *remoteexecution.GetTreeResponse) error {
+		responses = append(responses, proto.Clone(response).(*remoteexecution.GetTreeResponse))
+		return nil
+	}).Times(2)
+	require.NoError(t, contentAddressableStorageServer.GetTree(&remoteexecution.GetTreeRequest{
+		InstanceName: "ubuntu1804",
+		RootDigest:   partialDigestRoot,
+		PageSize:     2,
+	}, stream))
+
+	require.Len(t, responses, 2)
+	require.Len(t, responses[0].Directories, 2)
+	require.True(t, proto.Equal(directoryRoot, responses[0].Directories[0]))
+	require.True(t, proto.Equal(directoryA, responses[0].Directories[1]))
+	require.NotEmpty(t, responses[0].NextPageToken)
+	require.Len(t, responses[1].Directories, 2)
+	require.True(t, proto.Equal(directoryB, responses[1].Directories[0]))
+	require.True(t, proto.Equal(directoryC, responses[1].Directories[1]))
+	require.Empty(t, responses[1].NextPageToken)
+
+	// Resuming from the page token should yield the same results.
+	// As the page token contains the directories that still need
+	// to be returned, directories returned as part of the first
+	// page should not be fetched again.
+	expectGet(partialDigestB, dataB)
+	expectGet(partialDigestC, dataC)
+	stream.EXPECT().Send(gomock.Any()).DoAndReturn(func(response *remoteexecution.GetTreeResponse) error {
+		require.True(t, proto.Equal(responses[1], response))
+		return nil
+	})
+	require.NoError(t, contentAddressableStorageServer.GetTree(&remoteexecution.GetTreeRequest{
+		InstanceName: "ubuntu1804",
+		RootDigest:   partialDigestRoot,
+		PageToken:    responses[0].NextPageToken,
+	}, stream))
+
+	// Missing subdirectories should cause the request to fail,
+	// providing the digest of the missing directory.
+	expectGet(partialDigestRoot, dataRoot)
+	expectGet(partialDigestA, dataA)
+	contentAddressableStorage.EXPECT().Get(
+		gomock.Any(),
+		digest.MustNewDigest("ubuntu1804", remoteexecution.DigestFunction_UNKNOWN, partialDigestB.Hash, partialDigestB.SizeBytes),
+	).Return(buffer.NewBufferFromError(status.Error(codes.NotFound, "Object not found")))
+	err = contentAddressableStorageServer.GetTree(&remoteexecution.GetTreeRequest{
+		InstanceName: "ubuntu1804",
+		RootDigest:   partialDigestRoot,
+	}, stream)
+	s := status.Convert(err)
+	require.Equal(t, codes.NotFound, s.Code())
+	require.Equal(t, "Directory 3dfcd4d2b8c44ca7cd2d2f14e4d8f0d8f0d1c0e8b8d7ad1b0b2b8c77e3ec1803-"+strconv.FormatInt(partialDigestB.SizeBytes, 10)+"-ubuntu1804: Object not found", s.Message())
+	require.Len(t, s.Details(), 1)
+	require.True(t, proto.Equal(partialDigestB, s.Details()[0].(*remoteexecution.Digest)))
+
+	// Malformed page tokens should be rejected.
+	require.Equal(
+		t,
+		status.Error(codes.InvalidArgument, "Page token is not valid base64"),
+		contentAddressableStorageServer.GetTree(&remoteexecution.GetTreeRequest{
+			InstanceName: "ubuntu1804",
+			RootDigest:   partialDigestRoot,
+			PageToken:    "!!!",
+		}, stream))
+
+	// Page tokens may not be used in combination with another
+	// root directory.
+	require.Equal(
+		t,
+		status.Error(codes.InvalidArgument, "Page token belongs to a different root directory"),
+		contentAddressableStorageServer.GetTree(&remoteexecution.GetTreeRequest{
+			InstanceName: "ubuntu1804",
+			RootDigest:   partialDigestA,
+			PageToken:    responses[0].NextPageToken,
+		}, stream))
+}
+
+func TestContentAddressableStorageServerBatchUpdateBlobsPermissionDenied(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+	defer ctrl.Finish()
+
+	// Clients that are not permitted to write to the instance
+	// should not be able to store any objects.
+	contentAddressableStorage := mock.NewMockBlobAccess(ctrl)
+	authorizer := mock.NewMockAuthorizer(ctrl)
+	authorizer.EXPECT().Authorize(ctx, "ubuntu1804", auth.OperationContentAddressableStorageWrite).
+		Return(status.Error(codes.PermissionDenied, "Identity \"alice\" is not permitted to perform operation ContentAddressableStorageWrite on instance \"ubuntu1804\""))
+	contentAddressableStorageServer := cas.NewContentAddressableStorageServer(contentAddressableStorage, 1<<16, authorizer)
+
+	_, err := contentAddressableStorageServer.BatchUpdateBlobs(ctx, &remoteexecution.BatchUpdateBlobsRequest{
+		InstanceName: "ubuntu1804",
+		Requests: []*remoteexecution.BatchUpdateBlobsRequest_Request{
+			{
+				Digest: &remoteexecution.Digest{
+					Hash:      "8b1a9953c4611296a827abf8c47804d7",
+					SizeBytes: 5,
+				},
+				Data: []byte("Hello"),
+			},
+		},
+	})
+	require.Equal(t, status.Error(codes.PermissionDenied, "Identity \"alice\" is not permitted to perform operation ContentAddressableStorageWrite on instance \"ubuntu1804\""), err)
+}
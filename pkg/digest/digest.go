@@ -1,6 +1,7 @@
 package digest
 
 import (
+	"bytes"
 	"crypto/md5"
 	"crypto/sha1"
 	"crypto/sha256"
@@ -12,6 +13,9 @@ import (
 	"strings"
 
 	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/pkg/digest/sha256tree"
+	"github.com/google/uuid"
+	"github.com/zeebo/blake3"
 
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -25,8 +29,9 @@ import (
 // - Instances of these objects are guaranteed not to contain any
 //   degenerate values. The hash has already been decoded from
 //   hexadecimal to binary. The size is non-negative.
-// - They keep track of the instance as part of the digest, which allows
-//   us to keep function signatures across the codebase simple.
+// - They keep track of the instance and the digest function as part of
+//   the digest, which allows us to keep function signatures across the
+//   codebase simple.
 // - They provide utility functions for deriving new digests from them.
 //   This ensures that outputs of build actions automatically use the
 //   same instance name and hashing algorithm.
@@ -37,6 +42,12 @@ import (
 // representation upon creation. All functions that extract individual
 // components (e.g., GetInstance(), GetHash*() and GetSizeBytes())
 // operate directly on the key format.
+//
+// Digests computed using digest functions that can be inferred from
+// the length of the hash (MD5, SHA-1, SHA-256, SHA-384 and SHA-512)
+// use a key format of ${hash}-${size}-${instance}. For other digest
+// functions, the key is prefixed with the name of the digest function,
+// e.g. blake3-${hash}-${size}-${instance}.
 type Digest struct {
 	value string
 }
@@ -57,16 +68,69 @@ var (
 		remoteexecution.DigestFunction_SHA256,
 		remoteexecution.DigestFunction_SHA384,
 		remoteexecution.DigestFunction_SHA512,
+		remoteexecution.DigestFunction_SHA256TREE,
+		remoteexecution.DigestFunction_BLAKE3,
+	}
+)
+
+// digestFunctionInfo contains the properties of a digest function that
+// are needed to construct and validate digests.
+type digestFunctionInfo struct {
+	newHasher     func() hash.Hash
+	hashSizeBytes int
+	// Whether the digest function is the one that is used for
+	// hashes of this length if no digest function is specified
+	// explicitly. Digests of other digest functions have their
+	// keys prefixed with the name of the digest function.
+	inferable bool
+}
+
+var (
+	digestFunctionInfos = map[remoteexecution.DigestFunction_Value]digestFunctionInfo{
+		remoteexecution.DigestFunction_MD5:        {newHasher: md5.New, inferable: true},
+		remoteexecution.DigestFunction_SHA1:       {newHasher: sha1.New, inferable: true},
+		remoteexecution.DigestFunction_SHA256:     {newHasher: sha256.New, inferable: true},
+		remoteexecution.DigestFunction_SHA384:     {newHasher: sha512.New384, inferable: true},
+		remoteexecution.DigestFunction_SHA512:     {newHasher: sha512.New, inferable: true},
+		remoteexecution.DigestFunction_SHA256TREE: {newHasher: sha256tree.New, inferable: false},
+		remoteexecution.DigestFunction_BLAKE3:     {newHasher: func() hash.Hash { return blake3.New() }, inferable: false},
 	}
+	inferableDigestFunctions = map[int]remoteexecution.DigestFunction_Value{}
+	prefixedDigestFunctions  = map[string]remoteexecution.DigestFunction_Value{}
 )
 
-// Unpack the individual hash, size and instance name fields from the
-// string representation stored inside the Digest object.
-func (d Digest) unpack() (int, int64, int) {
-	// Extract the leading hash.
-	hashEnd := md5.Size * 2
-	for d.value[hashEnd] != '-' {
-		hashEnd++
+func init() {
+	for digestFunction, info := range digestFunctionInfos {
+		info.hashSizeBytes = info.newHasher().Size()
+		digestFunctionInfos[digestFunction] = info
+		if info.inferable {
+			inferableDigestFunctions[info.hashSizeBytes*2] = digestFunction
+		} else {
+			prefixedDigestFunctions[getDigestFunctionName(digestFunction)] = digestFunction
+		}
+	}
+}
+
+// getDigestFunctionName returns the name of a digest function, as used
+// in keys and ByteStream resource names.
+func getDigestFunctionName(digestFunction remoteexecution.DigestFunction_Value) string {
+	return strings.ToLower(digestFunction.String())
+}
+
+// Unpack the individual digest function, hash, size and instance name
+// fields from the string representation stored inside the Digest
+// object.
+func (d Digest) unpack() (int, int, int64, int) {
+	// Extract the leading hash, which may be preceded by the name
+	// of the digest function. Names of digest functions are never
+	// as long as any of the hashes.
+	hashStart := 0
+	hashEnd := strings.IndexByte(d.value, '-')
+	switch hashEnd {
+	case md5.Size * 2, sha1.Size * 2, sha256.Size * 2, sha512.Size384 * 2, sha512.Size * 2:
+	default:
+		hashStart = hashEnd + 1
+		hashEnd = hashStart + strings.IndexByte(d.value[hashStart:], '-')
 	}
 
 	// Extract the size stored in the middle.
@@ -77,20 +141,32 @@ func (d Digest) unpack() (int, int64, int) {
 		sizeBytesEnd++
 	}
 
-	return hashEnd, sizeBytes, sizeBytesEnd
+	return hashStart, hashEnd, sizeBytes, sizeBytesEnd
 }
 
-// NewDigest constructs a Digest object from an instance name, hash and
-// object size. The instance returned by this function is guaranteed to
-// be non-degenerate.
-func NewDigest(instance string, hash string, sizeBytes int64) (Digest, error) {
+// NewDigest constructs a Digest object from an instance name, digest
+// function, hash and object size. The instance returned by this
+// function is guaranteed to be non-degenerate.
+//
+// If the digest function is UNKNOWN, it is inferred from the length
+// of the hash. This is permitted by the Remote Execution protocol for
+// MD5, SHA-1, SHA-256, SHA-384 and SHA-512, as older clients do not
+// specify the digest function explicitly.
+func NewDigest(instance string, digestFunction remoteexecution.DigestFunction_Value, hash string, sizeBytes int64) (Digest, error) {
 	// TODO(edsch): Validate the instance name. Maybe have a
 	// restrictive character set? What about length?
 
-	// Validate the hash.
-	if l := len(hash); l != md5.Size*2 && l != sha1.Size*2 &&
-		l != sha256.Size*2 && l != sha512.Size384*2 && l != sha512.Size*2 {
-		return BadDigest, status.Errorf(codes.InvalidArgument, "Unknown digest hash length: %d characters", l)
+	// Validate the digest function and the hash.
+	l := len(hash)
+	if digestFunction == remoteexecution.DigestFunction_UNKNOWN {
+		var ok bool
+		if digestFunction, ok = inferableDigestFunctions[l]; !ok {
+			return BadDigest, status.Errorf(codes.InvalidArgument, "Unknown digest hash length: %d characters", l)
+		}
+	} else if info, ok := digestFunctionInfos[digestFunction]; !ok {
+		return BadDigest, status.Errorf(codes.InvalidArgument, "Unsupported digest function: %s", digestFunction)
+	} else if expectedLength := info.hashSizeBytes * 2; l != expectedLength {
+		return BadDigest, status.Errorf(codes.InvalidArgument, "Hash has length %d, while %d characters were expected for digest function %s", l, expectedLength, digestFunction)
 	}
 	for _, c := range hash {
 		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
@@ -103,22 +179,28 @@ func NewDigest(instance string, hash string, sizeBytes int64) (Digest, error) {
 		return BadDigest, status.Errorf(codes.InvalidArgument, "Invalid digest size: %d bytes", sizeBytes)
 	}
 
-	return newDigestUnchecked(instance, hash, sizeBytes), nil
+	return newDigestUnchecked(instance, digestFunction, hash, sizeBytes), nil
 }
 
 // newDigestUnchecked constructs a Digest object from an instance name,
-// hash and object size without validating its contents.
-func newDigestUnchecked(instance string, hash string, sizeBytes int64) Digest {
+// digest function, hash and object size without validating its
+// contents.
+func newDigestUnchecked(instance string, digestFunction remoteexecution.DigestFunction_Value, hash string, sizeBytes int64) Digest {
+	if digestFunctionInfos[digestFunction].inferable {
+		return Digest{
+			value: fmt.Sprintf("%s-%d-%s", hash, sizeBytes, instance),
+		}
+	}
 	return Digest{
-		value: fmt.Sprintf("%s-%d-%s", hash, sizeBytes, instance),
+		value: fmt.Sprintf("%s-%s-%d-%s", getDigestFunctionName(digestFunction), hash, sizeBytes, instance),
 	}
 }
 
 // MustNewDigest constructs a Digest similar to NewDigest, but never
 // returns an error. Instead, execution will abort if the resulting
 // instance would be degenerate. Useful for unit testing.
-func MustNewDigest(instance string, hash string, sizeBytes int64) Digest {
-	d, err := NewDigest(instance, hash, sizeBytes)
+func MustNewDigest(instance string, digestFunction remoteexecution.DigestFunction_Value, hash string, sizeBytes int64) Digest {
+	d, err := NewDigest(instance, digestFunction, hash, sizeBytes)
 	if err != nil {
 		panic(err)
 	}
@@ -126,66 +208,241 @@ func MustNewDigest(instance string, hash string, sizeBytes int64) Digest {
 }
 
 // NewDigestFromPartialDigest constructs a Digest object from an
-// instance name and a protocol-level digest object. The instance
-// returned by this function is guaranteed to be non-degenerate.
-func NewDigestFromPartialDigest(instance string, partialDigest *remoteexecution.Digest) (Digest, error) {
+// instance name, digest function and a protocol-level digest object.
+// The instance returned by this function is guaranteed to be
+// non-degenerate.
+func NewDigestFromPartialDigest(instance string, digestFunction remoteexecution.DigestFunction_Value, partialDigest *remoteexecution.Digest) (Digest, error) {
 	if partialDigest == nil {
 		return BadDigest, status.Error(codes.InvalidArgument, "No digest provided")
 	}
-	return NewDigest(instance, partialDigest.Hash, partialDigest.SizeBytes)
+	return NewDigest(instance, digestFunction, partialDigest.Hash, partialDigest.SizeBytes)
+}
+
+// NewDigestFromPaddedHashBytes constructs a Digest object from a hash
+// that is stored in binary form in a fixed size array of sha256.Size
+// bytes, as done by the on-disk formats of the local and circular
+// storage backends. These formats store the digest function returned
+// by Digest.GetPaddedHashDigestFunction(), which should be provided.
+// Hashes that are shorter than SHA-256 are padded with zero bytes,
+// which is used to recover their original length.
+//
+// Hashes that are longer than SHA-256 (e.g., SHA-384 and SHA-512) are
+// truncated when stored in this format, meaning they cannot be
+// recovered. An error is returned for these, so that callers don't
+// attempt to validate objects against an incorrect digest.
+func NewDigestFromPaddedHashBytes(instance string, digestFunction remoteexecution.DigestFunction_Value, hash []byte, sizeBytes int64) (Digest, error) {
+	if len(hash) != sha256.Size {
+		return BadDigest, status.Errorf(codes.InvalidArgument, "Padded hash is %d bytes in size, while %d bytes were expected", len(hash), sha256.Size)
+	}
+	length := sha256.Size
+	if digestFunction == remoteexecution.DigestFunction_UNKNOWN {
+		for _, l := range []int{md5.Size, sha1.Size} {
+			if bytes.Count(hash[l:], []byte{0}) == sha256.Size-l {
+				length = l
+				break
+			}
+		}
+	} else if info, ok := digestFunctionInfos[digestFunction]; ok && info.hashSizeBytes > sha256.Size {
+		return BadDigest, status.Errorf(codes.FailedPrecondition, "Hashes of digest function %s are truncated when padded, meaning they cannot be recovered", digestFunction)
+	}
+	return NewDigest(instance, digestFunction, hex.EncodeToString(hash[:length]), sizeBytes)
+}
+
+// NewDigestFromKey constructs a Digest object from a key that was
+// returned by Digest.GetKey(). Keys of format KeyWithoutInstance yield
+// digests that have an empty instance name. This function can be used
+// by storage backends to enumerate the objects they store.
+func NewDigestFromKey(key string, format KeyFormat) (Digest, error) {
+	// Strip the name of the digest function, if present.
+	digestFunction := remoteexecution.DigestFunction_UNKNOWN
+	if i := strings.IndexByte(key, '-'); i >= 0 {
+		if f, ok := prefixedDigestFunctions[key[:i]]; ok {
+			digestFunction = f
+			key = key[i+1:]
+		}
+	}
+
+	var fields []string
+	instance := ""
+	switch format {
+	case KeyWithoutInstance:
+		fields = strings.SplitN(key, "-", 2)
+		if len(fields) != 2 {
+			return BadDigest, status.Error(codes.InvalidArgument, "Invalid key format")
+		}
+	case KeyWithInstance:
+		fields = strings.SplitN(key, "-", 3)
+		if len(fields) != 3 {
+			return BadDigest, status.Error(codes.InvalidArgument, "Invalid key format")
+		}
+		instance = fields[2]
+	default:
+		panic("Invalid digest key format")
+	}
+	sizeBytes, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return BadDigest, status.Error(codes.InvalidArgument, "Invalid key format")
+	}
+	return NewDigest(instance, digestFunction, fields[0], sizeBytes)
 }
 
 // NewDigestFromBytestreamPath creates a Digest from a string having one
-// of the following two formats:
+// of the following four formats:
 //
 // - blobs/${hash}/${size}
 // - ${instance}/blobs/${hash}/${size}
+// - compressed-blobs/${compressor}/${hash}/${size}
+// - ${instance}/compressed-blobs/${compressor}/${hash}/${size}
+//
+// For digest functions that cannot be inferred from the length of the
+// hash, the hash is preceded by the name of the digest function (e.g.,
+// blobs/blake3/${hash}/${size}).
 //
 // This notation is used by Bazel to refer to files accessible through a
-// gRPC Bytestream service.
-func NewDigestFromBytestreamPath(path string) (Digest, error) {
+// gRPC Bytestream service. In addition to the digest, the compressor
+// that should be applied to the data is returned. For the first two
+// formats, this is always IDENTITY.
+func NewDigestFromBytestreamPath(path string) (Digest, remoteexecution.Compressor_Value, error) {
 	fields := strings.FieldsFunc(path, func(r rune) bool { return r == '/' })
+	digestFunction := remoteexecution.DigestFunction_UNKNOWN
+	if l := len(fields); l >= 3 {
+		if value, ok := prefixedDigestFunctions[fields[l-3]]; ok {
+			digestFunction = value
+			fields = append(fields[:l-3:l-3], fields[l-2:]...)
+		}
+	}
+
 	l := len(fields)
-	if (l != 3 && l != 4) || fields[l-3] != "blobs" {
-		return BadDigest, status.Error(codes.InvalidArgument, "Invalid resource naming scheme")
+	var instanceFields []string
+	compressor := remoteexecution.Compressor_IDENTITY
+	if (l == 3 || l == 4) && fields[l-3] == "blobs" {
+		instanceFields = fields[:l-3]
+	} else if (l == 4 || l == 5) && fields[l-4] == "compressed-blobs" {
+		instanceFields = fields[:l-4]
+		name := fields[l-3]
+		value, ok := remoteexecution.Compressor_Value_value[strings.ToUpper(name)]
+		if !ok || name != strings.ToLower(name) || value == int32(remoteexecution.Compressor_IDENTITY) {
+			return BadDigest, remoteexecution.Compressor_IDENTITY, status.Errorf(codes.InvalidArgument, "Unsupported compressor %#v", name)
+		}
+		compressor = remoteexecution.Compressor_Value(value)
+	} else {
+		return BadDigest, remoteexecution.Compressor_IDENTITY, status.Error(codes.InvalidArgument, "Invalid resource naming scheme")
 	}
 	size, err := strconv.ParseInt(fields[l-1], 10, 64)
 	if err != nil {
-		return BadDigest, status.Error(codes.InvalidArgument, "Invalid resource naming scheme")
+		return BadDigest, remoteexecution.Compressor_IDENTITY, status.Error(codes.InvalidArgument, "Invalid resource naming scheme")
 	}
 	instance := ""
-	if l == 4 {
-		instance = fields[0]
+	if len(instanceFields) == 1 {
+		instance = instanceFields[0]
+	}
+	d, err := NewDigest(instance, digestFunction, fields[l-2], size)
+	if err != nil {
+		return BadDigest, remoteexecution.Compressor_IDENTITY, err
+	}
+	return d, compressor, nil
+}
+
+// getByteStreamBlobPath returns the part of a ByteStream resource name
+// that identifies the blob, i.e., everything following the instance
+// name and optional upload UUID.
+func (d Digest) getByteStreamBlobPath(compressor remoteexecution.Compressor_Value) string {
+	hashStart, hashEnd, sizeBytes, _ := d.unpack()
+	var path string
+	if compressor == remoteexecution.Compressor_IDENTITY {
+		path = "blobs/"
+	} else {
+		path = fmt.Sprintf("compressed-blobs/%s/", strings.ToLower(compressor.String()))
+	}
+	if hashStart > 0 {
+		path += d.value[:hashStart-1] + "/"
+	}
+	return fmt.Sprintf("%s%s/%d", path, d.value[hashStart:hashEnd], sizeBytes)
+}
+
+// GetByteStreamReadPath returns the ByteStream resource name that may
+// be used to read the object, optionally in compressed form. The
+// resource name is in one of the formats that is accepted by
+// NewDigestFromBytestreamPath().
+func (d Digest) GetByteStreamReadPath(compressor remoteexecution.Compressor_Value) string {
+	blobPath := d.getByteStreamBlobPath(compressor)
+	if instance := d.GetInstance(); instance != "" {
+		return instance + "/" + blobPath
 	}
-	return NewDigest(instance, fields[l-2], size)
+	return blobPath
+}
+
+// GetByteStreamWritePath returns the ByteStream resource name that may
+// be used to upload the object, optionally in compressed form.
+func (d Digest) GetByteStreamWritePath(uuid uuid.UUID, compressor remoteexecution.Compressor_Value) string {
+	blobPath := fmt.Sprintf("uploads/%s/%s", uuid, d.getByteStreamBlobPath(compressor))
+	if instance := d.GetInstance(); instance != "" {
+		return instance + "/" + blobPath
+	}
+	return blobPath
 }
 
 // NewDerivedDigest creates a Digest object that uses the same instance
-// name as the one from which it is derived. This can be used to refer
-// to inputs (command, directories, files) of an action.
+// name and digest function as the one from which it is derived. This
+// can be used to refer to inputs (command, directories, files) of an
+// action.
 func (d Digest) NewDerivedDigest(partialDigest *remoteexecution.Digest) (Digest, error) {
-	// TODO(edsch): Check whether the resulting digest uses the same
-	// hashing algorithm?
-	return NewDigestFromPartialDigest(d.GetInstance(), partialDigest)
+	return NewDigestFromPartialDigest(d.GetInstance(), d.GetDigestFunction(), partialDigest)
 }
 
 // GetPartialDigest encodes the digest into the format used by the remote
 // execution protocol, so that it may be stored in messages returned to
 // the client.
 func (d Digest) GetPartialDigest() *remoteexecution.Digest {
-	hashEnd, sizeBytes, _ := d.unpack()
+	hashStart, hashEnd, sizeBytes, _ := d.unpack()
 	return &remoteexecution.Digest{
-		Hash:      d.value[:hashEnd],
+		Hash:      d.value[hashStart:hashEnd],
 		SizeBytes: sizeBytes,
 	}
 }
 
 // GetInstance returns the instance name of the object.
 func (d Digest) GetInstance() string {
-	_, _, sizeBytesEnd := d.unpack()
+	_, _, _, sizeBytesEnd := d.unpack()
 	return d.value[sizeBytesEnd+1:]
 }
 
+// GetDigestFunction returns the digest function that was used to
+// compute the hash of the object.
+func (d Digest) GetDigestFunction() remoteexecution.DigestFunction_Value {
+	hashStart, hashEnd, _, _ := d.unpack()
+	if hashStart > 0 {
+		return prefixedDigestFunctions[d.value[:hashStart-1]]
+	}
+	return inferableDigestFunctions[hashEnd]
+}
+
+// GetExplicitDigestFunction returns the digest function that was used
+// to compute the hash of the object if it cannot be inferred from the
+// length of the hash. Otherwise, UNKNOWN is returned. Storage backends
+// may use this to only store the digest function if needed, so that
+// the on-disk representation of existing objects remains unaltered.
+func (d Digest) GetExplicitDigestFunction() remoteexecution.DigestFunction_Value {
+	hashStart, _, _, _ := d.unpack()
+	if hashStart > 0 {
+		return prefixedDigestFunctions[d.value[:hashStart-1]]
+	}
+	return remoteexecution.DigestFunction_UNKNOWN
+}
+
+// GetPaddedHashDigestFunction returns the digest function that needs
+// to be stored alongside the hash of the object if the hash is stored
+// in a fixed size array of sha256.Size bytes. In addition to digest
+// functions that cannot be inferred from the length of the hash, this
+// includes digest functions whose hashes are truncated when stored in
+// this form, so that NewDigestFromPaddedHashBytes() can identify them.
+func (d Digest) GetPaddedHashDigestFunction() remoteexecution.DigestFunction_Value {
+	if digestFunction := d.GetDigestFunction(); digestFunctionInfos[digestFunction].hashSizeBytes > sha256.Size {
+		return digestFunction
+	}
+	return d.GetExplicitDigestFunction()
+}
+
 // GetHashBytes returns the hash of the object as a slice of bytes.
 func (d Digest) GetHashBytes() []byte {
 	hash, err := hex.DecodeString(d.GetHashString())
@@ -197,13 +454,13 @@ func (d Digest) GetHashBytes() []byte {
 
 // GetHashString returns the hash of the object as a string.
 func (d Digest) GetHashString() string {
-	hashEnd, _, _ := d.unpack()
-	return d.value[:hashEnd]
+	hashStart, hashEnd, _, _ := d.unpack()
+	return d.value[hashStart:hashEnd]
 }
 
 // GetSizeBytes returns the size of the object, in bytes.
 func (d Digest) GetSizeBytes() int64 {
-	_, sizeBytes, _ := d.unpack()
+	_, _, sizeBytes, _ := d.unpack()
 	return sizeBytes
 }
 
@@ -222,11 +479,13 @@ const (
 )
 
 // GetKey generates a string representation of the digest object that
-// may be used as keys in hash tables.
+// may be used as keys in hash tables. For digest functions that cannot
+// be inferred from the length of the hash, the key is prefixed with the
+// name of the digest function.
 func (d Digest) GetKey(format KeyFormat) string {
 	switch format {
 	case KeyWithoutInstance:
-		_, _, sizeBytesEnd := d.unpack()
+		_, _, _, sizeBytesEnd := d.unpack()
 		return d.value[:sizeBytesEnd]
 	case KeyWithInstance:
 		return d.value
@@ -244,38 +503,45 @@ func (d Digest) String() string {
 // algorithm as the one that was used to create the digest, making it
 // possible to validate data against a digest.
 func (d Digest) NewHasher() hash.Hash {
-	hashEnd, _, _ := d.unpack()
-	switch hashEnd {
-	case md5.Size * 2:
-		return md5.New()
-	case sha1.Size * 2:
-		return sha1.New()
-	case sha256.Size * 2:
-		return sha256.New()
-	case sha512.Size384 * 2:
-		return sha512.New384()
-	case sha512.Size * 2:
-		return sha512.New()
-	default:
+	info, ok := digestFunctionInfos[d.GetDigestFunction()]
+	if !ok {
 		panic("Digest hash is of unknown type")
 	}
+	return info.newHasher()
 }
 
 // NewGenerator creates a writer that may be used to compute digests of
 // newly created files.
 func (d Digest) NewGenerator() *Generator {
 	return &Generator{
-		instance:    d.GetInstance(),
-		partialHash: d.NewHasher(),
+		instance:       d.GetInstance(),
+		digestFunction: d.GetDigestFunction(),
+		partialHash:    d.NewHasher(),
 	}
 }
 
+// NewGeneratorForDigestFunction creates a writer that may be used to
+// compute digests of newly created files, using a given instance name
+// and digest function.
+func NewGeneratorForDigestFunction(instance string, digestFunction remoteexecution.DigestFunction_Value) (*Generator, error) {
+	info, ok := digestFunctionInfos[digestFunction]
+	if !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "Unsupported digest function: %s", digestFunction)
+	}
+	return &Generator{
+		instance:       instance,
+		digestFunction: digestFunction,
+		partialHash:    info.newHasher(),
+	}, nil
+}
+
 // Generator is a writer that may be used to compute digests of newly
 // created files.
 type Generator struct {
-	instance    string
-	partialHash hash.Hash
-	sizeBytes   int64
+	instance       string
+	digestFunction remoteexecution.DigestFunction_Value
+	partialHash    hash.Hash
+	sizeBytes      int64
 }
 
 // Write a chunk of data from a newly created file into the state of the
@@ -291,6 +557,7 @@ func (dg *Generator) Write(p []byte) (int, error) {
 func (dg *Generator) Sum() Digest {
 	return newDigestUnchecked(
 		dg.instance,
+		dg.digestFunction,
 		hex.EncodeToString(dg.partialHash.Sum(nil)),
 		dg.sizeBytes)
 }
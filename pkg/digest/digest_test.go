@@ -5,6 +5,7 @@ import (
 
 	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
 	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/google/uuid"
 	"github.com/stretchr/testify/require"
 
 	"google.golang.org/grpc/codes"
@@ -12,14 +13,228 @@ import (
 )
 
 func TestNewDigest(t *testing.T) {
-	_, err := digest.NewDigest("hello", "0123456789abcd", 123)
+	_, err := digest.NewDigest("hello", remoteexecution.DigestFunction_UNKNOWN, "0123456789abcd", 123)
 	require.Equal(t, status.Error(codes.InvalidArgument, "Unknown digest hash length: 14 characters"), err)
 
-	_, err = digest.NewDigest("hello", "555555555555555X5555555555555555", 123)
+	_, err = digest.NewDigest("hello", remoteexecution.DigestFunction_UNKNOWN, "555555555555555X5555555555555555", 123)
 	require.Equal(t, status.Error(codes.InvalidArgument, "Non-hexadecimal character in digest hash: U+0058 'X'"), err)
 
-	_, err = digest.NewDigest("hello", "00000000000000000000000000000000", -1)
+	_, err = digest.NewDigest("hello", remoteexecution.DigestFunction_MD5, "00000000000000000000000000000000", -1)
 	require.Equal(t, status.Error(codes.InvalidArgument, "Invalid digest size: -1 bytes"), err)
+
+	_, err = digest.NewDigest("hello", remoteexecution.DigestFunction_VSO, "00000000000000000000000000000000", 123)
+	require.Equal(t, status.Error(codes.InvalidArgument, "Unsupported digest function: VSO"), err)
+
+	_, err = digest.NewDigest("hello", remoteexecution.DigestFunction_BLAKE3, "00000000000000000000000000000000", 123)
+	require.Equal(t, status.Error(codes.InvalidArgument, "Hash has length 32, while 64 characters were expected for digest function BLAKE3"), err)
+}
+
+func TestNewDigestFromPaddedHashBytes(t *testing.T) {
+	t.Run("MD5", func(t *testing.T) {
+		d, err := digest.NewDigestFromPaddedHashBytes("hello", remoteexecution.DigestFunction_UNKNOWN, []byte{
+			0x8b, 0x1a, 0x99, 0x53, 0xc4, 0x61, 0x12, 0x96,
+			0xa8, 0x27, 0xab, 0xf8, 0xc4, 0x78, 0x04, 0xd7,
+			0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+			0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		}, 123)
+		require.NoError(t, err)
+		require.Equal(t, digest.MustNewDigest("hello", remoteexecution.DigestFunction_MD5, "8b1a9953c4611296a827abf8c47804d7", 123), d)
+	})
+
+	t.Run("SHA1", func(t *testing.T) {
+		d, err := digest.NewDigestFromPaddedHashBytes("hello", remoteexecution.DigestFunction_UNKNOWN, []byte{
+			0xa5, 0x4d, 0x88, 0xe0, 0x6a, 0x97, 0x5c, 0x5b,
+			0xb8, 0x58, 0xea, 0x5c, 0x33, 0x91, 0x44, 0x42,
+			0x4b, 0x15, 0xd3, 0x10, 0x00, 0x00, 0x00, 0x00,
+			0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		}, 123)
+		require.NoError(t, err)
+		require.Equal(t, digest.MustNewDigest("hello", remoteexecution.DigestFunction_SHA1, "a54d88e06a975c5bb858ea5c339144424b15d310", 123), d)
+	})
+
+	t.Run("SHA256", func(t *testing.T) {
+		d, err := digest.NewDigestFromPaddedHashBytes("hello", remoteexecution.DigestFunction_UNKNOWN, []byte{
+			0x18, 0x5f, 0x8d, 0xb3, 0x22, 0x71, 0xfe, 0x25,
+			0xf5, 0x61, 0xa6, 0xfc, 0x93, 0x8b, 0x2e, 0x26,
+			0x43, 0x06, 0xec, 0x30, 0x4e, 0xda, 0x51, 0x80,
+			0x07, 0xd1, 0x76, 0x48, 0x26, 0x38, 0x19, 0x69,
+		}, 123)
+		require.NoError(t, err)
+		require.Equal(t, digest.MustNewDigest("hello", remoteexecution.DigestFunction_SHA256, "185f8db32271fe25f561a6fc938b2e264306ec304eda518007d1764826381969", 123), d)
+	})
+
+	t.Run("BLAKE3", func(t *testing.T) {
+		// Digest functions that cannot be inferred from the
+		// length of the hash need to be provided explicitly.
+		d, err := digest.NewDigestFromPaddedHashBytes("hello", remoteexecution.DigestFunction_BLAKE3, []byte{
+			0xaf, 0x13, 0x49, 0xb9, 0xf5, 0xf9, 0xa1, 0xa6,
+			0xa0, 0x40, 0x4d, 0xea, 0x36, 0xdc, 0xc9, 0x49,
+			0x9b, 0xcb, 0x25, 0xc9, 0xad, 0xc1, 0x12, 0xb7,
+			0xcc, 0x9a, 0x93, 0xca, 0xe4, 0x1f, 0x32, 0x62,
+		}, 0)
+		require.NoError(t, err)
+		require.Equal(t, digest.MustNewDigest("hello", remoteexecution.DigestFunction_BLAKE3, "af1349b9f5f9a1a6a0404dea36dcc9499bcb25c9adc112b7cc9a93cae41f3262", 0), d)
+	})
+
+	t.Run("SHA384", func(t *testing.T) {
+		// Hashes that are longer than SHA-256 are truncated
+		// when padded. They should not be reconstructed as
+		// SHA-256 hashes, as that would cause objects to be
+		// validated against an incorrect digest.
+		_, err := digest.NewDigestFromPaddedHashBytes("hello", remoteexecution.DigestFunction_SHA384, []byte{
+			0x38, 0xb0, 0x60, 0xa7, 0x51, 0xac, 0x96, 0x38,
+			0x4c, 0xd9, 0x32, 0x7e, 0xb1, 0xb1, 0xe3, 0x6a,
+			0x21, 0xfd, 0xb7, 0x11, 0x14, 0xbe, 0x07, 0x43,
+			0x4c, 0x0c, 0xc7, 0xbf, 0x63, 0xf6, 0xe1, 0xda,
+		}, 0)
+		require.Equal(t, status.Error(codes.FailedPrecondition, "Hashes of digest function SHA384 are truncated when padded, meaning they cannot be recovered"), err)
+	})
+
+	t.Run("InvalidLength", func(t *testing.T) {
+		_, err := digest.NewDigestFromPaddedHashBytes("hello", remoteexecution.DigestFunction_UNKNOWN, []byte{0x8b, 0x1a, 0x99, 0x53}, 123)
+		require.Equal(t, status.Error(codes.InvalidArgument, "Padded hash is 4 bytes in size, while 32 bytes were expected"), err)
+	})
+}
+
+func TestDigestGetPaddedHashDigestFunction(t *testing.T) {
+	// Digest functions only need to be stored if they cannot be
+	// inferred from the length of the hash, or if their hashes are
+	// truncated.
+	require.Equal(
+		t,
+		remoteexecution.DigestFunction_UNKNOWN,
+		digest.MustNewDigest("hello", remoteexecution.DigestFunction_SHA256, "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", 0).GetPaddedHashDigestFunction())
+	require.Equal(
+		t,
+		remoteexecution.DigestFunction_BLAKE3,
+		digest.MustNewDigest("hello", remoteexecution.DigestFunction_BLAKE3, "af1349b9f5f9a1a6a0404dea36dcc9499bcb25c9adc112b7cc9a93cae41f3262", 0).GetPaddedHashDigestFunction())
+	require.Equal(
+		t,
+		remoteexecution.DigestFunction_SHA384,
+		digest.MustNewDigest("hello", remoteexecution.DigestFunction_SHA384, "38b060a751ac96384cd9327eb1b1e36a21fdb71114be07434c0cc7bf63f6e1da274edebfe76f65fbd51ad2f14898b95b", 0).GetPaddedHashDigestFunction())
+}
+
+func TestNewDigestFromBytestreamPath(t *testing.T) {
+	t.Run("Uncompressed", func(t *testing.T) {
+		d, compressor, err := digest.NewDigestFromBytestreamPath("hello/blobs/8b1a9953c4611296a827abf8c47804d7/123")
+		require.NoError(t, err)
+		require.Equal(t, digest.MustNewDigest("hello", remoteexecution.DigestFunction_MD5, "8b1a9953c4611296a827abf8c47804d7", 123), d)
+		require.Equal(t, remoteexecution.Compressor_IDENTITY, compressor)
+	})
+
+	t.Run("Compressed", func(t *testing.T) {
+		d, compressor, err := digest.NewDigestFromBytestreamPath("compressed-blobs/zstd/8b1a9953c4611296a827abf8c47804d7/123")
+		require.NoError(t, err)
+		require.Equal(t, digest.MustNewDigest("", remoteexecution.DigestFunction_MD5, "8b1a9953c4611296a827abf8c47804d7", 123), d)
+		require.Equal(t, remoteexecution.Compressor_ZSTD, compressor)
+
+		d, compressor, err = digest.NewDigestFromBytestreamPath("hello/compressed-blobs/deflate/8b1a9953c4611296a827abf8c47804d7/123")
+		require.NoError(t, err)
+		require.Equal(t, digest.MustNewDigest("hello", remoteexecution.DigestFunction_MD5, "8b1a9953c4611296a827abf8c47804d7", 123), d)
+		require.Equal(t, remoteexecution.Compressor_DEFLATE, compressor)
+	})
+
+	t.Run("DigestFunction", func(t *testing.T) {
+		d, compressor, err := digest.NewDigestFromBytestreamPath("hello/blobs/blake3/af1349b9f5f9a1a6a0404dea36dcc9499bcb25c9adc112b7cc9a93cae41f3262/0")
+		require.NoError(t, err)
+		require.Equal(t, digest.MustNewDigest("hello", remoteexecution.DigestFunction_BLAKE3, "af1349b9f5f9a1a6a0404dea36dcc9499bcb25c9adc112b7cc9a93cae41f3262", 0), d)
+		require.Equal(t, remoteexecution.Compressor_IDENTITY, compressor)
+
+		d, compressor, err = digest.NewDigestFromBytestreamPath("compressed-blobs/zstd/sha256tree/e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855/0")
+		require.NoError(t, err)
+		require.Equal(t, digest.MustNewDigest("", remoteexecution.DigestFunction_SHA256TREE, "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", 0), d)
+		require.Equal(t, remoteexecution.Compressor_ZSTD, compressor)
+	})
+
+	t.Run("UnsupportedCompressor", func(t *testing.T) {
+		_, _, err := digest.NewDigestFromBytestreamPath("compressed-blobs/identity/8b1a9953c4611296a827abf8c47804d7/123")
+		require.Equal(t, status.Error(codes.InvalidArgument, "Unsupported compressor \"identity\""), err)
+
+		_, _, err = digest.NewDigestFromBytestreamPath("compressed-blobs/lzma/8b1a9953c4611296a827abf8c47804d7/123")
+		require.Equal(t, status.Error(codes.InvalidArgument, "Unsupported compressor \"lzma\""), err)
+	})
+
+	t.Run("InvalidResourceName", func(t *testing.T) {
+		_, _, err := digest.NewDigestFromBytestreamPath("hello/blobs/8b1a9953c4611296a827abf8c47804d7")
+		require.Equal(t, status.Error(codes.InvalidArgument, "Invalid resource naming scheme"), err)
+	})
+}
+
+func TestNewDigestFromKey(t *testing.T) {
+	t.Run("WithoutInstance", func(t *testing.T) {
+		d, err := digest.NewDigestFromKey("8b1a9953c4611296a827abf8c47804d7-123", digest.KeyWithoutInstance)
+		require.NoError(t, err)
+		require.Equal(t, digest.MustNewDigest("", remoteexecution.DigestFunction_MD5, "8b1a9953c4611296a827abf8c47804d7", 123), d)
+	})
+
+	t.Run("WithInstance", func(t *testing.T) {
+		d, err := digest.NewDigestFromKey("8b1a9953c4611296a827abf8c47804d7-123-hello-world", digest.KeyWithInstance)
+		require.NoError(t, err)
+		require.Equal(t, digest.MustNewDigest("hello-world", remoteexecution.DigestFunction_MD5, "8b1a9953c4611296a827abf8c47804d7", 123), d)
+	})
+
+	t.Run("DigestFunction", func(t *testing.T) {
+		d, err := digest.NewDigestFromKey("blake3-af1349b9f5f9a1a6a0404dea36dcc9499bcb25c9adc112b7cc9a93cae41f3262-123-hello", digest.KeyWithInstance)
+		require.NoError(t, err)
+		require.Equal(t, digest.MustNewDigest("hello", remoteexecution.DigestFunction_BLAKE3, "af1349b9f5f9a1a6a0404dea36dcc9499bcb25c9adc112b7cc9a93cae41f3262", 123), d)
+	})
+
+	t.Run("InvalidKey", func(t *testing.T) {
+		_, err := digest.NewDigestFromKey("8b1a9953c4611296a827abf8c47804d7-123-hello", digest.KeyWithoutInstance)
+		require.Equal(t, status.Error(codes.InvalidArgument, "Invalid key format"), err)
+
+		_, err = digest.NewDigestFromKey("8b1a9953c4611296a827abf8c47804d7-123", digest.KeyWithInstance)
+		require.Equal(t, status.Error(codes.InvalidArgument, "Invalid key format"), err)
+
+		_, err = digest.NewDigestFromKey("some-other-key", digest.KeyWithInstance)
+		require.Equal(t, status.Error(codes.InvalidArgument, "Invalid key format"), err)
+	})
+}
+
+func TestDigestGetByteStreamReadPath(t *testing.T) {
+	require.Equal(
+		t,
+		"blobs/8b1a9953c4611296a827abf8c47804d7/123",
+		digest.MustNewDigest("", remoteexecution.DigestFunction_MD5, "8b1a9953c4611296a827abf8c47804d7", 123).GetByteStreamReadPath(remoteexecution.Compressor_IDENTITY))
+	require.Equal(
+		t,
+		"hello/compressed-blobs/zstd/blake3/af1349b9f5f9a1a6a0404dea36dcc9499bcb25c9adc112b7cc9a93cae41f3262/0",
+		digest.MustNewDigest("hello", remoteexecution.DigestFunction_BLAKE3, "af1349b9f5f9a1a6a0404dea36dcc9499bcb25c9adc112b7cc9a93cae41f3262", 0).GetByteStreamReadPath(remoteexecution.Compressor_ZSTD))
+}
+
+func TestDigestGetByteStreamWritePath(t *testing.T) {
+	uuid := uuid.Must(uuid.Parse("7de747e0-ab6b-4d83-90cb-11989f84c473"))
+	require.Equal(
+		t,
+		"uploads/7de747e0-ab6b-4d83-90cb-11989f84c473/blobs/8b1a9953c4611296a827abf8c47804d7/123",
+		digest.MustNewDigest("", remoteexecution.DigestFunction_MD5, "8b1a9953c4611296a827abf8c47804d7", 123).GetByteStreamWritePath(uuid, remoteexecution.Compressor_IDENTITY))
+	require.Equal(
+		t,
+		"hello/uploads/7de747e0-ab6b-4d83-90cb-11989f84c473/blobs/sha256tree/e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855/0",
+		digest.MustNewDigest("hello", remoteexecution.DigestFunction_SHA256TREE, "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", 0).GetByteStreamWritePath(uuid, remoteexecution.Compressor_IDENTITY))
+}
+
+func TestDigestGetDigestFunction(t *testing.T) {
+	d := digest.MustNewDigest("hello", remoteexecution.DigestFunction_UNKNOWN, "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", 0)
+	require.Equal(t, remoteexecution.DigestFunction_SHA256, d.GetDigestFunction())
+	require.Equal(t, remoteexecution.DigestFunction_UNKNOWN, d.GetExplicitDigestFunction())
+
+	d = digest.MustNewDigest("hello", remoteexecution.DigestFunction_BLAKE3, "af1349b9f5f9a1a6a0404dea36dcc9499bcb25c9adc112b7cc9a93cae41f3262", 0)
+	require.Equal(t, remoteexecution.DigestFunction_BLAKE3, d.GetDigestFunction())
+	require.Equal(t, remoteexecution.DigestFunction_BLAKE3, d.GetExplicitDigestFunction())
+}
+
+func TestDigestNewGenerator(t *testing.T) {
+	// Generators should use the same digest function as the
+	// digest from which they are created.
+	for _, d := range []digest.Digest{
+		digest.MustNewDigest("hello", remoteexecution.DigestFunction_MD5, "d41d8cd98f00b204e9800998ecf8427e", 0),
+		digest.MustNewDigest("hello", remoteexecution.DigestFunction_SHA256, "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", 0),
+		digest.MustNewDigest("hello", remoteexecution.DigestFunction_SHA256TREE, "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", 0),
+		digest.MustNewDigest("hello", remoteexecution.DigestFunction_BLAKE3, "af1349b9f5f9a1a6a0404dea36dcc9499bcb25c9adc112b7cc9a93cae41f3262", 0),
+	} {
+		require.Equal(t, d, d.NewGenerator().Sum())
+	}
 }
 
 func TestDigestGetPartialDigest(t *testing.T) {
@@ -31,6 +246,7 @@ func TestDigestGetPartialDigest(t *testing.T) {
 		},
 		digest.MustNewDigest(
 			"hello",
+			remoteexecution.DigestFunction_SHA256,
 			"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
 			123).GetPartialDigest())
 }
@@ -41,6 +257,7 @@ func TestDigestGetInstance(t *testing.T) {
 		"hello",
 		digest.MustNewDigest(
 			"hello",
+			remoteexecution.DigestFunction_SHA256,
 			"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
 			123).GetInstance())
 }
@@ -56,6 +273,7 @@ func TestDigestGetHashBytes(t *testing.T) {
 		},
 		digest.MustNewDigest(
 			"hello",
+			remoteexecution.DigestFunction_SHA256,
 			"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
 			123).GetHashBytes())
 }
@@ -66,6 +284,7 @@ func TestDigestGetHashString(t *testing.T) {
 		"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
 		digest.MustNewDigest(
 			"hello",
+			remoteexecution.DigestFunction_SHA256,
 			"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
 			123).GetHashString())
 }
@@ -76,12 +295,13 @@ func TestDigestGetSizeBytes(t *testing.T) {
 		int64(123),
 		digest.MustNewDigest(
 			"hello",
+			remoteexecution.DigestFunction_SHA256,
 			"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
 			123).GetSizeBytes())
 }
 
 func TestDigestGetKey(t *testing.T) {
-	d := digest.MustNewDigest("hello", "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", 123)
+	d := digest.MustNewDigest("hello", remoteexecution.DigestFunction_SHA256, "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", 123)
 	require.Equal(
 		t,
 		"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855-123",
@@ -90,6 +310,18 @@ func TestDigestGetKey(t *testing.T) {
 		t,
 		"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855-123-hello",
 		d.GetKey(digest.KeyWithInstance))
+
+	// Keys of digest functions that cannot be inferred from the
+	// length of the hash are prefixed with the digest function.
+	d = digest.MustNewDigest("hello", remoteexecution.DigestFunction_BLAKE3, "af1349b9f5f9a1a6a0404dea36dcc9499bcb25c9adc112b7cc9a93cae41f3262", 123)
+	require.Equal(
+		t,
+		"blake3-af1349b9f5f9a1a6a0404dea36dcc9499bcb25c9adc112b7cc9a93cae41f3262-123",
+		d.GetKey(digest.KeyWithoutInstance))
+	require.Equal(
+		t,
+		"blake3-af1349b9f5f9a1a6a0404dea36dcc9499bcb25c9adc112b7cc9a93cae41f3262-123-hello",
+		d.GetKey(digest.KeyWithInstance))
 }
 
 func TestDigestString(t *testing.T) {
@@ -98,6 +330,7 @@ func TestDigestString(t *testing.T) {
 		"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855-123-hello",
 		digest.MustNewDigest(
 			"hello",
+			remoteexecution.DigestFunction_SHA256,
 			"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
 			123).String())
 }
@@ -2,6 +2,8 @@ package digest
 
 import (
 	"container/heap"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
 )
 
 // Set of digests. Sets are immutable and can be created using
@@ -40,6 +42,34 @@ func (s Set) Length() int {
 	return len(s.digests)
 }
 
+// PartitionByInstanceAndDigestFunction splits up the elements stored
+// in the set into one or more sets, such that all digests in each of
+// the resulting sets have the same instance name and digest function.
+// This is useful for RPCs such as FindMissingBlobs(), which can only
+// process digests for a single instance name and digest function.
+func (s Set) PartitionByInstanceAndDigestFunction() []Set {
+	type partitionKey struct {
+		instance       string
+		digestFunction remoteexecution.DigestFunction_Value
+	}
+	indices := map[partitionKey]int{}
+	var partitions []Set
+	for _, d := range s.digests {
+		key := partitionKey{
+			instance:       d.GetInstance(),
+			digestFunction: d.GetDigestFunction(),
+		}
+		index, ok := indices[key]
+		if !ok {
+			index = len(partitions)
+			indices[key] = index
+			partitions = append(partitions, Set{})
+		}
+		partitions[index].digests = append(partitions[index].digests, d)
+	}
+	return partitions
+}
+
 // GetDifferenceAndIntersection partitions the elements stored in sets A
 // and B across three resulting sets: one containing the elements
 // present only in A, one containing the elements present in both A and
@@ -3,6 +3,7 @@ package digest_test
 import (
 	"testing"
 
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
 	"github.com/buildbarn/bb-storage/pkg/digest"
 	"github.com/stretchr/testify/require"
 )
@@ -12,7 +13,7 @@ func TestSetEmpty(t *testing.T) {
 	require.False(
 		t,
 		digest.NewSetBuilder().
-			Add(digest.MustNewDigest("instance", "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", 123)).
+			Add(digest.MustNewDigest("instance", remoteexecution.DigestFunction_MD5, "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", 123)).
 			Build().Empty())
 }
 
@@ -21,10 +22,10 @@ func TestSetFirst(t *testing.T) {
 	require.False(t, ok)
 
 	d, ok := digest.NewSetBuilder().
-		Add(digest.MustNewDigest("instance", "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", 123)).
+		Add(digest.MustNewDigest("instance", remoteexecution.DigestFunction_MD5, "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", 123)).
 		Build().First()
 	require.True(t, ok)
-	require.Equal(t, digest.MustNewDigest("instance", "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", 123), d)
+	require.Equal(t, digest.MustNewDigest("instance", remoteexecution.DigestFunction_MD5, "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", 123), d)
 }
 
 func TestSetLength(t *testing.T) {
@@ -33,30 +34,49 @@ func TestSetLength(t *testing.T) {
 		t,
 		1,
 		digest.NewSetBuilder().
-			Add(digest.MustNewDigest("instance", "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", 123)).
+			Add(digest.MustNewDigest("instance", remoteexecution.DigestFunction_MD5, "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", 123)).
 			Build().Length())
 	require.Equal(
 		t,
 		2,
 		digest.NewSetBuilder().
-			Add(digest.MustNewDigest("instance", "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", 123)).
-			Add(digest.MustNewDigest("instance", "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb", 123)).
+			Add(digest.MustNewDigest("instance", remoteexecution.DigestFunction_MD5, "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", 123)).
+			Add(digest.MustNewDigest("instance", remoteexecution.DigestFunction_MD5, "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb", 123)).
 			Build().Length())
 }
 
+func TestSetPartitionByInstanceAndDigestFunction(t *testing.T) {
+	require.Empty(t, digest.EmptySet.PartitionByInstanceAndDigestFunction())
+
+	d1 := digest.MustNewDigest("a", remoteexecution.DigestFunction_SHA256, "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", 0)
+	d2 := digest.MustNewDigest("a", remoteexecution.DigestFunction_BLAKE3, "af1349b9f5f9a1a6a0404dea36dcc9499bcb25c9adc112b7cc9a93cae41f3262", 0)
+	d3 := digest.MustNewDigest("b", remoteexecution.DigestFunction_SHA256, "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", 0)
+	d4 := digest.MustNewDigest("a", remoteexecution.DigestFunction_MD5, "d41d8cd98f00b204e9800998ecf8427e", 0)
+	d5 := digest.MustNewDigest("a", remoteexecution.DigestFunction_SHA256, "185f8db32271fe25f561a6fc938b2e264306ec304eda518007d1764826381969", 5)
+	require.ElementsMatch(
+		t,
+		[]digest.Set{
+			digest.NewSetBuilder().Add(d1).Add(d5).Build(),
+			digest.NewSetBuilder().Add(d2).Build(),
+			digest.NewSetBuilder().Add(d3).Build(),
+			digest.NewSetBuilder().Add(d4).Build(),
+		},
+		digest.NewSetBuilder().Add(d1).Add(d2).Add(d3).Add(d4).Add(d5).Build().PartitionByInstanceAndDigestFunction())
+}
+
 func TestGetDifferenceAndIntersection(t *testing.T) {
 	onlyA, both, onlyB := digest.GetDifferenceAndIntersection(
 		digest.NewSetBuilder().
-			Add(digest.MustNewDigest("instance", "0aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", 123)).
-			Add(digest.MustNewDigest("instance", "1aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", 123)).
-			Add(digest.MustNewDigest("instance", "0fffffffffffffffffffffffffffffff", 789)).
-			Add(digest.MustNewDigest("instance", "1fffffffffffffffffffffffffffffff", 789)).
+			Add(digest.MustNewDigest("instance", remoteexecution.DigestFunction_MD5, "0aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", 123)).
+			Add(digest.MustNewDigest("instance", remoteexecution.DigestFunction_MD5, "1aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", 123)).
+			Add(digest.MustNewDigest("instance", remoteexecution.DigestFunction_MD5, "0fffffffffffffffffffffffffffffff", 789)).
+			Add(digest.MustNewDigest("instance", remoteexecution.DigestFunction_MD5, "1fffffffffffffffffffffffffffffff", 789)).
 			Build(),
 		digest.NewSetBuilder().
-			Add(digest.MustNewDigest("instance", "0bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb", 456)).
-			Add(digest.MustNewDigest("instance", "1bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb", 456)).
-			Add(digest.MustNewDigest("instance", "0fffffffffffffffffffffffffffffff", 789)).
-			Add(digest.MustNewDigest("instance", "1fffffffffffffffffffffffffffffff", 789)).
+			Add(digest.MustNewDigest("instance", remoteexecution.DigestFunction_MD5, "0bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb", 456)).
+			Add(digest.MustNewDigest("instance", remoteexecution.DigestFunction_MD5, "1bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb", 456)).
+			Add(digest.MustNewDigest("instance", remoteexecution.DigestFunction_MD5, "0fffffffffffffffffffffffffffffff", 789)).
+			Add(digest.MustNewDigest("instance", remoteexecution.DigestFunction_MD5, "1fffffffffffffffffffffffffffffff", 789)).
 			Build())
 
 	// Ensure that the resulting sets both have the right contents,
@@ -64,22 +84,22 @@ func TestGetDifferenceAndIntersection(t *testing.T) {
 	require.Equal(
 		t,
 		[]digest.Digest{
-			digest.MustNewDigest("instance", "0aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", 123),
-			digest.MustNewDigest("instance", "1aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", 123),
+			digest.MustNewDigest("instance", remoteexecution.DigestFunction_MD5, "0aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", 123),
+			digest.MustNewDigest("instance", remoteexecution.DigestFunction_MD5, "1aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", 123),
 		},
 		onlyA.Items())
 	require.Equal(
 		t,
 		[]digest.Digest{
-			digest.MustNewDigest("instance", "0fffffffffffffffffffffffffffffff", 789),
-			digest.MustNewDigest("instance", "1fffffffffffffffffffffffffffffff", 789),
+			digest.MustNewDigest("instance", remoteexecution.DigestFunction_MD5, "0fffffffffffffffffffffffffffffff", 789),
+			digest.MustNewDigest("instance", remoteexecution.DigestFunction_MD5, "1fffffffffffffffffffffffffffffff", 789),
 		},
 		both.Items())
 	require.Equal(
 		t,
 		[]digest.Digest{
-			digest.MustNewDigest("instance", "0bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb", 456),
-			digest.MustNewDigest("instance", "1bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb", 456),
+			digest.MustNewDigest("instance", remoteexecution.DigestFunction_MD5, "0bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb", 456),
+			digest.MustNewDigest("instance", remoteexecution.DigestFunction_MD5, "1bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb", 456),
 		},
 		onlyB.Items())
 }
@@ -95,13 +115,13 @@ func TestGetUnion(t *testing.T) {
 		require.Equal(
 			t,
 			[]digest.Digest{
-				digest.MustNewDigest("instance", "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", 1),
-				digest.MustNewDigest("instance", "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb", 1),
+				digest.MustNewDigest("instance", remoteexecution.DigestFunction_MD5, "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", 1),
+				digest.MustNewDigest("instance", remoteexecution.DigestFunction_MD5, "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb", 1),
 			},
 			digest.GetUnion([]digest.Set{
 				digest.NewSetBuilder().
-					Add(digest.MustNewDigest("instance", "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", 1)).
-					Add(digest.MustNewDigest("instance", "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb", 1)).
+					Add(digest.MustNewDigest("instance", remoteexecution.DigestFunction_MD5, "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", 1)).
+					Add(digest.MustNewDigest("instance", remoteexecution.DigestFunction_MD5, "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb", 1)).
 					Build(),
 			}).Items())
 	})
@@ -111,32 +131,32 @@ func TestGetUnion(t *testing.T) {
 		require.Equal(
 			t,
 			[]digest.Digest{
-				digest.MustNewDigest("instance", "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", 1),
-				digest.MustNewDigest("instance", "abababababababababababababababab", 2),
-				digest.MustNewDigest("instance", "abcabcabcabcabcabcabcabcabcabcab", 3),
-				digest.MustNewDigest("instance", "acacacacacacacacacacacacacacacac", 2),
-				digest.MustNewDigest("instance", "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb", 1),
-				digest.MustNewDigest("instance", "bcbcbcbcbcbcbcbcbcbcbcbcbcbcbcbc", 2),
-				digest.MustNewDigest("instance", "cccccccccccccccccccccccccccccccc", 1),
+				digest.MustNewDigest("instance", remoteexecution.DigestFunction_MD5, "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", 1),
+				digest.MustNewDigest("instance", remoteexecution.DigestFunction_MD5, "abababababababababababababababab", 2),
+				digest.MustNewDigest("instance", remoteexecution.DigestFunction_MD5, "abcabcabcabcabcabcabcabcabcabcab", 3),
+				digest.MustNewDigest("instance", remoteexecution.DigestFunction_MD5, "acacacacacacacacacacacacacacacac", 2),
+				digest.MustNewDigest("instance", remoteexecution.DigestFunction_MD5, "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb", 1),
+				digest.MustNewDigest("instance", remoteexecution.DigestFunction_MD5, "bcbcbcbcbcbcbcbcbcbcbcbcbcbcbcbc", 2),
+				digest.MustNewDigest("instance", remoteexecution.DigestFunction_MD5, "cccccccccccccccccccccccccccccccc", 1),
 			},
 			digest.GetUnion([]digest.Set{
 				digest.NewSetBuilder().
-					Add(digest.MustNewDigest("instance", "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", 1)).
-					Add(digest.MustNewDigest("instance", "abababababababababababababababab", 2)).
-					Add(digest.MustNewDigest("instance", "acacacacacacacacacacacacacacacac", 2)).
-					Add(digest.MustNewDigest("instance", "abcabcabcabcabcabcabcabcabcabcab", 3)).
+					Add(digest.MustNewDigest("instance", remoteexecution.DigestFunction_MD5, "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", 1)).
+					Add(digest.MustNewDigest("instance", remoteexecution.DigestFunction_MD5, "abababababababababababababababab", 2)).
+					Add(digest.MustNewDigest("instance", remoteexecution.DigestFunction_MD5, "acacacacacacacacacacacacacacacac", 2)).
+					Add(digest.MustNewDigest("instance", remoteexecution.DigestFunction_MD5, "abcabcabcabcabcabcabcabcabcabcab", 3)).
 					Build(),
 				digest.NewSetBuilder().
-					Add(digest.MustNewDigest("instance", "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb", 1)).
-					Add(digest.MustNewDigest("instance", "abababababababababababababababab", 2)).
-					Add(digest.MustNewDigest("instance", "bcbcbcbcbcbcbcbcbcbcbcbcbcbcbcbc", 2)).
-					Add(digest.MustNewDigest("instance", "abcabcabcabcabcabcabcabcabcabcab", 3)).
+					Add(digest.MustNewDigest("instance", remoteexecution.DigestFunction_MD5, "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb", 1)).
+					Add(digest.MustNewDigest("instance", remoteexecution.DigestFunction_MD5, "abababababababababababababababab", 2)).
+					Add(digest.MustNewDigest("instance", remoteexecution.DigestFunction_MD5, "bcbcbcbcbcbcbcbcbcbcbcbcbcbcbcbc", 2)).
+					Add(digest.MustNewDigest("instance", remoteexecution.DigestFunction_MD5, "abcabcabcabcabcabcabcabcabcabcab", 3)).
 					Build(),
 				digest.NewSetBuilder().
-					Add(digest.MustNewDigest("instance", "cccccccccccccccccccccccccccccccc", 1)).
-					Add(digest.MustNewDigest("instance", "acacacacacacacacacacacacacacacac", 2)).
-					Add(digest.MustNewDigest("instance", "bcbcbcbcbcbcbcbcbcbcbcbcbcbcbcbc", 2)).
-					Add(digest.MustNewDigest("instance", "abcabcabcabcabcabcabcabcabcabcab", 3)).
+					Add(digest.MustNewDigest("instance", remoteexecution.DigestFunction_MD5, "cccccccccccccccccccccccccccccccc", 1)).
+					Add(digest.MustNewDigest("instance", remoteexecution.DigestFunction_MD5, "acacacacacacacacacacacacacacacac", 2)).
+					Add(digest.MustNewDigest("instance", remoteexecution.DigestFunction_MD5, "bcbcbcbcbcbcbcbcbcbcbcbcbcbcbcbc", 2)).
+					Add(digest.MustNewDigest("instance", remoteexecution.DigestFunction_MD5, "abcabcabcabcabcabcabcabcabcabcab", 3)).
 					Build(),
 			}).Items())
 	})
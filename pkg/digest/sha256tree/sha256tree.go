@@ -0,0 +1,139 @@
+package sha256tree
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"hash"
+	"math/bits"
+)
+
+const (
+	// Size of a SHA256TREE hash in bytes.
+	Size = sha256.Size
+
+	// chunkSize is the size of the leaves of the Merkle tree. Blobs
+	// that are this size or smaller are hashed using plain SHA-256.
+	chunkSize = 1024
+)
+
+// parentInitialization contains the values of H that are used as the
+// initial state of the SHA-256 block cipher when computing the hash of
+// a parent node. These are the leading fractional parts of the square
+// roots of the 9th to the 16th prime number.
+var parentInitialization = [8]uint32{
+	0xcbbb9d5d, 0x629a292a, 0x9159015a, 0x152fecd8,
+	0x67332667, 0x8eb44a87, 0xdb0c2e0d, 0x47b5481d,
+}
+
+var roundConstants = [64]uint32{
+	0x428a2f98, 0x71374491, 0xb5c0fbcf, 0xe9b5dba5, 0x3956c25b, 0x59f111f1, 0x923f82a4, 0xab1c5ed5,
+	0xd807aa98, 0x12835b01, 0x243185be, 0x550c7dc3, 0x72be5d74, 0x80deb1fe, 0x9bdc06a7, 0xc19bf174,
+	0xe49b69c1, 0xefbe4786, 0x0fc19dc6, 0x240ca1cc, 0x2de92c6f, 0x4a7484aa, 0x5cb0a9dc, 0x76f988da,
+	0x983e5152, 0xa831c66d, 0xb00327c8, 0xbf597fc7, 0xc6e00bf3, 0xd5a79147, 0x06ca6351, 0x14292967,
+	0x27b70a85, 0x2e1b2138, 0x4d2c6dfc, 0x53380d13, 0x650a7354, 0x766a0abb, 0x81c2c92e, 0x92722c85,
+	0xa2bfe8a1, 0xa81a664b, 0xc24b8b70, 0xc76c51a3, 0xd192e819, 0xd6990624, 0xf40e3585, 0x106aa070,
+	0x19a4c116, 0x1e376c08, 0x2748774c, 0x34b0bcb5, 0x391c0cb3, 0x4ed8aa4a, 0x5b9cca4f, 0x682e6ff3,
+	0x748f82ee, 0x78a5636f, 0x84c87814, 0x8cc70208, 0x90befffa, 0xa4506ceb, 0xbef9a3f7, 0xc67178f2,
+}
+
+// hashParent computes the hash of a parent node in the Merkle tree by
+// invoking the SHA-256 block cipher once on the concatenation of the
+// hashes of its children. Unlike SHA-256's compression function, the
+// initial state is not added to the output.
+func hashParent(left *[Size]byte, right *[Size]byte) (out [Size]byte) {
+	var w [64]uint32
+	for i := 0; i < 8; i++ {
+		w[i] = binary.BigEndian.Uint32(left[i*4:])
+		w[i+8] = binary.BigEndian.Uint32(right[i*4:])
+	}
+	for i := 16; i < 64; i++ {
+		s0 := bits.RotateLeft32(w[i-15], -7) ^ bits.RotateLeft32(w[i-15], -18) ^ (w[i-15] >> 3)
+		s1 := bits.RotateLeft32(w[i-2], -17) ^ bits.RotateLeft32(w[i-2], -19) ^ (w[i-2] >> 10)
+		w[i] = w[i-16] + s0 + w[i-7] + s1
+	}
+
+	h := parentInitialization
+	a, b, c, d, e, f, g, hh := h[0], h[1], h[2], h[3], h[4], h[5], h[6], h[7]
+	for i := 0; i < 64; i++ {
+		s1 := bits.RotateLeft32(e, -6) ^ bits.RotateLeft32(e, -11) ^ bits.RotateLeft32(e, -25)
+		ch := (e & f) ^ (^e & g)
+		t1 := hh + s1 + ch + roundConstants[i] + w[i]
+		s0 := bits.RotateLeft32(a, -2) ^ bits.RotateLeft32(a, -13) ^ bits.RotateLeft32(a, -22)
+		maj := (a & b) ^ (a & c) ^ (b & c)
+		t2 := s0 + maj
+		hh, g, f, e, d, c, b, a = g, f, e, d+t1, c, b, a, t1+t2
+	}
+	for i, v := range [8]uint32{a, b, c, d, e, f, g, hh} {
+		binary.BigEndian.PutUint32(out[i*4:], v)
+	}
+	return
+}
+
+type digest struct {
+	// Data of the chunk that is currently being written. A chunk is
+	// only hashed when data beyond its end is written, as the final
+	// chunk may need to be hashed differently.
+	chunk       [chunkSize]byte
+	chunkLength int
+
+	// Hashes of the largest complete subtrees, ordered from left to
+	// right, and the number of chunks that they cover.
+	stack       [][Size]byte
+	chunksCount uint64
+}
+
+// New creates a hash.Hash that computes SHA256TREE hashes, as defined
+// by the Remote Execution protocol.
+func New() hash.Hash {
+	return &digest{}
+}
+
+func (d *digest) pushChunk() {
+	cv := sha256.Sum256(d.chunk[:])
+	d.chunkLength = 0
+
+	// Merge subtrees that have become complete. Because the final
+	// chunk is never pushed, every merge yields a left subtree of
+	// which the size is a power of two.
+	d.chunksCount++
+	for n := d.chunksCount; n&1 == 0; n >>= 1 {
+		cv = hashParent(&d.stack[len(d.stack)-1], &cv)
+		d.stack = d.stack[:len(d.stack)-1]
+	}
+	d.stack = append(d.stack, cv)
+}
+
+func (d *digest) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		if d.chunkLength == chunkSize {
+			d.pushChunk()
+		}
+		copied := copy(d.chunk[d.chunkLength:], p)
+		d.chunkLength += copied
+		p = p[copied:]
+	}
+	return n, nil
+}
+
+func (d *digest) Sum(b []byte) []byte {
+	cv := sha256.Sum256(d.chunk[:d.chunkLength])
+	for i := len(d.stack) - 1; i >= 0; i-- {
+		cv = hashParent(&d.stack[i], &cv)
+	}
+	return append(b, cv[:]...)
+}
+
+func (d *digest) Reset() {
+	d.chunkLength = 0
+	d.stack = d.stack[:0]
+	d.chunksCount = 0
+}
+
+func (d *digest) Size() int {
+	return Size
+}
+
+func (d *digest) BlockSize() int {
+	return sha256.BlockSize
+}
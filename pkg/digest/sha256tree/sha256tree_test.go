@@ -0,0 +1,71 @@
+package sha256tree_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/pkg/digest/sha256tree"
+	"github.com/stretchr/testify/require"
+)
+
+func getTestData(sizeBytes int) []byte {
+	data := make([]byte, sizeBytes)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	return data
+}
+
+func TestSHA256Tree(t *testing.T) {
+	t.Run("SmallBlobs", func(t *testing.T) {
+		// Blobs of up to 1024 bytes in size should have the
+		// same hash as plain SHA-256.
+		for _, sizeBytes := range []int{0, 1, 1023, 1024} {
+			data := getTestData(sizeBytes)
+			h := sha256tree.New()
+			h.Write(data)
+			expected := sha256.Sum256(data)
+			require.Equal(t, expected[:], h.Sum(nil))
+		}
+	})
+
+	t.Run("LargeBlobs", func(t *testing.T) {
+		for sizeBytes, expectedHash := range map[int]string{
+			2048:    "b584996386f01793751c5cf0c39561f51b7e9924b818943b3cb2f6928cea0fa9",
+			5000:    "07cd62b49e0abfe78f088abf11ff7db53209f76cb0c3699a2ba40f9dca2b02c0",
+			1 << 20: "795b0b21820fcb090cece32110b99946ae429fab1f04a2199355b4fa573f15df",
+		} {
+			h := sha256tree.New()
+			h.Write(getTestData(sizeBytes))
+			require.Equal(t, expectedHash, hex.EncodeToString(h.Sum(nil)))
+		}
+	})
+
+	t.Run("IncrementalWrites", func(t *testing.T) {
+		// The hash should not depend on how data is split up
+		// across calls to Write().
+		data := getTestData(100000)
+		h1 := sha256tree.New()
+		h1.Write(data)
+		h2 := sha256tree.New()
+		for p := data; len(p) > 0; {
+			n := 77
+			if n > len(p) {
+				n = len(p)
+			}
+			h2.Write(p[:n])
+			p = p[n:]
+		}
+		require.Equal(t, h1.Sum(nil), h2.Sum(nil))
+	})
+
+	t.Run("Reset", func(t *testing.T) {
+		h := sha256tree.New()
+		h.Write(getTestData(5000))
+		h.Reset()
+		h.Write(getTestData(1000))
+		expected := sha256.Sum256(getTestData(1000))
+		require.Equal(t, expected[:], h.Sum(nil))
+	})
+}
@@ -91,6 +91,11 @@ type Directory interface {
 	// RemoveAllChildren empties out a directory, without removing
 	// the directory itself.
 	RemoveAllChildren() error
+	// Rename is the equivalent of os.Rename().
+	Rename(oldName string, newDirectory Directory, newName string) error
 	// Symlink is the equivalent of os.Symlink().
 	Symlink(oldName string, newName string) error
+	// Sync flushes changes to the directory's entries (e.g., files
+	// that were created or renamed) to stable storage.
+	Sync() error
 }
@@ -9,6 +9,8 @@ import (
 type FileAppender interface {
 	io.Closer
 	io.Writer
+
+	Sync() error
 }
 
 // FileReader is returned by Directory.OpenRead(). It is a handle
@@ -26,6 +28,7 @@ type FileReadWriter interface {
 	io.ReaderAt
 	io.WriterAt
 
+	Sync() error
 	Truncate(size int64) error
 }
 
@@ -35,5 +38,6 @@ type FileWriter interface {
 	io.Closer
 	io.WriterAt
 
+	Sync() error
 	Truncate(size int64) error
 }
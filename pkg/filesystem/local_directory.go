@@ -348,6 +348,23 @@ func (d *localDirectory) RemoveAll(name string) error {
 	}
 }
 
+func (d *localDirectory) Rename(oldName string, newDirectory Directory, newName string) error {
+	if err := validateFilename(oldName); err != nil {
+		return err
+	}
+	if err := validateFilename(newName); err != nil {
+		return err
+	}
+	defer runtime.KeepAlive(d)
+	defer runtime.KeepAlive(newDirectory)
+
+	d2, ok := newDirectory.(*localDirectory)
+	if !ok {
+		return errors.New("Source and target directory have different types")
+	}
+	return unix.Renameat(d.fd, oldName, d2.fd, newName)
+}
+
 func (d *localDirectory) Symlink(oldName string, newName string) error {
 	if err := validateFilename(newName); err != nil {
 		return err
@@ -356,3 +373,9 @@ func (d *localDirectory) Symlink(oldName string, newName string) error {
 
 	return unix.Symlinkat(oldName, d.fd, newName)
 }
+
+func (d *localDirectory) Sync() error {
+	defer runtime.KeepAlive(d)
+
+	return unix.Fsync(d.fd)
+}
@@ -364,6 +364,45 @@ func TestLocalDirectoryRemoveSymlink(t *testing.T) {
 	require.NoError(t, d.Close())
 }
 
+func TestLocalDirectoryRenameBadName(t *testing.T) {
+	d := openTmpDir(t)
+
+	// Invalid source name.
+	require.Equal(t, status.Error(codes.InvalidArgument, "Invalid filename: \"\""), d.Rename("", d, "file"))
+	require.Equal(t, status.Error(codes.InvalidArgument, "Invalid filename: \".\""), d.Rename(".", d, "file"))
+	require.Equal(t, status.Error(codes.InvalidArgument, "Invalid filename: \"..\""), d.Rename("..", d, "file"))
+	require.Equal(t, status.Error(codes.InvalidArgument, "Invalid filename: \"foo/bar\""), d.Rename("foo/bar", d, "file"))
+
+	// Invalid target name.
+	require.Equal(t, status.Error(codes.InvalidArgument, "Invalid filename: \"\""), d.Rename("file", d, ""))
+	require.Equal(t, status.Error(codes.InvalidArgument, "Invalid filename: \".\""), d.Rename("file", d, "."))
+	require.Equal(t, status.Error(codes.InvalidArgument, "Invalid filename: \"..\""), d.Rename("file", d, ".."))
+	require.Equal(t, status.Error(codes.InvalidArgument, "Invalid filename: \"foo/bar\""), d.Rename("file", d, "foo/bar"))
+
+	require.NoError(t, d.Close())
+}
+
+func TestLocalDirectoryRenameNotFound(t *testing.T) {
+	d := openTmpDir(t)
+	require.Equal(t, syscall.ENOENT, d.Rename("source", d, "target"))
+	require.NoError(t, d.Close())
+}
+
+func TestLocalDirectoryRenameTargetExists(t *testing.T) {
+	// Unlike Link(), Rename() should atomically replace the target.
+	d := openTmpDir(t)
+	f, err := d.OpenWrite("source", filesystem.CreateExcl(0666))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	f, err = d.OpenWrite("target", filesystem.CreateExcl(0666))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	require.NoError(t, d.Rename("source", d, "target"))
+	_, err = d.Lstat("source")
+	require.True(t, os.IsNotExist(err))
+	require.NoError(t, d.Close())
+}
+
 func TestLocalDirectorySymlinkBadName(t *testing.T) {
 	d := openTmpDir(t)
 	require.Equal(t, status.Error(codes.InvalidArgument, "Invalid filename: \"\""), d.Symlink("/whatever", ""))
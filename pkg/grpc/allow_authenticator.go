@@ -6,8 +6,8 @@ import (
 
 type allowAuthenticator struct{}
 
-func (a allowAuthenticator) Authenticate(ctx context.Context) error {
-	return nil
+func (a allowAuthenticator) Authenticate(ctx context.Context) (string, error) {
+	return "", nil
 }
 
 // AllowAuthenticator is an implementation of Authenticator that simply
@@ -9,5 +9,6 @@ import (
 )
 
 func TestAllowAuthenticator(t *testing.T) {
-	require.NoError(t, bb_grpc.AllowAuthenticator.Authenticate(context.Background()))
+	_, err := bb_grpc.AllowAuthenticator.Authenticate(context.Background())
+	require.NoError(t, err)
 }
@@ -3,9 +3,15 @@ package grpc
 import (
 	"context"
 	"crypto/x509"
+	"time"
 
+	"github.com/buildbarn/bb-storage/pkg/auth"
 	"github.com/buildbarn/bb-storage/pkg/clock"
+	"github.com/buildbarn/bb-storage/pkg/jwt"
 	configuration "github.com/buildbarn/bb-storage/pkg/proto/configuration/grpc"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/grpc-ecosystem/go-grpc-middleware"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
@@ -15,8 +21,13 @@ import (
 // Authenticator can be used to grant or deny access to a gRPC server.
 // Implementations may grant access based on TLS connection state,
 // provided headers, source IP address ranges, etc. etc. etc.
+//
+// Upon success, the identity of the client is returned. This identity
+// may be used by an auth.Authorizer to perform finer grained access
+// control. The empty string is returned if the Authenticator grants
+// access without establishing an identity.
 type Authenticator interface {
-	Authenticate(ctx context.Context) error
+	Authenticate(ctx context.Context) (string, error)
 }
 
 // NewAuthenticatorFromConfiguration creates a tree of Authenticator
@@ -48,6 +59,49 @@ func NewAuthenticatorFromConfiguration(policy *configuration.AuthenticationPolic
 		return NewTLSClientCertificateAuthenticator(
 			clientCAs,
 			clock.SystemClock), nil
+	case *configuration.AuthenticationPolicy_Jwt:
+		var signatureValidator jwt.SignatureValidator
+		switch signatureKeys := policyKind.Jwt.SignatureKeys.(type) {
+		case *configuration.JWTAuthenticationPolicy_PublicKeys:
+			keys, err := jwt.ParsePEMPublicKeys([]byte(signatureKeys.PublicKeys))
+			if err != nil {
+				return nil, util.StatusWrap(err, "Failed to parse JWT public keys")
+			}
+			signatureValidator = keys
+		case *configuration.JWTAuthenticationPolicy_JwksPath:
+			refreshInterval := 5 * time.Minute
+			if policyKind.Jwt.JwksRefreshInterval != nil {
+				var err error
+				refreshInterval, err = ptypes.Duration(policyKind.Jwt.JwksRefreshInterval)
+				if err != nil {
+					return nil, util.StatusWrap(err, "Failed to parse JWKS refresh interval")
+				}
+				if refreshInterval <= 0 {
+					return nil, status.Error(codes.InvalidArgument, "JWKS refresh interval must be positive")
+				}
+			}
+			var err error
+			signatureValidator, err = jwt.NewJSONWebKeySetFileSignatureValidator(signatureKeys.JwksPath, clock.SystemClock, refreshInterval)
+			if err != nil {
+				return nil, err
+			}
+		default:
+			return nil, status.Error(codes.InvalidArgument, "No JWT signature keys provided")
+		}
+		allowedClockSkew := time.Minute
+		if policyKind.Jwt.AllowedClockSkew != nil {
+			var err error
+			allowedClockSkew, err = ptypes.Duration(policyKind.Jwt.AllowedClockSkew)
+			if err != nil {
+				return nil, util.StatusWrap(err, "Failed to parse allowed clock skew")
+			}
+		}
+		return NewJWTAuthenticator(
+			signatureValidator,
+			clock.SystemClock,
+			allowedClockSkew,
+			policyKind.Jwt.Audience,
+			policyKind.Jwt.Issuer), nil
 	default:
 		return nil, status.Error(codes.InvalidArgument, "Configuration did not contain an authentication policy type")
 	}
@@ -58,10 +112,11 @@ func NewAuthenticatorFromConfiguration(policy *configuration.AuthenticationPolic
 // This may be used to enable authentication support on a gRPC server.
 func NewAuthenticatingUnaryInterceptor(a Authenticator) grpc.UnaryServerInterceptor {
 	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
-		if err := a.Authenticate(ctx); err != nil {
+		identity, err := a.Authenticate(ctx)
+		if err != nil {
 			return nil, err
 		}
-		return handler(ctx, req)
+		return handler(auth.NewContextWithIdentity(ctx, identity), req)
 	}
 }
 
@@ -71,9 +126,12 @@ func NewAuthenticatingUnaryInterceptor(a Authenticator) grpc.UnaryServerIntercep
 // gRPC server.
 func NewAuthenticatingStreamInterceptor(a Authenticator) grpc.StreamServerInterceptor {
 	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
-		if err := a.Authenticate(ss.Context()); err != nil {
+		identity, err := a.Authenticate(ss.Context())
+		if err != nil {
 			return err
 		}
-		return handler(srv, ss)
+		wrappedStream := grpc_middleware.WrapServerStream(ss)
+		wrappedStream.WrappedContext = auth.NewContextWithIdentity(ss.Context(), identity)
+		return handler(srv, wrappedStream)
 	}
 }
@@ -13,8 +13,6 @@ import (
 
 func TestDenyAuthenticator(t *testing.T) {
 	authenticator := bb_grpc.NewDenyAuthenticator("This service has been disabled")
-	require.Equal(
-		t,
-		status.Error(codes.Unauthenticated, "This service has been disabled"),
-		authenticator.Authenticate(context.Background()))
+	_, err := authenticator.Authenticate(context.Background())
+	require.Equal(t, status.Error(codes.Unauthenticated, "This service has been disabled"), err)
 }
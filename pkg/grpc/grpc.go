@@ -50,18 +50,35 @@ func NewGRPCClientFromConfiguration(configuration *configuration.GRPCClientConfi
 // messages. In then lets all of these gRPC servers listen on the
 // network addresses of UNIX socket paths provided.
 func NewGRPCServersFromConfigurationAndServe(configurations []*configuration.GRPCServerConfiguration, registrationFunc func(*grpc.Server)) error {
+	authenticators := make([]Authenticator, 0, len(configurations))
+	for _, configuration := range configurations {
+		authenticator, err := NewAuthenticatorFromConfiguration(configuration.AuthenticationPolicy)
+		if err != nil {
+			return err
+		}
+		authenticators = append(authenticators, authenticator)
+	}
+	return NewGRPCServersFromConfigurationWithAuthenticatorsAndServe(configurations, authenticators, registrationFunc)
+}
+
+// NewGRPCServersFromConfigurationWithAuthenticatorsAndServe is
+// identical to NewGRPCServersFromConfigurationAndServe, except that
+// the Authenticators used by the gRPC servers are provided by the
+// caller, instead of being created from the authentication policies in
+// the configuration. This permits the use of Authenticators that may
+// be replaced at runtime.
+func NewGRPCServersFromConfigurationWithAuthenticatorsAndServe(configurations []*configuration.GRPCServerConfiguration, authenticators []Authenticator, registrationFunc func(*grpc.Server)) error {
 	serveErrors := make(chan error)
 
 	if len(configurations) == 0 {
 		return status.Error(codes.InvalidArgument, "Expected GRPC server configuration is missing")
 	}
+	if len(authenticators) != len(configurations) {
+		return status.Errorf(codes.InvalidArgument, "Received %d authenticators for %d gRPC servers", len(authenticators), len(configurations))
+	}
 
-	for _, configuration := range configurations {
-		// Create an authenticator for requests.
-		authenticator, err := NewAuthenticatorFromConfiguration(configuration.AuthenticationPolicy)
-		if err != nil {
-			return err
-		}
+	for i, configuration := range configurations {
+		authenticator := authenticators[i]
 
 		// Default server options.
 		serverOptions := []grpc.ServerOption{
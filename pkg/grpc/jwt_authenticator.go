@@ -0,0 +1,154 @@
+package grpc
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/buildbarn/bb-storage/pkg/clock"
+	"github.com/buildbarn/bb-storage/pkg/jwt"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type jwtHeader struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+}
+
+// jwtAudience contains the "aud" claim of a JSON Web Token, which may
+// either be a single string or an array of strings.
+type jwtAudience []string
+
+func (a *jwtAudience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = jwtAudience{single}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(a))
+}
+
+type jwtClaims struct {
+	Subject        string      `json:"sub"`
+	Issuer         string      `json:"iss"`
+	Audience       jwtAudience `json:"aud"`
+	ExpirationTime *float64    `json:"exp"`
+	NotBefore      *float64    `json:"nbf"`
+}
+
+func numericDateToTime(v float64) time.Time {
+	seconds, fraction := math.Modf(v)
+	return time.Unix(int64(seconds), int64(fraction*1e9))
+}
+
+type jwtAuthenticator struct {
+	signatureValidator jwt.SignatureValidator
+	clock              clock.Clock
+	allowedClockSkew   time.Duration
+	audience           string
+	issuer             string
+}
+
+// NewJWTAuthenticator creates an Authenticator that only grants access
+// in case the client provided a JSON Web Token (JWT) as a bearer token
+// in the "authorization" metadata. The signature of the token must be
+// accepted by a SignatureValidator, and the token must not be expired.
+// The "exp" and "nbf" claims are checked with a leeway of
+// allowedClockSkew, to account for differences between the clocks of
+// the issuer and this process.
+//
+// If an audience or issuer is provided, the "aud" and "iss" claims of
+// the token must match them. The "sub" claim is used as the identity of
+// the client.
+func NewJWTAuthenticator(signatureValidator jwt.SignatureValidator, clock clock.Clock, allowedClockSkew time.Duration, audience string, issuer string) Authenticator {
+	return &jwtAuthenticator{
+		signatureValidator: signatureValidator,
+		clock:              clock,
+		allowedClockSkew:   allowedClockSkew,
+		audience:           audience,
+		issuer:             issuer,
+	}
+}
+
+// getBearerToken extracts a bearer token from the "authorization"
+// metadata of an incoming request.
+func getBearerToken(ctx context.Context) (string, bool) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, value := range md.Get("authorization") {
+		if len(value) > 7 && strings.EqualFold(value[:7], "Bearer ") {
+			return value[7:], true
+		}
+	}
+	return "", false
+}
+
+func (a *jwtAuthenticator) Authenticate(ctx context.Context) (string, error) {
+	token, ok := getBearerToken(ctx)
+	if !ok {
+		return "", status.Error(codes.Unauthenticated, "No bearer token provided")
+	}
+
+	// Decode the components of the token.
+	components := strings.Split(token, ".")
+	if len(components) != 3 {
+		return "", status.Error(codes.Unauthenticated, "Bearer token is not a JSON Web Token")
+	}
+	headerJSON, err := base64.RawURLEncoding.DecodeString(components[0])
+	if err != nil {
+		return "", status.Error(codes.Unauthenticated, "Failed to decode JSON Web Token header")
+	}
+	var header jwtHeader
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return "", status.Error(codes.Unauthenticated, "Failed to parse JSON Web Token header")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(components[2])
+	if err != nil {
+		return "", status.Error(codes.Unauthenticated, "Failed to decode JSON Web Token signature")
+	}
+
+	// Only inspect the claims of tokens that have a valid signature.
+	if !a.signatureValidator.ValidateSignature(header.Algorithm, header.KeyID, components[0]+"."+components[1], signature) {
+		return "", status.Error(codes.Unauthenticated, "Invalid JSON Web Token signature")
+	}
+	claimsJSON, err := base64.RawURLEncoding.DecodeString(components[1])
+	if err != nil {
+		return "", status.Error(codes.Unauthenticated, "Failed to decode JSON Web Token payload")
+	}
+	var claims jwtClaims
+	if err := json.Unmarshal(claimsJSON, &claims); err != nil {
+		return "", status.Error(codes.Unauthenticated, "Failed to parse JSON Web Token payload")
+	}
+
+	now := a.clock.Now()
+	if claims.ExpirationTime == nil {
+		return "", status.Error(codes.Unauthenticated, "JSON Web Token has no expiration time")
+	}
+	if !now.Before(numericDateToTime(*claims.ExpirationTime).Add(a.allowedClockSkew)) {
+		return "", status.Error(codes.Unauthenticated, "JSON Web Token has expired")
+	}
+	if claims.NotBefore != nil && now.Before(numericDateToTime(*claims.NotBefore).Add(-a.allowedClockSkew)) {
+		return "", status.Error(codes.Unauthenticated, "JSON Web Token is not yet valid")
+	}
+	if a.issuer != "" && claims.Issuer != a.issuer {
+		return "", status.Errorf(codes.Unauthenticated, "JSON Web Token has issuer %#v, while %#v was expected", claims.Issuer, a.issuer)
+	}
+	if a.audience != "" {
+		found := false
+		for _, audience := range claims.Audience {
+			if audience == a.audience {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return "", status.Errorf(codes.Unauthenticated, "JSON Web Token is not intended for audience %#v", a.audience)
+		}
+	}
+	return claims.Subject, nil
+}
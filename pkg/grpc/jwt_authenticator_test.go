@@ -0,0 +1,128 @@
+package grpc_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	bb_grpc "github.com/buildbarn/bb-storage/pkg/grpc"
+	"github.com/buildbarn/bb-storage/pkg/jwt"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// signES256 creates a JSON Web Token with a given payload, signed with
+// an ECDSA P-256 key.
+func signES256(t *testing.T, privateKey *ecdsa.PrivateKey, payload string) string {
+	headerAndPayload := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"ES256","typ":"JWT"}`)) +
+		"." + base64.RawURLEncoding.EncodeToString([]byte(payload))
+	hash := sha256.Sum256([]byte(headerAndPayload))
+	r, s, err := ecdsa.Sign(rand.Reader, privateKey, hash[:])
+	require.NoError(t, err)
+	signature := make([]byte, 64)
+	rBytes, sBytes := r.Bytes(), s.Bytes()
+	copy(signature[32-len(rBytes):], rBytes)
+	copy(signature[64-len(sBytes):], sBytes)
+	return headerAndPayload + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func contextWithAuthorization(value string) context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", value))
+}
+
+func TestJWTAuthenticator(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	otherPrivateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	clock := mock.NewMockClock(ctrl)
+	authenticator := bb_grpc.NewJWTAuthenticator(
+		jwt.KeySet{{PublicKey: &privateKey.PublicKey}},
+		clock,
+		10*time.Second,
+		"buildbarn",
+		"https://issuer.example.com")
+
+	t.Run("NoMetadata", func(t *testing.T) {
+		_, err := authenticator.Authenticate(context.Background())
+		require.Equal(t, status.Error(codes.Unauthenticated, "No bearer token provided"), err)
+	})
+
+	t.Run("MalformedToken", func(t *testing.T) {
+		_, err := authenticator.Authenticate(contextWithAuthorization("Bearer hello"))
+		require.Equal(t, status.Error(codes.Unauthenticated, "Bearer token is not a JSON Web Token"), err)
+	})
+
+	t.Run("InvalidSignature", func(t *testing.T) {
+		token := signES256(t, otherPrivateKey, `{"aud":"buildbarn","iss":"https://issuer.example.com","exp":2000}`)
+		_, err := authenticator.Authenticate(contextWithAuthorization("Bearer " + token))
+		require.Equal(t, status.Error(codes.Unauthenticated, "Invalid JSON Web Token signature"), err)
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		clock.EXPECT().Now().Return(time.Unix(2010, 0))
+		token := signES256(t, privateKey, `{"aud":"buildbarn","iss":"https://issuer.example.com","exp":2000}`)
+		_, err := authenticator.Authenticate(contextWithAuthorization("Bearer " + token))
+		require.Equal(t, status.Error(codes.Unauthenticated, "JSON Web Token has expired"), err)
+	})
+
+	t.Run("NotYetValid", func(t *testing.T) {
+		clock.EXPECT().Now().Return(time.Unix(1000, 0))
+		token := signES256(t, privateKey, `{"aud":"buildbarn","iss":"https://issuer.example.com","exp":2000,"nbf":1500}`)
+		_, err := authenticator.Authenticate(contextWithAuthorization("Bearer " + token))
+		require.Equal(t, status.Error(codes.Unauthenticated, "JSON Web Token is not yet valid"), err)
+	})
+
+	t.Run("WithinClockSkew", func(t *testing.T) {
+		// Tokens that have expired or are not yet valid
+		// according to our clock should be accepted, as long as
+		// the difference is within the allowed clock skew.
+		clock.EXPECT().Now().Return(time.Unix(2009, 0))
+		token := signES256(t, privateKey, `{"aud":"buildbarn","iss":"https://issuer.example.com","exp":2000,"sub":"alice"}`)
+		identity, err := authenticator.Authenticate(contextWithAuthorization("Bearer " + token))
+		require.NoError(t, err)
+		require.Equal(t, "alice", identity)
+
+		clock.EXPECT().Now().Return(time.Unix(1490, 0))
+		token = signES256(t, privateKey, `{"aud":"buildbarn","iss":"https://issuer.example.com","exp":2000,"nbf":1500,"sub":"alice"}`)
+		identity, err = authenticator.Authenticate(contextWithAuthorization("Bearer " + token))
+		require.NoError(t, err)
+		require.Equal(t, "alice", identity)
+	})
+
+	t.Run("WrongIssuer", func(t *testing.T) {
+		clock.EXPECT().Now().Return(time.Unix(1000, 0))
+		token := signES256(t, privateKey, `{"aud":"buildbarn","iss":"https://other.example.com","exp":2000}`)
+		_, err := authenticator.Authenticate(contextWithAuthorization("Bearer " + token))
+		require.Equal(t, status.Error(codes.Unauthenticated, "JSON Web Token has issuer \"https://other.example.com\", while \"https://issuer.example.com\" was expected"), err)
+	})
+
+	t.Run("WrongAudience", func(t *testing.T) {
+		clock.EXPECT().Now().Return(time.Unix(1000, 0))
+		token := signES256(t, privateKey, `{"aud":["bazel","other"],"iss":"https://issuer.example.com","exp":2000}`)
+		_, err := authenticator.Authenticate(contextWithAuthorization("Bearer " + token))
+		require.Equal(t, status.Error(codes.Unauthenticated, "JSON Web Token is not intended for audience \"buildbarn\""), err)
+	})
+
+	t.Run("Success", func(t *testing.T) {
+		clock.EXPECT().Now().Return(time.Unix(1000, 0))
+		token := signES256(t, privateKey, `{"aud":["bazel","buildbarn"],"iss":"https://issuer.example.com","exp":2000,"nbf":500,"sub":"alice"}`)
+		identity, err := authenticator.Authenticate(contextWithAuthorization("bearer " + token))
+		require.NoError(t, err)
+		require.Equal(t, "alice", identity)
+	})
+}
@@ -0,0 +1,41 @@
+package grpc
+
+import (
+	"context"
+	"sync"
+)
+
+// SwappableAuthenticator is an Authenticator that forwards all calls
+// to an Authenticator that may be replaced at runtime. It is used to
+// apply changes to authentication policies without restarting.
+type SwappableAuthenticator interface {
+	Authenticator
+
+	Swap(authenticator Authenticator)
+}
+
+type swappableAuthenticator struct {
+	lock          sync.RWMutex
+	authenticator Authenticator
+}
+
+// NewSwappableAuthenticator creates a SwappableAuthenticator that
+// initially forwards all calls to the provided Authenticator.
+func NewSwappableAuthenticator(authenticator Authenticator) SwappableAuthenticator {
+	return &swappableAuthenticator{
+		authenticator: authenticator,
+	}
+}
+
+func (a *swappableAuthenticator) Swap(authenticator Authenticator) {
+	a.lock.Lock()
+	a.authenticator = authenticator
+	a.lock.Unlock()
+}
+
+func (a *swappableAuthenticator) Authenticate(ctx context.Context) (string, error) {
+	a.lock.RLock()
+	authenticator := a.authenticator
+	a.lock.RUnlock()
+	return authenticator.Authenticate(ctx)
+}
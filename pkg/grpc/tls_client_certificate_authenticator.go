@@ -21,7 +21,8 @@ type tlsClientCertificateAuthenticator struct {
 // NewTLSClientCertificateAuthenticator creates an Authenticator that
 // only grants access in case the client connected to the gRPC server
 // using a TLS client certificate that can be validated against the
-// chain of CAs used by the server.
+// chain of CAs used by the server. The common name of the certificate's
+// subject is used as the identity of the client.
 func NewTLSClientCertificateAuthenticator(clientCAs *x509.CertPool, clock clock.Clock) Authenticator {
 	return &tlsClientCertificateAuthenticator{
 		clientCAs: clientCAs,
@@ -29,19 +30,19 @@ func NewTLSClientCertificateAuthenticator(clientCAs *x509.CertPool, clock clock.
 	}
 }
 
-func (a *tlsClientCertificateAuthenticator) Authenticate(ctx context.Context) error {
+func (a *tlsClientCertificateAuthenticator) Authenticate(ctx context.Context) (string, error) {
 	// Extract client certificate chain from the connection.
 	p, ok := peer.FromContext(ctx)
 	if !ok {
-		return status.Error(codes.Unauthenticated, "Connection was not established using gRPC")
+		return "", status.Error(codes.Unauthenticated, "Connection was not established using gRPC")
 	}
 	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
 	if !ok {
-		return status.Error(codes.Unauthenticated, "Connection was not established using TLS")
+		return "", status.Error(codes.Unauthenticated, "Connection was not established using TLS")
 	}
 	certs := tlsInfo.State.PeerCertificates
 	if len(certs) == 0 {
-		return status.Error(codes.Unauthenticated, "Client provided no TLS client certificate")
+		return "", status.Error(codes.Unauthenticated, "Client provided no TLS client certificate")
 	}
 
 	// Perform certificate verification.
@@ -56,7 +57,7 @@ func (a *tlsClientCertificateAuthenticator) Authenticate(ctx context.Context) er
 		opts.Intermediates.AddCert(cert)
 	}
 	if _, err := certs[0].Verify(opts); err != nil {
-		return util.StatusWrapWithCode(err, codes.Unauthenticated, "Cannot validate TLS client certificate")
+		return "", util.StatusWrapWithCode(err, codes.Unauthenticated, "Cannot validate TLS client certificate")
 	}
-	return nil
+	return certs[0].Subject.CommonName, nil
 }
@@ -0,0 +1,55 @@
+package http
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/buildbarn/bb-storage/pkg/auth"
+	bb_grpc "github.com/buildbarn/bb-storage/pkg/grpc"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+type authenticatingHandler struct {
+	base          http.Handler
+	authenticator bb_grpc.Authenticator
+}
+
+// NewAuthenticatingHandler creates an HTTP handler that passes all
+// requests through an Authenticator before forwarding them to a base
+// handler. This makes it possible to apply the same authentication
+// policies to HTTP servers as to gRPC servers.
+//
+// As Authenticators obtain the identity of the client from gRPC peer
+// information and metadata, the request context is extended to contain
+// the remote address, TLS connection state and "Authorization" header
+// of the HTTP request. The identity returned by the Authenticator is
+// attached to the context passed on to the base handler.
+func NewAuthenticatingHandler(base http.Handler, authenticator bb_grpc.Authenticator) http.Handler {
+	return &authenticatingHandler{
+		base:          base,
+		authenticator: authenticator,
+	}
+}
+
+func (h *authenticatingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p := &peer.Peer{}
+	if addr, err := net.ResolveTCPAddr("tcp", r.RemoteAddr); err == nil {
+		p.Addr = addr
+	}
+	if r.TLS != nil {
+		p.AuthInfo = credentials.TLSInfo{State: *r.TLS}
+	}
+	ctx := peer.NewContext(r.Context(), p)
+	if authorization := r.Header["Authorization"]; len(authorization) > 0 {
+		ctx = metadata.NewIncomingContext(ctx, metadata.MD{"authorization": authorization})
+	}
+	identity, err := h.authenticator.Authenticate(ctx)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	h.base.ServeHTTP(w, r.WithContext(auth.NewContextWithIdentity(ctx, identity)))
+}
@@ -0,0 +1,70 @@
+package http_test
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/auth"
+	bb_http "github.com/buildbarn/bb-storage/pkg/http"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+func TestAuthenticatingHandler(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	authenticator := mock.NewMockAuthenticator(ctrl)
+	var baseCalled bool
+	var identity string
+	handler := bb_http.NewAuthenticatingHandler(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			baseCalled = true
+			identity = auth.GetIdentityFromContext(r.Context())
+		}),
+		authenticator)
+
+	t.Run("Denied", func(t *testing.T) {
+		authenticator.EXPECT().Authenticate(gomock.Any()).
+			Return("", status.Error(codes.Unauthenticated, "Client provided no TLS client certificate"))
+
+		baseCalled = false
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/cas/8b1a9953c4611296a827abf8c47804d7", nil))
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+		require.Equal(t, "Client provided no TLS client certificate\n", w.Body.String())
+		require.False(t, baseCalled)
+	})
+
+	t.Run("Allowed", func(t *testing.T) {
+		// The TLS connection state should be provided to the
+		// Authenticator as gRPC peer information.
+		r := httptest.NewRequest(http.MethodGet, "/cas/8b1a9953c4611296a827abf8c47804d7", nil)
+		r.TLS = &tls.ConnectionState{ServerName: "example.com"}
+		authenticator.EXPECT().Authenticate(gomock.Any()).DoAndReturn(
+			func(ctx context.Context) (string, error) {
+				p, ok := peer.FromContext(ctx)
+				require.True(t, ok)
+				tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+				require.True(t, ok)
+				require.Equal(t, "example.com", tlsInfo.State.ServerName)
+				return "alice", nil
+			})
+
+		baseCalled = false
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.True(t, baseCalled)
+		require.Equal(t, "alice", identity)
+	})
+}
@@ -0,0 +1,321 @@
+package http
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/pkg/auth"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/eviction"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/golang/protobuf/proto"
+	"github.com/gorilla/mux"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// bazelCacheReadChunkSize is the maximum size of chunks that are
+// read from the Content Addressable Storage when serving objects.
+const bazelCacheReadChunkSize = 1 << 16
+
+// bazelCacheSizeRecordPrefix is prepended to the hash of an object in
+// the Content Addressable Storage to derive the key under which its
+// size is recorded in the Action Cache.
+const bazelCacheSizeRecordPrefix = "bazel-http-cache-size:"
+
+type bazelCacheHandler struct {
+	contentAddressableStorage blobstore.BlobAccess
+	actionCache               blobstore.BlobAccess
+	instance                  string
+	allowActionCacheUpdates   func(instance string) bool
+	maximumMessageSizeBytes   int
+	sizes                     *sizeCache
+	authorizer                auth.Authorizer
+}
+
+// NewBazelCacheHandler creates an HTTP handler that exposes the
+// Content Addressable Storage (CAS) and Action Cache (AC) through
+// Bazel's HTTP caching protocol. It serves GET, HEAD and PUT requests
+// on /cas/${hash} and /ac/${hash}.
+//
+// See: https://docs.bazel.build/versions/master/remote-caching.html#http-caching-protocol
+//
+// As this protocol identifies objects by hash only, Action Cache
+// entries are accessed using digests with a size of zero. The Action
+// Cache should be wrapped in SizeAgnosticBlobAccess, so that entries
+// are shared with clients using the REv2 gRPC protocol.
+//
+// Sizes of objects in the CAS are learned from uploads and from
+// ActionResult messages returned by the AC. They are stored in a
+// cache of a fixed size. Uploads additionally store the size of the
+// object in the AC, under a key derived from the object's hash, so
+// that other processes and restarted instances of this handler can
+// serve the object as well. Requests for objects of which the size
+// cannot be determined in either way are treated as cache misses.
+// Sizes learned from ActionResult messages on another process are not
+// recorded in the AC, as the handler would otherwise need to write to
+// the AC on every read.
+//
+// Every request is checked against an Authorizer before the backend is
+// accessed.
+func NewBazelCacheHandler(contentAddressableStorage blobstore.BlobAccess, actionCache blobstore.BlobAccess, instance string, allowActionCacheUpdates func(instance string) bool, maximumMessageSizeBytes int, sizeCacheSize int, sizeCacheEvictionSet eviction.Set, authorizer auth.Authorizer) http.Handler {
+	h := &bazelCacheHandler{
+		contentAddressableStorage: contentAddressableStorage,
+		actionCache:               actionCache,
+		instance:                  instance,
+		allowActionCacheUpdates:   allowActionCacheUpdates,
+		maximumMessageSizeBytes:   maximumMessageSizeBytes,
+		sizes:                     newSizeCache(sizeCacheSize, sizeCacheEvictionSet),
+		authorizer:                authorizer,
+	}
+	router := mux.NewRouter()
+	router.HandleFunc("/ac/{hash}", h.authorize(auth.OperationActionCacheRead, h.getActionResult)).Methods(http.MethodGet, http.MethodHead)
+	router.HandleFunc("/ac/{hash}", h.authorize(auth.OperationActionCacheWrite, h.putActionResult)).Methods(http.MethodPut)
+	router.HandleFunc("/cas/{hash}", h.authorize(auth.OperationContentAddressableStorageRead, h.getBlob)).Methods(http.MethodGet)
+	router.HandleFunc("/cas/{hash}", h.authorize(auth.OperationContentAddressableStorageRead, h.headBlob)).Methods(http.MethodHead)
+	router.HandleFunc("/cas/{hash}", h.authorize(auth.OperationContentAddressableStorageWrite, h.putBlob)).Methods(http.MethodPut)
+	return router
+}
+
+// authorize wraps a handler function, so that it is only invoked if
+// the client is permitted to perform an operation on the instance.
+func (h *bazelCacheHandler) authorize(operation auth.Operation, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := h.authorizer.Authorize(r.Context(), h.instance, operation); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+func (h *bazelCacheHandler) getActionDigest(r *http.Request) (digest.Digest, error) {
+	return digest.NewDigest(h.instance, remoteexecution.DigestFunction_UNKNOWN, mux.Vars(r)["hash"], 0)
+}
+
+// addPartialDigest stores the size of an object referenced by an
+// ActionResult in the size cache.
+func (h *bazelCacheHandler) addPartialDigest(partialDigest *remoteexecution.Digest) {
+	if partialDigest == nil {
+		return
+	}
+	if d, err := digest.NewDigestFromPartialDigest(h.instance, remoteexecution.DigestFunction_UNKNOWN, partialDigest); err == nil {
+		h.sizes.add(d)
+	}
+}
+
+// addActionResultSizes stores the sizes of all objects referenced by
+// an ActionResult in the size cache, so that clients may download
+// them afterwards. This includes the contents of output directories.
+func (h *bazelCacheHandler) addActionResultSizes(r *http.Request, actionResult *remoteexecution.ActionResult) {
+	for _, outputFile := range actionResult.OutputFiles {
+		h.addPartialDigest(outputFile.Digest)
+	}
+	for _, outputDirectory := range actionResult.OutputDirectories {
+		h.addPartialDigest(outputDirectory.TreeDigest)
+		treeDigest, err := digest.NewDigestFromPartialDigest(h.instance, remoteexecution.DigestFunction_UNKNOWN, outputDirectory.TreeDigest)
+		if err != nil {
+			continue
+		}
+		// Failing to load the tree is not fatal. Clients will
+		// merely observe cache misses for its contents.
+		data, err := h.contentAddressableStorage.Get(r.Context(), treeDigest).ToByteSlice(h.maximumMessageSizeBytes)
+		if err != nil {
+			continue
+		}
+		var tree remoteexecution.Tree
+		if err := proto.Unmarshal(data, &tree); err != nil {
+			continue
+		}
+		for _, directory := range append([]*remoteexecution.Directory{tree.Root}, tree.Children...) {
+			for _, file := range directory.GetFiles() {
+				h.addPartialDigest(file.Digest)
+			}
+		}
+	}
+	h.addPartialDigest(actionResult.StdoutDigest)
+	h.addPartialDigest(actionResult.StderrDigest)
+}
+
+func (h *bazelCacheHandler) getActionResult(w http.ResponseWriter, r *http.Request) {
+	actionDigest, err := h.getActionDigest(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	actionResult, err := h.actionCache.Get(r.Context(), actionDigest).ToActionResult(h.maximumMessageSizeBytes)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	data, err := proto.Marshal(actionResult)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	h.addActionResultSizes(r, actionResult)
+
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(data)
+}
+
+func (h *bazelCacheHandler) putActionResult(w http.ResponseWriter, r *http.Request) {
+	actionDigest, err := h.getActionDigest(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if !h.allowActionCacheUpdates(h.instance) {
+		writeError(w, r, status.Errorf(codes.Unimplemented, "This service can only be used to get action results for instance %#v", h.instance))
+		return
+	}
+	if r.ContentLength > int64(h.maximumMessageSizeBytes) {
+		writeError(w, r, status.Errorf(codes.InvalidArgument, "Buffer is %d bytes in size, while a maximum of %d bytes is permitted", r.ContentLength, h.maximumMessageSizeBytes))
+		return
+	}
+	if err := h.actionCache.Put(
+		r.Context(),
+		actionDigest,
+		buffer.NewACBufferFromReader(
+			http.MaxBytesReader(w, r.Body, int64(h.maximumMessageSizeBytes)),
+			buffer.UserProvided)); err != nil {
+		writeError(w, r, err)
+		return
+	}
+}
+
+// getSizeRecordDigest returns the digest of the Action Cache entry
+// in which the size of an object in the Content Addressable Storage is
+// recorded.
+func getSizeRecordDigest(blobDigest digest.Digest) digest.Digest {
+	generator := blobDigest.NewGenerator()
+	generator.Write([]byte(bazelCacheSizeRecordPrefix + blobDigest.GetHashString()))
+	return generator.Sum()
+}
+
+// getBlobDigest converts the hash in the URL of a request to a digest
+// of an object in the Content Addressable Storage. The size of the
+// object is obtained from the size cache, falling back to the record
+// stored in the Action Cache when the object was uploaded.
+func (h *bazelCacheHandler) getBlobDigest(r *http.Request) (digest.Digest, error) {
+	hash := mux.Vars(r)["hash"]
+	sizeAgnosticDigest, err := digest.NewDigest(h.instance, remoteexecution.DigestFunction_UNKNOWN, hash, 0)
+	if err != nil {
+		return digest.BadDigest, err
+	}
+	if sizeBytes, ok := h.sizes.get(hash); ok {
+		return digest.NewDigest(h.instance, remoteexecution.DigestFunction_UNKNOWN, hash, sizeBytes)
+	}
+
+	sizeRecord, err := h.actionCache.Get(r.Context(), getSizeRecordDigest(sizeAgnosticDigest)).ToActionResult(h.maximumMessageSizeBytes)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return digest.BadDigest, status.Errorf(codes.NotFound, "Size of object with hash %s is unknown", hash)
+		}
+		return digest.BadDigest, util.StatusWrapf(err, "Failed to obtain size of object with hash %s", hash)
+	}
+	if len(sizeRecord.OutputFiles) != 1 || sizeRecord.OutputFiles[0].Digest.GetHash() != hash {
+		return digest.BadDigest, status.Errorf(codes.NotFound, "Size of object with hash %s is unknown", hash)
+	}
+	blobDigest, err := digest.NewDigestFromPartialDigest(h.instance, remoteexecution.DigestFunction_UNKNOWN, sizeRecord.OutputFiles[0].Digest)
+	if err != nil {
+		return digest.BadDigest, util.StatusWrapf(err, "Invalid size record for object with hash %s", hash)
+	}
+	h.sizes.add(blobDigest)
+	return blobDigest, nil
+}
+
+func (h *bazelCacheHandler) getBlob(w http.ResponseWriter, r *http.Request) {
+	blobDigest, err := h.getBlobDigest(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	chunkReader := h.contentAddressableStorage.Get(r.Context(), blobDigest).ToChunkReader(0, bazelCacheReadChunkSize)
+	defer chunkReader.Close()
+
+	// Only send the response headers after the first chunk has
+	// been read successfully, so that errors such as the object
+	// being absent are reported properly.
+	chunk, err := chunkReader.Read()
+	if err != nil && err != io.EOF {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(blobDigest.GetSizeBytes(), 10))
+	w.Header().Set("Content-Type", "application/octet-stream")
+	for err == nil {
+		if _, writeErr := w.Write(chunk); writeErr != nil {
+			return
+		}
+		chunk, err = chunkReader.Read()
+	}
+	if err != io.EOF {
+		// The response is already partially sent. Let the
+		// client observe a truncated response.
+		panic(http.ErrAbortHandler)
+	}
+}
+
+func (h *bazelCacheHandler) headBlob(w http.ResponseWriter, r *http.Request) {
+	blobDigest, err := h.getBlobDigest(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	missing, err := h.contentAddressableStorage.FindMissing(r.Context(), digest.NewSetBuilder().Add(blobDigest).Build())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if !missing.Empty() {
+		writeError(w, r, status.Errorf(codes.NotFound, "Object %s not found", blobDigest))
+		return
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(blobDigest.GetSizeBytes(), 10))
+}
+
+func (h *bazelCacheHandler) putBlob(w http.ResponseWriter, r *http.Request) {
+	if r.ContentLength < 0 {
+		http.Error(w, "Uploads to the Content Addressable Storage require a Content-Length header", http.StatusLengthRequired)
+		return
+	}
+	blobDigest, err := digest.NewDigest(h.instance, remoteexecution.DigestFunction_UNKNOWN, mux.Vars(r)["hash"], r.ContentLength)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := h.contentAddressableStorage.Put(
+		r.Context(),
+		blobDigest,
+		buffer.NewCASBufferFromReader(blobDigest, r.Body, buffer.UserProvided)); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	h.sizes.add(blobDigest)
+
+	// Record the size of the object in the Action Cache, so that
+	// it can also be served when the size cache is cold. Failing
+	// to do so is not fatal, as the object itself has been stored.
+	if h.allowActionCacheUpdates(h.instance) {
+		h.actionCache.Put(
+			r.Context(),
+			getSizeRecordDigest(blobDigest),
+			buffer.NewACBufferFromActionResult(
+				&remoteexecution.ActionResult{
+					OutputFiles: []*remoteexecution.OutputFile{
+						{
+							Path:   "blob",
+							Digest: blobDigest.GetPartialDigest(),
+						},
+					},
+				},
+				buffer.UserProvided))
+	}
+}
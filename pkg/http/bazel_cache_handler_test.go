@@ -0,0 +1,219 @@
+package http_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/auth"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/eviction"
+	bb_http "github.com/buildbarn/bb-storage/pkg/http"
+	"github.com/golang/mock/gomock"
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestBazelCacheHandler(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	contentAddressableStorage := mock.NewMockBlobAccess(ctrl)
+	actionCache := mock.NewMockBlobAccess(ctrl)
+	authorizer := mock.NewMockAuthorizer(ctrl)
+	handler := bb_http.NewBazelCacheHandler(contentAddressableStorage, actionCache, "main", func(instance string) bool { return true }, 1000, 10, eviction.NewLRUSet(), authorizer)
+
+	serve := func(method string, path string, body []byte) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(method, path, bytes.NewBuffer(body)))
+		return w
+	}
+
+	t.Run("PutBlobPermissionDenied", func(t *testing.T) {
+		// Requests should be rejected if the Authorizer does
+		// not permit them.
+		authorizer.EXPECT().Authorize(gomock.Any(), "main", auth.OperationContentAddressableStorageWrite).
+			Return(status.Error(codes.PermissionDenied, "Identity \"alice\" is not permitted to perform operation ContentAddressableStorageWrite on instance \"main\""))
+
+		w := serve(http.MethodPut, "/cas/8b1a9953c4611296a827abf8c47804d7", []byte("Hello"))
+		require.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	authorizer.EXPECT().Authorize(gomock.Any(), "main", gomock.Any()).Return(nil).AnyTimes()
+
+	t.Run("GetBlobUnknownSize", func(t *testing.T) {
+		// Objects that have not been observed before cannot be
+		// accessed, as their size is unknown. The Action Cache
+		// should be consulted for a size record.
+		actionCache.EXPECT().Get(gomock.Any(), gomock.Any()).
+			Return(buffer.NewBufferFromError(status.Error(codes.NotFound, "Object not found")))
+
+		w := serve(http.MethodGet, "/cas/8b1a9953c4611296a827abf8c47804d7", nil)
+		require.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("GetBlobInvalidHash", func(t *testing.T) {
+		w := serve(http.MethodGet, "/cas/hello", nil)
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		require.Equal(t, "Unknown digest hash length: 5 characters\n", w.Body.String())
+	})
+
+	var sizeRecordDigest digest.Digest
+	var sizeRecord *remoteexecution.ActionResult
+	t.Run("PutBlobSuccess", func(t *testing.T) {
+		// Uploads should be validated against the digest. The
+		// size of the object should be recorded in the Action
+		// Cache.
+		contentAddressableStorage.EXPECT().Put(
+			gomock.Any(),
+			digest.MustNewDigest("main", remoteexecution.DigestFunction_MD5, "8b1a9953c4611296a827abf8c47804d7", 5),
+			gomock.Any(),
+		).DoAndReturn(func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+			data, err := b.ToByteSlice(100)
+			require.NoError(t, err)
+			require.Equal(t, []byte("Hello"), data)
+			return nil
+		})
+		actionCache.EXPECT().Put(gomock.Any(), gomock.Any(), gomock.Any()).
+			DoAndReturn(func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+				actionResult, err := b.ToActionResult(1000)
+				require.NoError(t, err)
+				sizeRecordDigest = digest
+				sizeRecord = actionResult
+				return nil
+			})
+
+		w := serve(http.MethodPut, "/cas/8b1a9953c4611296a827abf8c47804d7", []byte("Hello"))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "main", sizeRecordDigest.GetInstance())
+		require.NotEqual(t, "8b1a9953c4611296a827abf8c47804d7", sizeRecordDigest.GetHashString())
+	})
+
+	t.Run("GetBlobSizeFromActionCache", func(t *testing.T) {
+		// A handler with a cold size cache should be able to
+		// serve the object, using the size recorded in the
+		// Action Cache during the upload.
+		coldHandler := bb_http.NewBazelCacheHandler(contentAddressableStorage, actionCache, "main", func(instance string) bool { return true }, 1000, 10, eviction.NewLRUSet(), authorizer)
+		actionCache.EXPECT().Get(gomock.Any(), sizeRecordDigest).
+			Return(buffer.NewACBufferFromActionResult(sizeRecord, buffer.Irreparable))
+		contentAddressableStorage.EXPECT().Get(
+			gomock.Any(),
+			digest.MustNewDigest("main", remoteexecution.DigestFunction_MD5, "8b1a9953c4611296a827abf8c47804d7", 5),
+		).Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+
+		w := httptest.NewRecorder()
+		coldHandler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/cas/8b1a9953c4611296a827abf8c47804d7", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "5", w.Header().Get("Content-Length"))
+		require.Equal(t, "Hello", w.Body.String())
+	})
+
+	t.Run("PutBlobCorrupted", func(t *testing.T) {
+		contentAddressableStorage.EXPECT().Put(
+			gomock.Any(),
+			digest.MustNewDigest("main", remoteexecution.DigestFunction_MD5, "8b1a9953c4611296a827abf8c47804d7", 5),
+			gomock.Any(),
+		).DoAndReturn(func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+			_, err := b.ToByteSlice(100)
+			return err
+		})
+
+		w := serve(http.MethodPut, "/cas/8b1a9953c4611296a827abf8c47804d7", []byte("Hallo"))
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		require.Equal(t, "Buffer has checksum d1bf93299de1b68e6d382c893bf1215f, while 8b1a9953c4611296a827abf8c47804d7 was expected\n", w.Body.String())
+	})
+
+	t.Run("GetBlobSuccess", func(t *testing.T) {
+		// The size of the object is known, due to the upload
+		// performed previously.
+		contentAddressableStorage.EXPECT().Get(
+			gomock.Any(),
+			digest.MustNewDigest("main", remoteexecution.DigestFunction_MD5, "8b1a9953c4611296a827abf8c47804d7", 5),
+		).Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+
+		w := serve(http.MethodGet, "/cas/8b1a9953c4611296a827abf8c47804d7", nil)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "5", w.Header().Get("Content-Length"))
+		require.Equal(t, "Hello", w.Body.String())
+	})
+
+	t.Run("GetBlobNotFound", func(t *testing.T) {
+		contentAddressableStorage.EXPECT().Get(
+			gomock.Any(),
+			digest.MustNewDigest("main", remoteexecution.DigestFunction_MD5, "8b1a9953c4611296a827abf8c47804d7", 5),
+		).Return(buffer.NewBufferFromError(status.Error(codes.NotFound, "Blob not found")))
+
+		w := serve(http.MethodGet, "/cas/8b1a9953c4611296a827abf8c47804d7", nil)
+		require.Equal(t, http.StatusNotFound, w.Code)
+		require.Equal(t, "Blob not found\n", w.Body.String())
+	})
+
+	t.Run("HeadBlobSuccess", func(t *testing.T) {
+		blobDigest := digest.MustNewDigest("main", remoteexecution.DigestFunction_MD5, "8b1a9953c4611296a827abf8c47804d7", 5)
+		contentAddressableStorage.EXPECT().FindMissing(
+			gomock.Any(),
+			digest.NewSetBuilder().Add(blobDigest).Build(),
+		).Return(digest.EmptySet, nil)
+
+		w := serve(http.MethodHead, "/cas/8b1a9953c4611296a827abf8c47804d7", nil)
+		require.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("GetActionResultSuccess", func(t *testing.T) {
+		// Sizes of output files should be learned from the
+		// ActionResult, so that they can be downloaded.
+		actionResult := &remoteexecution.ActionResult{
+			OutputFiles: []*remoteexecution.OutputFile{
+				{
+					Path: "hello.txt",
+					Digest: &remoteexecution.Digest{
+						Hash:      "6fc422233a40a75a1f028e11c3cd1140",
+						SizeBytes: 7,
+					},
+				},
+			},
+		}
+		actionCache.EXPECT().Get(
+			gomock.Any(),
+			digest.MustNewDigest("main", remoteexecution.DigestFunction_MD5, "d41d8cd98f00b204e9800998ecf8427e", 0),
+		).Return(buffer.NewACBufferFromActionResult(actionResult, buffer.Irreparable))
+
+		w := serve(http.MethodGet, "/ac/d41d8cd98f00b204e9800998ecf8427e", nil)
+		require.Equal(t, http.StatusOK, w.Code)
+		var receivedActionResult remoteexecution.ActionResult
+		require.NoError(t, proto.Unmarshal(w.Body.Bytes(), &receivedActionResult))
+		require.True(t, proto.Equal(actionResult, &receivedActionResult))
+
+		contentAddressableStorage.EXPECT().Get(
+			gomock.Any(),
+			digest.MustNewDigest("main", remoteexecution.DigestFunction_MD5, "6fc422233a40a75a1f028e11c3cd1140", 7),
+		).Return(buffer.NewValidatedBufferFromByteSlice([]byte("Goodbye")))
+
+		w = serve(http.MethodGet, "/cas/6fc422233a40a75a1f028e11c3cd1140", nil)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "Goodbye", w.Body.String())
+	})
+
+	t.Run("PutActionResultInvalid", func(t *testing.T) {
+		// Action results must be valid Protobuf messages.
+		actionCache.EXPECT().Put(
+			gomock.Any(),
+			digest.MustNewDigest("main", remoteexecution.DigestFunction_MD5, "d41d8cd98f00b204e9800998ecf8427e", 0),
+			gomock.Any(),
+		).DoAndReturn(func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+			_, err := b.ToActionResult(1000)
+			return err
+		})
+
+		w := serve(http.MethodPut, "/ac/d41d8cd98f00b204e9800998ecf8427e", []byte("\xff\xff"))
+		require.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
@@ -0,0 +1,65 @@
+package http
+
+import (
+	"sync"
+
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/eviction"
+)
+
+// sizeCache keeps track of the sizes of objects stored in the Content
+// Addressable Storage, indexed by hash. Bazel's HTTP caching protocol
+// only identifies objects by hash, while BlobAccess requires digests
+// that also contain the size of the object.
+//
+// It is safe to access sizeCache concurrently.
+type sizeCache struct {
+	cacheSize int
+
+	lock        sync.Mutex
+	sizes       map[string]int64
+	evictionSet eviction.Set
+}
+
+func newSizeCache(cacheSize int, evictionSet eviction.Set) *sizeCache {
+	return &sizeCache{
+		cacheSize: cacheSize,
+
+		sizes:       map[string]int64{},
+		evictionSet: evictionSet,
+	}
+}
+
+// add the size of an object to the cache.
+func (sc *sizeCache) add(d digest.Digest) {
+	hash := d.GetHashString()
+	sc.lock.Lock()
+	defer sc.lock.Unlock()
+
+	if _, ok := sc.sizes[hash]; ok {
+		sc.evictionSet.Touch(hash)
+	} else {
+		// Free up space to insert the entry.
+		if len(sc.sizes) >= sc.cacheSize {
+			if sc.cacheSize <= 0 {
+				return
+			}
+			delete(sc.sizes, sc.evictionSet.Peek())
+			sc.evictionSet.Remove()
+		}
+		sc.evictionSet.Insert(hash)
+	}
+	sc.sizes[hash] = d.GetSizeBytes()
+}
+
+// get the size of an object with a given hash, if known.
+func (sc *sizeCache) get(hash string) (int64, bool) {
+	sc.lock.Lock()
+	defer sc.lock.Unlock()
+
+	sizeBytes, ok := sc.sizes[hash]
+	if ok {
+		sc.evictionSet.Touch(hash)
+	}
+	return sizeBytes, ok
+}
@@ -0,0 +1,45 @@
+package http
+
+import (
+	"log"
+	"net/http"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// statusCodeFromError converts a gRPC status code to the HTTP status
+// code that should be returned to clients.
+func statusCodeFromError(err error) int {
+	switch status.Code(err) {
+	case codes.InvalidArgument, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// writeError sends an error message back to an HTTP client, using an
+// HTTP status code that corresponds to the gRPC status code of the
+// error.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	code := statusCodeFromError(err)
+	if code == http.StatusInternalServerError {
+		log.Printf("%s %s: %s", r.Method, r.URL.Path, err)
+	}
+	http.Error(w, status.Convert(err).Message(), code)
+}
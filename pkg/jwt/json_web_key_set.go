@@ -0,0 +1,123 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type jsonWebKey struct {
+	KeyType string `json:"kty"`
+	KeyID   string `json:"kid"`
+	Use     string `json:"use"`
+	Curve   string `json:"crv"`
+	N       string `json:"n"`
+	E       string `json:"e"`
+	X       string `json:"x"`
+	Y       string `json:"y"`
+}
+
+type jsonWebKeySet struct {
+	Keys []jsonWebKey `json:"keys"`
+}
+
+func decodeBigInt(field string, value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(data) == 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "Field %#v does not contain a valid base64url encoded integer", field)
+	}
+	return new(big.Int).SetBytes(data), nil
+}
+
+func (k *jsonWebKey) toKey() (Key, error) {
+	switch k.KeyType {
+	case "RSA":
+		n, err := decodeBigInt("n", k.N)
+		if err != nil {
+			return Key{}, err
+		}
+		e, err := decodeBigInt("e", k.E)
+		if err != nil {
+			return Key{}, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return Key{}, status.Error(codes.InvalidArgument, "RSA public exponent is too large")
+		}
+		return Key{
+			ID:        k.KeyID,
+			PublicKey: &rsa.PublicKey{N: n, E: int(e.Int64())},
+		}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return Key{}, status.Errorf(codes.InvalidArgument, "Unsupported elliptic curve %#v", k.Curve)
+		}
+		x, err := decodeBigInt("x", k.X)
+		if err != nil {
+			return Key{}, err
+		}
+		y, err := decodeBigInt("y", k.Y)
+		if err != nil {
+			return Key{}, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return Key{}, status.Error(codes.InvalidArgument, "Point is not on the elliptic curve")
+		}
+		return Key{
+			ID:        k.KeyID,
+			PublicKey: &ecdsa.PublicKey{Curve: curve, X: x, Y: y},
+		}, nil
+	case "OKP":
+		if k.Curve != "Ed25519" {
+			return Key{}, status.Errorf(codes.InvalidArgument, "Unsupported elliptic curve %#v", k.Curve)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return Key{}, status.Error(codes.InvalidArgument, "Field \"x\" does not contain a valid Ed25519 public key")
+		}
+		return Key{
+			ID:        k.KeyID,
+			PublicKey: ed25519.PublicKey(x),
+		}, nil
+	default:
+		return Key{}, status.Errorf(codes.InvalidArgument, "Unsupported key type %#v", k.KeyType)
+	}
+}
+
+// ParseJSONWebKeySet creates a KeySet from a JSON Web Key Set (JWKS),
+// as described in RFC 7517. Keys that are not intended to be used for
+// validating signatures are ignored.
+func ParseJSONWebKeySet(data []byte) (KeySet, error) {
+	var jwks jsonWebKeySet
+	if err := json.Unmarshal(data, &jwks); err != nil {
+		return nil, util.StatusWrapWithCode(err, codes.InvalidArgument, "Failed to parse JSON Web Key Set")
+	}
+	keys := KeySet{}
+	for i, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.toKey()
+		if err != nil {
+			return nil, util.StatusWrapf(err, "Key at index %d", i)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
@@ -0,0 +1,72 @@
+package jwt
+
+import (
+	"io/ioutil"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/buildbarn/bb-storage/pkg/clock"
+	"github.com/buildbarn/bb-storage/pkg/util"
+)
+
+type jsonWebKeySetFileSignatureValidator struct {
+	path            string
+	clock           clock.Clock
+	refreshInterval time.Duration
+
+	lock            sync.Mutex
+	keys            KeySet
+	nextRefreshTime time.Time
+}
+
+func loadJSONWebKeySetFile(path string) (KeySet, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, util.StatusWrapf(err, "Failed to read JSON Web Key Set file %#v", path)
+	}
+	keys, err := ParseJSONWebKeySet(data)
+	if err != nil {
+		return nil, util.StatusWrapf(err, "Failed to parse JSON Web Key Set file %#v", path)
+	}
+	return keys, nil
+}
+
+// NewJSONWebKeySetFileSignatureValidator creates a SignatureValidator
+// that accepts tokens signed by any of the keys contained in a JSON Web
+// Key Set (JWKS) file. The file is reloaded periodically, so that keys
+// may be rotated without restarting. If reloading fails, the previously
+// loaded keys remain in use.
+func NewJSONWebKeySetFileSignatureValidator(path string, clock clock.Clock, refreshInterval time.Duration) (SignatureValidator, error) {
+	keys, err := loadJSONWebKeySetFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return &jsonWebKeySetFileSignatureValidator{
+		path:            path,
+		clock:           clock,
+		refreshInterval: refreshInterval,
+
+		keys:            keys,
+		nextRefreshTime: clock.Now().Add(refreshInterval),
+	}, nil
+}
+
+func (sv *jsonWebKeySetFileSignatureValidator) getKeys() KeySet {
+	sv.lock.Lock()
+	defer sv.lock.Unlock()
+
+	if now := sv.clock.Now(); !now.Before(sv.nextRefreshTime) {
+		if keys, err := loadJSONWebKeySetFile(sv.path); err == nil {
+			sv.keys = keys
+		} else {
+			log.Print(err)
+		}
+		sv.nextRefreshTime = now.Add(sv.refreshInterval)
+	}
+	return sv.keys
+}
+
+func (sv *jsonWebKeySetFileSignatureValidator) ValidateSignature(algorithm string, keyID string, headerAndPayload string, signature []byte) bool {
+	return sv.getKeys().ValidateSignature(algorithm, keyID, headerAndPayload, signature)
+}
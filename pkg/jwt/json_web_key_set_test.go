@@ -0,0 +1,66 @@
+package jwt_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/pkg/jwt"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestParseJSONWebKeySet(t *testing.T) {
+	t.Run("InvalidJSON", func(t *testing.T) {
+		_, err := jwt.ParseJSONWebKeySet([]byte("Hello"))
+		require.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("UnsupportedKeyType", func(t *testing.T) {
+		_, err := jwt.ParseJSONWebKeySet([]byte(`{"keys":[{"kty":"oct","k":"c2VjcmV0"}]}`))
+		require.Equal(t, status.Error(codes.InvalidArgument, "Key at index 0: Unsupported key type \"oct\""), err)
+	})
+
+	t.Run("PointNotOnCurve", func(t *testing.T) {
+		_, err := jwt.ParseJSONWebKeySet([]byte(`{"keys":[{"kty":"EC","crv":"P-256","x":"AQ","y":"AQ"}]}`))
+		require.Equal(t, status.Error(codes.InvalidArgument, "Key at index 0: Point is not on the elliptic curve"), err)
+	})
+
+	t.Run("Success", func(t *testing.T) {
+		// Example keys from RFC 7517, appendix A.1. Keys that
+		// are used for encryption should be skipped.
+		keys, err := jwt.ParseJSONWebKeySet([]byte(`{"keys": [
+			{
+				"kty": "EC",
+				"crv": "P-256",
+				"x": "MKBCTNIcKUSDii11ySs3526iDZ8AiTo7Tu6KPAqv7D4",
+				"y": "4Etl6SRW2YiLUrN5vfvVHuhp7x8PxltmWWlbbM4IFyM",
+				"use": "enc",
+				"kid": "1"
+			},
+			{
+				"kty": "EC",
+				"crv": "P-256",
+				"x": "MKBCTNIcKUSDii11ySs3526iDZ8AiTo7Tu6KPAqv7D4",
+				"y": "4Etl6SRW2YiLUrN5vfvVHuhp7x8PxltmWWlbbM4IFyM",
+				"use": "sig",
+				"kid": "2"
+			},
+			{
+				"kty": "RSA",
+				"n": "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+				"e": "AQAB",
+				"alg": "RS256",
+				"kid": "2011-04-29"
+			}
+		]}`))
+		require.NoError(t, err)
+		require.Len(t, keys, 2)
+		require.Equal(t, "2", keys[0].ID)
+		require.IsType(t, &ecdsa.PublicKey{}, keys[0].PublicKey)
+		require.Equal(t, "2011-04-29", keys[1].ID)
+		require.Equal(t, 65537, keys[1].PublicKey.(*rsa.PublicKey).E)
+	})
+}
@@ -0,0 +1,149 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/pem"
+	"math/big"
+
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Key is a public key that may be used to validate signatures of JSON
+// Web Tokens. The key ID is optional. If set, the key is only used to
+// validate tokens that either have no key ID or a matching one.
+type Key struct {
+	ID        string
+	PublicKey crypto.PublicKey
+}
+
+// KeySet is a list of public keys that may be used to validate
+// signatures of JSON Web Tokens. It implements SignatureValidator,
+// accepting tokens that have been signed by any of the keys.
+type KeySet []Key
+
+// ParsePEMPublicKeys creates a KeySet from a series of PEM blocks,
+// each containing a PKIX public key.
+func ParsePEMPublicKeys(data []byte) (KeySet, error) {
+	var keys KeySet
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		publicKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, util.StatusWrapWithCode(err, codes.InvalidArgument, "Failed to parse public key")
+		}
+		keys = append(keys, Key{PublicKey: publicKey})
+	}
+	if len(keys) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "No PEM encoded public keys found")
+	}
+	return keys, nil
+}
+
+// ValidateSignature returns whether the signature of a token has been
+// created by one of the keys in the KeySet.
+func (ks KeySet) ValidateSignature(algorithm string, keyID string, headerAndPayload string, signature []byte) bool {
+	for _, key := range ks {
+		if keyID != "" && key.ID != "" && key.ID != keyID {
+			continue
+		}
+		if validateSignature(key.PublicKey, algorithm, headerAndPayload, signature) {
+			return true
+		}
+	}
+	return false
+}
+
+// getHash returns the hash function that is used by a JSON Web
+// Signature algorithm, based on the numerical suffix of its name.
+func getHash(algorithm string) (crypto.Hash, bool) {
+	if len(algorithm) != 5 {
+		return 0, false
+	}
+	switch algorithm[2:] {
+	case "256":
+		return crypto.SHA256, true
+	case "384":
+		return crypto.SHA384, true
+	case "512":
+		return crypto.SHA512, true
+	default:
+		return 0, false
+	}
+}
+
+func computeHash(hash crypto.Hash, data string) []byte {
+	switch hash {
+	case crypto.SHA256:
+		h := sha256.Sum256([]byte(data))
+		return h[:]
+	case crypto.SHA384:
+		h := sha512.Sum384([]byte(data))
+		return h[:]
+	default:
+		h := sha512.Sum512([]byte(data))
+		return h[:]
+	}
+}
+
+// ecdsaCurves contains the elliptic curve that is used by each of the
+// ECDSA based JSON Web Signature algorithms.
+var ecdsaCurves = map[crypto.Hash]elliptic.Curve{
+	crypto.SHA256: elliptic.P256(),
+	crypto.SHA384: elliptic.P384(),
+	crypto.SHA512: elliptic.P521(),
+}
+
+// validateSignature checks whether a signature of a token was created
+// using a public key and algorithm. Only asymmetric algorithms are
+// supported.
+func validateSignature(publicKey crypto.PublicKey, algorithm string, headerAndPayload string, signature []byte) bool {
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		hash, ok := getHash(algorithm)
+		if !ok {
+			return false
+		}
+		switch algorithm[:2] {
+		case "RS":
+			return rsa.VerifyPKCS1v15(key, hash, computeHash(hash, headerAndPayload), signature) == nil
+		case "PS":
+			return rsa.VerifyPSS(key, hash, computeHash(hash, headerAndPayload), signature, &rsa.PSSOptions{
+				SaltLength: rsa.PSSSaltLengthEqualsHash,
+			}) == nil
+		default:
+			return false
+		}
+	case *ecdsa.PublicKey:
+		hash, ok := getHash(algorithm)
+		if !ok || algorithm[:2] != "ES" || ecdsaCurves[hash] != key.Curve {
+			return false
+		}
+		// Signatures consist of the concatenation of R and S,
+		// both padded to the size of the curve.
+		keySizeBytes := (key.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*keySizeBytes {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:keySizeBytes])
+		s := new(big.Int).SetBytes(signature[keySizeBytes:])
+		return ecdsa.Verify(key, computeHash(hash, headerAndPayload), r, s)
+	case ed25519.PublicKey:
+		return algorithm == "EdDSA" && ed25519.Verify(key, []byte(headerAndPayload), signature)
+	default:
+		return false
+	}
+}
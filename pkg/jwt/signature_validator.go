@@ -0,0 +1,11 @@
+package jwt
+
+// SignatureValidator is used by JSON Web Token (JWT) authentication to
+// check whether a token has been signed by a trusted party.
+type SignatureValidator interface {
+	// ValidateSignature returns whether the signature of a token is
+	// valid. The algorithm and key ID are obtained from the token's
+	// header, while headerAndPayload contains the first two
+	// components of the token, as they are covered by the signature.
+	ValidateSignature(algorithm string, keyID string, headerAndPayload string, signature []byte) bool
+}